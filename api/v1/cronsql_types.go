@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CronSQLSpec defines the desired state of CronSQL
+type CronSQLSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the job is
+	// scheduled on. It needs an image with pg_cron and pg_cron in its
+	// shared_preload_libraries parameter.
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Name of the job in cron.job. Defaults to the name of the resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Schedule of the job, as a cron expression or e.g. '30 seconds'
+	Schedule string `json:"schedule"`
+
+	// Command is the SQL the job runs
+	Command string `json:"command"`
+
+	// Database the command runs in. Defaults to the database pg_cron is
+	// installed in, set by the cron.database_name parameter.
+	// +optional
+	Database string `json:"database,omitempty"`
+}
+
+// CronSQLStatus defines the observed state of CronSQL
+type CronSQLStatus struct {
+	// JobID of the job in cron.job
+	// +optional
+	JobID int64 `json:"jobID,omitempty"`
+
+	// Conditions report whether the job has been scheduled on the instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// CronSQL is the Schema for the cronsqls API
+type CronSQL struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CronSQLSpec   `json:"spec,omitempty"`
+	Status CronSQLStatus `json:"status,omitempty"`
+}
+
+// JobName is the name of the job in cron.job
+func (c *CronSQL) JobName() string {
+	if c.Spec.Name != "" {
+		return c.Spec.Name
+	}
+	return c.Name
+}
+
+//+kubebuilder:object:root=true
+
+// CronSQLList contains a list of CronSQL
+type CronSQLList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CronSQL `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CronSQL{}, &CronSQLList{})
+}
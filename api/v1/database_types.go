@@ -0,0 +1,165 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseSpec defines the desired state of Database
+type DatabaseSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the database
+	// lives on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Name of the database in Postgres. Defaults to the name of the
+	// resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Owner is the role owning the database, and the user in its
+	// credentials Secret. Defaults to the name of the database. The role is
+	// created as a login role when missing.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// Encoding the database is created with, e.g. UTF8. It cannot be
+	// changed once the database exists.
+	// +optional
+	Encoding string `json:"encoding,omitempty"`
+
+	// LocaleProvider is the provider of the database's default collation.
+	// Defaults to libc; icu needs Postgres 15 or later built with ICU.
+	// +kubebuilder:validation:Enum=libc;icu
+	// +optional
+	LocaleProvider string `json:"localeProvider,omitempty"`
+
+	// LCCollate is the libc locale the database sorts strings with, e.g.
+	// en_US.utf8. It has to be one the instance has, see the LocaleSupport
+	// of its status.
+	// +optional
+	LCCollate string `json:"lcCollate,omitempty"`
+
+	// LCCtype is the libc locale the database classifies characters with
+	// +optional
+	LCCtype string `json:"lcCtype,omitempty"`
+
+	// ICULocale is the ICU locale of the database when LocaleProvider is
+	// icu, e.g. en-US
+	// +optional
+	ICULocale string `json:"icuLocale,omitempty"`
+
+	// Template is the database the new database is copied from, e.g. one
+	// with IsTemplate holding a golden schema. Defaults to template1, or to
+	// template0 when Encoding or a locale is set. Creating the database fails, and is
+	// retried, while other sessions are connected to the template.
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// IsTemplate marks the database as a template other databases can be
+	// copied from
+	// +optional
+	IsTemplate bool `json:"isTemplate,omitempty"`
+
+	// InitScripts select ConfigMap keys, in the same namespace, holding SQL
+	// run in order as the owner right after the database is created. A
+	// database whose scripts fail is dropped and created again on the next
+	// attempt.
+	// +optional
+	InitScripts []corev1.ConfigMapKeySelector `json:"initScripts,omitempty"`
+
+	// ReclaimPolicy decides whether deleting the resource drops the
+	// database. Defaults to Retain.
+	// +optional
+	ReclaimPolicy ReclaimPolicy `json:"reclaimPolicy,omitempty"`
+}
+
+// ReclaimPolicy decides what happens in Postgres when a resource managing a
+// database, role or extension is deleted
+// +kubebuilder:validation:Enum=Retain;Delete
+type ReclaimPolicy string
+
+const (
+	// ReclaimRetain stops managing the object and leaves it in place
+	ReclaimRetain ReclaimPolicy = "Retain"
+	// ReclaimDelete drops the object
+	ReclaimDelete ReclaimPolicy = "Delete"
+)
+
+// DatabaseStatus defines the observed state of Database
+type DatabaseStatus struct {
+	// CredentialsSecret is the Secret, named <name>-app, applications use
+	// to connect to the database
+	// +optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+
+	// CredentialsSecretVersion is the resource version of the credentials
+	// Secret whose password was last applied to the owner
+	// +optional
+	CredentialsSecretVersion string `json:"credentialsSecretVersion,omitempty"`
+
+	// Conditions report whether the database has been applied to the
+	// instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Database is the Schema for the databases API
+type Database struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseSpec   `json:"spec,omitempty"`
+	Status DatabaseStatus `json:"status,omitempty"`
+}
+
+// DatabaseName is the name of the database in Postgres
+func (d *Database) DatabaseName() string {
+	if d.Spec.Name != "" {
+		return d.Spec.Name
+	}
+	return d.Name
+}
+
+// OwnerName is the role owning the database
+func (d *Database) OwnerName() string {
+	if d.Spec.Owner != "" {
+		return d.Spec.Owner
+	}
+	return d.DatabaseName()
+}
+
+//+kubebuilder:object:root=true
+
+// DatabaseList contains a list of Database
+type DatabaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Database `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Database{}, &DatabaseList{})
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// databaseValidator checks Databases against the instance they live on,
+// which the plain webhook.Validator has no way of looking up
+type databaseValidator struct {
+	client client.Reader
+}
+
+func (r *Database) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&databaseValidator{client: mgr.GetAPIReader()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-database-db-example-com-v1-database,mutating=false,failurePolicy=fail,sideEffects=None,groups=database.db.example.com,resources=databases,verbs=create;update,versions=v1,name=vdatabase.kb.io,admissionReviewVersions=v1
+
+var _ admission.CustomValidator = &databaseValidator{}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *databaseValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return v.validate(ctx, obj.(*Database))
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *databaseValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	return v.validate(ctx, newObj.(*Database))
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v *databaseValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (v *databaseValidator) validate(ctx context.Context, database *Database) error {
+	var pg Postgresql
+	err := v.client.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: database.Spec.InstanceRef.Name}, &pg)
+	if apierrors.IsNotFound(err) {
+		// The instance may well be created after its databases
+		return database.validateLocale(nil)
+	}
+	if err != nil {
+		return err
+	}
+	return database.validateLocale(pg.Status.LocaleSupport)
+}
+
+// validateLocale rejects locale settings CREATE DATABASE would fail on.
+// Without the instance's locale support, only settings that cannot work
+// anywhere are rejected.
+func (r *Database) validateLocale(support *LocaleSupport) error {
+	spec := r.Spec
+	icu := spec.LocaleProvider == "icu"
+	if icu && spec.ICULocale == "" {
+		return fmt.Errorf("localeProvider icu needs icuLocale")
+	}
+	if !icu && spec.ICULocale != "" {
+		return fmt.Errorf("icuLocale needs localeProvider icu")
+	}
+	if support == nil {
+		return nil
+	}
+
+	if icu {
+		if !support.ICU {
+			return fmt.Errorf("instance %s is not built with ICU", spec.InstanceRef.Name)
+		}
+		if catalog.Compare(catalog.Major(support.Version), "15") < 0 {
+			return fmt.Errorf("instance %s runs Postgres %s, ICU database locales need 15 or later",
+				spec.InstanceRef.Name, support.Version)
+		}
+	}
+	for _, locale := range []string{spec.LCCollate, spec.LCCtype} {
+		if locale != "" && !hasLocale(support.Locales, locale) {
+			return fmt.Errorf("instance %s has no locale %s", spec.InstanceRef.Name, locale)
+		}
+	}
+	return nil
+}
+
+// hasLocale looks a locale up the way glibc does, which accepts en_US.UTF-8
+// for en_US.utf8
+func hasLocale(locales []string, locale string) bool {
+	normalize := func(locale string) string {
+		return strings.ReplaceAll(strings.ToLower(locale), "-", "")
+	}
+	for _, available := range locales {
+		if normalize(available) == normalize(locale) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import "testing"
+
+func TestValidateLocale(t *testing.T) {
+	support := &LocaleSupport{Version: "15.4", Locales: []string{"C", "POSIX", "en_US.utf8"}, ICU: true}
+	tests := []struct {
+		name    string
+		spec    DatabaseSpec
+		support *LocaleSupport
+		wantErr bool
+	}{
+		{"no locale", DatabaseSpec{}, support, false},
+		{"available libc locale", DatabaseSpec{LCCollate: "en_US.UTF-8", LCCtype: "C"}, support, false},
+		{"missing libc locale", DatabaseSpec{LCCollate: "de_DE.utf8"}, support, true},
+		{"unknown support", DatabaseSpec{LCCollate: "de_DE.utf8"}, nil, false},
+		{"icu", DatabaseSpec{LocaleProvider: "icu", ICULocale: "de-DE"}, support, false},
+		{"icu without locale", DatabaseSpec{LocaleProvider: "icu"}, nil, true},
+		{"icu locale without provider", DatabaseSpec{ICULocale: "de-DE"}, nil, true},
+		{"icu on 14", DatabaseSpec{LocaleProvider: "icu", ICULocale: "de-DE"}, &LocaleSupport{Version: "14.9", ICU: true}, true},
+		{"icu not built in", DatabaseSpec{LocaleProvider: "icu", ICULocale: "de-DE"}, &LocaleSupport{Version: "16.1"}, true},
+	}
+	for _, tt := range tests {
+		database := &Database{Spec: tt.spec}
+		if err := database.validateLocale(tt.support); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateLocale() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ExtensionSpec defines the desired state of Extension
+type ExtensionSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the extension
+	// is installed on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Database the extension is installed in
+	Database string `json:"database"`
+
+	// Name of the extension. Defaults to the name of the resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Version of the extension. Changing it runs ALTER EXTENSION UPDATE.
+	// Defaults to the version the instance installs by default.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Schema the extension's objects are created in
+	// +optional
+	Schema string `json:"schema,omitempty"`
+
+	// ReclaimPolicy decides whether deleting the resource drops the
+	// extension. Defaults to Retain. Dropping fails, and is retried, while
+	// objects in the database still depend on the extension.
+	// +optional
+	ReclaimPolicy ReclaimPolicy `json:"reclaimPolicy,omitempty"`
+}
+
+// ExtensionStatus defines the observed state of Extension
+type ExtensionStatus struct {
+	// InstalledVersion is the version of the extension in the database
+	// +optional
+	InstalledVersion string `json:"installedVersion,omitempty"`
+
+	// Conditions report whether the extension has been applied to the
+	// instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Extension is the Schema for the extensions API
+type Extension struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ExtensionSpec   `json:"spec,omitempty"`
+	Status ExtensionStatus `json:"status,omitempty"`
+}
+
+// ExtensionName is the name of the extension in Postgres
+func (e *Extension) ExtensionName() string {
+	if e.Spec.Name != "" {
+		return e.Spec.Name
+	}
+	return e.Name
+}
+
+//+kubebuilder:object:root=true
+
+// ExtensionList contains a list of Extension
+type ExtensionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Extension `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Extension{}, &ExtensionList{})
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ForeignServerSpec defines the desired state of ForeignServer
+type ForeignServerSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the server is
+	// defined on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Database the server is defined in
+	Database string `json:"database"`
+
+	// Name of the server in Postgres. Defaults to the name of the resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Wrapper is the foreign data wrapper of the server. postgres_fdw is
+	// installed in the database when it is missing.
+	// +kubebuilder:default=postgres_fdw
+	// +optional
+	Wrapper string `json:"wrapper,omitempty"`
+
+	// Options of the server, e.g. host, port and dbname for postgres_fdw
+	// +optional
+	Options map[string]string `json:"options,omitempty"`
+
+	// UserMappings map local roles to credentials on the foreign server
+	// +optional
+	UserMappings []UserMapping `json:"userMappings,omitempty"`
+}
+
+// UserMapping gives a local role the credentials it uses on a foreign server
+type UserMapping struct {
+	// Role is the local role, or PUBLIC for every role
+	Role string `json:"role"`
+
+	// SecretRef names a Secret, in the same namespace, with the user and
+	// password keys to connect with
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// ForeignServerStatus defines the observed state of ForeignServer
+type ForeignServerStatus struct {
+	// Conditions report whether the server has been applied to the instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// ForeignServer is the Schema for the foreignservers API
+type ForeignServer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ForeignServerSpec   `json:"spec,omitempty"`
+	Status ForeignServerStatus `json:"status,omitempty"`
+}
+
+// ServerName is the name of the server in Postgres
+func (s *ForeignServer) ServerName() string {
+	if s.Spec.Name != "" {
+		return s.Spec.Name
+	}
+	return s.Name
+}
+
+//+kubebuilder:object:root=true
+
+// ForeignServerList contains a list of ForeignServer
+type ForeignServerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ForeignServer `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ForeignServer{}, &ForeignServerList{})
+}
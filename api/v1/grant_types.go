@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GrantSpec defines the desired state of Grant
+type GrantSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the privileges
+	// are granted on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	GrantTarget `json:",inline"`
+}
+
+// GrantTarget describes privileges held by a role. They apply to Tables
+// when any are listed, else to Schema when it is set, else to Database
+// itself. With DefaultPrivileges they apply to objects created later
+// instead.
+type GrantTarget struct {
+	// Role the privileges are granted to
+	Role string `json:"role"`
+
+	// Database holding the objects, or the database itself for
+	// database-level privileges
+	Database string `json:"database"`
+
+	// Schema holding the tables, or the schema itself for schema-level
+	// privileges
+	// +optional
+	Schema string `json:"schema,omitempty"`
+
+	// Tables in Schema the privileges apply to. "*" stands for every table
+	// in the schema, or in public without one.
+	// +optional
+	Tables []string `json:"tables,omitempty"`
+
+	// DefaultPrivileges grants the privileges on objects created from now
+	// on, in Schema when it is set, rather than on existing ones
+	// +optional
+	DefaultPrivileges *DefaultPrivileges `json:"defaultPrivileges,omitempty"`
+
+	// +kubebuilder:validation:MinItems=1
+	Privileges []Privilege `json:"privileges"`
+}
+
+// DefaultPrivileges selects the objects ALTER DEFAULT PRIVILEGES applies to
+type DefaultPrivileges struct {
+	// ForRole is the role creating the objects, typically the one running
+	// migrations
+	ForRole string `json:"forRole"`
+
+	// ObjectType of the objects
+	// +kubebuilder:validation:Enum=TABLES;SEQUENCES;FUNCTIONS;TYPES;SCHEMAS
+	ObjectType string `json:"objectType"`
+}
+
+// +kubebuilder:validation:Enum=ALL;SELECT;INSERT;UPDATE;DELETE;TRUNCATE;REFERENCES;TRIGGER;CREATE;CONNECT;TEMPORARY;EXECUTE;USAGE
+type Privilege string
+
+// GrantStatus defines the observed state of Grant
+type GrantStatus struct {
+	// Applied is the target last granted. It is what gets revoked when the
+	// target changes or the Grant is deleted.
+	// +optional
+	Applied *GrantTarget `json:"applied,omitempty"`
+
+	// Conditions report whether the privileges have been applied to the
+	// instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Grant is the Schema for the grants API
+type Grant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GrantSpec   `json:"spec,omitempty"`
+	Status GrantStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GrantList contains a list of Grant
+type GrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Grant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Grant{}, &GrantList{})
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GroupSyncSpec defines the desired state of GroupSync
+type GroupSyncSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the roles live on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Source the directory groups are read from
+	Source GroupSource `json:"source"`
+
+	// Groups maps directory groups to Postgres roles
+	// +kubebuilder:validation:MinItems=1
+	Groups []GroupMapping `json:"groups"`
+
+	// Interval between two syncs. Defaults to 5m.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// GroupSource is where the members of directory groups come from. Exactly
+// one of ConfigMapRef and LDAP is set.
+type GroupSource struct {
+	// ConfigMapRef names a ConfigMap, in the same namespace, with a key per
+	// group holding its members one per line. It suits directories the
+	// operator cannot search itself, such as OIDC providers, whose exports
+	// are expected to keep it up to date.
+	// +optional
+	ConfigMapRef *corev1.LocalObjectReference `json:"configMapRef,omitempty"`
+
+	// LDAP searches an LDAP directory for the groups
+	// +optional
+	LDAP *LDAPGroupSource `json:"ldap,omitempty"`
+}
+
+// LDAPGroupSource reads groups from an LDAP directory. A group is the entry
+// below BaseDN whose GroupAttribute is the name of the group, and its
+// members are the values of MemberAttribute. A member given as a DN, as in
+// groupOfNames, stands for the value of its first RDN: the member
+// uid=alice,ou=people,dc=example,dc=com is the role alice.
+type LDAPGroupSource struct {
+	// Server is the host name or address of the LDAP server
+	Server string `json:"server"`
+
+	// Port of the LDAP server. Defaults to 389, or 636 with the ldaps
+	// scheme.
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// Scheme is ldap or ldaps. Defaults to ldap.
+	// +kubebuilder:validation:Enum=ldap;ldaps
+	// +optional
+	Scheme string `json:"scheme,omitempty"`
+
+	// StartTLS upgrades ldap connections to TLS
+	// +optional
+	StartTLS bool `json:"startTLS,omitempty"`
+
+	// BaseDN is where the search for groups starts
+	BaseDN string `json:"baseDN"`
+
+	// BindDN is the entry the operator binds as to search. Without one it
+	// searches anonymously.
+	// +optional
+	BindDN string `json:"bindDN,omitempty"`
+
+	// BindPasswordSecretRef selects the Secret key holding the password of
+	// BindDN
+	// +optional
+	BindPasswordSecretRef *corev1.SecretKeySelector `json:"bindPasswordSecretRef,omitempty"`
+
+	// GroupAttribute holds the name of a group. Defaults to cn.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][-A-Za-z0-9]*$`
+	// +optional
+	GroupAttribute string `json:"groupAttribute,omitempty"`
+
+	// MemberAttribute holds the members of a group, e.g. memberUid for
+	// posixGroup entries. Defaults to member.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][-A-Za-z0-9]*$`
+	// +optional
+	MemberAttribute string `json:"memberAttribute,omitempty"`
+}
+
+// GroupMapping maps a directory group to a Postgres role
+type GroupMapping struct {
+	// Group in the source
+	Group string `json:"group"`
+
+	// Role whose members are kept in line with the group. It is created
+	// without LOGIN when missing. Defaults to the group name.
+	// +optional
+	Role string `json:"role,omitempty"`
+}
+
+// RoleName is the Postgres role the group maps to
+func (m GroupMapping) RoleName() string {
+	if m.Role != "" {
+		return m.Role
+	}
+	return m.Group
+}
+
+// GroupSyncStatus defines the observed state of GroupSync
+type GroupSyncStatus struct {
+	// LastSyncTime is when the groups were last synced successfully
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Conditions report whether the last sync succeeded
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// GroupSync is the Schema for the groupsyncs API
+type GroupSync struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GroupSyncSpec   `json:"spec,omitempty"`
+	Status GroupSyncStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GroupSyncList contains a list of GroupSync
+type GroupSyncList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GroupSync `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GroupSync{}, &GroupSyncList{})
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// validateClusterIPFamilies rejects IP families the cluster cannot give
+// Services, e.g. IPv6 on an IPv4-only cluster or RequireDualStack on a
+// single-stack one. Only the API server knows which families it has, so
+// it is asked to create a Service with the settings in dry-run mode.
+func (v *postgresqlValidator) validateClusterIPFamilies(ctx context.Context, pg, old *Postgresql) error {
+	if old != nil && reflect.DeepEqual(old.Spec.Service, pg.Spec.Service) {
+		return nil
+	}
+	for _, name := range []string{"rw", "ro", "r"} {
+		settings := pg.Spec.Service.Settings(name)
+		if settings.IPFamilyPolicy == nil && len(settings.IPFamilies) == 0 {
+			continue
+		}
+		svc := corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: pg.Name + "-", Namespace: pg.Namespace},
+			Spec: corev1.ServiceSpec{
+				IPFamilyPolicy: settings.IPFamilyPolicy,
+				IPFamilies:     settings.IPFamilies,
+				Ports:          []corev1.ServicePort{{Port: 5432, TargetPort: intstr.FromInt(5432)}},
+			},
+		}
+		if err := v.dryRun.Create(ctx, &svc, client.DryRunAll); err != nil {
+			return fmt.Errorf("spec.service: the cluster cannot give the %s Service these IP families: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// ipv4OnlyCluster rejects Services asking for IPv6, like the API server of
+// a cluster without IPv6 Service addresses
+type ipv4OnlyCluster struct {
+	client.Writer
+	created int
+}
+
+func (c *ipv4OnlyCluster) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.created++
+	for _, family := range obj.(*corev1.Service).Spec.IPFamilies {
+		if family == corev1.IPv6Protocol {
+			return errors.New(`spec.ipFamilies[0]: Invalid value: "IPv6": not configured on this cluster`)
+		}
+	}
+	return c.Writer.Create(ctx, obj, opts...)
+}
+
+func TestValidateClusterIPFamilies(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	cluster := &ipv4OnlyCluster{Writer: c}
+	v := &postgresqlValidator{client: c, dryRun: cluster}
+	ctx := context.Background()
+
+	pg := postgresqlWithVersion("", nil)
+	if err := v.ValidateCreate(ctx, pg); err != nil || cluster.created != 0 {
+		t.Fatalf("without IP families the cluster need not be asked, got %v after %d calls", err, cluster.created)
+	}
+
+	pg.Spec.Service = &ServiceSpec{ServiceSettings: ServiceSettings{IPFamilies: []corev1.IPFamily{corev1.IPv4Protocol}}}
+	if err := v.ValidateCreate(ctx, pg); err != nil {
+		t.Errorf("expected IPv4 to be accepted, got %v", err)
+	}
+
+	old := pg.DeepCopy()
+	pg.Spec.Service.Overrides = []ServiceOverride{{Name: "rw", ServiceSettings: ServiceSettings{
+		IPFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+	}}}
+	if err := v.ValidateUpdate(ctx, old, pg); err == nil {
+		t.Error("expected IPv6 on an IPv4-only cluster to be rejected")
+	}
+
+	var services corev1.ServiceList
+	if err := c.List(ctx, &services); err != nil || len(services.Items) != 0 {
+		t.Errorf("the check should not leave Services behind, got %d, %v", len(services.Items), err)
+	}
+}
+
+func TestValidateIPFamilies(t *testing.T) {
+	singleStack, dualStack := corev1.IPFamilyPolicySingleStack, corev1.IPFamilyPolicyPreferDualStack
+	tests := []struct {
+		settings ServiceSettings
+		valid    bool
+	}{
+		{ServiceSettings{IPFamilies: []corev1.IPFamily{corev1.IPv6Protocol}}, true},
+		{ServiceSettings{IPFamilies: []corev1.IPFamily{"IPv5"}}, false},
+		{ServiceSettings{IPFamilyPolicy: &dualStack, IPFamilies: []corev1.IPFamily{corev1.IPv6Protocol, corev1.IPv4Protocol}}, true},
+		{ServiceSettings{IPFamilyPolicy: &dualStack, IPFamilies: []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv4Protocol}}, false},
+		{ServiceSettings{IPFamilyPolicy: &singleStack, IPFamilies: []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}}, false},
+	}
+	for _, test := range tests {
+		if err := validateIPFamilies(test.settings); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.settings, test.valid, err)
+		}
+	}
+}
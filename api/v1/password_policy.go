@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"math"
+)
+
+// PasswordPolicy is what passwords handed to the operator, or generated by
+// it, must meet
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+
+	// MinEntropyBits is the minimum entropy, estimated from how often each
+	// character occurs, so that long passwords made of few distinct
+	// characters are rejected too
+	MinEntropyBits float64
+}
+
+// OperatorPasswordPolicy is the policy of the running operator, set from
+// its command line
+var OperatorPasswordPolicy = PasswordPolicy{MinLength: 12, MinEntropyBits: 36}
+
+// Check returns why a password does not meet the policy
+func (p PasswordPolicy) Check(password string) error {
+	runes := []rune(password)
+	if len(runes) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if entropy := PasswordEntropy(password); entropy < p.MinEntropyBits {
+		return fmt.Errorf("password is too easy to guess: %.0f bits of entropy, at least %.0f needed",
+			entropy, p.MinEntropyBits)
+	}
+	return nil
+}
+
+// PasswordEntropy estimates the entropy of a password in bits, as its
+// length times the Shannon entropy of its characters
+func PasswordEntropy(password string) float64 {
+	runes := []rune(password)
+	counts := map[rune]int{}
+	for _, r := range runes {
+		counts[r]++
+	}
+	var perChar float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(runes))
+		perChar -= p * math.Log2(p)
+	}
+	return perChar * float64(len(runes))
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 12, MinEntropyBits: 36}
+	tests := []struct {
+		password string
+		wantErr  bool
+	}{
+		{"7vQ-kd2Lw9xZ", false},
+		{"s3cr3t", true},
+		{"aaaaaaaaaaaaaaaaaaaa", true},
+		{"abababababababab", true},
+		{"J8fXq2rT0bHs5LmWcNe4yZuA", false},
+	}
+	for _, tt := range tests {
+		if err := policy.Check(tt.password); (err != nil) != tt.wantErr {
+			t.Errorf("Check(%q) = %v, wantErr %v", tt.password, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	weak := postgresqlWithVersion("", nil)
+	weak.Spec.Password = "postgres"
+	if err := weak.ValidateCreate(); err == nil {
+		t.Error("expected a weak password to be rejected on create")
+	}
+	// An instance keeps a password set before the policy until it changes
+	updated := weak.DeepCopy()
+	updated.Spec.Version = "14.9"
+	if err := updated.ValidateUpdate(weak); err != nil {
+		t.Errorf("unchanged password should be accepted, got %v", err)
+	}
+	updated.Spec.Password = "passwordpass"
+	if err := updated.ValidateUpdate(weak); err == nil {
+		t.Error("expected a weak new password to be rejected")
+	}
+}
+
+func TestValidatePasswordSecretRef(t *testing.T) {
+	pg := postgresqlWithVersion("", nil)
+	pg.Spec.Password = ""
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected an instance without any password to be rejected")
+	}
+	pg.Spec.PasswordSecretRef = &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "pg-superuser"}, Key: "password"}
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("password secret should be accepted, got %v", err)
+	}
+}
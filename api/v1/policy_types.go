@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PolicySpec defines the desired state of Policy
+type PolicySpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the table lives
+	// on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	PolicyTarget `json:",inline"`
+
+	// Command the policy applies to. Defaults to ALL.
+	// +kubebuilder:validation:Enum=ALL;SELECT;INSERT;UPDATE;DELETE
+	// +optional
+	Command string `json:"command,omitempty"`
+
+	// Restrictive policies have to pass on top of the permissive ones,
+	// rather than being one of the alternatives letting a row through
+	// +optional
+	Restrictive bool `json:"restrictive,omitempty"`
+
+	// Roles the policy applies to. Defaults to PUBLIC.
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// Using is the SQL expression rows have to satisfy to be visible, e.g.
+	// tenant_id = current_setting('app.tenant')::int
+	// +optional
+	Using string `json:"using,omitempty"`
+
+	// WithCheck is the SQL expression rows written have to satisfy
+	// +optional
+	WithCheck string `json:"withCheck,omitempty"`
+
+	// Force applies row level security to the table's owner too
+	// +optional
+	Force bool `json:"force,omitempty"`
+}
+
+// PolicyTarget identifies a row level security policy
+type PolicyTarget struct {
+	// Database holding the table
+	Database string `json:"database"`
+
+	// Schema holding the table. Defaults to public.
+	// +optional
+	Schema string `json:"schema,omitempty"`
+
+	// Table the policy is on. Row level security is enabled on it, and left
+	// enabled when the policy is deleted.
+	Table string `json:"table"`
+
+	// Name of the policy in Postgres. Defaults to the name of the resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// PolicyStatus defines the observed state of Policy
+type PolicyStatus struct {
+	// Applied is the policy last created. It is what gets dropped when the
+	// target changes or the Policy is deleted.
+	// +optional
+	Applied *PolicyTarget `json:"applied,omitempty"`
+
+	// Conditions report whether the policy has been applied to the instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Policy is the Schema for the policies API
+type Policy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PolicySpec   `json:"spec,omitempty"`
+	Status PolicyStatus `json:"status,omitempty"`
+}
+
+// Target is the policy the spec describes, with its defaults filled in
+func (p *Policy) Target() PolicyTarget {
+	target := p.Spec.PolicyTarget
+	if target.Schema == "" {
+		target.Schema = "public"
+	}
+	if target.Name == "" {
+		target.Name = p.Name
+	}
+	return target
+}
+
+//+kubebuilder:object:root=true
+
+// PolicyList contains a list of Policy
+type PolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Policy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Policy{}, &PolicyList{})
+}
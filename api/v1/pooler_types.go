@@ -0,0 +1,88 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PoolerSpec defines the desired state of Pooler
+type PoolerSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, PgBouncer pools
+	// connections to. PgBouncer connects to its primary through the -rw
+	// Service, so the pooler's pods have to be among the allowed CIDRs of
+	// its access spec, if there are any.
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Replicas is the number of PgBouncer pods. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	PoolSettings `json:",inline"`
+
+	// Resources of the PgBouncer container
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// PoolerStatus defines the observed state of Pooler
+type PoolerStatus struct {
+	// ReadyReplicas is the number of PgBouncer pods taking connections
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// Conditions report whether all the PgBouncer pods are ready
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.readyReplicas
+//+kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.spec.instanceRef.name`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Pooler runs PgBouncer in a Deployment of its own, in front of an
+// instance, and exposes it through a Service of the same name. Unlike the
+// sidecar of the instance, it can be scaled independently.
+type Pooler struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PoolerSpec   `json:"spec,omitempty"`
+	Status PoolerStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PoolerList contains a list of Pooler
+type PoolerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Pooler `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Pooler{}, &PoolerList{})
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Defaults filled in for a Postgresql that leaves them out
+var (
+	DefaultStorageSize = resource.MustParse("1Gi")
+	DefaultResources   = corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("256Mi"),
+	}}
+)
+
+//+kubebuilder:webhook:path=/mutate-database-db-example-com-v1-postgresql,mutating=true,failurePolicy=fail,sideEffects=None,groups=database.db.example.com,resources=postgresqls,verbs=create;update,versions=v1,name=mpostgresql.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Postgresql{}
+
+// Default implements webhook.Defaulter so a Postgresql with nothing but a
+// name makes a working instance
+func (r *Postgresql) Default() {
+	postgresqllog.Info("default", "name", r.Name)
+
+	// Nothing else applies to a server the operator does not run
+	if external := r.Spec.External; external != nil {
+		if external.Port == 0 {
+			external.Port = 5432
+		}
+		if external.SSLMode == "" {
+			external.SSLMode = "require"
+		}
+		return
+	}
+	if r.Spec.DefaultUser == "" {
+		r.Spec.DefaultUser = "postgres"
+	}
+	if r.Spec.Version == "" {
+		r.Spec.Version = operatorconfig.Get().DefaultVersion
+	}
+	// The operator generates the password of the <name>-superuser Secret
+	if r.Spec.Password == "" && r.Spec.PasswordSecretRef == nil {
+		r.Spec.PasswordSecretRef = &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: r.Name + "-superuser"},
+			Key:                  corev1.BasicAuthPasswordKey,
+		}
+	}
+	if r.Spec.Citus != nil && r.Spec.Citus.Database == "" {
+		r.Spec.Citus.Database = "postgres"
+	}
+	if r.Spec.Storage != nil && r.Spec.Storage.Size.IsZero() {
+		r.Spec.Storage.Size = DefaultStorageSize.DeepCopy()
+	}
+	for i := range r.Spec.Tablespaces {
+		if storage := &r.Spec.Tablespaces[i].Storage; storage.Size.IsZero() {
+			storage.Size = DefaultStorageSize.DeepCopy()
+		}
+	}
+	if r.Spec.Resources == nil {
+		r.Spec.Resources = DefaultResources.DeepCopy()
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDefault(t *testing.T) {
+	pg := &Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "pg"}}
+	pg.Spec.Storage = &StorageSpec{}
+	pg.Default()
+
+	if pg.Spec.DefaultUser != "postgres" || pg.Spec.Version != catalog.DefaultVersion {
+		t.Errorf("unexpected defaults %+v", pg.Spec)
+	}
+	if ref := pg.Spec.PasswordSecretRef; ref == nil || ref.Name != "pg-superuser" || ref.Key != corev1.BasicAuthPasswordKey {
+		t.Errorf("password should default to the superuser secret, got %+v", ref)
+	}
+	if !pg.Spec.Storage.Size.Equal(resource.MustParse("1Gi")) {
+		t.Errorf("storage size should default to 1Gi, got %s", pg.Spec.Storage.Size.String())
+	}
+	if pg.Spec.Resources == nil || pg.Spec.Resources.Requests.Memory().String() != "256Mi" {
+		t.Errorf("resource requests should be defaulted, got %+v", pg.Spec.Resources)
+	}
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("defaulted Postgresql should be valid, got %v", err)
+	}
+
+	pg = &Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "pg"}}
+	pg.Spec.Version = "15.4"
+	pg.Spec.Password = "7vQ-kd2Lw9xZ"
+	pg.Default()
+	if pg.Spec.Version != "15.4" || pg.Spec.PasswordSecretRef != nil {
+		t.Errorf("fields set should be kept, got %+v", pg.Spec)
+	}
+}
+
+func TestDefaultExternal(t *testing.T) {
+	pg := &Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "pg"}}
+	pg.Spec.External = &ExternalSpec{Host: "pg.example.rds.amazonaws.com", AdminSecretRef: corev1.LocalObjectReference{Name: "rds-admin"}}
+	pg.Default()
+
+	if pg.Spec.External.Port != 5432 || pg.Spec.External.SSLMode != "require" {
+		t.Errorf("unexpected defaults %+v", pg.Spec.External)
+	}
+	if pg.Spec.Version != "" || pg.Spec.PasswordSecretRef != nil || pg.Spec.Resources != nil {
+		t.Errorf("settings of a server the operator runs should not be defaulted, got %+v", pg.Spec)
+	}
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("defaulted external Postgresql should be valid, got %v", err)
+	}
+
+	old := pg.DeepCopy()
+	pg.Spec.Storage = &StorageSpec{}
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected storage for an external server to be rejected")
+	}
+	pg.Spec.Storage = nil
+	pg.Spec.External = nil
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected clearing spec.external to be rejected")
+	}
+}
@@ -18,6 +18,8 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,19 +27,394 @@ import (
 
 // PostgresqlSpec defines the desired state of Postgresql
 type PostgresqlSpec struct {
-	DefaultUser string `json:"defaultuser"`
+	// External has the operator manage databases, roles, grants and
+	// extensions on an existing server, e.g. on Amazon RDS or Cloud SQL,
+	// rather than run one. Settings of the server the operator would run
+	// do not apply. It cannot be set or cleared after creation.
+	// +optional
+	External *ExternalSpec `json:"external,omitempty"`
 
-	Password string `json:"password"`
+	// DefaultUser cannot be changed after creation. Defaults to postgres.
+	// +optional
+	DefaultUser string `json:"defaultuser,omitempty"`
+
+	// Password of the superuser. Deprecated: the operator moves it into
+	// the <name>-superuser Secret, points PasswordSecretRef at it and
+	// clears this field, as anyone able to read the resource could read
+	// the password.
+	// +optional
+	Password string `json:"password,omitempty"`
+
+	// PasswordSecretRef selects the key of a Secret, in the same namespace,
+	// holding the password of the superuser. A change to the Secret is
+	// applied to the running instance. Defaults to the <name>-superuser
+	// Secret, which the operator creates with a generated password if it
+	// does not exist.
+	// +optional
+	PasswordSecretRef *corev1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+
+	// Version of Postgres to run, e.g. 14.5. Changing it within the same
+	// major version upgrades the running instance in place, moving to a new
+	// major version runs pg_upgrade against the data volume and needs
+	// Storage.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// ImageRepository is the repository of the Postgres image, tagged with
+	// the version. Defaults to the official postgres image. Images adding
+	// extensions, e.g. pg_cron, can be used as long as they follow the same
+	// tags. A change restarts the server on the new image within the
+	// maintenance window.
+	// +optional
+	ImageRepository string `json:"imageRepository,omitempty"`
+
+	// Resources of the container running the server. Defaults to
+	// requests of 100m CPU and 256Mi of memory. A change takes effect when
+	// the pod is next recreated.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Parameters are Postgres settings passed on the server command line,
+	// e.g. shared_preload_libraries. A change takes effect when the instance
+	// is next restarted, see RestartedAtAnnotation.
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// UpdatePolicy controls whether the operator moves the instance to new
+	// patch releases of its major version by itself
+	// +optional
+	UpdatePolicy UpdatePolicy `json:"updatePolicy,omitempty"`
+
+	// TLS configures SSL for client connections. It is on by default, with
+	// a certificate signed by a CA the operator generates for the instance.
+	// A change takes effect when the pod is next recreated; renewed
+	// certificates are picked up without a restart.
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// LocalOnly has Postgres listen on localhost only and creates no
+	// Services, for clients running in the same pod, e.g. as sidecars. The
+	// operator reaches the instance through port forwarding; Jobs that
+	// connect through the Services, such as the seed, are not available. A
+	// change takes effect when the pod is next recreated.
+	// +optional
+	LocalOnly bool `json:"localOnly,omitempty"`
+
+	// Access restricts where clients may connect from
+	// +optional
+	Access *AccessSpec `json:"access,omitempty"`
+
+	// Service configures how the Services of the instance expose it. By
+	// default they are of type ClusterIP, reachable from inside the cluster
+	// only.
+	// +optional
+	Service *ServiceSpec `json:"service,omitempty"`
+
+	// Authentication configures how client roles prove who they are, on top
+	// of the passwords and client certificates the operator manages
+	// +optional
+	Authentication *AuthenticationSpec `json:"authentication,omitempty"`
+
+	// Vault configures the database secrets engine of a HashiCorp Vault
+	// against the instance, so applications get short-lived credentials
+	// from Vault rather than static Secrets
+	// +optional
+	Vault *VaultSpec `json:"vault,omitempty"`
+
+	// ReadOnly freezes writes, e.g. during a migration or an incident: new
+	// transactions are read-only unless a session asks otherwise. It takes
+	// effect without a restart, unless Parameters sets
+	// default_transaction_read_only, which then wins. Resources the operator
+	// manages through SQL fail to apply while the instance is read-only.
+	// +optional
+	ReadOnly *ReadOnlySpec `json:"readOnly,omitempty"`
+
+	// ServiceAccount configures the ServiceAccount of its own the pod of
+	// the instance runs under
+	// +optional
+	ServiceAccount *ServiceAccountSpec `json:"serviceAccount,omitempty"`
+
+	// SecurityProfiles confines the containers of the pod with seccomp and
+	// AppArmor. A change takes effect when the pod is next recreated.
+	// +optional
+	SecurityProfiles *SecurityProfiles `json:"securityProfiles,omitempty"`
+
+	// Compliance holds settings regulated environments ask for
+	// +optional
+	Compliance *ComplianceSpec `json:"compliance,omitempty"`
+
+	// Audit logs statements with pgaudit into a channel of their own: the
+	// audit container of the pod, which can ship the records on. It needs an
+	// image with pgaudit, see ImageRepository. A change takes effect when
+	// the pod is next recreated.
+	// +optional
+	Audit *AuditSpec `json:"audit,omitempty"`
+
+	// Pooler runs PgBouncer in front of the instance. A change takes effect
+	// when the pod is next recreated.
+	// +optional
+	Pooler *InstancePoolerSpec `json:"pooler,omitempty"`
+
+	// Bootstrap configures what happens once the instance first comes up
+	// +optional
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// PrivilegeAudit periodically looks for privileges on the instance that
+	// no Grant declares
+	// +optional
+	PrivilegeAudit *PrivilegeAuditSpec `json:"privilegeAudit,omitempty"`
+
+	// UpgradeHooks are SQL scripts run once the instance is up on a new
+	// version, e.g. ALTER EXTENSION ... UPDATE or a REINDEX of indexes on
+	// collations that changed
+	// +optional
+	UpgradeHooks []UpgradeHook `json:"upgradeHooks,omitempty"`
+
+	// MaintenanceWindow restricts restarts and upgrades to a recurring
+	// period. Without one they happen as soon as they are needed.
+	// +optional
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+
+	// Storage puts the data directory on a PersistentVolumeClaim. Without it
+	// the data lives in an emptyDir and is lost whenever the pod goes away.
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+
+	// Tablespaces are created on volumes of their own, so hot tables can be
+	// put on faster storage classes. Adding one takes effect when the pod
+	// is next recreated.
+	// +optional
+	Tablespaces []TablespaceSpec `json:"tablespaces,omitempty"`
+
+	// Hibernate removes the database pod while keeping its volume claim, so
+	// an idle instance stops consuming compute. Clearing it resumes the
+	// instance on the same data. It requires Storage.
+	// +optional
+	Hibernate bool `json:"hibernate,omitempty"`
+
+	// HibernationSchedule hibernates and resumes the instance on a recurring
+	// schedule, e.g. to stop it at night and over weekends. Hibernate takes
+	// precedence and keeps the instance down regardless of the schedule.
+	// It requires Storage.
+	// +optional
+	HibernationSchedule *HibernationSchedule `json:"hibernationSchedule,omitempty"`
+
+	// DrainTimeout enables connection draining before restarts, upgrades and
+	// hibernation. The pod is taken out of its Services first and running
+	// transactions get this long to finish before the remaining client
+	// connections are terminated. Without it connections are cut right away.
+	// +optional
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+
+	// Affinity controls how the instance's pods are spread over nodes.
+	// Without it they prefer not to share a node.
+	// +optional
+	Affinity *AffinitySpec `json:"affinity,omitempty"`
+
+	// Replication configures where the instance's pods are placed
+	// +optional
+	Replication *ReplicationSpec `json:"replication,omitempty"`
+
+	// Citus makes the instance the coordinator of a Citus cluster, which
+	// shards distributed tables over worker instances. The image has to
+	// ship the citus extension, see ImageRepository.
+	// +optional
+	Citus *CitusSpec `json:"citus,omitempty"`
+
+	// PGVector enables the pgvector extension for vector similarity search.
+	// The instance runs the operator's pgvector image unless it sets
+	// ImageRepository, and gets more memory for building vector indexes
+	// unless Parameters say otherwise. Enabling it on a running instance
+	// restarts the server on the pgvector image within the maintenance
+	// window; the extension is created once it runs there.
+	// +optional
+	PGVector *PGVectorSpec `json:"pgvector,omitempty"`
+}
+
+// PGVectorSpec configures where the vector extension is created
+type PGVectorSpec struct {
+	// Databases the vector extension is created in, on top of postgres and
+	// template1, which databases created later copy it from
+	// +optional
+	Databases []string `json:"databases,omitempty"`
+}
+
+// CitusSpec configures the workers of a Citus coordinator
+type CitusSpec struct {
+	// Workers is the number of worker instances, named <name>-worker-<n>.
+	// They take the version, image, resources, parameters and storage of
+	// the coordinator. A worker is only removed once Citus has moved its
+	// shards elsewhere, e.g. with citus_drain_node.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=32
+	Workers int32 `json:"workers"`
+
+	// Database the citus extension is created in on every node, and the
+	// one distributed tables live in. Defaults to postgres.
+	// +optional
+	Database string `json:"database,omitempty"`
+}
+
+// ReplicationSpec holds the placement policy for the primary and its
+// standbys
+type ReplicationSpec struct {
+	// ZoneSpread requires every pod of the instance to land in a different
+	// topology.kubernetes.io/zone. Pods that cannot be placed that way stay
+	// pending and the Degraded condition is set.
+	// +optional
+	ZoneSpread bool `json:"zoneSpread,omitempty"`
 }
 
+// AffinitySpec configures the anti-affinity between the pods of an instance,
+// so the primary and its standbys do not fail together.
+type AffinitySpec struct {
+	// PodAntiAffinityType is "preferred" to spread pods where the scheduler
+	// can, "required" to leave pods pending rather than co-locate them, or
+	// "disabled".
+	// +kubebuilder:validation:Enum=preferred;required;disabled
+	// +kubebuilder:default=preferred
+	// +optional
+	PodAntiAffinityType PodAntiAffinityType `json:"podAntiAffinityType,omitempty"`
+
+	// TopologyKey is the node label pods are spread over. Use
+	// topology.kubernetes.io/zone to keep them in different zones.
+	// +kubebuilder:default="kubernetes.io/hostname"
+	// +optional
+	TopologyKey string `json:"topologyKey,omitempty"`
+
+	// NodeSelector restricts the pods to nodes with these labels
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+type PodAntiAffinityType string
+
+const (
+	PodAntiAffinityPreferred PodAntiAffinityType = "preferred"
+	PodAntiAffinityRequired  PodAntiAffinityType = "required"
+	PodAntiAffinityDisabled  PodAntiAffinityType = "disabled"
+)
+
+// HibernationSchedule is a pair of cron expressions (minute hour
+// day-of-month month day-of-week). They are evaluated in UTC unless prefixed
+// with CRON_TZ=<zone>.
+type HibernationSchedule struct {
+	// Stop is when the instance is hibernated
+	Stop string `json:"stop"`
+
+	// Start is when the instance is resumed
+	Start string `json:"start"`
+}
+
+// StorageSpec describes the volume claimed for the data directory
+type StorageSpec struct {
+	// Size of the volume. Defaults to 1Gi.
+	// +optional
+	Size resource.Quantity `json:"size,omitempty"`
+
+	// StorageClassName of the claim, the cluster default is used when empty.
+	// It cannot be changed once the claim exists.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// TablespaceSpec is a tablespace on a dedicated volume
+type TablespaceSpec struct {
+	// Name of the tablespace. Names starting with pg_ are reserved.
+	// +kubebuilder:validation:Pattern=`^[a-z_][a-z0-9_]*$`
+	// +kubebuilder:validation:MaxLength=40
+	Name string `json:"name"`
+
+	// Owner of the tablespace. Defaults to the superuser.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// Storage is the volume the tablespace lives on
+	Storage StorageSpec `json:"storage"`
+}
+
+// UpdatePolicy decides who moves an instance to new patch releases
+// +kubebuilder:validation:Enum=Manual;AutoPatch
+type UpdatePolicy string
+
+const (
+	// UpdatePolicyManual only changes version when Spec.Version changes
+	UpdatePolicyManual UpdatePolicy = "Manual"
+	// UpdatePolicyAutoPatch follows the newest patch release in the
+	// operator's image catalog
+	UpdatePolicyAutoPatch UpdatePolicy = "AutoPatch"
+)
+
+// MaintenanceWindow is a recurring period in which the operator may disrupt
+// the instance
+type MaintenanceWindow struct {
+	// Days on which the window opens, every day when empty
+	// +optional
+	Days []Weekday `json:"days,omitempty"`
+
+	// Start is the time of day the window opens, as HH:MM in UTC
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// Duration the window stays open for
+	Duration metav1.Duration `json:"duration"`
+}
+
+// Weekday is a day of the week in its three letter form
+// +kubebuilder:validation:Enum=Mon;Tue;Wed;Thu;Fri;Sat;Sun
+type Weekday string
+
+// ForceMaintenanceAnnotation lets deferred restarts and upgrades run outside
+// the maintenance window while it is set to "true"
+const ForceMaintenanceAnnotation = "db.example.com/force-maintenance"
+
 type PgPhase string
 
 const (
 	PgUp      PgPhase = "up"
 	PgPending PgPhase = "pending"
 	PgFailed  PgPhase = "Failed"
+	PgFenced  PgPhase = "Fenced"
+
+	PgHibernated PgPhase = "Hibernated"
+	PgUpgrading  PgPhase = "Upgrading"
 )
 
+// FencedAnnotation marks a Postgresql as fenced when set to "true". A fenced
+// instance is taken out of every Service selector and its pod is not
+// recreated until the annotation is removed, so a primary that may have
+// diverged can never be exposed alongside its replacement. The operator
+// sets it on the old primary when it promotes a standby, and never removes
+// it: remove the annotation only once the data directory has been
+// pg_rewind'd or reinitialized.
+const FencedAnnotation = "db.example.com/fenced"
+
+// PromoteAnnotation names the instance pod that should become the primary,
+// for runbooks that drive recovery by hand. Only a standby can be promoted;
+// naming the current primary is a no-op. The operator does not run standbys
+// yet, so a standby is a pod labelled with the instance and the replica
+// role. Promoting one fences the instance first, see FencedAnnotation. The
+// operator removes the annotation once it has handled the request, and
+// records an event.
+const PromoteAnnotation = "db.example.com/promote"
+
+// ReconcileAnnotation set to ReconcilePaused makes the operator observe the
+// instance without changing anything, e.g. during incident response or
+// manual repairs. The status keeps being updated.
+const ReconcileAnnotation = "db.example.com/reconcile"
+
+const ReconcilePaused = "paused"
+
+// RestartedAtAnnotation requests a restart of the instance whenever its value
+// changes, e.g. to apply parameters that need one. By convention the value is
+// a timestamp, as set by `kubectl rollout restart`. Instances without
+// Storage cannot be restarted, as their data would go with the pod.
+const RestartedAtAnnotation = "db.example.com/restartedAt"
+
+// PhaseLabel mirrors the status phase onto the Postgresql, so instances can
+// be selected by phase, e.g. `kubectl get pg -l db.example.com/phase=Down`
+const PhaseLabel = "db.example.com/phase"
+
 // PostgresqlStatus defines the observed state of Postgresql
 type PostgresqlStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
@@ -46,10 +423,770 @@ type PostgresqlStatus struct {
 	Phase PgPhase `json:"pgPhase,omitempty"`
 
 	Active corev1.ObjectReference `json:"active,omitempty"`
+
+	// NextScheduledTransition is when the hibernation schedule will next
+	// stop or start the instance
+	NextScheduledTransition *metav1.Time `json:"nextScheduledTransition,omitempty"`
+
+	// Version of Postgres the instance is running
+	Version string `json:"version,omitempty"`
+
+	// ImageDigest is the digest the image of the running version resolved
+	// to. Pods are pinned to it so restarts cannot pick up a moved tag.
+	ImageDigest string `json:"imageDigest,omitempty"`
+
+	// UpgradeHooksVersion is the version the upgrade hooks last ran for
+	// +optional
+	UpgradeHooksVersion string `json:"upgradeHooksVersion,omitempty"`
+
+	// UpgradeHookResults are the outcomes of the upgrade hooks run for
+	// UpgradeHooksVersion
+	// +optional
+	UpgradeHookResults []UpgradeHookResult `json:"upgradeHookResults,omitempty"`
+
+	// PrivilegeAudit is the outcome of the last privilege audit
+	// +optional
+	PrivilegeAudit *PrivilegeAudit `json:"privilegeAudit,omitempty"`
+
+	// TLS reports the certificates of the instance
+	// +optional
+	TLS *TLSStatus `json:"tls,omitempty"`
+
+	// LDAPCheckTime is when the LDAP server was last checked, see
+	// ConditionLDAPReachable
+	// +optional
+	LDAPCheckTime *metav1.Time `json:"ldapCheckTime,omitempty"`
+
+	// LocaleSupport lists the locales databases on the instance can use
+	// +optional
+	LocaleSupport *LocaleSupport `json:"localeSupport,omitempty"`
+
+	// Citus reports the workers of a Citus coordinator and their shards
+	// +optional
+	Citus *CitusStatus `json:"citus,omitempty"`
+
+	// Endpoints are where clients outside the cluster reach the instance,
+	// one for each of its Services of type LoadBalancer
+	// +optional
+	// +listType=map
+	// +listMapKey=service
+	Endpoints []Endpoint `json:"endpoints,omitempty"`
+
+	// Conditions report the progress of longer running operations
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// TLSSpec configures the server certificate of an instance
+type TLSSpec struct {
+	// Disabled turns SSL off altogether
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// CertManager has cert-manager issue and renew the server certificate
+	// instead of the operator
+	// +optional
+	CertManager *CertManagerTLS `json:"certManager,omitempty"`
+}
+
+// TLSStatus tracks the expiry of the instance's certificates. A renewal has
+// been picked up once ServedNotAfter catches up with SecretNotAfter.
+type TLSStatus struct {
+	// SecretVersion is the resource version of the server TLS Secret the
+	// other fields were last checked against
+	SecretVersion string `json:"secretVersion"`
+
+	// SecretNotAfter is the expiry of the certificate in the server TLS
+	// Secret
+	// +optional
+	SecretNotAfter *metav1.Time `json:"secretNotAfter,omitempty"`
+
+	// ServedNotAfter is the expiry of the certificate the server presents
+	// to clients
+	// +optional
+	ServedNotAfter *metav1.Time `json:"servedNotAfter,omitempty"`
+
+	// CANotAfter is the expiry of the operator-managed CA
+	// +optional
+	CANotAfter *metav1.Time `json:"caNotAfter,omitempty"`
+
+	// CABundle is the ConfigMap holding, under ca.crt, the CA clients
+	// verify the server certificate with
+	// +optional
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+// CertManagerTLS selects the cert-manager issuer of the server certificate
+type CertManagerTLS struct {
+	// IssuerRef is the Issuer or ClusterIssuer signing the certificate
+	IssuerRef IssuerReference `json:"issuerRef"`
+}
+
+// IssuerReference refers to a cert-manager issuer, mirroring the type of
+// the same name in cert-manager's API
+type IssuerReference struct {
+	Name string `json:"name"`
+
+	// Kind of the issuer. Defaults to Issuer.
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Group of the issuer. Defaults to cert-manager.io.
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// AccessSpec limits the client addresses allowed to connect, both in
+// pg_hba.conf and, optionally, with a NetworkPolicy
+type AccessSpec struct {
+	// AllowedCIDRs are the address ranges clients may connect from, e.g.
+	// 10.0.0.0/8. Without any, clients may connect from anywhere. The
+	// superuser stays allowed from anywhere in pg_hba.conf, as the operator
+	// and its Jobs connect as it.
+	// +optional
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+
+	// NetworkPolicy creates the <name>-access NetworkPolicy, letting in
+	// only AllowedCIDRs, Peers, the operator and the instance's own Jobs.
+	// An operator started with --default-deny-network creates it for every
+	// instance.
+	// +optional
+	NetworkPolicy bool `json:"networkPolicy,omitempty"`
+
+	// Peers are the workloads the NetworkPolicy lets in, e.g. the pods of
+	// an application selected by label
+	// +optional
+	Peers []networkingv1.NetworkPolicyPeer `json:"peers,omitempty"`
+}
+
+// ExternalSpec points at a server the operator does not run
+type ExternalSpec struct {
+	// Host name or address of the server
+	// +kubebuilder:validation:MinLength=1
+	Host string `json:"host"`
+
+	// Port of the server. Defaults to 5432.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// AdminSecretRef names a Secret, in the same namespace, with the
+	// username and password keys of a role that may create databases and
+	// roles, e.g. the master user of RDS. The operator connects as it
+	// wherever it would connect as the superuser.
+	AdminSecretRef corev1.LocalObjectReference `json:"adminSecretRef"`
+
+	// SSLMode of the connections to the server. Defaults to require.
+	// +kubebuilder:validation:Enum=disable;require
+	// +optional
+	SSLMode string `json:"sslMode,omitempty"`
+}
+
+// ServiceSpec configures the -rw, -ro and -r Services of an instance. Clients
+// outside the cluster still have to be allowed by the access spec, which
+// sees the address they come from unless the Service is set to keep it.
+type ServiceSpec struct {
+	ServiceSettings `json:",inline"`
+
+	// Overrides change the settings of single Services, e.g. to expose only
+	// the primary outside the cluster. Settings an override leaves empty
+	// are taken from the ones above; its annotations are added to theirs.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Overrides []ServiceOverride `json:"overrides,omitempty"`
+
+	// Additional Services to create besides -rw, -ro and -r, e.g. to expose
+	// a metrics endpoint or only the primary for replication. They do not
+	// take the settings above.
+	// +optional
+	// +listType=map
+	// +listMapKey=suffix
+	Additional []AdditionalService `json:"additional,omitempty"`
+}
+
+// AdditionalService is a Service of an instance declared in its spec
+type AdditionalService struct {
+	// Suffix of the Service, which is named <instance>-<suffix>. It cannot
+	// be rw, ro or r.
+	// +kubebuilder:validation:Pattern=`^[a-z]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=40
+	Suffix string `json:"suffix"`
+
+	// Role of the pods the Service selects: primary or replica. Defaults
+	// to every pod of the instance.
+	// +kubebuilder:validation:Enum=primary;replica
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// Port the Service listens on. Defaults to TargetPort.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// TargetPort is the port of the pods the Service forwards to. Defaults
+	// to that of Postgres, 5432.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	TargetPort int32 `json:"targetPort,omitempty"`
+
+	ServiceSettings `json:",inline"`
+}
+
+// ServiceSettings apply to the Services of an instance
+type ServiceSettings struct {
+	// Type of the Services. Defaults to ClusterIP.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// LoadBalancerSourceRanges restrict the clients a cloud load balancer
+	// lets through, where the cloud provider supports it. Only valid with
+	// type LoadBalancer.
+	// +optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+
+	// IPFamilyPolicy of the Services, e.g. PreferDualStack on a dual-stack
+	// cluster. Defaults to SingleStack.
+	// +kubebuilder:validation:Enum=SingleStack;PreferDualStack;RequireDualStack
+	// +optional
+	IPFamilyPolicy *corev1.IPFamilyPolicyType `json:"ipFamilyPolicy,omitempty"`
+
+	// IPFamilies of the Services, the primary one first, e.g. IPv6 on an
+	// IPv6-only cluster. Defaults to the primary family of the cluster.
+	// The primary family of an existing Service cannot be changed.
+	// +kubebuilder:validation:MaxItems=2
+	// +optional
+	IPFamilies []corev1.IPFamily `json:"ipFamilies,omitempty"`
+
+	// Annotations of the Services, e.g. to ask the cloud provider for an
+	// internal load balancer or external-dns for a hostname. Annotations
+	// removed here stay on the Services, as others may have set them too.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ServiceOverride changes the settings of one of the Services of an instance
+type ServiceOverride struct {
+	// Name is the suffix of the Service: rw, ro or r
+	// +kubebuilder:validation:Enum=rw;ro;r
+	Name string `json:"name"`
+
+	ServiceSettings `json:",inline"`
+}
+
+// Settings returns the settings of the Service with the suffix given, its
+// override applied
+func (s *ServiceSpec) Settings(name string) ServiceSettings {
+	if s == nil {
+		return ServiceSettings{}
+	}
+	settings := s.ServiceSettings
+	for _, override := range s.Overrides {
+		if override.Name != name {
+			continue
+		}
+		if override.Type != "" {
+			settings.Type = override.Type
+		}
+		if override.LoadBalancerSourceRanges != nil {
+			settings.LoadBalancerSourceRanges = override.LoadBalancerSourceRanges
+		}
+		if override.IPFamilyPolicy != nil {
+			settings.IPFamilyPolicy = override.IPFamilyPolicy
+		}
+		if override.IPFamilies != nil {
+			settings.IPFamilies = override.IPFamilies
+		}
+		if len(override.Annotations) > 0 {
+			annotations := make(map[string]string, len(settings.Annotations)+len(override.Annotations))
+			for key, value := range settings.Annotations {
+				annotations[key] = value
+			}
+			for key, value := range override.Annotations {
+				annotations[key] = value
+			}
+			settings.Annotations = annotations
+		}
+	}
+	return settings
+}
+
+// AuthenticationSpec configures external authentication of client roles
+type AuthenticationSpec struct {
+	// LDAP checks the passwords of some roles against a directory
+	// +optional
+	LDAP *LDAPAuthentication `json:"ldap,omitempty"`
+}
+
+// LDAPAuthentication is rendered into ldap entries of pg_hba.conf, in
+// search+bind mode: the server looks up the user's entry, then binds as it
+// with the password the client sent. Roles must still exist in Postgres.
+type LDAPAuthentication struct {
+	// Server is the host name or address of the LDAP server
+	Server string `json:"server"`
+
+	// Port of the LDAP server. Defaults to 389, or 636 with the ldaps
+	// scheme.
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// Scheme is ldap or ldaps. Defaults to ldap.
+	// +kubebuilder:validation:Enum=ldap;ldaps
+	// +optional
+	Scheme string `json:"scheme,omitempty"`
+
+	// StartTLS upgrades ldap connections to TLS
+	// +optional
+	StartTLS bool `json:"startTLS,omitempty"`
+
+	// BaseDN is where the search for the user's entry starts
+	BaseDN string `json:"baseDN"`
+
+	// BindDN is the entry the server binds as to search. Without one it
+	// searches anonymously.
+	// +optional
+	BindDN string `json:"bindDN,omitempty"`
+
+	// BindPasswordSecretRef selects the Secret key holding the password of
+	// BindDN
+	// +optional
+	BindPasswordSecretRef *corev1.SecretKeySelector `json:"bindPasswordSecretRef,omitempty"`
+
+	// SearchAttribute is matched against the user name. Defaults to uid.
+	// +optional
+	SearchAttribute string `json:"searchAttribute,omitempty"`
+
+	// SearchFilter replaces SearchAttribute with a filter in which $username
+	// stands for the user name, e.g. (&(uid=$username)(memberOf=cn=dba,ou=groups,dc=example,dc=com))
+	// +optional
+	SearchFilter string `json:"searchFilter,omitempty"`
+
+	// Roles authenticating through LDAP. A name starting with + stands for
+	// the members of that role, as in pg_hba.conf. Names cannot contain
+	// double quotes or control characters.
+	// +kubebuilder:validation:MinItems=1
+	Roles []string `json:"roles"`
+}
+
+// VaultSpec is the Vault whose database secrets engine hands out
+// credentials for the instance. The operator creates a vault role on the
+// instance for Vault to connect as, and registers the instance as the
+// connection <namespace>-<name> of the engine.
+type VaultSpec struct {
+	// Address of Vault, e.g. https://vault.vault.svc:8200
+	Address string `json:"address"`
+
+	// Mount is the path the database secrets engine is mounted at.
+	// Defaults to database.
+	// +optional
+	Mount string `json:"mount,omitempty"`
+
+	// TokenSecretRef selects the Secret key holding the Vault token the
+	// operator uses
+	// +optional
+	TokenSecretRef *corev1.SecretKeySelector `json:"tokenSecretRef,omitempty"`
+
+	// KubernetesAuth logs the operator in with a short-lived token of the
+	// instance's own ServiceAccount instead of a token from a Secret
+	// +optional
+	KubernetesAuth *VaultKubernetesAuth `json:"kubernetesAuth,omitempty"`
+
+	// Roles are the Vault roles applications read credentials from
+	// +kubebuilder:validation:MinItems=1
+	Roles []VaultRole `json:"roles"`
+}
+
+// VaultKubernetesAuth is a role of Vault's Kubernetes auth method
+type VaultKubernetesAuth struct {
+	// Role of the auth method bound to the ServiceAccount of the instance,
+	// which is named after it
+	Role string `json:"role"`
+
+	// Audience of the token Vault is given, which the role has to expect.
+	// Defaults to vault.
+	// +optional
+	Audience string `json:"audience,omitempty"`
+
+	// Mount is the path the auth method is mounted at. Defaults to
+	// kubernetes.
+	// +optional
+	Mount string `json:"mount,omitempty"`
 }
 
+// VaultRole is a role of the database secrets engine. Each credential read
+// from it is a new login role, dropped by Vault once its lease expires.
+type VaultRole struct {
+	// Name of the role in Vault. Roles are shared by every connection of
+	// the engine, so the name must be unique across instances.
+	Name string `json:"name"`
+
+	// GrantRoles are the roles the generated login roles are members of,
+	// and so get their privileges from
+	// +optional
+	GrantRoles []string `json:"grantRoles,omitempty"`
+
+	// DefaultTTL of the credentials. Defaults to the engine's.
+	// +optional
+	DefaultTTL *metav1.Duration `json:"defaultTTL,omitempty"`
+
+	// MaxTTL the credentials can be renewed up to. Defaults to the
+	// engine's.
+	// +optional
+	MaxTTL *metav1.Duration `json:"maxTTL,omitempty"`
+}
+
+// ReadOnlySpec configures the read-only mode of an instance
+type ReadOnlySpec struct {
+	// TerminateWriters ends the sessions in the middle of a writing
+	// transaction when the instance turns read-only, rather than letting
+	// them commit
+	// +optional
+	TerminateWriters bool `json:"terminateWriters,omitempty"`
+}
+
+// ServiceAccountSpec configures the ServiceAccount of an instance
+type ServiceAccountSpec struct {
+	// Annotations of the ServiceAccount, e.g. to bind it to a cloud
+	// identity for workload identity. A change takes effect when the pod is
+	// next recreated.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SecurityProfiles are the seccomp and AppArmor profiles of the containers
+// of an instance's pod
+type SecurityProfiles struct {
+	// Postgres is the profile of the container running the server
+	// +optional
+	Postgres *ContainerSecurityProfile `json:"postgres,omitempty"`
+
+	// Sidecars is the profile of every other container, init containers
+	// included
+	// +optional
+	Sidecars *ContainerSecurityProfile `json:"sidecars,omitempty"`
+}
+
+// ContainerSecurityProfile confines a container
+type ContainerSecurityProfile struct {
+	// Seccomp is the seccomp profile, e.g. RuntimeDefault
+	// +optional
+	Seccomp *corev1.SeccompProfile `json:"seccomp,omitempty"`
+
+	// AppArmor is the AppArmor profile: runtime/default, unconfined or
+	// localhost/ followed by the name of a profile loaded on the node
+	// +kubebuilder:validation:Pattern=`^(runtime/default|unconfined|localhost/.+)$`
+	// +optional
+	AppArmor string `json:"appArmor,omitempty"`
+}
+
+// ComplianceSpec configures an instance for regulated environments
+type ComplianceSpec struct {
+	// FIPS runs a FIPS-enabled image, the ImageRepository or else the
+	// operator's FIPS repository, and lets clients in over TLS only, with
+	// SCRAM-SHA-256 passwords, client certificates or LDAP over TLS. It
+	// needs TLS. A change of image restarts the server within the
+	// maintenance window.
+	// +optional
+	FIPS bool `json:"fips,omitempty"`
+}
+
+// AuditSpec configures pgaudit and where its records go. The server writes
+// its log as CSV as well, from which the audit container picks the audit
+// records, one per line.
+type AuditSpec struct {
+	// Log is the pgaudit.log setting, the classes of statements logged,
+	// e.g. ddl, write or role. Defaults to ddl and role.
+	// +optional
+	Log []string `json:"log,omitempty"`
+
+	// Webhook receives the audit records as CSV in POST requests
+	// +optional
+	Webhook *AuditWebhookSink `json:"webhook,omitempty"`
+
+	// S3 receives the audit records as CSV objects
+	// +optional
+	S3 *AuditS3Sink `json:"s3,omitempty"`
+
+	// FlushInterval between two shipments to the sinks. Defaults to 1m.
+	// +optional
+	FlushInterval *metav1.Duration `json:"flushInterval,omitempty"`
+}
+
+// AuditWebhookSink is an HTTP endpoint taking audit records
+type AuditWebhookSink struct {
+	URL string `json:"url"`
+}
+
+// AuditS3Sink is an S3 bucket, or one of a compatible object store, audit
+// records are uploaded to
+type AuditS3Sink struct {
+	Bucket string `json:"bucket"`
+
+	// Prefix of the object names
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	Region string `json:"region"`
+
+	// Endpoint of a store other than AWS S3, e.g. https://minio.example.com
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// CredentialsSecretRef is a Secret holding AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY
+	CredentialsSecretRef corev1.LocalObjectReference `json:"credentialsSecretRef"`
+}
+
+// BootstrapSpec configures the first start of an instance
+type BootstrapSpec struct {
+	// Seed loads data into the instance once it is first up
+	// +optional
+	Seed *SeedSpec `json:"seed,omitempty"`
+}
+
+// SeedSpec is a plain SQL dump, as written by pg_dump, loaded with psql in a
+// Job. It runs once; a failed seed is not retried and its Job is kept for
+// its logs.
+type SeedSpec struct {
+	// Database the dump is loaded into. It is created when missing.
+	// Defaults to postgres.
+	// +optional
+	Database string `json:"database,omitempty"`
+
+	// ConfigMapRef selects a ConfigMap key, in the same namespace, holding
+	// the dump
+	// +optional
+	ConfigMapRef *corev1.ConfigMapKeySelector `json:"configMapRef,omitempty"`
+
+	// URL the dump is downloaded from with a plain HTTP or HTTPS GET, e.g. a
+	// presigned S3 URL. No credentials are sent, so s3:// and other URLs
+	// needing them are not supported. Dumps whose path ends in .gz are
+	// decompressed.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	URL string `json:"url,omitempty"`
+}
+
+// PoolerMode is where PgBouncer runs
+// +kubebuilder:validation:Enum=sidecar
+type PoolerMode string
+
+// PoolerSidecar runs PgBouncer in the pod of the instance
+const PoolerSidecar PoolerMode = "sidecar"
+
+// PoolMode is when PgBouncer hands a server connection back to the pool
+// +kubebuilder:validation:Enum=session;transaction;statement
+type PoolMode string
+
+const (
+	PoolModeSession     PoolMode = "session"
+	PoolModeTransaction PoolMode = "transaction"
+	PoolModeStatement   PoolMode = "statement"
+)
+
+// InstancePoolerSpec configures PgBouncer running with the instance.
+// Clients log in with the password of their role, which PgBouncer looks up
+// on the instance as the superuser. Roles using client certificates or
+// LDAP cannot connect through it, even if they have a password.
+type InstancePoolerSpec struct {
+	// Mode is where PgBouncer runs. As a sidecar, it shares the pod of the
+	// instance and the Services expose it on port 6432 next to Postgres.
+	Mode PoolerMode `json:"mode"`
+
+	PoolSettings `json:",inline"`
+}
+
+// PoolSettings size the pools of PgBouncer and say how connections are
+// shared
+type PoolSettings struct {
+	// PoolMode is when a server connection goes back to the pool: when the
+	// client disconnects, after each transaction or after each statement.
+	// Defaults to session.
+	// +optional
+	PoolMode PoolMode `json:"poolMode,omitempty"`
+
+	// DefaultPoolSize is the number of server connections for each pair of
+	// role and database. Defaults to 20.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	DefaultPoolSize int32 `json:"defaultPoolSize,omitempty"`
+
+	// MaxClientConnections is the number of client connections PgBouncer
+	// accepts. Defaults to 100.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxClientConnections int32 `json:"maxClientConnections,omitempty"`
+}
+
+// PrivilegeAuditSpec configures the privilege audit
+type PrivilegeAuditSpec struct {
+	// Interval between two audits, e.g. 1h
+	Interval metav1.Duration `json:"interval"`
+}
+
+// PrivilegeAudit is the outcome of the last privilege audit
+type PrivilegeAudit struct {
+	// Time the audit ran
+	Time metav1.Time `json:"time"`
+
+	// UnmanagedCount is the number of privileges no Grant declares
+	UnmanagedCount int32 `json:"unmanagedCount"`
+
+	// Unmanaged lists the first of those privileges
+	// +optional
+	Unmanaged []string `json:"unmanaged,omitempty"`
+}
+
+// UpgradeHook is a SQL script run after every successful version change
+type UpgradeHook struct {
+	// Name identifies the hook in the status
+	Name string `json:"name"`
+
+	// ConfigMapRef selects a ConfigMap key, in the same namespace, holding
+	// the SQL to run
+	ConfigMapRef corev1.ConfigMapKeySelector `json:"configMapRef"`
+
+	// Database the SQL runs in. Defaults to postgres.
+	// +optional
+	Database string `json:"database,omitempty"`
+}
+
+// UpgradeHookResult is the outcome of running an upgrade hook
+type UpgradeHookResult struct {
+	// Name of the hook
+	Name string `json:"name"`
+
+	SQLJobRun `json:",inline"`
+}
+
+// LocaleSupport is what the instance's build of Postgres offers for the
+// locales of databases
+type LocaleSupport struct {
+	// Version of Postgres the locales were read from
+	Version string `json:"version"`
+
+	// Locales are the libc locales available to LC_COLLATE and LC_CTYPE
+	// +optional
+	Locales []string `json:"locales,omitempty"`
+
+	// ICU is true when Postgres was built with ICU
+	// +optional
+	ICU bool `json:"icu,omitempty"`
+}
+
+// Endpoint is the address a Service of an instance is reachable at from
+// outside the cluster
+type Endpoint struct {
+	// Service is the name of the Service
+	Service string `json:"service"`
+
+	// Host clients connect to: the hostname external-dns publishes for the
+	// Service when it is annotated with one, otherwise the address of its
+	// load balancer. Empty until the load balancer is provisioned.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// Port of the Service, that of Postgres on the Services of the instance
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// Addresses Host resolves to. Empty while the DNS record propagates.
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// CitusStatus is what the coordinator knows of its workers
+type CitusStatus struct {
+	// Workers lists the worker instances, with those that are registered
+	// but no longer wanted
+	// +optional
+	Workers []CitusWorker `json:"workers,omitempty"`
+
+	// Shards is the number of shard placements over every worker
+	// +optional
+	Shards int32 `json:"shards,omitempty"`
+}
+
+// CitusWorker is a worker of a Citus coordinator
+type CitusWorker struct {
+	// Name of the worker Postgresql
+	Name string `json:"name"`
+
+	// Registered is true once the coordinator has added the worker
+	Registered bool `json:"registered"`
+
+	// Active is false while the coordinator has the worker disabled
+	Active bool `json:"active"`
+
+	// Shards is the number of shard placements on the worker
+	// +optional
+	Shards int32 `json:"shards,omitempty"`
+
+	// UnhealthyShards is the number of placements Citus marked inactive
+	// +optional
+	UnhealthyShards int32 `json:"unhealthyShards,omitempty"`
+}
+
+// ConditionUpgrading is true while a version change is being rolled out
+const ConditionUpgrading = "Upgrading"
+
+// ConditionReconcilePaused is true while the instance is only being observed
+const ConditionReconcilePaused = "ReconcilePaused"
+
+// ConditionMaintenancePending is true while operations are waiting for the
+// maintenance window
+const ConditionMaintenancePending = "MaintenancePending"
+
+// ConditionReady is true once a resource managed through SQL, such as a
+// Database, has been applied to its instance
+const ConditionReady = "Ready"
+
+// ConditionDriftDetected is true when the last check of a Role found, and
+// reverted, changes made to it outside of the operator
+const ConditionDriftDetected = "DriftDetected"
+
+// ConditionSeeded is true once the seed of the bootstrap spec has been
+// loaded
+const ConditionSeeded = "Seeded"
+
+// ConditionUnmanagedPrivileges is true when the last privilege audit found
+// privileges on the instance that no Grant declares
+const ConditionUnmanagedPrivileges = "UnmanagedPrivileges"
+
+// ConditionLDAPReachable is true when the operator could reach, and bind
+// to, the LDAP server of the authentication spec
+const ConditionLDAPReachable = "LDAPReachable"
+
+// ConditionVaultConfigured is true once the Vault database secrets engine
+// has been configured against the instance
+const ConditionVaultConfigured = "VaultConfigured"
+
+// ConditionReadOnly is true while the instance only takes read-only
+// transactions by default
+const ConditionReadOnly = "ReadOnly"
+
+// ConditionDegraded is true while the instance runs without the placement
+// guarantees it asked for
+const ConditionDegraded = "Degraded"
+
+// ConditionShardsHealthy is true while every worker of a Citus coordinator
+// is registered and active, with no inactive shard placements
+const ConditionShardsHealthy = "ShardsHealthy"
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName={pg,pgsql},categories={all,databases}
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.pgPhase`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Postgresql is the Schema for the postgresqls API
 type Postgresql struct {
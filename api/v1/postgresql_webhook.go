@@ -0,0 +1,473 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var postgresqllog = logf.Log.WithName("postgresql-resource")
+
+// AllowUnsafeVersionAnnotation lets a Postgresql move to a version outside
+// the operator's catalog or back to an older major version when set to
+// "true". Neither can be undone safely, so it has to be asked for explicitly.
+const AllowUnsafeVersionAnnotation = "db.example.com/allow-unsafe-version"
+
+func (r *Postgresql) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&postgresqlValidator{client: mgr.GetAPIReader(), dryRun: mgr.GetClient()}).
+		Complete()
+}
+
+// postgresqlValidator adds the checks that need to look up other objects,
+// such as StorageClasses, or to ask the API server, to the Validator of
+// Postgresql
+type postgresqlValidator struct {
+	client client.Reader
+	dryRun client.Writer
+}
+
+var _ admission.CustomValidator = &postgresqlValidator{}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *postgresqlValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	pg := obj.(*Postgresql)
+	if err := pg.ValidateCreate(); err != nil {
+		return err
+	}
+	if err := v.validateStorageEncryption(ctx, pg, nil); err != nil {
+		return err
+	}
+	return v.validateClusterIPFamilies(ctx, pg, nil)
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *postgresqlValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	pg, old := newObj.(*Postgresql), oldObj.(*Postgresql)
+	if err := pg.ValidateUpdate(old); err != nil {
+		return err
+	}
+	if err := v.validateStorageEncryption(ctx, pg, old); err != nil {
+		return err
+	}
+	return v.validateClusterIPFamilies(ctx, pg, old)
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v *postgresqlValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return obj.(*Postgresql).ValidateDelete()
+}
+
+//+kubebuilder:webhook:path=/validate-database-db-example-com-v1-postgresql,mutating=false,failurePolicy=fail,sideEffects=None,groups=database.db.example.com,resources=postgresqls,verbs=create;update,versions=v1,name=vpostgresql.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Postgresql{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Postgresql) ValidateCreate() error {
+	postgresqllog.Info("validate create", "name", r.Name)
+
+	if r.Spec.External != nil {
+		return r.validateExternal()
+	}
+	if err := r.validateVersion(nil); err != nil {
+		return err
+	}
+	if err := r.validatePassword(nil); err != nil {
+		return err
+	}
+	if err := r.validateAccess(); err != nil {
+		return err
+	}
+	if err := r.validateAuthentication(); err != nil {
+		return err
+	}
+	if err := r.validateService(); err != nil {
+		return err
+	}
+	if err := r.validateCompliance(); err != nil {
+		return err
+	}
+	if err := r.validatePGVector(); err != nil {
+		return err
+	}
+	if err := r.validateSemantics(nil); err != nil {
+		return err
+	}
+	return r.validatePromote()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Postgresql) ValidateUpdate(old runtime.Object) error {
+	postgresqllog.Info("validate update", "name", r.Name)
+
+	if err := r.validateImmutable(old.(*Postgresql)); err != nil {
+		return err
+	}
+	if r.Spec.External != nil {
+		return r.validateExternal()
+	}
+	if err := r.validateVersion(old.(*Postgresql)); err != nil {
+		return err
+	}
+	if err := r.validatePassword(old.(*Postgresql)); err != nil {
+		return err
+	}
+	if err := r.validateAccess(); err != nil {
+		return err
+	}
+	if err := r.validateAuthentication(); err != nil {
+		return err
+	}
+	if err := r.validateService(); err != nil {
+		return err
+	}
+	if err := r.validateCompliance(); err != nil {
+		return err
+	}
+	if err := r.validatePGVector(); err != nil {
+		return err
+	}
+	if err := r.validateSemantics(old.(*Postgresql)); err != nil {
+		return err
+	}
+	return r.validatePromote()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Postgresql) ValidateDelete() error {
+	return nil
+}
+
+// validateExternal rejects settings of the server the operator would run
+// on an instance that points at an external server
+func (r *Postgresql) validateExternal() error {
+	spec := r.Spec.DeepCopy()
+	spec.External, spec.DefaultUser = nil, ""
+	if !reflect.DeepEqual(*spec, PostgresqlSpec{}) {
+		return fmt.Errorf("spec.external: the operator does not run the server, no other settings apply")
+	}
+	return nil
+}
+
+// validateVersion rejects versions the catalog does not know and moves to an
+// older major version, unless the unsafe version annotation is set.
+func (r *Postgresql) validateVersion(old *Postgresql) error {
+	if r.Annotations[AllowUnsafeVersionAnnotation] == "true" {
+		return nil
+	}
+
+	version := r.Spec.Version
+	if version == "" {
+		return nil
+	}
+	if old != nil && old.Spec.Version == version {
+		return nil
+	}
+	if _, ok := operatorconfig.Get().Catalog.Lookup(version); !ok {
+		return fmt.Errorf("version %s is not in the operator's catalog; set the %s annotation to use it anyway",
+			version, AllowUnsafeVersionAnnotation)
+	}
+
+	if old == nil {
+		return nil
+	}
+	oldVersion := old.Spec.Version
+	if oldVersion == "" {
+		oldVersion = operatorconfig.Get().DefaultVersion
+	}
+	if catalog.Compare(catalog.Major(version), catalog.Major(oldVersion)) < 0 {
+		return fmt.Errorf("cannot downgrade from major version %s to %s; set the %s annotation to force it",
+			catalog.Major(oldVersion), catalog.Major(version), AllowUnsafeVersionAnnotation)
+	}
+	return nil
+}
+
+// validateImmutable rejects changes to fields only read when the instance or
+// its volumes are created, which would otherwise be ignored from then on
+func (r *Postgresql) validateImmutable(old *Postgresql) error {
+	if r.Spec.DefaultUser != old.Spec.DefaultUser {
+		return fmt.Errorf("spec.defaultuser cannot be changed after creation")
+	}
+	if (r.Spec.External == nil) != (old.Spec.External == nil) {
+		return fmt.Errorf("spec.external cannot be set or cleared after creation")
+	}
+	if old.Spec.Citus != nil && r.Spec.Citus == nil {
+		return fmt.Errorf("spec.citus cannot be cleared, the workers hold the shards of distributed tables")
+	}
+	if old.Spec.Citus != nil && r.Spec.Citus.Database != old.Spec.Citus.Database {
+		return fmt.Errorf("spec.citus.database cannot be changed after it is set")
+	}
+	if r.Spec.Storage != nil && old.Spec.Storage != nil &&
+		!equalStorageClass(r.Spec.Storage.StorageClassName, old.Spec.Storage.StorageClassName) {
+		return fmt.Errorf("spec.storage.storageClassName cannot be changed after creation")
+	}
+	for _, tablespace := range r.Spec.Tablespaces {
+		for _, oldTablespace := range old.Spec.Tablespaces {
+			if tablespace.Name == oldTablespace.Name &&
+				!equalStorageClass(tablespace.Storage.StorageClassName, oldTablespace.Storage.StorageClassName) {
+				return fmt.Errorf("spec.tablespaces[%s].storage.storageClassName cannot be changed after creation", tablespace.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func equalStorageClass(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// validatePassword holds a superuser password set in the spec to the
+// operator's password policy. Instances created before the policy keep their
+// password until it is changed.
+func (r *Postgresql) validatePassword(old *Postgresql) error {
+	if r.Spec.Password == "" {
+		if r.Spec.PasswordSecretRef == nil {
+			return fmt.Errorf("spec.passwordSecretRef is required")
+		}
+		return nil
+	}
+	if old != nil && old.Spec.Password == r.Spec.Password {
+		return nil
+	}
+	if err := OperatorPasswordPolicy.Check(r.Spec.Password); err != nil {
+		return fmt.Errorf("spec.password: %w", err)
+	}
+	return nil
+}
+
+// validateAccess rejects allowed CIDRs pg_hba.conf would not load with
+func (r *Postgresql) validateAccess() error {
+	if r.Spec.Access == nil {
+		return nil
+	}
+	for _, cidr := range r.Spec.Access.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("spec.access.allowedCIDRs: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateService rejects load balancer source ranges that are not CIDRs
+// or are given for Services that are not load balancers, IP families the
+// policy does not allow, and additional Services whose names clash or are
+// too long
+func (r *Postgresql) validateService() error {
+	for _, name := range []string{"rw", "ro", "r"} {
+		if err := validateServiceSettings(name, r.Spec.Service.Settings(name)); err != nil {
+			return err
+		}
+	}
+	if r.Spec.Service == nil {
+		return nil
+	}
+	for _, additional := range r.Spec.Service.Additional {
+		switch additional.Suffix {
+		case "rw", "ro", "r":
+			return fmt.Errorf("spec.service.additional: suffix %s is taken by a Service of the instance", additional.Suffix)
+		}
+		if name := r.Name + "-" + additional.Suffix; len(name) > validation.DNS1035LabelMaxLength {
+			return fmt.Errorf("spec.service.additional: Service name %s is longer than %d characters", name, validation.DNS1035LabelMaxLength)
+		}
+		if err := validateServiceSettings(additional.Suffix, additional.ServiceSettings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateServiceSettings(name string, settings ServiceSettings) error {
+	if err := validateIPFamilies(settings); err != nil {
+		return fmt.Errorf("spec.service: IP families of the %s Service: %w", name, err)
+	}
+	if len(settings.LoadBalancerSourceRanges) == 0 {
+		return nil
+	}
+	if settings.Type != corev1.ServiceTypeLoadBalancer {
+		return fmt.Errorf("spec.service: loadBalancerSourceRanges of the %s Service need type LoadBalancer", name)
+	}
+	for _, cidr := range settings.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("spec.service.loadBalancerSourceRanges: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateIPFamilies checks the IP families of a Service the way the API
+// server would, whether or not the cluster has them
+func validateIPFamilies(settings ServiceSettings) error {
+	seen := map[corev1.IPFamily]bool{}
+	for _, family := range settings.IPFamilies {
+		if family != corev1.IPv4Protocol && family != corev1.IPv6Protocol {
+			return fmt.Errorf("unknown family %s, expected IPv4 or IPv6", family)
+		}
+		if seen[family] {
+			return fmt.Errorf("%s is given twice", family)
+		}
+		seen[family] = true
+	}
+	if len(settings.IPFamilies) > 1 &&
+		(settings.IPFamilyPolicy == nil || *settings.IPFamilyPolicy == corev1.IPFamilyPolicySingleStack) {
+		return fmt.Errorf("two families need ipFamilyPolicy PreferDualStack or RequireDualStack")
+	}
+	return nil
+}
+
+// validateAuthentication rejects LDAP role names pg_hba.conf cannot hold:
+// it has no way to escape a double quote, and a line break starts a rule
+func (r *Postgresql) validateAuthentication() error {
+	if r.Spec.Authentication == nil || r.Spec.Authentication.LDAP == nil {
+		return nil
+	}
+	for _, role := range r.Spec.Authentication.LDAP.Roles {
+		if strings.Contains(role, `"`) || strings.IndexFunc(role, unicode.IsControl) >= 0 {
+			return fmt.Errorf("spec.authentication.ldap.roles: %q cannot contain double quotes or control characters", role)
+		}
+	}
+	return nil
+}
+
+// validateCompliance rejects FIPS instances without a FIPS-enabled image to
+// run or with settings that would let clients in without TLS
+func (r *Postgresql) validateCompliance() error {
+	if r.Spec.Compliance == nil || !r.Spec.Compliance.FIPS {
+		return nil
+	}
+	if r.Spec.ImageRepository == "" && operatorconfig.Get().FIPSImageRepository == "" {
+		return fmt.Errorf("spec.compliance.fips: the operator has no FIPS image repository, set spec.imageRepository")
+	}
+	if r.Spec.TLS != nil && r.Spec.TLS.Disabled {
+		return fmt.Errorf("spec.compliance.fips: TLS cannot be disabled")
+	}
+	if r.Spec.Authentication != nil && r.Spec.Authentication.LDAP != nil {
+		if ldap := r.Spec.Authentication.LDAP; ldap.Scheme != "ldaps" && !ldap.StartTLS {
+			return fmt.Errorf("spec.compliance.fips: LDAP needs the ldaps scheme or StartTLS")
+		}
+	}
+	return nil
+}
+
+// validatePGVector rejects pgvector without an image that ships it. A FIPS
+// image is not expected to, so FIPS instances have to name their own.
+func (r *Postgresql) validatePGVector() error {
+	if r.Spec.PGVector == nil || r.Spec.ImageRepository != "" {
+		return nil
+	}
+	if r.Spec.Compliance != nil && r.Spec.Compliance.FIPS {
+		return fmt.Errorf("spec.pgvector: a FIPS instance needs spec.imageRepository with an image shipping pgvector")
+	}
+	if operatorconfig.Get().PGVectorImageRepository == "" {
+		return fmt.Errorf("spec.pgvector: the operator has no pgvector image repository, set spec.imageRepository")
+	}
+	return nil
+}
+
+// validateSemantics rejects combinations of settings the operator could
+// only report as failing once it acts on them
+func (r *Postgresql) validateSemantics(old *Postgresql) error {
+	if r.Spec.LocalOnly {
+		if r.Spec.Vault != nil {
+			return fmt.Errorf("spec.vault: Vault connects through the Services a local-only instance does not have")
+		}
+		if r.Spec.Bootstrap != nil && r.Spec.Bootstrap.Seed != nil {
+			return fmt.Errorf("spec.bootstrap.seed: the seed connects through the Services a local-only instance does not have")
+		}
+		if r.Spec.Pooler != nil {
+			return fmt.Errorf("spec.pooler: clients reach the pooler through the Services a local-only instance does not have")
+		}
+		if r.Spec.Service != nil {
+			return fmt.Errorf("spec.service: a local-only instance has no Services")
+		}
+		if r.Spec.Citus != nil {
+			return fmt.Errorf("spec.citus: the workers reach the coordinator through the Services a local-only instance does not have")
+		}
+	}
+	if r.Spec.Citus != nil {
+		// The -rw Service of the last worker has the longest name
+		last := fmt.Sprintf("%s-worker-%d-rw", r.Name, r.Spec.Citus.Workers-1)
+		if len(last) > validation.DNS1035LabelMaxLength {
+			return fmt.Errorf("spec.citus.workers: Service name %s is longer than %d characters", last, validation.DNS1035LabelMaxLength)
+		}
+	}
+	if _, ok := r.Spec.Parameters["default_transaction_read_only"]; ok && r.Spec.ReadOnly != nil {
+		return fmt.Errorf("spec.readOnly: default_transaction_read_only is set in spec.parameters, which wins")
+	}
+	if old != nil && r.Spec.Storage == nil &&
+		catalog.Major(specVersion(r)) != catalog.Major(specVersion(old)) {
+		return fmt.Errorf("spec.version: moving to major version %s runs pg_upgrade, which requires spec.storage",
+			catalog.Major(specVersion(r)))
+	}
+	// Without a volume claim the data directory goes with the pod
+	if r.Spec.Storage == nil {
+		if r.Spec.Hibernate {
+			return fmt.Errorf("spec.hibernate: hibernating removes the pod, which requires spec.storage to keep the data")
+		}
+		if r.Spec.HibernationSchedule != nil {
+			return fmt.Errorf("spec.hibernationSchedule: hibernating removes the pod, which requires spec.storage to keep the data")
+		}
+		if old != nil && r.Annotations[RestartedAtAnnotation] != old.Annotations[RestartedAtAnnotation] {
+			return fmt.Errorf("metadata.annotations[%s]: restarting recreates the pod, which requires spec.storage to keep the data",
+				RestartedAtAnnotation)
+		}
+	}
+	return nil
+}
+
+// specVersion is the version an instance asks for, the default one when it
+// does not say
+func specVersion(pg *Postgresql) string {
+	if pg.Spec.Version == "" {
+		return operatorconfig.Get().DefaultVersion
+	}
+	return pg.Spec.Version
+}
+
+// validatePromote rejects promote requests that cannot name a pod. Whether
+// the pod is a standby of this instance is up to the operator, which sees
+// the pods.
+func (r *Postgresql) validatePromote() error {
+	target, ok := r.Annotations[PromoteAnnotation]
+	if !ok {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(target); len(errs) > 0 {
+		return fmt.Errorf("cannot promote %q: %s", target, strings.Join(errs, ", "))
+	}
+	return nil
+}
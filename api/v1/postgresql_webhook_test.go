@@ -0,0 +1,299 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func postgresqlWithVersion(version string, annotations map[string]string) *Postgresql {
+	return &Postgresql{
+		ObjectMeta: metav1.ObjectMeta{Name: "pg", Annotations: annotations},
+		Spec:       PostgresqlSpec{Version: version, Password: "7vQ-kd2Lw9xZ", Storage: &StorageSpec{}},
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	allow := map[string]string{AllowUnsafeVersionAnnotation: "true"}
+	tests := []struct {
+		name    string
+		old     *Postgresql
+		new     *Postgresql
+		wantErr bool
+	}{
+		{"create with catalog version", nil, postgresqlWithVersion("14.9", nil), false},
+		{"create with default version", nil, postgresqlWithVersion("", nil), false},
+		{"create with unknown version", nil, postgresqlWithVersion("14.99", nil), true},
+		{"create with unknown version and override", nil, postgresqlWithVersion("14.99", allow), false},
+		{"minor upgrade", postgresqlWithVersion("14.5", nil), postgresqlWithVersion("14.9", nil), false},
+		{"minor downgrade", postgresqlWithVersion("14.9", nil), postgresqlWithVersion("14.5", nil), false},
+		{"major upgrade", postgresqlWithVersion("14.9", nil), postgresqlWithVersion("16.0", nil), false},
+		{"major downgrade", postgresqlWithVersion("16.0", nil), postgresqlWithVersion("14.9", nil), true},
+		{"major downgrade from default", postgresqlWithVersion("", nil), postgresqlWithVersion("13.8", nil), true},
+		{"major downgrade with override", postgresqlWithVersion("16.0", nil), postgresqlWithVersion("14.9", allow), false},
+		{"unchanged unknown version", postgresqlWithVersion("14.99", nil), postgresqlWithVersion("14.99", nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.old == nil {
+				err = tt.new.ValidateCreate()
+			} else {
+				err = tt.new.ValidateUpdate(tt.old)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePromote(t *testing.T) {
+	tests := map[string]bool{
+		"":     false,
+		"pg":   false,
+		"pg-1": false,
+		"PG_1": true,
+	}
+	for target, wantErr := range tests {
+		pg := postgresqlWithVersion("", nil)
+		if target != "" {
+			pg.Annotations = map[string]string{PromoteAnnotation: target}
+		}
+		if err := pg.ValidateCreate(); (err != nil) != wantErr {
+			t.Errorf("promote %q: got error %v, want error %v", target, err, wantErr)
+		}
+	}
+}
+
+func TestValidateAccess(t *testing.T) {
+	pg := postgresqlWithVersion("", nil)
+	pg.Spec.Access = &AccessSpec{AllowedCIDRs: []string{"10.0.0.0/8", "fd00::/8"}}
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("valid CIDRs should be accepted, got %v", err)
+	}
+	pg.Spec.Access.AllowedCIDRs = append(pg.Spec.Access.AllowedCIDRs, "10.0.0.1")
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected an address without prefix length to be rejected")
+	}
+}
+
+func TestValidateCompliance(t *testing.T) {
+	pg := postgresqlWithVersion("", nil)
+	pg.Spec.Compliance = &ComplianceSpec{FIPS: true}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected FIPS without a FIPS image to be rejected")
+	}
+	pg.Spec.ImageRepository = "registry.example.com/postgres-fips"
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("FIPS with its own image should be accepted, got %v", err)
+	}
+	pg.Spec.Authentication = &AuthenticationSpec{LDAP: &LDAPAuthentication{Server: "ldap.example.com"}}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected LDAP without TLS to be rejected")
+	}
+	pg.Spec.Authentication.LDAP.StartTLS = true
+	pg.Spec.TLS = &TLSSpec{Disabled: true}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected FIPS without TLS to be rejected")
+	}
+}
+
+func TestValidateAuthentication(t *testing.T) {
+	pg := postgresqlWithVersion("", nil)
+	pg.Spec.Authentication = &AuthenticationSpec{LDAP: &LDAPAuthentication{Server: "ldap.example.com", Roles: []string{"app", "+dba"}}}
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("plain LDAP roles should be accepted, got %v", err)
+	}
+	for _, role := range []string{`app" all all trust`, "app\nhost all all all trust"} {
+		pg.Spec.Authentication.LDAP.Roles = []string{role}
+		if err := pg.ValidateCreate(); err == nil {
+			t.Errorf("expected LDAP role %q to be rejected", role)
+		}
+	}
+}
+
+func TestValidatePGVector(t *testing.T) {
+	pg := postgresqlWithVersion("", nil)
+	pg.Spec.PGVector = &PGVectorSpec{}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected pgvector without a pgvector image to be rejected")
+	}
+	pg.Spec.ImageRepository = "registry.example.com/postgres-pgvector"
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("pgvector with its own image should be accepted, got %v", err)
+	}
+}
+
+func TestValidateImmutable(t *testing.T) {
+	fast, slow := "fast", "slow"
+	old := postgresqlWithVersion("", nil)
+	old.Spec.DefaultUser = "app"
+	old.Spec.Storage = &StorageSpec{StorageClassName: &fast}
+	old.Spec.Tablespaces = []TablespaceSpec{{Name: "archive", Storage: StorageSpec{StorageClassName: &slow}}}
+
+	pg := old.DeepCopy()
+	pg.Spec.Parameters = map[string]string{"work_mem": "64MB"}
+	if err := pg.ValidateUpdate(old); err != nil {
+		t.Errorf("mutable changes should be accepted, got %v", err)
+	}
+
+	pg = old.DeepCopy()
+	pg.Spec.DefaultUser = "other"
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected a change of default user to be rejected")
+	}
+	pg = old.DeepCopy()
+	pg.Spec.Storage.StorageClassName = &slow
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected a change of storage class to be rejected")
+	}
+	pg = old.DeepCopy()
+	pg.Spec.Tablespaces[0].Storage.StorageClassName = nil
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected a change of tablespace storage class to be rejected")
+	}
+	old.Spec.Citus = &CitusSpec{Workers: 2, Database: "app"}
+	pg = old.DeepCopy()
+	pg.Spec.Citus.Workers = 4
+	if err := pg.ValidateUpdate(old); err != nil {
+		t.Errorf("adding Citus workers should be accepted, got %v", err)
+	}
+	pg.Spec.Citus = nil
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected clearing Citus to be rejected")
+	}
+}
+
+func TestValidateSemantics(t *testing.T) {
+	pg := postgresqlWithVersion("", nil)
+	pg.Spec.LocalOnly = true
+	pg.Spec.Vault = &VaultSpec{Address: "https://vault.example.com"}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected Vault on a local-only instance to be rejected")
+	}
+
+	pg = postgresqlWithVersion("", nil)
+	pg.Spec.LocalOnly = true
+	pg.Spec.Pooler = &InstancePoolerSpec{Mode: PoolerSidecar}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected a pooler on a local-only instance to be rejected")
+	}
+
+	pg = postgresqlWithVersion("", nil)
+	pg.Spec.LocalOnly = true
+	pg.Spec.Service = &ServiceSpec{ServiceSettings: ServiceSettings{Type: corev1.ServiceTypeLoadBalancer}}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected a service spec on a local-only instance to be rejected")
+	}
+
+	pg = postgresqlWithVersion("", nil)
+	pg.Name = strings.Repeat("a", 55)
+	pg.Spec.Citus = &CitusSpec{Workers: 10}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected Citus workers with Service names too long to be rejected")
+	}
+
+	pg = postgresqlWithVersion("", nil)
+	pg.Spec.ReadOnly = &ReadOnlySpec{}
+	pg.Spec.Parameters = map[string]string{"default_transaction_read_only": "off"}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected read-only mode overridden by a parameter to be rejected")
+	}
+
+	old := postgresqlWithVersion("14.9", nil)
+	pg = postgresqlWithVersion("15.4", nil)
+	if err := pg.ValidateUpdate(old); err != nil {
+		t.Errorf("major upgrade with storage should be accepted, got %v", err)
+	}
+	pg.Spec.Storage, old.Spec.Storage = nil, nil
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected a major upgrade without storage to be rejected")
+	}
+
+	// Stopping the pod of an instance without storage loses its data
+	pg = postgresqlWithVersion("", nil)
+	pg.Spec.Hibernate = true
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("hibernation with storage should be accepted, got %v", err)
+	}
+	pg.Spec.Storage = nil
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected hibernation without storage to be rejected")
+	}
+	pg.Spec.Hibernate = false
+	pg.Spec.HibernationSchedule = &HibernationSchedule{Stop: "0 20 * * *", Start: "0 8 * * *"}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected a hibernation schedule without storage to be rejected")
+	}
+	old = postgresqlWithVersion("", nil)
+	old.Spec.Storage = nil
+	pg = old.DeepCopy()
+	pg.Annotations = map[string]string{RestartedAtAnnotation: "2024-01-01T00:00:00Z"}
+	if err := pg.ValidateUpdate(old); err == nil {
+		t.Error("expected a restart without storage to be rejected")
+	}
+	old.Annotations = pg.Annotations
+	if err := pg.ValidateUpdate(old); err != nil {
+		t.Errorf("an unchanged restart annotation should be accepted, got %v", err)
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	pg := postgresqlWithVersion("", nil)
+	pg.Spec.Service = &ServiceSpec{
+		Overrides: []ServiceOverride{{Name: "rw", ServiceSettings: ServiceSettings{
+			Type:                     corev1.ServiceTypeLoadBalancer,
+			LoadBalancerSourceRanges: []string{"203.0.113.0/24"},
+		}}},
+	}
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("expected a load balancer for the primary to be accepted, got %v", err)
+	}
+
+	pg.Spec.Service.LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected source ranges on ClusterIP Services to be rejected")
+	}
+
+	pg.Spec.Service.LoadBalancerSourceRanges = nil
+	pg.Spec.Service.Overrides[0].LoadBalancerSourceRanges = []string{"203.0.113.0"}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected a source range that is not a CIDR to be rejected")
+	}
+
+	pg.Spec.Service.Overrides[0].LoadBalancerSourceRanges = nil
+	pg.Spec.Service.Additional = []AdditionalService{{Suffix: "metrics", TargetPort: 9187}}
+	if err := pg.ValidateCreate(); err != nil {
+		t.Errorf("expected an additional Service to be accepted, got %v", err)
+	}
+
+	pg.Spec.Service.Additional[0].Suffix = "ro"
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected an additional Service clashing with -ro to be rejected")
+	}
+
+	pg.Spec.Service.Additional[0].Suffix = "metrics"
+	pg.Spec.Service.Additional[0].LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+	if err := pg.ValidateCreate(); err == nil {
+		t.Error("expected source ranges on a ClusterIP additional Service to be rejected")
+	}
+}
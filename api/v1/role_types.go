@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RoleSpec defines the desired state of Role
+type RoleSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the role lives on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Name of the role in Postgres. Defaults to the name of the resource.
+	// It cannot contain double quotes or control characters, which
+	// pg_hba.conf cannot hold.
+	// +kubebuilder:validation:Pattern=`^[^"\x00-\x1f\x7f]*$`
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// PasswordSecretRef selects the key of a Secret, in the same namespace,
+	// holding the role's password. Without it the role has no password.
+	// +optional
+	PasswordSecretRef *corev1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+
+	// PasswordSecretProvider takes the role's password from the Secrets
+	// Store CSI driver instead of a Secret, so it is never stored in the
+	// cluster. A Job mounting the SecretProviderClass sets it whenever the
+	// spec changes. It cannot be combined with PasswordSecretRef.
+	// +optional
+	PasswordSecretProvider *SecretProviderPassword `json:"passwordSecretProvider,omitempty"`
+
+	// PasswordRotation has the operator generate a new password at an
+	// interval and write it to the password Secret, which is created when
+	// missing. A password the Secret already holds is kept for the first
+	// interval. Postgres keeps a single password per role, so clients have
+	// to pick up the new one from the Secret before they next connect.
+	// +optional
+	PasswordRotation *PasswordRotation `json:"passwordRotation,omitempty"`
+
+	// ClientCertificate has the operator issue a client certificate for the
+	// role, signed by the instance's CA, into the <name>-client-cert Secret.
+	// The role can then only log in over SSL with that certificate, and
+	// not through the pooler of the instance. It needs the instance to use
+	// the operator-managed CA.
+	// +optional
+	ClientCertificate bool `json:"clientCertificate,omitempty"`
+
+	// Login allows the role to log in
+	// +optional
+	Login bool `json:"login,omitempty"`
+
+	// +optional
+	Superuser bool `json:"superuser,omitempty"`
+
+	// +optional
+	CreateDB bool `json:"createdb,omitempty"`
+
+	// +optional
+	CreateRole bool `json:"createrole,omitempty"`
+
+	// +optional
+	Replication bool `json:"replication,omitempty"`
+
+	// ConnectionLimit caps the concurrent connections of the role. -1, the
+	// default, means no limit.
+	// +kubebuilder:validation:Minimum=-1
+	// +optional
+	ConnectionLimit *int32 `json:"connectionLimit,omitempty"`
+
+	// ValidUntil is when the role's password expires. It never does by
+	// default.
+	// +optional
+	ValidUntil *metav1.Time `json:"validUntil,omitempty"`
+
+	// Parameters are Postgres settings applied to the role's sessions with
+	// ALTER ROLE SET, e.g. statement_timeout or
+	// idle_in_transaction_session_timeout. Settings made outside of this
+	// list are reset.
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// InRoles lists the roles this role is a member of. Memberships granted
+	// outside of this list are revoked.
+	// +optional
+	InRoles []string `json:"inRoles,omitempty"`
+
+	// ReclaimPolicy decides whether deleting the resource drops the role.
+	// Defaults to Retain.
+	// +optional
+	ReclaimPolicy ReclaimPolicy `json:"reclaimPolicy,omitempty"`
+
+	// ReassignOwnedTo is the role that takes over the objects owned by this
+	// one, in every database, before it is dropped. The role's remaining
+	// privileges are dropped along with it. Without it, dropping a role
+	// that still owns objects fails until they are dealt with by hand.
+	// +optional
+	ReassignOwnedTo string `json:"reassignOwnedTo,omitempty"`
+}
+
+// PasswordRotation configures automatic password changes for a role
+type PasswordRotation struct {
+	// Interval between two rotations, e.g. 720h
+	Interval metav1.Duration `json:"interval"`
+}
+
+// SecretProviderPassword is a password mounted by the Secrets Store CSI
+// driver
+type SecretProviderPassword struct {
+	// SecretProviderClass in the same namespace that mounts the password
+	SecretProviderClass string `json:"secretProviderClass"`
+
+	// ObjectName is the file the provider mounts the password as
+	ObjectName string `json:"objectName"`
+
+	// ServiceAccountName runs the Job under a service account the provider
+	// authenticates, e.g. through workload identity
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// RoleStatus defines the observed state of Role
+type RoleStatus struct {
+	// ObservedGeneration is the generation of the spec last applied
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// PasswordSecretVersion is the resource version of the password Secret
+	// last applied to the role
+	// +optional
+	PasswordSecretVersion string `json:"passwordSecretVersion,omitempty"`
+
+	// PasswordProviderGeneration is the generation of the spec whose
+	// PasswordSecretProvider password was last set
+	// +optional
+	PasswordProviderGeneration int64 `json:"passwordProviderGeneration,omitempty"`
+
+	// LastRotationTime is when the operator last generated a password, or
+	// started the clock on an existing one
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// Conditions report whether the role has been applied to the instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Role is the Schema for the roles API
+type Role struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RoleSpec   `json:"spec,omitempty"`
+	Status RoleStatus `json:"status,omitempty"`
+}
+
+// RoleName is the name of the role in Postgres
+func (r *Role) RoleName() string {
+	if r.Spec.Name != "" {
+		return r.Spec.Name
+	}
+	return r.Name
+}
+
+//+kubebuilder:object:root=true
+
+// RoleList contains a list of Role
+type RoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Role `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Role{}, &RoleList{})
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SchemaSpec defines the desired state of Schema
+type SchemaSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the schema
+	// lives on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Database the schema is created in
+	Database string `json:"database"`
+
+	// Name of the schema in Postgres. Defaults to the name of the resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Owner is the role owning the schema. Defaults to the superuser.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// DropOnDelete drops the schema, along with everything in it, when the
+	// resource is deleted. By default the schema is left in place.
+	// +optional
+	DropOnDelete bool `json:"dropOnDelete,omitempty"`
+}
+
+// SchemaStatus defines the observed state of Schema
+type SchemaStatus struct {
+	// Conditions report whether the schema has been applied to the instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Schema is the Schema for the schemas API
+type Schema struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SchemaSpec   `json:"spec,omitempty"`
+	Status SchemaStatus `json:"status,omitempty"`
+}
+
+// SchemaName is the name of the schema in Postgres
+func (s *Schema) SchemaName() string {
+	if s.Spec.Name != "" {
+		return s.Spec.Name
+	}
+	return s.Name
+}
+
+//+kubebuilder:object:root=true
+
+// SchemaList contains a list of Schema
+type SchemaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Schema `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Schema{}, &SchemaList{})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SQLJobSpec defines the desired state of SQLJob
+type SQLJobSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, the SQL runs on
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Database the SQL runs in
+	Database string `json:"database"`
+
+	// SQL to run. Several statements run in a single implicit transaction
+	// unless the script manages transactions itself.
+	// +optional
+	SQL string `json:"sql,omitempty"`
+
+	// ConfigMapRef selects a ConfigMap key, in the same namespace, holding
+	// the SQL to run instead of SQL
+	// +optional
+	ConfigMapRef *corev1.ConfigMapKeySelector `json:"configMapRef,omitempty"`
+
+	// CredentialsSecretRef names a Secret, in the same namespace, with the
+	// user and password keys to run the SQL as. Defaults to the superuser.
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+
+	// Schedule runs the SQL repeatedly, as a cron expression (minute hour
+	// day-of-month month day-of-week). Without it the SQL runs once for
+	// every change of the spec.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// SQLJobRun is the outcome of running a SQLJob
+type SQLJobRun struct {
+	StartTime      metav1.Time `json:"startTime"`
+	CompletionTime metav1.Time `json:"completionTime"`
+
+	Succeeded bool `json:"succeeded"`
+
+	// Output holds the rows returned, tab separated and truncated to a few
+	// kilobytes
+	// +optional
+	Output string `json:"output,omitempty"`
+
+	// Error returned by Postgres when the run failed
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// SQLJobStatus defines the observed state of SQLJob
+type SQLJobStatus struct {
+	// ObservedGeneration is the generation of the spec last run
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastRun is the most recent run
+	// +optional
+	LastRun *SQLJobRun `json:"lastRun,omitempty"`
+
+	// NextRun is when a scheduled job runs next
+	// +optional
+	NextRun *metav1.Time `json:"nextRun,omitempty"`
+
+	// Conditions report whether the last run succeeded
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// SQLJob is the Schema for the sqljobs API
+type SQLJob struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SQLJobSpec   `json:"spec,omitempty"`
+	Status SQLJobStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SQLJobList contains a list of SQLJob
+type SQLJobList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SQLJob `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SQLJob{}, &SQLJobList{})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// StorageEncryptionPolicy decides which StorageClasses count as encrypted,
+// by their parameters
+type StorageEncryptionPolicy struct {
+	// Matchers are parameters in the form key=value, or just key for any
+	// value, e.g. encrypted=true for the AWS EBS driver. A class matching
+	// any of them is encrypted. Without matchers, storage is not checked.
+	Matchers []string
+}
+
+// OperatorStorageEncryptionPolicy is the policy of the running operator, set
+// from its command line
+var OperatorStorageEncryptionPolicy StorageEncryptionPolicy
+
+// Enabled reports whether storage has to be encrypted at all
+func (p StorageEncryptionPolicy) Enabled() bool {
+	return len(p.Matchers) > 0
+}
+
+// Encrypted reports whether a StorageClass with the parameters given
+// matches the policy
+func (p StorageEncryptionPolicy) Encrypted(parameters map[string]string) bool {
+	for _, matcher := range p.Matchers {
+		key, value, hasValue := strings.Cut(matcher, "=")
+		actual, ok := parameters[key]
+		if ok && (!hasValue || strings.EqualFold(actual, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultStorageClassAnnotation marks the StorageClass claims without a
+// class get
+const defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+
+// validateStorageEncryption rejects instances with volumes on StorageClasses
+// the operator's encryption policy does not match. An instance without
+// storage keeps its data on the node and is rejected too. Updates are only
+// checked when the storage changes, as existing claims keep their class.
+// External servers keep their data where their provider puts it.
+func (v *postgresqlValidator) validateStorageEncryption(ctx context.Context, pg, old *Postgresql) error {
+	policy := OperatorStorageEncryptionPolicy
+	if !policy.Enabled() || pg.Spec.External != nil {
+		return nil
+	}
+	if old != nil && reflect.DeepEqual(old.Spec.Storage, pg.Spec.Storage) &&
+		reflect.DeepEqual(old.Spec.Tablespaces, pg.Spec.Tablespaces) {
+		return nil
+	}
+	if pg.Spec.Storage == nil {
+		return fmt.Errorf("spec.storage is required, the operator only allows encrypted storage")
+	}
+
+	volumes := map[string]*string{"spec.storage": pg.Spec.Storage.StorageClassName}
+	for i, tablespace := range pg.Spec.Tablespaces {
+		volumes[fmt.Sprintf("spec.tablespaces[%d].storage", i)] = tablespace.Storage.StorageClassName
+	}
+	for field, name := range volumes {
+		class, err := v.storageClass(ctx, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		if !policy.Encrypted(class.Parameters) {
+			return fmt.Errorf("%s: storage class %s is not encrypted, it has none of the parameters %s",
+				field, class.Name, strings.Join(policy.Matchers, ", "))
+		}
+	}
+	return nil
+}
+
+// storageClass looks up a StorageClass by name, or the default one
+func (v *postgresqlValidator) storageClass(ctx context.Context, name *string) (*storagev1.StorageClass, error) {
+	if name != nil && *name != "" {
+		var class storagev1.StorageClass
+		if err := v.client.Get(ctx, types.NamespacedName{Name: *name}, &class); err != nil {
+			return nil, err
+		}
+		return &class, nil
+	}
+	var classes storagev1.StorageClassList
+	if err := v.client.List(ctx, &classes); err != nil {
+		return nil, err
+	}
+	for i := range classes.Items {
+		if classes.Items[i].Annotations[defaultStorageClassAnnotation] == "true" {
+			return &classes.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("there is no default storage class")
+}
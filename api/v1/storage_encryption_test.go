@@ -0,0 +1,95 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStorageEncryptionPolicy(t *testing.T) {
+	policy := StorageEncryptionPolicy{Matchers: []string{"encrypted=true", "disk-encryption-kms-key"}}
+	tests := []struct {
+		parameters map[string]string
+		want       bool
+	}{
+		{map[string]string{"encrypted": "true"}, true},
+		{map[string]string{"encrypted": "TRUE"}, true},
+		{map[string]string{"encrypted": "false"}, false},
+		{map[string]string{"disk-encryption-kms-key": "projects/p/keys/k"}, true},
+		{map[string]string{"type": "gp3"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := policy.Encrypted(tt.parameters); got != tt.want {
+			t.Errorf("Encrypted(%v) = %v, want %v", tt.parameters, got, tt.want)
+		}
+	}
+}
+
+func TestValidateStorageEncryption(t *testing.T) {
+	saved := OperatorStorageEncryptionPolicy
+	defer func() { OperatorStorageEncryptionPolicy = saved }()
+	OperatorStorageEncryptionPolicy = StorageEncryptionPolicy{Matchers: []string{"encrypted=true"}}
+
+	encrypted := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "encrypted"},
+		Parameters:  map[string]string{"encrypted": "true"},
+		Provisioner: "ebs.csi.aws.com",
+	}
+	plain := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "plain", Annotations: map[string]string{defaultStorageClassAnnotation: "true"}},
+		Provisioner: "ebs.csi.aws.com",
+	}
+	v := &postgresqlValidator{client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(encrypted, plain).Build()}
+	ctx := context.Background()
+	className := func(name string) *StorageSpec {
+		return &StorageSpec{Size: resource.MustParse("1Gi"), StorageClassName: &name}
+	}
+
+	pg := postgresqlWithVersion("", nil)
+	if err := v.ValidateCreate(ctx, pg); err == nil {
+		t.Error("expected an instance without storage to be rejected")
+	}
+	pg.Spec.Storage = className("encrypted")
+	if err := v.ValidateCreate(ctx, pg); err != nil {
+		t.Errorf("encrypted storage class should be accepted, got %v", err)
+	}
+	pg.Spec.Tablespaces = []TablespaceSpec{{Name: "hot", Storage: *className("plain")}}
+	if err := v.ValidateCreate(ctx, pg); err == nil {
+		t.Error("expected a tablespace on an unencrypted class to be rejected")
+	}
+	pg.Spec.Tablespaces = nil
+	pg.Spec.Storage = &StorageSpec{Size: resource.MustParse("1Gi")}
+	if err := v.ValidateCreate(ctx, pg); err == nil {
+		t.Error("expected the unencrypted default class to be rejected")
+	}
+
+	// Existing instances are only held to the policy when their storage
+	// changes
+	updated := pg.DeepCopy()
+	updated.Spec.Version = "14.9"
+	if err := v.ValidateUpdate(ctx, pg, updated); err != nil {
+		t.Errorf("unchanged storage should be accepted, got %v", err)
+	}
+}
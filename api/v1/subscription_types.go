@@ -0,0 +1,105 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SubscriptionSpec defines the desired state of Subscription
+type SubscriptionSpec struct {
+	// InstanceRef is the Postgresql, in the same namespace, that subscribes
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Database the subscription is created in
+	Database string `json:"database"`
+
+	// Name of the subscription in Postgres. Defaults to the name of the
+	// resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// ConnectionSecretRef selects the key of a Secret, in the same
+	// namespace, holding the libpq connection string of the publisher
+	ConnectionSecretRef corev1.SecretKeySelector `json:"connectionSecretRef"`
+
+	// Publications on the publisher to subscribe to
+	// +kubebuilder:validation:MinItems=1
+	Publications []string `json:"publications"`
+
+	// SlotName is the replication slot used on the publisher. Defaults to
+	// the name of the subscription.
+	// +optional
+	SlotName string `json:"slotName,omitempty"`
+
+	// CreateSlot creates the replication slot on the publisher. Turn it off
+	// when the slot has been created there already.
+	// +kubebuilder:default=true
+	// +optional
+	CreateSlot *bool `json:"createSlot,omitempty"`
+}
+
+// SubscriptionStatus defines the observed state of Subscription
+type SubscriptionStatus struct {
+	// ApplyLag is how long ago the last change from the publisher was
+	// applied
+	// +optional
+	ApplyLag *metav1.Duration `json:"applyLag,omitempty"`
+
+	// Conditions report whether the subscription has been applied to the
+	// instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Subscription is the Schema for the subscriptions API
+type Subscription struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SubscriptionSpec   `json:"spec,omitempty"`
+	Status SubscriptionStatus `json:"status,omitempty"`
+}
+
+// SubscriptionName is the name of the subscription in Postgres
+func (s *Subscription) SubscriptionName() string {
+	if s.Spec.Name != "" {
+		return s.Spec.Name
+	}
+	return s.Name
+}
+
+//+kubebuilder:object:root=true
+
+// SubscriptionList contains a list of Subscription
+type SubscriptionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Subscription `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Subscription{}, &SubscriptionList{})
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// instance looks up a Postgresql in the namespace of the plugin
+func (p *plugin) instance(ctx context.Context, name string) (*databasev1.Postgresql, error) {
+	var pg databasev1.Postgresql
+	if err := p.client.Get(ctx, types.NamespacedName{Namespace: p.namespace, Name: name}, &pg); err != nil {
+		return nil, err
+	}
+	return &pg, nil
+}
+
+// status prints the phase, version, external endpoints and conditions of an
+// instance
+func (p *plugin) status(ctx context.Context, name string) error {
+	pg, err := p.instance(ctx, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.stdout, "Name:       %s\n", pg.Name)
+	fmt.Fprintf(p.stdout, "Namespace:  %s\n", pg.Namespace)
+	fmt.Fprintf(p.stdout, "Phase:      %s\n", pg.Status.Phase)
+	fmt.Fprintf(p.stdout, "Version:    %s\n", pg.Status.Version)
+	if pg.Spec.External != nil {
+		fmt.Fprintf(p.stdout, "External:   %s:%d\n", pg.Spec.External.Host, pg.Spec.External.Port)
+	}
+	w := tabwriter.NewWriter(p.stdout, 0, 4, 2, ' ', 0)
+	if len(pg.Status.Endpoints) > 0 {
+		fmt.Fprintln(p.stdout, "\nEndpoints:")
+		fmt.Fprintln(w, "  SERVICE\tHOST\tPORT\tADDRESSES")
+		for _, endpoint := range pg.Status.Endpoints {
+			fmt.Fprintf(w, "  %s\t%s\t%d\t%s\n", endpoint.Service, endpoint.Host, endpoint.Port, strings.Join(endpoint.Addresses, ","))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(pg.Status.Conditions) == 0 {
+		return nil
+	}
+	fmt.Fprintln(p.stdout, "\nConditions:")
+	fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tMESSAGE")
+	for _, condition := range pg.Status.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", condition.Type, condition.Status, condition.Reason, condition.Message)
+	}
+	return w.Flush()
+}
+
+// restart sets the restart annotation, which the operator acts on within
+// the maintenance window of the instance
+func (p *plugin) restart(ctx context.Context, name string) error {
+	pg, err := p.instance(ctx, name)
+	if err != nil {
+		return err
+	}
+	if pg.Spec.External != nil {
+		return fmt.Errorf("%s is an external server, the operator cannot restart it", name)
+	}
+	if err := p.annotate(ctx, pg, databasev1.RestartedAtAnnotation, time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.stdout, "postgresql/%s restart requested\n", name)
+	return nil
+}
+
+// promote sets the promote annotation to the pod given, by default the pod
+// of the instance itself
+func (p *plugin) promote(ctx context.Context, name string, args []string) error {
+	pg, err := p.instance(ctx, name)
+	if err != nil {
+		return err
+	}
+	if pg.Spec.External != nil {
+		return fmt.Errorf("%s is an external server, the operator cannot promote it", name)
+	}
+	pod := name
+	if len(args) > 0 {
+		pod = args[0]
+	}
+	if err := p.annotate(ctx, pg, databasev1.PromoteAnnotation, pod); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.stdout, "postgresql/%s promotion of %s requested\n", name, pod)
+	return nil
+}
+
+func (p *plugin) annotate(ctx context.Context, pg *databasev1.Postgresql, key, value string) error {
+	patch := client.MergeFrom(pg.DeepCopy())
+	if pg.Annotations == nil {
+		pg.Annotations = map[string]string{}
+	}
+	pg.Annotations[key] = value
+	return p.client.Patch(ctx, pg, patch)
+}
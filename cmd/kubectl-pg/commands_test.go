@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testPlugin(pg *databasev1.Postgresql) (*plugin, *bytes.Buffer) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+	var out bytes.Buffer
+	return &plugin{
+		client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg).Build(),
+		namespace:   "db",
+		kubeContext: "staging",
+		stdout:      &out,
+		stderr:      &out,
+	}, &out
+}
+
+func testInstance() *databasev1.Postgresql {
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Status.Phase = databasev1.PgUp
+	pg.Status.Version = "14.9"
+	pg.Status.Conditions = []metav1.Condition{{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Running"}}
+	pg.Status.Endpoints = []databasev1.Endpoint{{Service: "pg-rw", Host: "pg.example.com", Port: 5432, Addresses: []string{"203.0.113.10"}}}
+	return pg
+}
+
+func TestStatus(t *testing.T) {
+	p, out := testPlugin(testInstance())
+	if err := p.run(context.Background(), "status", []string{"pg"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Phase:      up", "Version:    14.9", "Ready", "Running", "pg.example.com", "203.0.113.10"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in\n%s", expected, out.String())
+		}
+	}
+	if err := p.run(context.Background(), "status", []string{"missing"}); err == nil {
+		t.Error("expected an unknown instance to fail")
+	}
+}
+
+func TestAnnotationCommands(t *testing.T) {
+	ctx := context.Background()
+	p, _ := testPlugin(testInstance())
+	if err := p.run(ctx, "restart", []string{"pg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.run(ctx, "promote", []string{"pg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var pg databasev1.Postgresql
+	if err := p.client.Get(ctx, types.NamespacedName{Namespace: "db", Name: "pg"}, &pg); err != nil {
+		t.Fatal(err)
+	}
+	if pg.Annotations[databasev1.RestartedAtAnnotation] == "" {
+		t.Error("expected a restart to be requested")
+	}
+	if pg.Annotations[databasev1.PromoteAnnotation] != "pg" {
+		t.Errorf("expected the pod of the instance to be promoted, got %q", pg.Annotations[databasev1.PromoteAnnotation])
+	}
+}
+
+func TestExternalInstance(t *testing.T) {
+	pg := testInstance()
+	pg.Spec.External = &databasev1.ExternalSpec{Host: "db.example.com", Port: 5432}
+	p, _ := testPlugin(pg)
+	for _, command := range []string{"restart", "promote", "psql", "backup"} {
+		if err := p.run(context.Background(), command, []string{"pg"}); err == nil {
+			t.Errorf("expected %s to be refused for an external server", command)
+		}
+	}
+}
+
+func TestExecArgs(t *testing.T) {
+	p, _ := testPlugin(testInstance())
+	args := strings.Join(p.execArgs(testInstance(), true, []string{"psql", "-d", "app"}), " ")
+	if args != "exec -it -n db --context staging pg -c pg -- psql -d app" {
+		t.Errorf("unexpected kubectl arguments %q", args)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	p, _ := testPlugin(testInstance())
+	if err := p.run(context.Background(), "vacuum", []string{"pg"}); err == nil {
+		t.Error("expected an unknown command to fail")
+	}
+}
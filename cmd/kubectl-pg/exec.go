@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+// superuser is the role the operator itself connects as. It is trusted
+// over the loopback interface of the instance, so no password is needed.
+const superuser = "postgres"
+
+// psql runs psql in the instance pod, attached to the terminal
+func (p *plugin) psql(ctx context.Context, name string, args []string) error {
+	flags := flag.NewFlagSet("psql", flag.ContinueOnError)
+	database := flags.String("d", "postgres", "The database to connect to.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	pg, err := p.execInstance(ctx, name)
+	if err != nil {
+		return err
+	}
+	command := append([]string{"psql", "-h", "127.0.0.1", "-U", superuser, "-d", *database}, flags.Args()...)
+	return p.kubectl(ctx, p.execArgs(pg, true, command), os.Stdin, p.stdout)
+}
+
+// backup streams a logical backup of the instance, or of one database, to
+// standard output
+func (p *plugin) backup(ctx context.Context, name string, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	database := flags.String("d", "", "The database to back up. Defaults to all of them, with the roles.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	pg, err := p.execInstance(ctx, name)
+	if err != nil {
+		return err
+	}
+	command := []string{"pg_dumpall", "-h", "127.0.0.1", "-U", superuser}
+	if *database != "" {
+		command = []string{"pg_dump", "-h", "127.0.0.1", "-U", superuser, "-d", *database}
+	}
+	return p.kubectl(ctx, p.execArgs(pg, false, command), nil, p.stdout)
+}
+
+// execInstance looks up an instance whose pod can be exec'ed into
+func (p *plugin) execInstance(ctx context.Context, name string) (*databasev1.Postgresql, error) {
+	pg, err := p.instance(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if pg.Spec.External != nil {
+		return nil, fmt.Errorf("%s is an external server, connect to %s directly", name, pg.Spec.External.Host)
+	}
+	if pg.Status.Phase != databasev1.PgUp {
+		return nil, fmt.Errorf("%s is %s, not %s", name, pg.Status.Phase, databasev1.PgUp)
+	}
+	return pg, nil
+}
+
+// execArgs are the kubectl arguments that run command in the postgres
+// container, which is named after the instance like its pod
+func (p *plugin) execArgs(pg *databasev1.Postgresql, interactive bool, command []string) []string {
+	args := []string{"exec"}
+	if interactive {
+		args = append(args, "-it")
+	}
+	args = append(args, "-n", pg.Namespace)
+	if p.kubeContext != "" {
+		args = append(args, "--context", p.kubeContext)
+	}
+	args = append(args, pg.Name, "-c", pg.Name, "--")
+	return append(args, command...)
+}
+
+func (p *plugin) kubectl(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = p.stderr
+	return cmd.Run()
+}
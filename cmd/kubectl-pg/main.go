@@ -0,0 +1,128 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-pg is a kubectl plugin for the day-2 operations of Postgresql
+// instances, so they need no annotations or pod names to be remembered:
+//
+//	kubectl pg status NAME
+//	kubectl pg psql NAME [-d DATABASE] [-- PSQL ARGS]
+//	kubectl pg sql NAME [-d DATABASE] [-job] ["SQL"]
+//	kubectl pg backup NAME [-d DATABASE] > backup.sql
+//	kubectl pg restart NAME
+//	kubectl pg promote NAME [POD]
+//
+// Installed on the PATH, kubectl runs it for kubectl pg.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Usage: kubectl pg [-n NAMESPACE] [--context CONTEXT] COMMAND NAME
+
+Commands:
+  status NAME                       Show the phase, version, endpoints and conditions of an instance
+  psql NAME [-d DATABASE] [-- ARGS] Run psql on the instance as the superuser
+  sql NAME [-d DATABASE] [-job] SQL Run SQL, or the SQL on standard input, as the superuser
+  backup NAME [-d DATABASE]         Write a logical backup of the instance, or of one database, to standard output
+  restart NAME                      Restart the instance, within its maintenance window
+  promote NAME [POD]                Promote a standby of the instance to primary
+`
+
+// plugin holds what every command needs
+type plugin struct {
+	client      client.Client
+	namespace   string
+	kubeContext string
+	stdout      io.Writer
+	stderr      io.Writer
+}
+
+func main() {
+	flags := flag.NewFlagSet("kubectl-pg", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	var namespace, kubeContext string
+	flags.StringVar(&namespace, "n", "", "The namespace of the instance. Defaults to that of the current context.")
+	flags.StringVar(&namespace, "namespace", "", "The namespace of the instance. Defaults to that of the current context.")
+	flags.StringVar(&kubeContext, "context", "", "The kubeconfig context to use.")
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	p, err := newPlugin(namespace, kubeContext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kubectl-pg:", err)
+		os.Exit(1)
+	}
+	if err := p.run(context.Background(), flags.Arg(0), flags.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "kubectl-pg:", err)
+		os.Exit(1)
+	}
+}
+
+// newPlugin connects to the cluster of the kubeconfig, like kubectl does
+func newPlugin(namespace, kubeContext string) (*plugin, error) {
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+	if namespace == "" {
+		var err error
+		if namespace, _, err = config.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return &plugin{client: c, namespace: namespace, kubeContext: kubeContext, stdout: os.Stdout, stderr: os.Stderr}, nil
+}
+
+func (p *plugin) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "status":
+		return p.status(ctx, args[0])
+	case "psql":
+		return p.psql(ctx, args[0], args[1:])
+	case "sql":
+		return p.sql(ctx, args[0], args[1:])
+	case "backup":
+		return p.backup(ctx, args[0], args[1:])
+	case "restart":
+		return p.restart(ctx, args[0])
+	case "promote":
+		return p.promote(ctx, args[0], args[1:])
+	}
+	return fmt.Errorf("unknown command %s\n\n%s", command, usage)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// How often a SQLJob is checked for its run
+const sqlJobPoll = time.Second
+
+// sql runs ad-hoc SQL, given as an argument or on standard input, as the
+// superuser. By default it runs psql in the instance pod; with -job, or for
+// an external server, the operator runs it through a short-lived SQLJob.
+// Either way no password leaves the cluster.
+func (p *plugin) sql(ctx context.Context, name string, args []string) error {
+	flags := flag.NewFlagSet("sql", flag.ContinueOnError)
+	database := flags.String("d", "postgres", "The database to run the SQL in.")
+	job := flags.Bool("job", false, "Run the SQL through a SQLJob, which needs no exec permissions on the pod.")
+	timeout := flags.Duration("timeout", time.Minute, "How long to wait for a SQLJob to run.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	script := strings.Join(flags.Args(), " ")
+	if script == "" {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		script = string(content)
+	}
+	if strings.TrimSpace(script) == "" {
+		return errors.New("no SQL given")
+	}
+
+	pg, err := p.instance(ctx, name)
+	if err != nil {
+		return err
+	}
+	if *job || pg.Spec.External != nil {
+		return p.sqlJob(ctx, pg, *database, script, *timeout)
+	}
+	if pg.Status.Phase != databasev1.PgUp {
+		return fmt.Errorf("%s is %s, not %s", name, pg.Status.Phase, databasev1.PgUp)
+	}
+	// ON_ERROR_STOP makes psql fail, and so kubectl exit non-zero, on the
+	// first error rather than carrying on with the rest of the script
+	command := []string{"psql", "-X", "-v", "ON_ERROR_STOP=1", "-h", "127.0.0.1", "-U", superuser, "-d", *database, "-f", "-"}
+	return p.kubectl(ctx, p.execArgs(pg, false, command), strings.NewReader(script), p.stdout)
+}
+
+// sqlJob runs a script through a SQLJob, prints its output and deletes it
+func (p *plugin) sqlJob(ctx context.Context, pg *databasev1.Postgresql, database, script string, timeout time.Duration) error {
+	job := newSQLJob(pg, database, script)
+	if err := p.client.Create(ctx, job); err != nil {
+		return err
+	}
+	defer func() {
+		// The run is kept in the status only, so nothing is lost with the job
+		_ = p.client.Delete(context.Background(), job)
+	}()
+
+	run, err := p.waitForRun(ctx, job, timeout)
+	if err != nil {
+		return fmt.Errorf("sqljob/%s: %w", job.Name, err)
+	}
+	fmt.Fprint(p.stdout, run.Output)
+	if !run.Succeeded {
+		return errors.New(run.Error)
+	}
+	return nil
+}
+
+func newSQLJob(pg *databasev1.Postgresql, database, script string) *databasev1.SQLJob {
+	return &databasev1.SQLJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    pg.Namespace,
+			GenerateName: pg.Name + "-sql-",
+		},
+		Spec: databasev1.SQLJobSpec{
+			InstanceRef: corev1.LocalObjectReference{Name: pg.Name},
+			Database:    database,
+			SQL:         script,
+		},
+	}
+}
+
+// waitForRun waits for the operator to run a SQLJob once. The reason the
+// job could not run yet, if any, is returned on timeout.
+func (p *plugin) waitForRun(ctx context.Context, job *databasev1.SQLJob, timeout time.Duration) (*databasev1.SQLJobRun, error) {
+	var run *databasev1.SQLJobRun
+	var pending string
+	err := wait.PollImmediate(sqlJobPoll, timeout, func() (bool, error) {
+		if err := p.client.Get(ctx, client.ObjectKeyFromObject(job), job); err != nil {
+			return false, err
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Status == metav1.ConditionFalse {
+				pending = condition.Message
+			}
+		}
+		run = job.Status.LastRun
+		return run != nil && job.Status.ObservedGeneration == job.Generation, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) && pending != "" {
+		return nil, fmt.Errorf("not run: %s", pending)
+	}
+	return run, err
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWaitForRun(t *testing.T) {
+	ctx := context.Background()
+	p, _ := testPlugin(testInstance())
+
+	job := newSQLJob(testInstance(), "app", "SELECT 1")
+	job.Name = "pg-sql-abcde"
+	if err := p.client.Create(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Spec.InstanceRef.Name != "pg" || job.Spec.Database != "app" {
+		t.Errorf("unexpected job %+v", job.Spec)
+	}
+
+	job.Status.Conditions = []metav1.Condition{{Type: "Ready", Status: metav1.ConditionFalse, Reason: "Failed", Message: "pg is not ready"}}
+	if err := p.client.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.waitForRun(ctx, job, 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "pg is not ready") {
+		t.Errorf("expected why the job has not run, got %v", err)
+	}
+
+	job.Status.LastRun = &databasev1.SQLJobRun{Succeeded: true, Output: "1\n"}
+	job.Status.ObservedGeneration = job.Generation
+	if err := p.client.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	run, err := p.waitForRun(ctx, job, 10*time.Millisecond)
+	if err != nil || run.Output != "1\n" {
+		t.Errorf("expected the run, got %+v, %v", run, err)
+	}
+}
+
+func TestSQLWithoutScript(t *testing.T) {
+	p, _ := testPlugin(testInstance())
+	if err := p.run(context.Background(), "sql", []string{"pg", " "}); err == nil {
+		t.Error("expected blank SQL to be refused")
+	}
+}
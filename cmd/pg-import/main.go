@@ -0,0 +1,72 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// pg-import translates the postgresql resources of Zalando's operator and
+// the Cluster resources of CloudNativePG into resources of this operator:
+//
+//	kubectl get postgresql,clusters.postgresql.cnpg.io -o yaml | pg-import | kubectl apply -f -
+//
+// What cannot be translated is reported on standard error.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/convert"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func main() {
+	var file string
+	flag.StringVar(&file, "f", "-", "The file to read the resources from, - for standard input.")
+	flag.Parse()
+
+	if err := run(file, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "pg-import:", err)
+		os.Exit(1)
+	}
+}
+
+func run(file string, stdout, stderr io.Writer) error {
+	in := os.Stdin
+	if file != "-" {
+		var err error
+		if in, err = os.Open(file); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	sources, err := convert.ReadManifests(in)
+	if err != nil {
+		return err
+	}
+
+	var objects []client.Object
+	for _, source := range sources {
+		result, err := convert.Convert(source)
+		if err != nil {
+			return err
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(stderr, "%s/%s: %s\n", source.GetKind(), source.GetName(), warning)
+		}
+		objects = append(objects, result.Objects...)
+	}
+	return convert.WriteManifests(stdout, objects)
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// adopt takes over an object of the instance that no controller owns, as
+// left by older versions of the operator or created by hand: it gets the
+// instance label and the Postgresql as its controller, so that its changes
+// are watched and it goes with the instance. Objects another controller
+// owns are left alone.
+func (r *PostgresqlReconciler) adopt(ctx context.Context, pg *databasev1.Postgresql, obj client.Object) error {
+	if metav1.GetControllerOf(obj) != nil {
+		return nil
+	}
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[instanceLabel] = pg.Name
+	obj.SetLabels(labels)
+	setManagedLabels(obj, *pg)
+	if err := ctrl.SetControllerReference(pg, obj, r.Scheme); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("adopting object", "name", pg.Name, "object", obj.GetName())
+	return r.Update(ctx, obj)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAdopt(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg", UID: "uid"}}
+	orphan := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg-data"}}
+	r := &PostgresqlReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(orphan).Build(), Scheme: scheme}
+
+	if err := r.adopt(ctx, pg, orphan); err != nil {
+		t.Fatal(err)
+	}
+	var pvc v1.PersistentVolumeClaim
+	if err := r.Get(ctx, client.ObjectKeyFromObject(orphan), &pvc); err != nil {
+		t.Fatal(err)
+	}
+	if owner := metav1.GetControllerOf(&pvc); owner == nil || owner.UID != pg.UID {
+		t.Errorf("claim should be owned by the instance, got %v", pvc.OwnerReferences)
+	}
+	if pvc.Labels[instanceLabel] != pg.Name {
+		t.Errorf("claim should carry the instance label, got %v", pvc.Labels)
+	}
+
+	other := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "other", UID: "other"}}
+	if err := r.adopt(ctx, other, &pvc); err != nil {
+		t.Fatal(err)
+	}
+	if owner := metav1.GetControllerOf(&pvc); owner.UID != pg.UID {
+		t.Error("a claim owned by another instance should be left alone")
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultTopologyKey = "kubernetes.io/hostname"
+	zoneTopologyKey    = "topology.kubernetes.io/zone"
+)
+
+// podAffinity keeps the pods of an instance apart so that losing one node
+// (or zone) does not take the primary and its standbys down together.
+func podAffinity(pg databasev1.Postgresql) *v1.Affinity {
+	antiAffinityType := databasev1.PodAntiAffinityPreferred
+	topologyKey := defaultTopologyKey
+	if spec := pg.Spec.Affinity; spec != nil {
+		if spec.PodAntiAffinityType != "" {
+			antiAffinityType = spec.PodAntiAffinityType
+		}
+		if spec.TopologyKey != "" {
+			topologyKey = spec.TopologyKey
+		}
+	}
+
+	term := v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{clusterLabel: pg.Name},
+		},
+		TopologyKey: topologyKey,
+	}
+	switch antiAffinityType {
+	case databasev1.PodAntiAffinityRequired:
+		return &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{term},
+		}}
+	case databasev1.PodAntiAffinityDisabled:
+		return nil
+	default:
+		return &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+				{Weight: 100, PodAffinityTerm: term},
+			},
+		}}
+	}
+}
+
+// topologySpread puts every pod of the instance in a zone of its own when
+// Spec.Replication.ZoneSpread is set
+func topologySpread(pg databasev1.Postgresql) []v1.TopologySpreadConstraint {
+	if pg.Spec.Replication == nil || !pg.Spec.Replication.ZoneSpread {
+		return nil
+	}
+	return []v1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       zoneTopologyKey,
+		WhenUnsatisfiable: v1.DoNotSchedule,
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{clusterLabel: pg.Name},
+		},
+	}}
+}
+
+// topologySpreadPredicate is how the scheduler explains that a pod could not
+// be placed because of its topology spread constraints
+const topologySpreadPredicate = "topology spread constraints"
+
+// setDegradedCondition reports whether the zone spread asked for could be
+// met. A pod the scheduler cannot place for its spread constraints is the
+// only signal available, as nodes without a zone label are never
+// candidates under the constraint. The condition is left as it is while
+// there is no pod, or while the pod cannot be placed for other reasons.
+func setDegradedCondition(pg *databasev1.Postgresql, pod *v1.Pod) {
+	if pg.Spec.Replication == nil || !pg.Spec.Replication.ZoneSpread {
+		meta.RemoveStatusCondition(&pg.Status.Conditions, databasev1.ConditionDegraded)
+		return
+	}
+	if pod == nil || pod.Name == "" {
+		return
+	}
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason.PlacementSatisfied,
+		Message:            "pods are spread over zones",
+		ObservedGeneration: pg.Generation,
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type != v1.PodScheduled || c.Status != v1.ConditionFalse || c.Reason != v1.PodReasonUnschedulable {
+			continue
+		}
+		if !strings.Contains(c.Message, topologySpreadPredicate) {
+			return
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reason.ZoneSpreadUnsatisfiable
+		condition.Message = fmt.Sprintf("pod %s cannot be placed in a zone of its own: %s", pod.Name, c.Message)
+	}
+	meta.SetStatusCondition(&pg.Status.Conditions, condition)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodAffinity(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name = "pg"
+	affinity := podAffinity(pg)
+	if affinity == nil || len(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 0 {
+		t.Fatalf("expected a preferred anti-affinity by default, got %+v", affinity)
+	}
+	preferred := affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(preferred) != 1 || preferred[0].Weight != 100 || preferred[0].PodAffinityTerm.TopologyKey != defaultTopologyKey ||
+		!reflect.DeepEqual(preferred[0].PodAffinityTerm.LabelSelector.MatchLabels, map[string]string{clusterLabel: "pg"}) {
+		t.Errorf("expected the pods of the instance to prefer separate nodes, got %+v", preferred)
+	}
+
+	pg.Spec.Affinity = &databasev1.AffinitySpec{PodAntiAffinityType: databasev1.PodAntiAffinityRequired, TopologyKey: zoneTopologyKey}
+	affinity = podAffinity(pg)
+	if required := affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution; len(required) != 1 ||
+		required[0].TopologyKey != zoneTopologyKey || len(affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 0 {
+		t.Errorf("expected the pods of the instance to require separate zones, got %+v", affinity)
+	}
+
+	pg.Spec.Affinity.PodAntiAffinityType = databasev1.PodAntiAffinityDisabled
+	if affinity := podAffinity(pg); affinity != nil {
+		t.Errorf("expected no anti-affinity when disabled, got %+v", affinity)
+	}
+}
+
+func TestTopologySpread(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name = "pg"
+	if constraints := topologySpread(pg); constraints != nil {
+		t.Errorf("expected no constraint without a zone spread, got %+v", constraints)
+	}
+	pg.Spec.Replication = &databasev1.ReplicationSpec{ZoneSpread: true}
+	constraints := topologySpread(pg)
+	if len(constraints) != 1 || constraints[0].TopologyKey != zoneTopologyKey || constraints[0].MaxSkew != 1 ||
+		constraints[0].WhenUnsatisfiable != v1.DoNotSchedule {
+		t.Errorf("expected every pod in a zone of its own, got %+v", constraints)
+	}
+}
+
+func TestSetDegradedCondition(t *testing.T) {
+	pg := &databasev1.Postgresql{}
+	pg.Spec.Replication = &databasev1.ReplicationSpec{ZoneSpread: true}
+	unschedulable := func(message string) *v1.Pod {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pg"}}
+		pod.Status.Conditions = []v1.PodCondition{{
+			Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable, Message: message,
+		}}
+		return pod
+	}
+
+	// Nothing is known about the placement before there is a pod
+	setDegradedCondition(pg, &v1.Pod{})
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionDegraded); condition != nil {
+		t.Errorf("expected no condition without a pod, got %+v", condition)
+	}
+
+	setDegradedCondition(pg, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pg"}})
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionDegraded); condition == nil || condition.Reason != reason.PlacementSatisfied {
+		t.Errorf("expected the placement to be satisfied, got %+v", condition)
+	}
+
+	// A pod short of memory says nothing about the zones
+	setDegradedCondition(pg, unschedulable("0/3 nodes are available: 3 Insufficient memory."))
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionDegraded); condition.Status != metav1.ConditionFalse {
+		t.Errorf("expected other scheduling failures to be left out, got %+v", condition)
+	}
+
+	setDegradedCondition(pg, unschedulable("0/3 nodes are available: 3 node(s) didn't match pod topology spread constraints."))
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionDegraded); condition.Status != metav1.ConditionTrue ||
+		condition.Reason != reason.ZoneSpreadUnsatisfiable {
+		t.Errorf("expected the zone spread to be reported unsatisfiable, got %+v", condition)
+	}
+
+	pg.Spec.Replication.ZoneSpread = false
+	setDegradedCondition(pg, unschedulable(""))
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionDegraded); condition != nil {
+		t.Errorf("expected the condition to go with the zone spread, got %+v", condition)
+	}
+}
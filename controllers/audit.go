@@ -0,0 +1,153 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Where the server writes its log files when auditing, shared with the
+// audit container
+const logMountPath = "/var/log/postgresql"
+
+const defaultAuditFlushInterval = time.Minute
+
+// auditScript follows the CSV log files, one per hour of the day, and
+// writes the audit records to its output. With a sink configured, the
+// records are also collected and shipped at the flush interval.
+const auditScript = `set -u
+files=""
+for hour in $(seq -w 0 23); do
+  files="$files $LOG_DIRECTORY/postgresql-$hour.csv"
+done
+batch=/tmp/audit-batch.csv
+ship() {
+  [ -s "$batch" ] || return 0
+  name="$POD_NAME-$(date -u +%Y%m%dT%H%M%SZ).csv"
+  mv "$batch" "/tmp/$name"
+  if [ -n "${AUDIT_WEBHOOK_URL:-}" ]; then
+    curl -fsS -X POST -H 'Content-Type: text/csv' --data-binary "@/tmp/$name" "$AUDIT_WEBHOOK_URL" ||
+      echo "shipping $name to the webhook failed" >&2
+  fi
+  if [ -n "${AUDIT_S3_URL:-}" ]; then
+    curl -fsS --aws-sigv4 "aws:amz:$AWS_REGION:s3" --user "$AWS_ACCESS_KEY_ID:$AWS_SECRET_ACCESS_KEY" \
+      -T "/tmp/$name" "$AUDIT_S3_URL$name" || echo "uploading $name to S3 failed" >&2
+  fi
+  rm -f "/tmp/$name"
+}
+shipping="${AUDIT_WEBHOOK_URL:-}${AUDIT_S3_URL:-}"
+if [ -n "$shipping" ]; then
+  (while sleep "$AUDIT_FLUSH_SECONDS"; do ship; done) &
+fi
+tail -q -n +1 -F $files 2>/dev/null | while IFS= read -r line; do
+  case "$line" in
+  *',"AUDIT: '*)
+    printf '%s\n' "$line"
+    if [ -n "$shipping" ]; then printf '%s\n' "$line" >>"$batch"; fi
+    ;;
+  esac
+done
+`
+
+// auditParameters load pgaudit, on top of any other preloaded libraries,
+// and have the server log to hourly CSV files the audit container can read
+func auditParameters(pg databasev1.Postgresql) map[string]string {
+	audit := pg.Spec.Audit
+	classes := audit.Log
+	if len(classes) == 0 {
+		classes = []string{"ddl", "role"}
+	}
+	libraries := []string{}
+	for _, library := range strings.Split(pg.Spec.Parameters["shared_preload_libraries"], ",") {
+		if library = strings.TrimSpace(library); library != "" && library != "pgaudit" {
+			libraries = append(libraries, library)
+		}
+	}
+	return map[string]string{
+		"shared_preload_libraries": strings.Join(append(libraries, "pgaudit"), ","),
+		"pgaudit.log":              strings.Join(classes, ","),
+		"logging_collector":        "on",
+		"log_destination":          "stderr,csvlog",
+		"log_directory":            logMountPath,
+		"log_filename":             "postgresql-%H",
+		"log_rotation_age":         "60",
+		"log_rotation_size":        "0",
+		"log_truncate_on_rotation": "on",
+		"log_file_mode":            "0644",
+	}
+}
+
+// addAuditContainer shares the log directory of the server with the audit
+// container. The server's own log keeps going to its output, through the
+// wrapper script following the plain log files.
+func addAuditContainer(pg databasev1.Postgresql, spec *v1.PodSpec) {
+	const logVolume = "logs"
+	audit := pg.Spec.Audit
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name: logVolume, VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	mount := v1.VolumeMount{Name: logVolume, MountPath: logMountPath}
+	server := &spec.Containers[0]
+	server.VolumeMounts = append(server.VolumeMounts, mount)
+	server.Env = append(server.Env, v1.EnvVar{Name: "LOG_DIRECTORY", Value: logMountPath})
+
+	flush := defaultAuditFlushInterval
+	if audit.FlushInterval != nil {
+		flush = audit.FlushInterval.Duration
+	}
+	mount.ReadOnly = true
+	container := v1.Container{
+		Name:    "audit",
+		Image:   operatorconfig.Get().CurlImage,
+		Command: []string{"sh", "-c", auditScript},
+		Env: []v1.EnvVar{
+			{Name: "LOG_DIRECTORY", Value: logMountPath},
+			{Name: "AUDIT_FLUSH_SECONDS", Value: strconv.Itoa(int(flush.Seconds()))},
+			{Name: "POD_NAME", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+		},
+		VolumeMounts: []v1.VolumeMount{mount},
+	}
+	if audit.Webhook != nil {
+		container.Env = append(container.Env, v1.EnvVar{Name: "AUDIT_WEBHOOK_URL", Value: audit.Webhook.URL})
+	}
+	if s3 := audit.S3; s3 != nil {
+		container.Env = append(container.Env,
+			v1.EnvVar{Name: "AUDIT_S3_URL", Value: auditS3URL(s3)},
+			v1.EnvVar{Name: "AWS_REGION", Value: s3.Region})
+		container.EnvFrom = []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: s3.CredentialsSecretRef}}}
+	}
+	spec.Containers = append(spec.Containers, container)
+}
+
+// auditS3URL is the path-style URL object names are appended to
+func auditS3URL(s3 *databasev1.AuditS3Sink) string {
+	endpoint := strings.TrimSuffix(s3.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+	}
+	url := endpoint + "/" + s3.Bucket + "/"
+	if prefix := strings.Trim(s3.Prefix, "/"); prefix != "" {
+		url += prefix + "/"
+	}
+	return url
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAuditParameters(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.Audit = &databasev1.AuditSpec{}
+	pg.Spec.Parameters = map[string]string{"shared_preload_libraries": "pg_stat_statements, pgaudit"}
+
+	parameters := auditParameters(pg)
+	if got := parameters["shared_preload_libraries"]; got != "pg_stat_statements,pgaudit" {
+		t.Errorf("pgaudit should be preloaded once next to other libraries, got %q", got)
+	}
+	if got := parameters["pgaudit.log"]; got != "ddl,role" {
+		t.Errorf("expected the default classes, got %q", got)
+	}
+	if parameters["log_destination"] != "stderr,csvlog" || parameters["log_directory"] != logMountPath {
+		t.Errorf("server should log CSV files to the shared directory, got %v", parameters)
+	}
+
+	pg.Spec.Audit.Log = []string{"write"}
+	if got := serverParameters(pg)["pgaudit.log"]; got != "write" {
+		t.Errorf("expected the classes given, got %q", got)
+	}
+}
+
+func TestAddAuditContainer(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.Audit = &databasev1.AuditSpec{
+		Webhook:       &databasev1.AuditWebhookSink{URL: "https://audit.example.com/pg"},
+		S3:            &databasev1.AuditS3Sink{Bucket: "audit", Prefix: "/pg/", Region: "eu-west-1", CredentialsSecretRef: v1.LocalObjectReference{Name: "s3"}},
+		FlushInterval: &metav1.Duration{Duration: 30 * time.Second},
+	}
+	spec := v1.PodSpec{Containers: []v1.Container{{Name: "postgres"}}}
+	addAuditContainer(pg, &spec)
+
+	if len(spec.Containers) != 2 || spec.Containers[1].Name != "audit" {
+		t.Fatalf("expected an audit container, got %+v", spec.Containers)
+	}
+	if len(spec.Containers[0].VolumeMounts) != 1 || spec.Containers[0].VolumeMounts[0].MountPath != logMountPath {
+		t.Errorf("server should write its logs to the shared volume, got %+v", spec.Containers[0].VolumeMounts)
+	}
+	env := map[string]string{}
+	for _, variable := range spec.Containers[1].Env {
+		env[variable.Name] = variable.Value
+	}
+	if env["AUDIT_S3_URL"] != "https://s3.eu-west-1.amazonaws.com/audit/pg/" {
+		t.Errorf("unexpected S3 URL %q", env["AUDIT_S3_URL"])
+	}
+	if env["AUDIT_WEBHOOK_URL"] != "https://audit.example.com/pg" || env["AUDIT_FLUSH_SECONDS"] != "30" {
+		t.Errorf("unexpected environment %v", env)
+	}
+	if envFrom := spec.Containers[1].EnvFrom; len(envFrom) != 1 || envFrom[0].SecretRef.Name != "s3" {
+		t.Errorf("S3 credentials should come from their Secret, got %+v", envFrom)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// Lifetimes of the certificates the operator issues itself. Server and
+// client certificates are renewed once less than
+// serverCertificateRenewBefore of their lifetime is left.
+const (
+	caCertificateValidity        = 10 * 365 * 24 * time.Hour
+	serverCertificateValidity    = 365 * 24 * time.Hour
+	serverCertificateRenewBefore = 30 * 24 * time.Hour
+)
+
+// keyPair is a PEM encoded certificate and its private key
+type keyPair struct {
+	Certificate []byte
+	Key         []byte
+}
+
+// newCA creates a self-signed CA certificate
+func newCA(commonName string, now time.Time) (keyPair, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caCertificateValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return issueCertificate(template, nil, nil)
+}
+
+// newServerCertificate creates a server certificate for the DNS names,
+// signed by the CA
+func newServerCertificate(ca keyPair, dnsNames []string, now time.Time) (keyPair, error) {
+	caCert, caKey, err := parseKeyPair(ca)
+	if err != nil {
+		return keyPair{}, err
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(serverCertificateValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return issueCertificate(template, caCert, caKey)
+}
+
+// newClientCertificate creates a certificate authenticating the user, signed
+// by the CA. Postgres matches the common name against the role.
+func newClientCertificate(ca keyPair, user string, now time.Time) (keyPair, error) {
+	caCert, caKey, err := parseKeyPair(ca)
+	if err != nil {
+		return keyPair{}, err
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: user},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(serverCertificateValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return issueCertificate(template, caCert, caKey)
+}
+
+// issueCertificate generates a key for the template and signs it with the
+// parent, or with itself when there is no parent
+func issueCertificate(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return keyPair{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return keyPair{}, err
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return keyPair{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return keyPair{}, err
+	}
+	return keyPair{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:         pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func parseKeyPair(pair keyPair) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := parseCertificate(pair.Certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(pair.Key)
+	if block == nil {
+		return nil, nil, errors.New("no PEM encoded key found")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// serverCertificateCurrent reports whether a server certificate is signed
+// by the CA, covers the DNS names and is not due for renewal
+func serverCertificateCurrent(server []byte, ca []byte, dnsNames []string, now time.Time) bool {
+	cert, ok := verifiedCertificate(server, ca, x509.ExtKeyUsageServerAuth, now)
+	if !ok {
+		return false
+	}
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// clientCertificateCurrent reports whether a client certificate is signed
+// by the CA, names the user and is not due for renewal
+func clientCertificateCurrent(client []byte, ca []byte, user string, now time.Time) bool {
+	cert, ok := verifiedCertificate(client, ca, x509.ExtKeyUsageClientAuth, now)
+	return ok && cert.Subject.CommonName == user
+}
+
+// verifiedCertificate parses a certificate and checks it chains to the CA
+// for the usage and stays valid for longer than the renewal margin
+func verifiedCertificate(data []byte, ca []byte, usage x509.ExtKeyUsage, now time.Time) (*x509.Certificate, bool) {
+	cert, err := parseCertificate(data)
+	if err != nil {
+		return nil, false
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, false
+	}
+	if now.Add(serverCertificateRenewBefore).After(cert.NotAfter) {
+		return nil, false
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+		return nil, false
+	}
+	return cert, true
+}
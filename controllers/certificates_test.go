@@ -0,0 +1,80 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerCertificate(t *testing.T) {
+	now := time.Now()
+	ca, err := newCA("pg CA", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"pg-rw", "pg-rw.default.svc"}
+	server, err := newServerCertificate(ca, names, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !serverCertificateCurrent(server.Certificate, ca.Certificate, names, now) {
+		t.Error("fresh certificate should be current")
+	}
+	if serverCertificateCurrent(server.Certificate, ca.Certificate, append(names, "pg-ro"), now) {
+		t.Error("certificate should not cover a name it was not issued for")
+	}
+	if serverCertificateCurrent(server.Certificate, ca.Certificate, names, now.Add(serverCertificateValidity-serverCertificateRenewBefore/2)) {
+		t.Error("certificate close to expiry should be renewed")
+	}
+	other, err := newCA("other CA", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverCertificateCurrent(server.Certificate, other.Certificate, names, now) {
+		t.Error("certificate signed by another CA should be replaced")
+	}
+	if serverCertificateCurrent(nil, ca.Certificate, names, now) {
+		t.Error("missing certificate should be issued")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	now := time.Now()
+	ca, err := newCA("pg CA", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newClientCertificate(ca, "app", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clientCertificateCurrent(client.Certificate, ca.Certificate, "app", now) {
+		t.Error("fresh certificate should be current")
+	}
+	if clientCertificateCurrent(client.Certificate, ca.Certificate, "other", now) {
+		t.Error("certificate should only authenticate its own user")
+	}
+	server, err := newServerCertificate(ca, []string{"pg-rw"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientCertificateCurrent(server.Certificate, ca.Certificate, "pg-rw", now) {
+		t.Error("server certificate should not pass as a client certificate")
+	}
+}
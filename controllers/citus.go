@@ -0,0 +1,392 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// citusLabel names the coordinator of a Citus cluster on its worker
+// Postgresqls and on the pods of every node, so the nodes' NetworkPolicies
+// let each other in
+const citusLabel = "db.example.com/citus"
+
+const citusDefaultDatabase = "postgres"
+
+// How often the workers and shards of a coordinator are looked at, as no
+// watch reports a worker or shard placement failing inside Citus
+const citusHealthInterval = time.Minute
+
+// citusCoordinator is the name of the coordinator of the Citus cluster the
+// instance belongs to, or empty when it belongs to none
+func citusCoordinator(pg databasev1.Postgresql) string {
+	if pg.Spec.Citus != nil {
+		return pg.Name
+	}
+	return pg.Labels[citusLabel]
+}
+
+// citusParameters load citus, which has to come first, ahead of any other
+// preloaded libraries. Nodes connect to each other with TLS when they have
+// it.
+func citusParameters(pg databasev1.Postgresql, preloaded string) map[string]string {
+	libraries := []string{"citus"}
+	for _, library := range strings.Split(preloaded, ",") {
+		if library = strings.TrimSpace(library); library != "" && library != "citus" {
+			libraries = append(libraries, library)
+		}
+	}
+	conninfo := "sslmode=prefer"
+	if tlsEnabled(pg) {
+		conninfo = "sslmode=require"
+	}
+	return map[string]string{
+		"shared_preload_libraries": strings.Join(libraries, ","),
+		"citus.node_conninfo":      conninfo,
+	}
+}
+
+// reconcileCitusWorkers keeps a worker Postgresql for each of the workers
+// the coordinator asks for, in line with its spec. Workers no longer asked
+// for are left to reconcileCitus, which deletes them once Citus let go.
+func (r *PostgresqlReconciler) reconcileCitusWorkers(ctx context.Context, pg *databasev1.Postgresql) error {
+	if pg.Spec.Citus == nil {
+		return nil
+	}
+	if pg.Spec.PasswordSecretRef == nil {
+		// The workers share the superuser password through its Secret,
+		// which the password is moved to first
+		return nil
+	}
+	for i := 0; i < int(pg.Spec.Citus.Workers); i++ {
+		worker := databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{
+			Name:      getCitusWorkerName(*pg, i),
+			Namespace: pg.Namespace,
+		}}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, &worker, func() error {
+			if worker.Labels == nil {
+				worker.Labels = map[string]string{}
+			}
+			worker.Labels[citusLabel] = pg.Name
+			setCitusWorkerSpec(&worker.Spec, pg.Spec)
+			return ctrl.SetControllerReference(pg, &worker, r.Scheme)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCitusWorkerSpec gives a worker the settings of its coordinator that
+// have to match across a Citus cluster, or that it cannot do without
+func setCitusWorkerSpec(worker *databasev1.PostgresqlSpec, coordinator databasev1.PostgresqlSpec) {
+	worker.Version = coordinator.Version
+	worker.ImageRepository = coordinator.ImageRepository
+	worker.PasswordSecretRef = coordinator.PasswordSecretRef.DeepCopy()
+	worker.Resources = coordinator.Resources.DeepCopy()
+	worker.Parameters = nil
+	for name, value := range coordinator.Parameters {
+		if worker.Parameters == nil {
+			worker.Parameters = map[string]string{}
+		}
+		worker.Parameters[name] = value
+	}
+	worker.UpdatePolicy = coordinator.UpdatePolicy
+	worker.TLS = coordinator.TLS.DeepCopy()
+	worker.Storage = coordinator.Storage.DeepCopy()
+	worker.MaintenanceWindow = coordinator.MaintenanceWindow.DeepCopy()
+	worker.Affinity = coordinator.Affinity.DeepCopy()
+	worker.Replication = coordinator.Replication.DeepCopy()
+	worker.PGVector = coordinator.PGVector.DeepCopy()
+}
+
+// reconcileCitus registers the workers with the coordinator once they are
+// up, removes the workers no longer asked for once Citus has moved their
+// shards away, and reports the shards in the status
+func (r *PostgresqlReconciler) reconcileCitus(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if pg.Spec.Citus == nil {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+	database := citusDatabase(*pg)
+	password, err := superuserPassword(ctx, r.Client, pg)
+	if err != nil {
+		return err
+	}
+	db, err := r.openSuperuserDB(ctx, pg, pod, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if DryRun {
+		logger.Info("dry run: would set up Citus", "name", pg.Name)
+	} else {
+		if err := setupCitusNode(ctx, db, password); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, "SELECT citus_set_coordinator_host($1, $2)", getCitusHost(*pg), postgresPort); err != nil {
+			return err
+		}
+	}
+
+	nodes, err := citusNodes(ctx, db)
+	if err != nil {
+		return err
+	}
+	var workers databasev1.PostgresqlList
+	if err := r.List(ctx, &workers, client.InNamespace(pg.Namespace), client.MatchingLabels{citusLabel: pg.Name}); err != nil {
+		return err
+	}
+
+	status := &databasev1.CitusStatus{}
+	var pending, removals []string
+	for i := range workers.Items {
+		worker := &workers.Items[i]
+		if !metav1.IsControlledBy(worker, pg) {
+			continue
+		}
+		host := getCitusHost(*worker)
+		node, registered := nodes[host]
+		wanted := citusWorkerWanted(*pg, worker.Name)
+
+		switch {
+		case !wanted && registered && DryRun:
+			logger.Info("dry run: would remove Citus worker", "name", pg.Name, "worker", worker.Name)
+		case !wanted && registered:
+			// Fails for as long as the worker holds shard placements
+			if _, err := db.ExecContext(ctx, "SELECT citus_remove_node($1, $2)", host, postgresPort); err != nil {
+				removals = append(removals, fmt.Sprintf("%s: %v", worker.Name, err))
+				break
+			}
+			logger.Info("removed Citus worker", "name", pg.Name, "worker", worker.Name)
+			registered = false
+		case wanted && !registered && worker.Status.Phase != databasev1.PgUp:
+			pending = append(pending, worker.Name)
+		case wanted && !registered && DryRun:
+			logger.Info("dry run: would add Citus worker", "name", pg.Name, "worker", worker.Name)
+			pending = append(pending, worker.Name)
+		case wanted && !registered:
+			if err := r.addCitusWorker(ctx, db, worker, database, password); err != nil {
+				if !errors.Is(err, errInstanceNotReady) {
+					return err
+				}
+				pending = append(pending, worker.Name)
+				break
+			}
+			logger.Info("added Citus worker", "name", pg.Name, "worker", worker.Name)
+			node, registered = citusNode{active: true}, true
+		case wanted && !DryRun:
+			// Keeps the password the nodes use for each other current
+			if err := r.updateCitusWorker(ctx, worker, database, password); err != nil && !errors.Is(err, errInstanceNotReady) {
+				return err
+			}
+		}
+
+		if !wanted && !registered {
+			if err := r.Delete(ctx, worker); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			continue
+		}
+		status.Workers = append(status.Workers, databasev1.CitusWorker{
+			Name:            worker.Name,
+			Registered:      registered,
+			Active:          node.active,
+			Shards:          node.shards,
+			UnhealthyShards: node.unhealthyShards,
+		})
+		status.Shards += node.shards
+	}
+	// Workers not created yet, such as while the coordinator's password
+	// is still moved to its Secret
+	for i := 0; i < int(pg.Spec.Citus.Workers); i++ {
+		if name := getCitusWorkerName(*pg, i); !citusWorkerListed(status, name) {
+			pending = append(pending, name)
+		}
+	}
+
+	pg.Status.Citus = status
+	setShardsHealthyCondition(pg, pending, removals)
+	return nil
+}
+
+// addCitusWorker creates the citus extension on a worker and has the
+// coordinator add it
+func (r *PostgresqlReconciler) addCitusWorker(ctx context.Context, coordinator *sql.DB, worker *databasev1.Postgresql, database, password string) error {
+	if err := r.updateCitusWorker(ctx, worker, database, password); err != nil {
+		return err
+	}
+	_, err := coordinator.ExecContext(ctx, "SELECT citus_add_node($1, $2)", getCitusHost(*worker), postgresPort)
+	return err
+}
+
+func (r *PostgresqlReconciler) updateCitusWorker(ctx context.Context, worker *databasev1.Postgresql, database, password string) error {
+	db, err := connectInstance(ctx, r.Client, worker.Namespace, v1.LocalObjectReference{Name: worker.Name}, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return setupCitusNode(ctx, db, password)
+}
+
+// setupCitusNode creates the citus extension and stores the password the
+// node connects to the other nodes with. Every node shares the superuser
+// password, so one entry covers them all.
+func setupCitusNode(ctx context.Context, db *sql.DB, password string) error {
+	if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS citus"); err != nil {
+		return err
+	}
+	authinfo := "password=" + conninfoQuote(password)
+	result, err := db.ExecContext(ctx,
+		"UPDATE pg_dist_authinfo SET authinfo = $1 WHERE nodeid = 0 AND rolename = $2 AND authinfo <> $1", authinfo, superuser)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO pg_dist_authinfo (nodeid, rolename, authinfo) SELECT 0, $2, $1 "+
+			"WHERE NOT EXISTS (SELECT 1 FROM pg_dist_authinfo WHERE nodeid = 0 AND rolename = $2)", authinfo, superuser)
+	return err
+}
+
+// citusNode is a worker as the coordinator sees it
+type citusNode struct {
+	active          bool
+	shards          int32
+	unhealthyShards int32
+}
+
+// citusNodes reads the workers registered with the coordinator, by host,
+// including any added by hand, which the operator leaves alone. Placements
+// in a state other than 1 are inactive.
+func citusNodes(ctx context.Context, db *sql.DB) (map[string]citusNode, error) {
+	rows, err := db.QueryContext(ctx, `SELECT n.nodename, n.isactive,
+  count(p.placementid), count(p.placementid) FILTER (WHERE p.shardstate <> 1)
+FROM pg_dist_node n LEFT JOIN pg_dist_placement p ON p.groupid = n.groupid
+WHERE n.groupid <> 0 AND n.noderole = 'primary'
+GROUP BY n.nodename, n.isactive`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	nodes := map[string]citusNode{}
+	for rows.Next() {
+		var host string
+		var node citusNode
+		if err := rows.Scan(&host, &node.active, &node.shards, &node.unhealthyShards); err != nil {
+			return nil, err
+		}
+		nodes[host] = node
+	}
+	return nodes, rows.Err()
+}
+
+// setShardsHealthyCondition sums up the workers of a coordinator
+func setShardsHealthyCondition(pg *databasev1.Postgresql, pending, removals []string) {
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionShardsHealthy,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: pg.Generation,
+	}
+	var inactive []string
+	var unhealthy int32
+	for _, worker := range pg.Status.Citus.Workers {
+		if worker.Registered && !worker.Active {
+			inactive = append(inactive, worker.Name)
+		}
+		unhealthy += worker.UnhealthyShards
+	}
+	switch {
+	case len(inactive) > 0:
+		condition.Reason = reason.WorkersInactive
+		condition.Message = "workers disabled on the coordinator: " + strings.Join(inactive, ", ")
+	case unhealthy > 0:
+		condition.Reason = reason.ShardsInactive
+		condition.Message = fmt.Sprintf("%d shard placements are inactive", unhealthy)
+	case len(pending) > 0:
+		condition.Reason = reason.WorkersPending
+		condition.Message = "workers not registered yet: " + strings.Join(pending, ", ")
+	case len(removals) > 0:
+		condition.Reason = reason.WorkerRemovalFailed
+		condition.Message = "workers cannot be removed until their shards are moved: " + strings.Join(removals, "; ")
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reason.ShardsHealthy
+		condition.Message = fmt.Sprintf("%d workers hold %d shard placements", len(pg.Status.Citus.Workers), pg.Status.Citus.Shards)
+	}
+	meta.SetStatusCondition(&pg.Status.Conditions, condition)
+}
+
+// conninfoQuote quotes a value of a libpq connection string
+func conninfoQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+func citusDatabase(pg databasev1.Postgresql) string {
+	if pg.Spec.Citus.Database != "" {
+		return pg.Spec.Citus.Database
+	}
+	return citusDefaultDatabase
+}
+
+// citusWorkerWanted reports whether the named worker is one of those the
+// coordinator asks for
+func citusWorkerWanted(pg databasev1.Postgresql, name string) bool {
+	for i := 0; i < int(pg.Spec.Citus.Workers); i++ {
+		if getCitusWorkerName(pg, i) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func citusWorkerListed(status *databasev1.CitusStatus, name string) bool {
+	for _, worker := range status.Workers {
+		if worker.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func getCitusWorkerName(pg databasev1.Postgresql, i int) string {
+	return fmt.Sprintf("%s-worker-%d", pg.Name, i)
+}
+
+// getCitusHost is the host the nodes of a Citus cluster reach a node at,
+// its -rw Service
+func getCitusHost(pg databasev1.Postgresql) string {
+	return getServiceName(pg, "rw") + "." + pg.Namespace + ".svc"
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCitusParameters(t *testing.T) {
+	pg := databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "pg"}}
+	pg.Spec.Citus = &databasev1.CitusSpec{Workers: 2}
+	pg.Spec.Parameters = map[string]string{"shared_preload_libraries": "pg_stat_statements"}
+	pg.Spec.Audit = &databasev1.AuditSpec{}
+
+	parameters := serverParameters(pg)
+	if libraries := parameters["shared_preload_libraries"]; libraries != "citus,pg_stat_statements,pgaudit" {
+		t.Errorf("expected citus to be loaded first, got %q", libraries)
+	}
+
+	worker := databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "pg-worker-0", Labels: map[string]string{citusLabel: "pg"}}}
+	if libraries := serverParameters(worker)["shared_preload_libraries"]; libraries != "citus" {
+		t.Errorf("expected workers to load citus, got %q", libraries)
+	}
+	if _, ok := serverParameters(databasev1.Postgresql{})["shared_preload_libraries"]; ok {
+		t.Error("instances outside a Citus cluster should not load citus")
+	}
+}
+
+func TestReconcileCitusWorkers(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.Version = "15.4"
+	pg.Spec.ImageRepository = "citusdata/citus"
+	pg.Spec.Parameters = map[string]string{"work_mem": "64MB"}
+	pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "pg-superuser"}, Key: "password"}
+	pg.Spec.Citus = &databasev1.CitusSpec{Workers: 2}
+	r := &PostgresqlReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg).Build(), Scheme: scheme}
+	if err := r.reconcileCitusWorkers(ctx, pg); err != nil {
+		t.Fatal(err)
+	}
+
+	var worker databasev1.Postgresql
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "pg-worker-1"}, &worker); err != nil {
+		t.Fatal(err)
+	}
+	if worker.Labels[citusLabel] != "pg" || !metav1.IsControlledBy(&worker, pg) {
+		t.Errorf("expected the worker to belong to the coordinator, got %+v", worker.ObjectMeta)
+	}
+	if worker.Spec.Version != "15.4" || worker.Spec.ImageRepository != "citusdata/citus" ||
+		worker.Spec.Parameters["work_mem"] != "64MB" || worker.Spec.PasswordSecretRef.Name != "pg-superuser" {
+		t.Errorf("expected the worker to take the coordinator's settings, got %+v", worker.Spec)
+	}
+	if worker.Spec.Citus != nil {
+		t.Error("a worker should not be a coordinator itself")
+	}
+	if getCitusHost(worker) != "pg-worker-1-rw.db.svc" {
+		t.Errorf("unexpected host %s", getCitusHost(worker))
+	}
+}
+
+func TestShardsHealthyCondition(t *testing.T) {
+	pg := &databasev1.Postgresql{}
+	pg.Status.Citus = &databasev1.CitusStatus{
+		Workers: []databasev1.CitusWorker{
+			{Name: "pg-worker-0", Registered: true, Active: true, Shards: 16},
+			{Name: "pg-worker-1", Registered: true, Active: true, Shards: 16},
+		},
+		Shards: 32,
+	}
+	setShardsHealthyCondition(pg, nil, nil)
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionShardsHealthy); condition.Status != metav1.ConditionTrue {
+		t.Errorf("expected healthy shards, got %+v", condition)
+	}
+
+	setShardsHealthyCondition(pg, []string{"pg-worker-2"}, nil)
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionShardsHealthy); condition.Reason != reason.WorkersPending {
+		t.Errorf("expected a pending worker to be reported, got %+v", condition)
+	}
+
+	pg.Status.Citus.Workers[1].UnhealthyShards = 3
+	setShardsHealthyCondition(pg, nil, nil)
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionShardsHealthy); condition.Reason != reason.ShardsInactive {
+		t.Errorf("expected inactive placements to be reported, got %+v", condition)
+	}
+
+	pg.Status.Citus.Workers[0].Active = false
+	setShardsHealthyCondition(pg, nil, nil)
+	if condition := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionShardsHealthy); condition.Reason != reason.WorkersInactive {
+		t.Errorf("expected a disabled worker to be reported, got %+v", condition)
+	}
+}
+
+func TestConninfoQuote(t *testing.T) {
+	if quoted := conninfoQuote(`it's\here`); quoted != `'it\'s\\here'` {
+		t.Errorf("unexpected quoting %s", quoted)
+	}
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+
+// fipsEnabled reports whether an instance runs in FIPS mode
+func fipsEnabled(pg databasev1.Postgresql) bool {
+	return pg.Spec.Compliance != nil && pg.Spec.Compliance.FIPS
+}
+
+// fipsParameters keep the server to FIPS-approved algorithms for passwords
+// and TLS, whatever Spec.Parameters says
+func fipsParameters() map[string]string {
+	return map[string]string{
+		"password_encryption":      "scram-sha-256",
+		"ssl_min_protocol_version": "TLSv1.2",
+		"ssl_ciphers":              "ECDHE+AESGCM:DHE+AESGCM",
+	}
+}
+
+// operatorSSLMode is how the operator connects to an instance. FIPS
+// instances only take TLS connections from outside the pod.
+func operatorSSLMode(pg databasev1.Postgresql) string {
+	if fipsEnabled(pg) {
+		return "require"
+	}
+	return "disable"
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// MaxConcurrentReconciles is how many objects each controller reconciles at
+// once, unless ConcurrentReconciles has a number for its kind. Objects of
+// the same name are never reconciled concurrently.
+var MaxConcurrentReconciles = 1
+
+// ConcurrentReconciles holds the number of concurrent reconciles of single
+// controllers, by the kind they reconcile, e.g. Postgresql
+var ConcurrentReconciles = map[string]int{}
+
+// ParseConcurrentReconciles adds a kind=number setting to
+// ConcurrentReconciles
+func ParseConcurrentReconciles(setting string) error {
+	kind, value, ok := strings.Cut(setting, "=")
+	if !ok || kind == "" {
+		return fmt.Errorf("expected kind=number, got %q", setting)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("number of concurrent reconciles of %s must be a positive integer, got %q", kind, value)
+	}
+	ConcurrentReconciles[kind] = n
+	return nil
+}
+
+// Settings of the rate limiter of each controller's work queue. A failed
+// reconcile is retried after RetryBaseDelay, doubling with every further
+// failure up to RetryMaxDelay. On top of that, no controller retries more
+// than RetryQPS objects a second, with bursts of RetryBurst. The defaults
+// are those of controller-runtime.
+var (
+	RetryBaseDelay = 5 * time.Millisecond
+	RetryMaxDelay  = 1000 * time.Second
+	RetryQPS       = 10.0
+	RetryBurst     = 100
+)
+
+// controllerOptions are the options of the controller of kind
+func controllerOptions(kind string) controller.Options {
+	options := controller.Options{
+		MaxConcurrentReconciles: MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(RetryBaseDelay, RetryMaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(RetryQPS), RetryBurst)},
+		),
+	}
+	if n, ok := ConcurrentReconciles[kind]; ok {
+		options.MaxConcurrentReconciles = n
+	}
+	return options
+}
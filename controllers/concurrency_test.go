@@ -0,0 +1,62 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestControllerOptions(t *testing.T) {
+	defer func(max int, byKind map[string]int) {
+		MaxConcurrentReconciles, ConcurrentReconciles = max, byKind
+	}(MaxConcurrentReconciles, ConcurrentReconciles)
+	MaxConcurrentReconciles, ConcurrentReconciles = 2, map[string]int{}
+
+	if err := ParseConcurrentReconciles("Postgresql=8"); err != nil {
+		t.Fatal(err)
+	}
+	for _, setting := range []string{"Postgresql", "=4", "Role=0", "Role=many"} {
+		if err := ParseConcurrentReconciles(setting); err == nil {
+			t.Errorf("expected %q to be rejected", setting)
+		}
+	}
+	if n := controllerOptions("Postgresql").MaxConcurrentReconciles; n != 8 {
+		t.Errorf("Postgresql should reconcile 8 at once, got %d", n)
+	}
+	if n := controllerOptions("Role").MaxConcurrentReconciles; n != 2 {
+		t.Errorf("Role should fall back to the default of 2, got %d", n)
+	}
+}
+
+func TestControllerRateLimiter(t *testing.T) {
+	defer func(base, max time.Duration) {
+		RetryBaseDelay, RetryMaxDelay = base, max
+	}(RetryBaseDelay, RetryMaxDelay)
+	RetryBaseDelay, RetryMaxDelay = time.Second, 3*time.Second
+
+	limiter := controllerOptions("Postgresql").RateLimiter
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if got := limiter.When("pg"); got != want {
+			t.Errorf("expected a retry after %s, got %s", want, got)
+		}
+	}
+	limiter.Forget("pg")
+	if got := limiter.When("pg"); got != time.Second {
+		t.Errorf("expected the delay to start over, got %s", got)
+	}
+}
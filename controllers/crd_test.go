@@ -76,4 +76,45 @@ var _ = Describe("postgresql_controller", func() {
 			return false
 		}).WithTimeout(30 * time.Second).WithPolling(time.Second).Should(BeTrue())
 	})
+
+	It("Should take a fenced instance out of service and not recreate its pod", func() {
+		const fencedName = "pg-fenced"
+		ctx := context.Background()
+		pg := databasev1.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: fencedName, Namespace: "default"},
+			Spec: databasev1.PostgresqlSpec{
+				DefaultUser: "pguser",
+				Password:    "password1!",
+			},
+		}
+		Expect(k8sClient.Create(ctx, &pg)).Should(Succeed())
+		var pod v1.Pod
+		Eventually(func() bool {
+			return k8sClient.Get(ctx, GetPodNamespacedName(pg), &pod) == nil
+		}).WithTimeout(30 * time.Second).WithPolling(time.Second).Should(BeTrue())
+		Expect(pod.Labels).Should(HaveKeyWithValue(instanceLabel, fencedName))
+
+		By("fencing the instance")
+		Expect(k8sClient.Get(ctx, GetPodNamespacedName(pg), &pg)).Should(Succeed())
+		pg.Annotations = map[string]string{databasev1.FencedAnnotation: "true"}
+		Expect(k8sClient.Update(ctx, &pg)).Should(Succeed())
+		Eventually(func() bool {
+			if err := k8sClient.Get(ctx, GetPodNamespacedName(pg), &pod); err != nil {
+				return false
+			}
+			_, selectable := pod.Labels[instanceLabel]
+			return !selectable && pod.Labels[roleLabel] == roleFenced
+		}).WithTimeout(30 * time.Second).WithPolling(time.Second).Should(BeTrue())
+
+		By("deleting the fenced pod")
+		Expect(k8sClient.Delete(ctx, &pod)).Should(Succeed())
+		podGone := func() bool {
+			err := k8sClient.Get(ctx, GetPodNamespacedName(pg), &pod)
+			return err != nil && client.IgnoreNotFound(err) == nil
+		}
+		Eventually(podGone).WithTimeout(30 * time.Second).WithPolling(time.Second).Should(BeTrue())
+		Consistently(podGone).WithTimeout(10 * time.Second).WithPolling(time.Second).Should(BeTrue())
+
+		Expect(k8sClient.Delete(ctx, &pg)).Should(Succeed())
+	})
 })
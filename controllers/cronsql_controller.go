@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// CronSQLReconciler reconciles a CronSQL object
+type CronSQLReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder, when set, gets an event for every change to pg_cron a dry
+	// run skips
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=cronsqls,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=cronsqls/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=cronsqls/finalizers,verbs=update
+
+// Reconcile schedules the job with pg_cron and unschedules it on delete
+func (r *CronSQLReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var job databasev1.CronSQL
+	if err := r.Get(ctx, req.NamespacedName, &job); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !job.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&job, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.unschedule(ctx, &job); err != nil {
+			logger.Error(err, "could not unschedule job", "name", job.JobName())
+			setReadyCondition(&job.Status.Conditions, job.Generation, err)
+			if err := r.Status().Update(ctx, &job); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&job, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &job)
+	}
+
+	if !controllerutil.ContainsFinalizer(&job, dropFinalizer) {
+		controllerutil.AddFinalizer(&job, dropFinalizer)
+		if err := r.Update(ctx, &job); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.schedule(ctx, &job)
+	if err != nil {
+		logger.Error(err, "could not schedule job", "name", job.JobName())
+	}
+	setReadyCondition(&job.Status.Conditions, job.Generation, err)
+	if err := r.Status().Update(ctx, &job); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+func (r *CronSQLReconciler) schedule(ctx context.Context, job *databasev1.CronSQL) error {
+	if DryRun {
+		// The command would run as soon as it is due
+		reportDryRun(ctx, r.Recorder, job, fmt.Sprintf("schedule %s with pg_cron at %q", job.JobName(), job.Spec.Schedule))
+		return nil
+	}
+	db, err := r.connectCron(ctx, job)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS pg_cron"); err != nil {
+		return err
+	}
+
+	var jobID int64
+	var schedule, command, database string
+	err = db.QueryRowContext(ctx, `SELECT jobid, schedule, command, database FROM cron.job
+		WHERE jobname = $1 AND username = current_user`, job.JobName()).Scan(&jobID, &schedule, &command, &database)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && cronJobCurrent(job.Spec, schedule, command, database) {
+		job.Status.JobID = jobID
+		return nil
+	}
+
+	// Scheduling under an existing name replaces that job
+	log.FromContext(ctx).Info("scheduling job", "name", job.JobName(), "schedule", job.Spec.Schedule)
+	query, args := cronScheduleQuery(job)
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&jobID); err != nil {
+		return err
+	}
+	job.Status.JobID = jobID
+	return nil
+}
+
+// cronJobCurrent reports whether a job scheduled with pg_cron matches the
+// spec. Without a database of its own, the job runs in whichever database
+// it was scheduled in.
+func cronJobCurrent(spec databasev1.CronSQLSpec, schedule, command, database string) bool {
+	want := spec.Database
+	if want == "" {
+		want = database
+	}
+	return schedule == spec.Schedule && command == spec.Command && database == want
+}
+
+// cronScheduleQuery schedules the job with pg_cron, in the database of its
+// spec when it has one, and returns its job ID
+func cronScheduleQuery(job *databasev1.CronSQL) (string, []interface{}) {
+	if job.Spec.Database == "" {
+		return "SELECT cron.schedule($1, $2, $3)", []interface{}{job.JobName(), job.Spec.Schedule, job.Spec.Command}
+	}
+	return "SELECT cron.schedule_in_database($1, $2, $3, $4)",
+		[]interface{}{job.JobName(), job.Spec.Schedule, job.Spec.Command, job.Spec.Database}
+}
+
+func (r *CronSQLReconciler) unschedule(ctx context.Context, job *databasev1.CronSQL) error {
+	if DryRun {
+		reportDryRun(ctx, r.Recorder, job, "unschedule "+job.JobName()+" from pg_cron")
+		return nil
+	}
+	db, err := r.connectCron(ctx, job)
+	if errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, job.Namespace, job.Spec.InstanceRef) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	log.FromContext(ctx).Info("unscheduling job", "name", job.JobName())
+	_, err = db.ExecContext(ctx, `SELECT cron.unschedule(jobid) FROM cron.job
+		WHERE jobname = $1 AND username = current_user`, job.JobName())
+	return err
+}
+
+// connectCron connects to the database pg_cron keeps its jobs in
+func (r *CronSQLReconciler) connectCron(ctx context.Context, job *databasev1.CronSQL) (*sql.DB, error) {
+	var pg databasev1.Postgresql
+	if err := r.Get(ctx, types.NamespacedName{Namespace: job.Namespace, Name: job.Spec.InstanceRef.Name}, &pg); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInstanceNotReady, err)
+	}
+	return connectInstance(ctx, r.Client, job.Namespace, job.Spec.InstanceRef, cronDatabase(pg))
+}
+
+// cronDatabase is the database pg_cron is installed in
+func cronDatabase(pg databasev1.Postgresql) string {
+	if name := pg.Spec.Parameters["cron.database_name"]; name != "" {
+		return name
+	}
+	return "postgres"
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CronSQLReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.CronSQL{}).
+		WithOptions(controllerOptions("CronSQL")).
+		Complete(finishOnShutdown(r))
+}
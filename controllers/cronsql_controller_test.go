@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCronScheduleQuery(t *testing.T) {
+	job := &databasev1.CronSQL{ObjectMeta: metav1.ObjectMeta{Name: "vacuum"}}
+	job.Spec.Schedule = "0 3 * * *"
+	job.Spec.Command = "VACUUM"
+	query, args := cronScheduleQuery(job)
+	if query != "SELECT cron.schedule($1, $2, $3)" || !reflect.DeepEqual(args, []interface{}{"vacuum", "0 3 * * *", "VACUUM"}) {
+		t.Errorf("unexpected query %s %v", query, args)
+	}
+
+	job.Spec.Name = "nightly-vacuum"
+	job.Spec.Database = "app"
+	query, args = cronScheduleQuery(job)
+	if query != "SELECT cron.schedule_in_database($1, $2, $3, $4)" ||
+		!reflect.DeepEqual(args, []interface{}{"nightly-vacuum", "0 3 * * *", "VACUUM", "app"}) {
+		t.Errorf("expected the job to be scheduled in its database, got %s %v", query, args)
+	}
+}
+
+func TestCronJobCurrent(t *testing.T) {
+	spec := databasev1.CronSQLSpec{Schedule: "0 3 * * *", Command: "VACUUM"}
+	if !cronJobCurrent(spec, "0 3 * * *", "VACUUM", "postgres") {
+		t.Error("expected a job without a database of its own to be current in any")
+	}
+	if cronJobCurrent(spec, "0 4 * * *", "VACUUM", "postgres") || cronJobCurrent(spec, "0 3 * * *", "ANALYZE", "postgres") {
+		t.Error("expected a change of schedule or command to reschedule the job")
+	}
+	spec.Database = "app"
+	if cronJobCurrent(spec, "0 3 * * *", "VACUUM", "postgres") {
+		t.Error("expected a change of database to reschedule the job")
+	}
+}
+
+func TestCronDatabase(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	if name := cronDatabase(pg); name != "postgres" {
+		t.Errorf("expected pg_cron's default database, got %s", name)
+	}
+	pg.Spec.Parameters = map[string]string{"cron.database_name": "jobs"}
+	if name := cronDatabase(pg); name != "jobs" {
+		t.Errorf("expected cron.database_name to win, got %s", name)
+	}
+}
+
+func TestCronSQLInstanceGone(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	job := &databasev1.CronSQL{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "vacuum"}}
+	job.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	r := &CronSQLReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(job).Build(), Scheme: scheme}
+
+	if err := r.schedule(ctx, job); !errors.Is(err, errInstanceNotReady) {
+		t.Errorf("expected scheduling to wait for the instance, got %v", err)
+	}
+	if err := r.unschedule(ctx, job); err != nil {
+		t.Errorf("expected nothing to unschedule without an instance, got %v", err)
+	}
+}
+
+func TestCronSQLDryRun(t *testing.T) {
+	DryRun = true
+	defer func() { DryRun = false }()
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	// Without an instance, anything that connects fails
+	job := &databasev1.CronSQL{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "vacuum"}}
+	job.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	job.Spec.Schedule = "0 3 * * *"
+	job.Spec.Command = "VACUUM"
+	recorder := record.NewFakeRecorder(2)
+	r := &CronSQLReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(job).Build(), Scheme: scheme, Recorder: recorder}
+
+	if err := r.schedule(ctx, job); err != nil {
+		t.Errorf("expected scheduling to be skipped, got %v", err)
+	}
+	if err := r.unschedule(ctx, job); err != nil {
+		t.Errorf("expected unscheduling to be skipped, got %v", err)
+	}
+	for _, want := range []string{"would schedule", "would unschedule"} {
+		if event := <-recorder.Events; !strings.Contains(event, want) {
+			t.Errorf("expected an event saying %q, got %q", want, event)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DatabaseReconciler reconciles a Database object
+type DatabaseReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=databases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=databases/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=databases/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// Reconcile creates the database on its instance and keeps its owner in
+// line with the spec
+func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var database databasev1.Database
+	if err := r.Get(ctx, req.NamespacedName, &database); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !database.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&database, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.drop(ctx, &database); err != nil {
+			logger.Error(err, "could not drop database", "name", database.DatabaseName())
+			setReadyCondition(&database.Status.Conditions, database.Generation, err)
+			if err := r.Status().Update(ctx, &database); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&database, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &database)
+	}
+
+	// The finalizer follows the reclaim policy, so switching back to
+	// Retain releases the database again
+	if drop := database.Spec.ReclaimPolicy == databasev1.ReclaimDelete; drop != controllerutil.ContainsFinalizer(&database, dropFinalizer) {
+		if drop {
+			controllerutil.AddFinalizer(&database, dropFinalizer)
+		} else {
+			controllerutil.RemoveFinalizer(&database, dropFinalizer)
+		}
+		if err := r.Update(ctx, &database); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &database)
+	if err != nil {
+		logger.Error(err, "could not apply database", "name", database.DatabaseName())
+	}
+	setReadyCondition(&database.Status.Conditions, database.Generation, err)
+	if err := r.Status().Update(ctx, &database); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+func (r *DatabaseReconciler) apply(ctx context.Context, database *databasev1.Database) error {
+	db, err := connectInstance(ctx, r.Client, database.Namespace, database.Spec.InstanceRef, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	credentials, err := r.credentials(ctx, db, database)
+	if err != nil {
+		return err
+	}
+
+	name := database.DatabaseName()
+	var owner string
+	var isTemplate bool
+	err = db.QueryRowContext(ctx,
+		"SELECT pg_get_userbyid(datdba), datistemplate FROM pg_database WHERE datname = $1", name).Scan(&owner, &isTemplate)
+	switch {
+	case err == sql.ErrNoRows:
+		log.FromContext(ctx).Info("creating database", "name", name)
+		if _, err := db.ExecContext(ctx, createDatabaseStatement(database.Spec, name, database.OwnerName())); err != nil {
+			return err
+		}
+		if err := r.runInitScripts(ctx, database); err != nil {
+			log.FromContext(ctx).Info("dropping database after failed init scripts", "name", name)
+			if _, dropErr := db.ExecContext(ctx, "DROP DATABASE "+pq.QuoteIdentifier(name)); dropErr != nil {
+				return fmt.Errorf("%v, and could not drop the database again: %w", err, dropErr)
+			}
+			return err
+		}
+	case err != nil:
+		return err
+	case database.OwnerName() != owner:
+		log.FromContext(ctx).Info("changing database owner", "name", name, "from", owner, "to", database.OwnerName())
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER DATABASE %s OWNER TO %s",
+			pq.QuoteIdentifier(name), pq.QuoteIdentifier(database.OwnerName()))); err != nil {
+			return err
+		}
+	}
+
+	if database.Spec.IsTemplate != isTemplate {
+		log.FromContext(ctx).Info("changing database template flag", "name", name, "template", database.Spec.IsTemplate)
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER DATABASE %s IS_TEMPLATE %t",
+			pq.QuoteIdentifier(name), database.Spec.IsTemplate)); err != nil {
+			return err
+		}
+	}
+
+	return r.writeCredentials(ctx, database, credentials)
+}
+
+// runInitScripts runs the init scripts of a freshly created database as its
+// owner, so the objects they create belong to the owner
+func (r *DatabaseReconciler) runInitScripts(ctx context.Context, database *databasev1.Database) error {
+	if len(database.Spec.InitScripts) == 0 {
+		return nil
+	}
+	db, err := connectInstance(ctx, r.Client, database.Namespace, database.Spec.InstanceRef, database.DatabaseName())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET ROLE "+pq.QuoteIdentifier(database.OwnerName())); err != nil {
+		return err
+	}
+	for _, ref := range database.Spec.InitScripts {
+		script, err := configMapValue(ctx, r.Client, database.Namespace, ref)
+		if err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("running init script", "name", database.DatabaseName(), "configmap", ref.Name, "key", ref.Key)
+		if _, err := conn.ExecContext(ctx, script); err != nil {
+			return fmt.Errorf("init script %s/%s: %w", ref.Name, ref.Key, err)
+		}
+	}
+	return nil
+}
+
+// createDatabaseStatement builds the CREATE DATABASE for a spec. A database
+// with its own encoding or locale and no template of its own has to be
+// copied from template0, as template1 may hold data in another encoding or
+// sort order.
+func createDatabaseStatement(spec databasev1.DatabaseSpec, name, owner string) string {
+	statement := "CREATE DATABASE " + pq.QuoteIdentifier(name) + " OWNER " + pq.QuoteIdentifier(owner)
+	ownLocale := spec.LocaleProvider != "" || spec.LCCollate != "" || spec.LCCtype != "" || spec.ICULocale != ""
+	switch {
+	case spec.Template != "":
+		statement += " TEMPLATE " + pq.QuoteIdentifier(spec.Template)
+	case spec.Encoding != "" || ownLocale:
+		statement += " TEMPLATE template0"
+	}
+	if spec.Encoding != "" {
+		statement += " ENCODING " + pq.QuoteLiteral(spec.Encoding)
+	}
+	if spec.LocaleProvider != "" {
+		statement += " LOCALE_PROVIDER " + spec.LocaleProvider
+	}
+	if spec.LCCollate != "" {
+		statement += " LC_COLLATE " + pq.QuoteLiteral(spec.LCCollate)
+	}
+	if spec.LCCtype != "" {
+		statement += " LC_CTYPE " + pq.QuoteLiteral(spec.LCCtype)
+	}
+	if spec.ICULocale != "" {
+		statement += " ICU_LOCALE " + pq.QuoteLiteral(spec.ICULocale)
+	}
+	return statement
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Database{}).
+		Owns(&v1.Secret{}).
+		WithOptions(controllerOptions("Database")).
+		Complete(finishOnShutdown(r))
+}
+
+func (r *DatabaseReconciler) drop(ctx context.Context, database *databasev1.Database) error {
+	db, err := connectInstance(ctx, r.Client, database.Namespace, database.Spec.InstanceRef, "postgres")
+	if errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, database.Namespace, database.Spec.InstanceRef) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Fails, and is retried, while clients are still connected
+	log.FromContext(ctx).Info("dropping database", "name", database.DatabaseName())
+	_, err = db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(database.DatabaseName()))
+	return err
+}
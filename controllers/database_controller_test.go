@@ -0,0 +1,44 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestCreateDatabaseStatement(t *testing.T) {
+	tests := []struct {
+		spec databasev1.DatabaseSpec
+		want string
+	}{
+		{databasev1.DatabaseSpec{}, `CREATE DATABASE "app" OWNER "owner"`},
+		{databasev1.DatabaseSpec{Encoding: "UTF8"}, `CREATE DATABASE "app" OWNER "owner" TEMPLATE template0 ENCODING 'UTF8'`},
+		{databasev1.DatabaseSpec{LocaleProvider: "icu", ICULocale: "de-DE"},
+			`CREATE DATABASE "app" OWNER "owner" TEMPLATE template0 LOCALE_PROVIDER icu ICU_LOCALE 'de-DE'`},
+		{databasev1.DatabaseSpec{LCCollate: "C", LCCtype: "en_US.utf8"},
+			`CREATE DATABASE "app" OWNER "owner" TEMPLATE template0 LC_COLLATE 'C' LC_CTYPE 'en_US.utf8'`},
+		{databasev1.DatabaseSpec{Template: "golden"}, `CREATE DATABASE "app" OWNER "owner" TEMPLATE "golden"`},
+		{databasev1.DatabaseSpec{Template: "golden", Encoding: "UTF8"}, `CREATE DATABASE "app" OWNER "owner" TEMPLATE "golden" ENCODING 'UTF8'`},
+	}
+	for _, tt := range tests {
+		if got := createDatabaseStatement(tt.spec, "app", "owner"); got != tt.want {
+			t.Errorf("createDatabaseStatement(%+v) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// databaseCredentials is what an application needs to connect to its
+// database
+type databaseCredentials struct {
+	user, password string
+	// managed is set when the operator owns the password of the user,
+	// rather than a Role resource
+	managed bool
+}
+
+// credentials makes sure the owner of the database exists and works out its
+// password. An owner managed by a Role resource keeps the password of that
+// Role; any other owner gets the password from the credentials Secret,
+// generated the first time round.
+func (r *DatabaseReconciler) credentials(ctx context.Context, db *sql.DB, database *databasev1.Database) (databaseCredentials, error) {
+	owner := database.OwnerName()
+	if owner == superuser {
+		return databaseCredentials{}, fmt.Errorf("database %s cannot be owned by the superuser, whose password it would hand out", database.DatabaseName())
+	}
+
+	var roles databasev1.RoleList
+	if err := r.List(ctx, &roles, client.InNamespace(database.Namespace),
+		client.MatchingFields{instanceRefIndex: database.Spec.InstanceRef.Name}); err != nil {
+		return databaseCredentials{}, err
+	}
+	for _, role := range roles.Items {
+		if role.RoleName() != owner {
+			continue
+		}
+		ref := role.Spec.PasswordSecretRef
+		if ref == nil {
+			return databaseCredentials{}, fmt.Errorf("owner %s is managed by role %s, whose password is not in a Secret", owner, role.Name)
+		}
+		var secret v1.Secret
+		if err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: ref.Name}, &secret); err != nil {
+			return databaseCredentials{}, err
+		}
+		return databaseCredentials{user: owner, password: string(secret.Data[ref.Key])}, nil
+	}
+
+	var secret v1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: getCredentialsSecretName(database)}, &secret)
+	if client.IgnoreNotFound(err) != nil {
+		return databaseCredentials{}, err
+	}
+	// A missing Secret has the version "" of a status that never saw one
+	found := err == nil
+	password := string(secret.Data["password"])
+	if password == "" {
+		if password, err = generatePassword(); err != nil {
+			return databaseCredentials{}, err
+		}
+	}
+	credentials := databaseCredentials{user: owner, password: password, managed: true}
+
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT true FROM pg_roles WHERE rolname = $1", owner).Scan(&exists)
+	switch {
+	case err == sql.ErrNoRows:
+		log.FromContext(ctx).Info("creating database owner", "name", database.DatabaseName(), "owner", owner)
+		var verifier string
+		if verifier, err = scramVerifier(password); err == nil {
+			_, err = db.ExecContext(ctx, "CREATE ROLE "+pq.QuoteIdentifier(owner)+" LOGIN PASSWORD "+pq.QuoteLiteral(verifier))
+		}
+		return credentials, err
+	case err != nil:
+		return credentials, err
+	}
+
+	// Whoever edited or deleted the Secret wants the password changed, and a
+	// password hashed with MD5 is set again to rehash it
+	rehash := found && secret.ResourceVersion == database.Status.CredentialsSecretVersion
+	if rehash {
+		if rehash, err = hasMD5Password(ctx, db, owner); err != nil || !rehash {
+			return credentials, err
+		}
+	}
+	log.FromContext(ctx).Info("setting database owner password", "name", database.DatabaseName(), "owner", owner)
+	return credentials, setRolePassword(ctx, db, owner, password)
+}
+
+// writeCredentials keeps the credentials Secret of the database up to date
+func (r *DatabaseReconciler) writeCredentials(ctx context.Context, database *databasev1.Database, credentials databaseCredentials) error {
+	var pg databasev1.Postgresql
+	if err := r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: database.Spec.InstanceRef.Name}, &pg); err != nil {
+		return err
+	}
+	host := fmt.Sprintf("%s.%s.svc", getServiceName(pg, "rw"), pg.Namespace)
+	port := strconv.Itoa(postgresPort)
+	uri := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(credentials.user, credentials.password),
+		Host:   net.JoinHostPort(host, port),
+		Path:   "/" + database.DatabaseName(),
+	}
+
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getCredentialsSecretName(database), Namespace: database.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		secret.Type = v1.SecretTypeOpaque
+		secret.Data = map[string][]byte{
+			"dbname":   []byte(database.DatabaseName()),
+			"user":     []byte(credentials.user),
+			"password": []byte(credentials.password),
+			"host":     []byte(host),
+			"port":     []byte(port),
+			"uri":      []byte(uri.String()),
+		}
+		return ctrl.SetControllerReference(database, &secret, r.Scheme)
+	}); err != nil {
+		return err
+	}
+	database.Status.CredentialsSecret = secret.Name
+	database.Status.CredentialsSecretVersion = ""
+	if credentials.managed {
+		database.Status.CredentialsSecretVersion = secret.ResourceVersion
+	}
+	return nil
+}
+
+func getCredentialsSecretName(database *databasev1.Database) string {
+	return database.Name + "-app"
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeServer answers queries with the single value of the first entry of
+// rows whose key the query starts with, and records the statements run
+type fakeServer struct {
+	rows       map[string]driver.Value
+	statements []string
+}
+
+func (s *fakeServer) Connect(context.Context) (driver.Conn, error) { return s, nil }
+func (s *fakeServer) Driver() driver.Driver                        { return nil }
+func (s *fakeServer) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (s *fakeServer) Close() error                                 { return nil }
+func (s *fakeServer) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (s *fakeServer) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	s.statements = append(s.statements, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeServer) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	for prefix, value := range s.rows {
+		if strings.HasPrefix(query, prefix) {
+			return &fakeRows{values: []driver.Value{value}}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+func TestCredentialsOfExistingOwner(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	database := &databasev1.Database{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "app"}}
+	database.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: getCredentialsSecretName(database)},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+
+	tests := []struct {
+		name   string
+		secret bool
+		// seen is whether the status has the version of the Secret
+		seen bool
+		set  bool
+	}{
+		// The owner was there before the operator, with a password of its
+		// own that the Secret about to be written would not match
+		{"no Secret yet", false, false, true},
+		{"Secret unchanged", true, true, false},
+		{"Secret edited", true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if tt.secret {
+				builder = builder.WithObjects(secret.DeepCopy())
+			}
+			c := builder.Build()
+			d := database.DeepCopy()
+			if tt.seen {
+				var current v1.Secret
+				if err := c.Get(context.Background(), client.ObjectKeyFromObject(secret), &current); err != nil {
+					t.Fatal(err)
+				}
+				d.Status.CredentialsSecretVersion = current.ResourceVersion
+			}
+			server := &fakeServer{rows: map[string]driver.Value{
+				"SELECT true FROM pg_roles": true,
+				// A SCRAM verifier
+				"SELECT coalesce(rolpassword LIKE 'md5%'": false,
+			}}
+			db := sql.OpenDB(server)
+			defer db.Close()
+
+			r := &DatabaseReconciler{Client: c, Scheme: scheme}
+			credentials, err := r.credentials(context.Background(), db, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := len(server.statements) == 1 && strings.HasPrefix(server.statements[0], `ALTER ROLE "app" WITH PASSWORD 'SCRAM-SHA-256$`)
+			if set != tt.set {
+				t.Errorf("expected the password to be set: %v, statements run: %v", tt.set, server.statements)
+			}
+			if tt.secret && credentials.password != "secret" {
+				t.Errorf("expected the password of the Secret, got %q", credentials.password)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// drainingSinceAnnotation records on the pod when draining started
+const drainingSinceAnnotation = "db.example.com/draining-since"
+
+// Client backends other than the operator's own connection
+const clientBackends = `FROM pg_stat_activity
+	WHERE backend_type = 'client backend' AND pid <> pg_backend_pid()`
+
+// drain prepares the pod for a planned disruption when Spec.DrainTimeout is
+// set. The first call takes the pod out of its Services so no new
+// connections arrive; later calls wait for the busy connections to finish,
+// up to the timeout, and then terminate whatever is left. It reports
+// whether the pod may be disrupted.
+func (r *PostgresqlReconciler) drain(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) (bool, error) {
+	logger := log.FromContext(ctx)
+	if pg.Spec.DrainTimeout == nil || !isRunning(pod) {
+		return true, nil
+	}
+
+	since, err := time.Parse(time.RFC3339, pod.Annotations[drainingSinceAnnotation])
+	if err != nil {
+		logger.Info("draining connections", "name", pg.Name, "timeout", pg.Spec.DrainTimeout.Duration)
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[drainingSinceAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		setPodLabels(pod, *pg)
+		return false, r.Update(ctx, pod)
+	}
+
+	db, err := r.openSuperuserDB(ctx, pg, pod, "postgres")
+	if err != nil {
+		// Nothing can be drained from an instance that does not accept
+		// connections
+		logger.Error(err, "could not connect to drain connections", "name", pg.Name)
+		return true, nil
+	}
+	defer db.Close()
+
+	var busy int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) "+clientBackends+" AND state <> 'idle'").Scan(&busy); err != nil {
+		return false, err
+	}
+	if busy > 0 && time.Since(since) < pg.Spec.DrainTimeout.Duration {
+		logger.Info("waiting for connections to finish", "name", pg.Name, "busy", busy)
+		return false, nil
+	}
+
+	if _, err := db.ExecContext(ctx, "SELECT pg_terminate_backend(pid) "+clientBackends); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isDraining reports whether the pod has been taken out of service ahead of
+// a planned disruption
+func isDraining(pod *v1.Pod) bool {
+	_, ok := pod.Annotations[drainingSinceAnnotation]
+	return ok
+}
+
+func isRunning(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodRunning && pod.Status.PodIP != ""
+}
+
+// stopPod drains the pod and deletes it once draining is complete. The pod
+// of an instance without storage is never stopped, as its data directory
+// would go with it.
+func (r *PostgresqlReconciler) stopPod(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod, why string) error {
+	if !pod.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	if pg.Spec.Storage == nil {
+		log.FromContext(ctx).Info("not stopping instance without storage", "name", pg.Name, "for", why)
+		r.warn(pg, reason.StopRefused, "not "+why+": the data directory is not on a volume claim and would be lost")
+		return nil
+	}
+	drained, err := r.drain(ctx, pg, pod)
+	if err != nil || !drained {
+		return err
+	}
+	log.FromContext(ctx).Info(why, "name", pg.Name)
+	return client.IgnoreNotFound(r.Delete(ctx, pod))
+}
+
+// undrain puts a draining pod back into service when the disruption it was
+// drained for is no longer pending, e.g. because the maintenance window
+// closed or the change was reverted.
+func (r *PostgresqlReconciler) undrain(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if !isDraining(pod) || !pod.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	log.FromContext(ctx).Info("disruption no longer pending, back in service", "name", pg.Name)
+	delete(pod.Annotations, drainingSinceAnnotation)
+	setPodLabels(pod, *pg)
+	return r.Update(ctx, pod)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DryRun makes the operator work out what it would change without changing
+// anything: writes to the API server are only validated by it and reported
+// as events, the SQL of SQLJobs and CronSQLs is not run at all, the
+// operator's own SQL sessions are read-only and Vault is only read from
+var DryRun bool
+
+// The longest diff an event carries, events are cut off at 1024 bytes
+const maxDryRunDiff = 768
+
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// NewDryRunClient wraps a client so that its writes are sent as dry runs.
+// Each write is reported as an event of the Postgresql the object belongs
+// to, or of the object itself when it belongs to none. Status updates are
+// left out, as they come with every reconcile.
+func NewDryRunClient(c client.Client, recorder record.EventRecorder) client.Client {
+	return &dryRunClient{Client: c, recorder: recorder}
+}
+
+type dryRunClient struct {
+	client.Client
+	recorder record.EventRecorder
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.Client.Create(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.report(ctx, obj, "create", "")
+	return nil
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	diff := c.diff(ctx, obj)
+	if err := c.Client.Update(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.report(ctx, obj, "update", diff)
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	diff, _ := patch.Data(obj)
+	if err := c.Client.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.report(ctx, obj, "patch", string(diff))
+	return nil
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.Client.Delete(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.report(ctx, obj, "delete", "")
+	return nil
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	return c.Client.DeleteAllOf(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+func (c *dryRunClient) Status() client.StatusWriter {
+	return dryRunStatusWriter{c.Client.Status()}
+}
+
+// diff is the merge patch that would take the object as it is in the
+// cluster to obj
+func (c *dryRunClient) diff(ctx context.Context, obj client.Object) string {
+	current, ok := obj.DeepCopyObject().(client.Object)
+	if !ok || c.Get(ctx, client.ObjectKeyFromObject(obj), current) != nil {
+		return ""
+	}
+	data, err := client.MergeFrom(current).Data(obj)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (c *dryRunClient) report(ctx context.Context, obj client.Object, action, diff string) {
+	kind := ""
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	message := fmt.Sprintf("dry run: would %s %s %s", action, kind, obj.GetName())
+	if len(diff) > maxDryRunDiff {
+		diff = diff[:maxDryRunDiff] + "..."
+	}
+	if diff != "" && diff != "{}" {
+		message += ": " + diff
+	}
+	log.FromContext(ctx).Info(message)
+
+	var subject runtime.Object = obj
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		subject = &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: owner.APIVersion, Kind: owner.Kind},
+			ObjectMeta: metav1.ObjectMeta{Namespace: obj.GetNamespace(), Name: owner.Name, UID: owner.UID},
+		}
+	}
+	c.recorder.Event(subject, v1.EventTypeNormal, reason.DryRun, message)
+}
+
+// reportDryRun reports, as an event of obj, what the operator would have
+// done outside the API server. The recorder may be nil.
+func reportDryRun(ctx context.Context, recorder record.EventRecorder, obj runtime.Object, message string) {
+	message = "dry run: would " + message
+	log.FromContext(ctx).Info(message)
+	if recorder != nil {
+		recorder.Event(obj, v1.EventTypeNormal, reason.DryRun, message)
+	}
+}
+
+type dryRunStatusWriter struct {
+	client.StatusWriter
+}
+
+func (w dryRunStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return w.StatusWriter.Update(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+func (w dryRunStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return w.StatusWriter.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDryRunClient(t *testing.T) {
+	ctx := context.Background()
+	existing := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "settings"},
+		Data:       map[string]string{"a": "1"},
+	}
+	recorder := record.NewFakeRecorder(10)
+	c := NewDryRunClient(fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(existing).Build(), recorder)
+
+	created := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "new"}}
+	if err := c.Create(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(created), &v1.ConfigMap{}); err == nil {
+		t.Error("dry run should not have created the ConfigMap")
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "would create ConfigMap new") {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	var changed v1.ConfigMap
+	if err := c.Get(ctx, client.ObjectKeyFromObject(existing), &changed); err != nil {
+		t.Fatal(err)
+	}
+	changed.Data["a"] = "2"
+	if err := c.Update(ctx, &changed); err != nil {
+		t.Fatal(err)
+	}
+	var stored v1.ConfigMap
+	if err := c.Get(ctx, client.ObjectKeyFromObject(existing), &stored); err != nil || stored.Data["a"] != "1" {
+		t.Errorf("dry run should not have updated the ConfigMap, got %v", stored.Data)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, `would update ConfigMap settings: {"data":{"a":"2"}}`) {
+		t.Errorf("unexpected event %q", event)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// externalDNSHostnameAnnotation asks external-dns for DNS records of a
+// Service. It may hold several hostnames, comma separated.
+const externalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+
+// How long resolving the host of an endpoint may take
+const endpointLookupTimeout = 2 * time.Second
+
+// lookupHost resolves the hosts of endpoints, replaced in tests
+var lookupHost = net.DefaultResolver.LookupHost
+
+// reconcileEndpoints publishes where the load balancers of the instance's
+// Services are reachable, resolving their hosts so that clients can tell
+// whether the DNS records are out yet. Hosts that do not resolve yet are
+// tried again, see nextResync.
+func (r *PostgresqlReconciler) reconcileEndpoints(ctx context.Context, pg *databasev1.Postgresql) error {
+	var services v1.ServiceList
+	if err := r.List(ctx, &services, client.InNamespace(pg.Namespace), client.MatchingLabels{instanceLabel: pg.Name}); err != nil {
+		return err
+	}
+	sort.Slice(services.Items, func(i, j int) bool { return services.Items[i].Name < services.Items[j].Name })
+
+	// Hosts resolved before are not looked up again, which could hold up
+	// every reconcile
+	resolved := map[string][]string{}
+	for _, endpoint := range pg.Status.Endpoints {
+		if len(endpoint.Addresses) > 0 {
+			resolved[endpoint.Service+"/"+endpoint.Host] = endpoint.Addresses
+		}
+	}
+
+	var endpoints []databasev1.Endpoint
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		endpoint := serviceEndpoint(svc)
+		if addresses, ok := resolved[endpoint.Service+"/"+endpoint.Host]; ok {
+			endpoint.Addresses = addresses
+		} else if endpoint.Host != "" {
+			endpoint.Addresses = resolveEndpoint(ctx, endpoint.Host)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	pg.Status.Endpoints = endpoints
+	return nil
+}
+
+// serviceEndpoint is the endpoint of a load balancer Service, without its
+// addresses
+func serviceEndpoint(svc *v1.Service) databasev1.Endpoint {
+	endpoint := databasev1.Endpoint{Service: svc.Name}
+	if len(svc.Spec.Ports) > 0 {
+		endpoint.Port = svc.Spec.Ports[0].Port
+	}
+	if hostnames := svc.Annotations[externalDNSHostnameAnnotation]; hostnames != "" {
+		endpoint.Host = strings.TrimSpace(strings.Split(hostnames, ",")[0])
+		return endpoint
+	}
+	// Cloud providers give load balancers an IP, or a hostname as on AWS
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.Hostname != "" {
+			endpoint.Host = ingress.Hostname
+			break
+		}
+		if ingress.IP != "" {
+			endpoint.Host = ingress.IP
+			break
+		}
+	}
+	return endpoint
+}
+
+// endpointsUnresolved reports whether a host of an endpoint has yet to
+// resolve, which no watch reports
+func endpointsUnresolved(pg *databasev1.Postgresql) bool {
+	for _, endpoint := range pg.Status.Endpoints {
+		if endpoint.Host != "" && len(endpoint.Addresses) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveEndpoint looks a host up, an IP standing for itself. A host that
+// does not resolve has no addresses yet rather than failing the reconcile.
+func resolveEndpoint(ctx context.Context, host string) []string {
+	if net.ParseIP(host) != nil {
+		return []string{host}
+	}
+	ctx, cancel := context.WithTimeout(ctx, endpointLookupTimeout)
+	defer cancel()
+	addresses, err := lookupHost(ctx, host)
+	if err != nil {
+		log.FromContext(ctx).Info("endpoint does not resolve yet", "host", host, "error", err.Error())
+		return nil
+	}
+	sort.Strings(addresses)
+	return addresses
+}
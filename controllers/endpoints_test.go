@@ -0,0 +1,106 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileEndpoints(t *testing.T) {
+	defer func(original func(context.Context, string) ([]string, error)) { lookupHost = original }(lookupHost)
+	lookupHost = func(_ context.Context, host string) ([]string, error) {
+		if host == "pg.example.com" {
+			return []string{"203.0.113.20", "203.0.113.10"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+	service := func(name string, serviceType v1.ServiceType) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: name, Labels: map[string]string{instanceLabel: "pg"}},
+			Spec:       v1.ServiceSpec{Type: serviceType, Ports: []v1.ServicePort{{Name: "postgres", Port: postgresPort}}},
+		}
+	}
+	rw := service("pg-rw", v1.ServiceTypeLoadBalancer)
+	rw.Annotations = map[string]string{externalDNSHostnameAnnotation: "pg.example.com,primary.example.com"}
+	ro := service("pg-ro", v1.ServiceTypeLoadBalancer)
+	ro.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "198.51.100.7"}}
+	pending := service("pg-replication", v1.ServiceTypeLoadBalancer)
+	internal := service("pg-r", v1.ServiceTypeClusterIP)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	r := &PostgresqlReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg, rw, ro, pending, internal).Build(),
+		Scheme: scheme,
+	}
+	if err := r.reconcileEndpoints(context.Background(), pg); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := pg.Status.Endpoints
+	if len(endpoints) != 3 {
+		t.Fatalf("expected an endpoint for each load balancer, got %+v", endpoints)
+	}
+	if endpoints[0].Service != "pg-replication" || endpoints[0].Host != "" || endpoints[0].Addresses != nil {
+		t.Errorf("expected no address before the load balancer is provisioned, got %+v", endpoints[0])
+	}
+	if endpoints[1].Service != "pg-ro" || endpoints[1].Host != "198.51.100.7" || endpoints[1].Addresses[0] != "198.51.100.7" {
+		t.Errorf("expected the IP of the load balancer, got %+v", endpoints[1])
+	}
+	if endpoints[2].Host != "pg.example.com" || endpoints[2].Port != postgresPort ||
+		len(endpoints[2].Addresses) != 2 || endpoints[2].Addresses[0] != "203.0.113.10" {
+		t.Errorf("expected the external-dns hostname and its addresses, got %+v", endpoints[2])
+	}
+
+	if endpointsUnresolved(pg) {
+		t.Error("expected every host to be resolved")
+	}
+
+	// Resolved hosts are not looked up again, those that do not resolve
+	// yet are retried
+	lookups := 0
+	lookupHost = func(_ context.Context, host string) ([]string, error) {
+		lookups++
+		return nil, errors.New("no such host")
+	}
+	if err := r.reconcileEndpoints(context.Background(), pg); err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 0 || len(pg.Status.Endpoints[2].Addresses) != 2 {
+		t.Errorf("expected the resolved addresses to be kept, got %d lookups and %+v", lookups, pg.Status.Endpoints[2])
+	}
+	pg.Status.Endpoints[2].Addresses = nil
+	if !endpointsUnresolved(pg) {
+		t.Error("expected a host without addresses to be unresolved")
+	}
+	if got := nextResync(pg, &v1.Pod{}, &maintenance{allowed: true}, time.Now()); got != transitionRetry {
+		t.Errorf("expected an unresolved host to be looked up again soon, got %s", got)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ExtensionReconciler reconciles a Extension object
+type ExtensionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=extensions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=extensions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=extensions/finalizers,verbs=update
+
+// Reconcile installs the extension in its database and moves it to the
+// version and schema asked for, and drops it on delete when the reclaim
+// policy says so
+func (r *ExtensionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var extension databasev1.Extension
+	if err := r.Get(ctx, req.NamespacedName, &extension); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !extension.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&extension, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.drop(ctx, &extension); err != nil {
+			logger.Error(err, "could not drop extension", "name", extension.ExtensionName())
+			setReadyCondition(&extension.Status.Conditions, extension.Generation, err)
+			if err := r.Status().Update(ctx, &extension); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&extension, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &extension)
+	}
+
+	// The finalizer follows the reclaim policy, so switching back to
+	// Retain releases the extension again
+	if drop := extension.Spec.ReclaimPolicy == databasev1.ReclaimDelete; drop != controllerutil.ContainsFinalizer(&extension, dropFinalizer) {
+		if drop {
+			controllerutil.AddFinalizer(&extension, dropFinalizer)
+		} else {
+			controllerutil.RemoveFinalizer(&extension, dropFinalizer)
+		}
+		if err := r.Update(ctx, &extension); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &extension)
+	if err != nil {
+		logger.Error(err, "could not apply extension", "name", extension.ExtensionName())
+	}
+	setReadyCondition(&extension.Status.Conditions, extension.Generation, err)
+	if err := r.Status().Update(ctx, &extension); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+func (r *ExtensionReconciler) apply(ctx context.Context, extension *databasev1.Extension) error {
+	logger := log.FromContext(ctx)
+
+	db, err := connectInstance(ctx, r.Client, extension.Namespace, extension.Spec.InstanceRef, extension.Spec.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := extension.ExtensionName()
+	spec := extension.Spec
+	var version, schema string
+	err = db.QueryRowContext(ctx, `SELECT e.extversion, n.nspname FROM pg_extension e
+		JOIN pg_namespace n ON n.oid = e.extnamespace WHERE e.extname = $1`, name).Scan(&version, &schema)
+	switch {
+	case err == sql.ErrNoRows:
+		logger.Info("creating extension", "name", name, "database", spec.Database)
+		if _, err := db.ExecContext(ctx, createExtensionStatement(spec, name)); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		for _, statement := range alterExtensionStatements(spec, name, version, schema) {
+			logger.Info("altering extension", "name", name, "statement", statement)
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+	}
+
+	return db.QueryRowContext(ctx, "SELECT extversion FROM pg_extension WHERE extname = $1", name).
+		Scan(&extension.Status.InstalledVersion)
+}
+
+// createExtensionStatement installs the extension in the schema and at the
+// version of the spec, when given
+func createExtensionStatement(spec databasev1.ExtensionSpec, name string) string {
+	statement := "CREATE EXTENSION " + pq.QuoteIdentifier(name)
+	if spec.Schema != "" {
+		statement += " SCHEMA " + pq.QuoteIdentifier(spec.Schema)
+	}
+	if spec.Version != "" {
+		statement += " VERSION " + pq.QuoteLiteral(spec.Version)
+	}
+	return statement
+}
+
+// alterExtensionStatements move an extension installed at version in
+// schema to the version and schema of the spec. Whatever the spec leaves
+// out is left as installed.
+func alterExtensionStatements(spec databasev1.ExtensionSpec, name, version, schema string) []string {
+	var statements []string
+	if spec.Version != "" && spec.Version != version {
+		statements = append(statements, "ALTER EXTENSION "+pq.QuoteIdentifier(name)+" UPDATE TO "+pq.QuoteLiteral(spec.Version))
+	}
+	if spec.Schema != "" && spec.Schema != schema {
+		statements = append(statements, "ALTER EXTENSION "+pq.QuoteIdentifier(name)+" SET SCHEMA "+pq.QuoteIdentifier(spec.Schema))
+	}
+	return statements
+}
+
+func (r *ExtensionReconciler) drop(ctx context.Context, extension *databasev1.Extension) error {
+	db, err := connectInstance(ctx, r.Client, extension.Namespace, extension.Spec.InstanceRef, extension.Spec.Database)
+	if isUndefinedDatabase(err) ||
+		errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, extension.Namespace, extension.Spec.InstanceRef) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Without CASCADE, so columns and functions built on the extension are
+	// never dropped along with it
+	log.FromContext(ctx).Info("dropping extension", "name", extension.ExtensionName(), "database", extension.Spec.Database)
+	_, err = db.ExecContext(ctx, "DROP EXTENSION IF EXISTS "+pq.QuoteIdentifier(extension.ExtensionName()))
+	return err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ExtensionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Extension{}).
+		WithOptions(controllerOptions("Extension")).
+		Complete(finishOnShutdown(r))
+}
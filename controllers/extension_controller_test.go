@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestExtensionReclaimPolicy(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	extension := &databasev1.Extension{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "vector"}}
+	extension.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	extension.Spec.Database = "app"
+	extension.Spec.ReclaimPolicy = databasev1.ReclaimDelete
+	r := &ExtensionReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(extension).Build(), Scheme: scheme}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "db", Name: "vector"}}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, extension); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(extension, dropFinalizer) {
+		t.Fatal("expected the Delete policy to hold the extension with a finalizer")
+	}
+
+	// With its instance gone there is nothing left to drop the extension from
+	if err := r.Delete(ctx, extension); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, extension); !apierrors.IsNotFound(err) && controllerutil.ContainsFinalizer(extension, dropFinalizer) {
+		t.Errorf("expected the finalizer to be released, got %v", extension.Finalizers)
+	}
+}
+
+func TestCreateExtensionStatement(t *testing.T) {
+	tests := []struct {
+		spec databasev1.ExtensionSpec
+		want string
+	}{
+		{databasev1.ExtensionSpec{}, `CREATE EXTENSION "vector"`},
+		{databasev1.ExtensionSpec{Schema: "ext"}, `CREATE EXTENSION "vector" SCHEMA "ext"`},
+		{databasev1.ExtensionSpec{Schema: "ext", Version: "0.5.1"}, `CREATE EXTENSION "vector" SCHEMA "ext" VERSION '0.5.1'`},
+	}
+	for _, tt := range tests {
+		if got := createExtensionStatement(tt.spec, "vector"); got != tt.want {
+			t.Errorf("createExtensionStatement(%+v) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestAlterExtensionStatements(t *testing.T) {
+	tests := []struct {
+		spec databasev1.ExtensionSpec
+		want []string
+	}{
+		{databasev1.ExtensionSpec{}, nil},
+		{databasev1.ExtensionSpec{Version: "0.5.0", Schema: "public"}, nil},
+		{databasev1.ExtensionSpec{Version: "0.5.1"}, []string{`ALTER EXTENSION "vector" UPDATE TO '0.5.1'`}},
+		{databasev1.ExtensionSpec{Version: "0.5.1", Schema: "ext"},
+			[]string{`ALTER EXTENSION "vector" UPDATE TO '0.5.1'`, `ALTER EXTENSION "vector" SET SCHEMA "ext"`}},
+	}
+	for _, tt := range tests {
+		if got := alterExtensionStatements(tt.spec, "vector", "0.5.0", "public"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("alterExtensionStatements(%+v) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestExtensionInstanceNotReady(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	extension := &databasev1.Extension{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "vector"}}
+	extension.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	r := &ExtensionReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg, extension).Build(), Scheme: scheme}
+
+	if err := r.apply(ctx, extension); !errors.Is(err, errInstanceNotReady) {
+		t.Errorf("expected the instance not to be ready, got %v", err)
+	}
+	if err := r.drop(ctx, extension); !errors.Is(err, errInstanceNotReady) {
+		t.Errorf("expected the drop to wait for the instance, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// How often an external server is checked, as no watch reports on it
+const externalResync = 5 * time.Minute
+
+// reconcileExternal checks that the operator can connect to the external
+// server an instance points at and reports its version. The operator runs
+// nothing for it, so there is nothing to create or clean up: the resources
+// managed through SQL connect to the server themselves.
+func (r *PostgresqlReconciler) reconcileExternal(ctx context.Context, pg *databasev1.Postgresql) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if objectDeleting(pg) {
+		return ctrl.Result{}, nil
+	}
+
+	resync := externalResync
+	version, err := r.externalVersion(ctx, pg)
+	if err != nil {
+		logger.Error(err, "could not connect to external server", "host", pg.Spec.External.Host)
+		r.warn(pg, reason.ExternalUnreachable, "could not connect to external server: "+err.Error())
+		pg.Status.Phase = databasev1.PgFailed
+		resync = recoveryResync
+	} else {
+		pg.Status.Phase = databasev1.PgUp
+		pg.Status.Version = version
+	}
+	if err := r.updateStatus(ctx, pg); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.labelPhase(ctx, pg); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: resync}, nil
+}
+
+// externalVersion is the version of Postgres the external server runs,
+// without what the build adds to it, e.g. 14.5 of "14.5 (Debian 14.5-1)"
+func (r *PostgresqlReconciler) externalVersion(ctx context.Context, pg *databasev1.Postgresql) (string, error) {
+	db, err := connectExternal(ctx, r.Client, pg, "postgres", "", "")
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return "", err
+	}
+	return strings.Fields(version)[0], nil
+}
+
+// connectExternal connects to the named database on the external server of
+// an instance as user, or as its admin role when user is empty
+func connectExternal(ctx context.Context, c client.Client, pg *databasev1.Postgresql, dbname, user, password string) (*sql.DB, error) {
+	external := pg.Spec.External
+	if user == "" {
+		var err error
+		if user, password, err = externalAdmin(ctx, c, pg); err != nil {
+			return nil, err
+		}
+	}
+	port := external.Port
+	if port == 0 {
+		port = postgresPort
+	}
+	sslmode := external.SSLMode
+	if sslmode == "" {
+		sslmode = "require"
+	}
+	address := net.JoinHostPort(external.Host, strconv.Itoa(int(port)))
+	db, err := openDB(ctx, netDialer{}, address, user, password, dbname, sslmode)
+	if err != nil {
+		return nil, connectError(err)
+	}
+	return db, nil
+}
+
+// externalAdmin reads the role the operator connects to an external server
+// as from its Secret
+func externalAdmin(ctx context.Context, c client.Reader, pg *databasev1.Postgresql) (string, string, error) {
+	name := pg.Spec.External.AdminSecretRef.Name
+	var secret v1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: name}, &secret); err != nil {
+		return "", "", err
+	}
+	user, password := secret.Data[v1.BasicAuthUsernameKey], secret.Data[v1.BasicAuthPasswordKey]
+	if len(user) == 0 || len(password) == 0 {
+		return "", "", fmt.Errorf("secret %s needs the keys %s and %s", name, v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey)
+	}
+	return string(user), string(password), nil
+}
+
+// netDialer is the dialer of lib/pq for servers reached over the network
+type netDialer struct{}
+
+func (netDialer) Dial(network, address string) (net.Conn, error) {
+	return net.Dial(network, address)
+}
+
+func (netDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, address, timeout)
+}
+
+func (netDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
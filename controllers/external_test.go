@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileExternal(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	// Nothing listens on the port of a listener that has been closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.External = &databasev1.ExternalSpec{
+		Host:           "127.0.0.1",
+		Port:           int32(port),
+		AdminSecretRef: v1.LocalObjectReference{Name: "rds-admin"},
+		SSLMode:        "disable",
+	}
+	admin := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "rds-admin"},
+		Data:       map[string][]byte{"username": []byte("master"), "password": []byte("s3cret-password")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg, admin).Build()
+	recorder := record.NewFakeRecorder(1)
+	r := &PostgresqlReconciler{Client: c, Scheme: scheme, Recorder: recorder}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pg)}
+
+	result, err := r.Reconcile(ctx, req)
+	if err != nil || result.RequeueAfter != recoveryResync {
+		t.Fatalf("an unreachable server should be checked again soon, got %v, %v", result, err)
+	}
+	if err := c.Get(ctx, req.NamespacedName, pg); err != nil {
+		t.Fatal(err)
+	}
+	if pg.Status.Phase != databasev1.PgFailed || pg.Labels[databasev1.PhaseLabel] != string(databasev1.PgFailed) {
+		t.Errorf("expected the instance to have failed, got %q", pg.Status.Phase)
+	}
+	if len(pg.Finalizers) != 0 {
+		t.Errorf("there is nothing to clean up for an external server, got %v", pg.Finalizers)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, reason.ExternalUnreachable) {
+			t.Errorf("expected a %s event, got %q", reason.ExternalUnreachable, event)
+		}
+	default:
+		t.Error("an unreachable server should be reported")
+	}
+
+	var pods v1.PodList
+	if err := c.List(ctx, &pods); err != nil || len(pods.Items) != 0 {
+		t.Errorf("no pod should be created for an external server, got %d, %v", len(pods.Items), err)
+	}
+
+	// Resources managed through SQL wait for the server like for a pod
+	_, err = connectInstance(ctx, c, "db", v1.LocalObjectReference{Name: "pg"}, "postgres")
+	if !errors.Is(err, errInstanceNotReady) {
+		t.Errorf("expected the server to be waited for, got %v", err)
+	}
+}
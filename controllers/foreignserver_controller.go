@@ -0,0 +1,246 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const postgresFDW = "postgres_fdw"
+
+// ForeignServerReconciler reconciles a ForeignServer object
+type ForeignServerReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=foreignservers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=foreignservers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=foreignservers/finalizers,verbs=update
+
+// Reconcile defines the foreign server and its user mappings and drops them
+// on delete
+func (r *ForeignServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var server databasev1.ForeignServer
+	if err := r.Get(ctx, req.NamespacedName, &server); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !server.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&server, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.drop(ctx, &server); err != nil {
+			logger.Error(err, "could not drop foreign server", "name", server.ServerName())
+			setReadyCondition(&server.Status.Conditions, server.Generation, err)
+			if err := r.Status().Update(ctx, &server); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&server, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &server)
+	}
+
+	if !controllerutil.ContainsFinalizer(&server, dropFinalizer) {
+		controllerutil.AddFinalizer(&server, dropFinalizer)
+		if err := r.Update(ctx, &server); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &server)
+	if err != nil {
+		logger.Error(err, "could not apply foreign server", "name", server.ServerName())
+	}
+	setReadyCondition(&server.Status.Conditions, server.Generation, err)
+	if err := r.Status().Update(ctx, &server); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+func (r *ForeignServerReconciler) apply(ctx context.Context, server *databasev1.ForeignServer) error {
+	logger := log.FromContext(ctx)
+	spec := server.Spec
+	wrapper := spec.Wrapper
+	if wrapper == "" {
+		wrapper = postgresFDW
+	}
+
+	db, err := connectInstance(ctx, r.Client, server.Namespace, spec.InstanceRef, spec.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if wrapper == postgresFDW {
+		if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS "+postgresFDW); err != nil {
+			return err
+		}
+	}
+
+	name := server.ServerName()
+	var options []string
+	err = db.QueryRowContext(ctx, "SELECT coalesce(srvoptions, '{}') FROM pg_foreign_server WHERE srvname = $1", name).
+		Scan(pq.Array(&options))
+	switch {
+	case err == sql.ErrNoRows:
+		logger.Info("creating foreign server", "name", name, "database", spec.Database)
+		statement := "CREATE SERVER " + pq.QuoteIdentifier(name) + " FOREIGN DATA WRAPPER " + pq.QuoteIdentifier(wrapper)
+		if changes := optionChanges(nil, spec.Options); changes != "" {
+			statement += " OPTIONS (" + changes + ")"
+		}
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if changes := optionChanges(options, spec.Options); changes != "" {
+			logger.Info("changing foreign server options", "name", name)
+			if _, err := db.ExecContext(ctx, "ALTER SERVER "+pq.QuoteIdentifier(name)+" OPTIONS ("+changes+")"); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mapping := range spec.UserMappings {
+		if err := r.applyUserMapping(ctx, db, server, mapping); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyUserMapping creates the user mapping and keeps its credentials in
+// line with the Secret
+func (r *ForeignServerReconciler) applyUserMapping(ctx context.Context, db *sql.DB, server *databasev1.ForeignServer, mapping databasev1.UserMapping) error {
+	var secret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: server.Namespace, Name: mapping.SecretRef.Name}, &secret); err != nil {
+		return err
+	}
+	user, ok := secret.Data["user"]
+	if !ok {
+		return fmt.Errorf("secret %s has no key user", mapping.SecretRef.Name)
+	}
+	password, ok := secret.Data["password"]
+	if !ok {
+		return fmt.Errorf("secret %s has no key password", mapping.SecretRef.Name)
+	}
+
+	role := pq.QuoteIdentifier(mapping.Role)
+	if strings.EqualFold(mapping.Role, "public") {
+		role = "PUBLIC"
+	}
+	target := " FOR " + role + " SERVER " + pq.QuoteIdentifier(server.ServerName())
+	if _, err := db.ExecContext(ctx, "CREATE USER MAPPING IF NOT EXISTS"+target); err != nil {
+		return err
+	}
+	var options []string
+	if err := db.QueryRowContext(ctx, `SELECT coalesce(umoptions, '{}') FROM pg_user_mappings
+		WHERE srvname = $1 AND usename = $2`, server.ServerName(), mapping.Role).Scan(pq.Array(&options)); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	changes := optionChanges(options, map[string]string{"user": string(user), "password": string(password)})
+	if changes == "" {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "ALTER USER MAPPING"+target+" OPTIONS ("+changes+")")
+	return err
+}
+
+func (r *ForeignServerReconciler) drop(ctx context.Context, server *databasev1.ForeignServer) error {
+	db, err := connectInstance(ctx, r.Client, server.Namespace, server.Spec.InstanceRef, server.Spec.Database)
+	if isUndefinedDatabase(err) ||
+		errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, server.Namespace, server.Spec.InstanceRef) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	log.FromContext(ctx).Info("dropping foreign server", "name", server.ServerName(), "database", server.Spec.Database)
+	// Takes the user mappings and foreign tables of the server along
+	_, err = db.ExecContext(ctx, "DROP SERVER IF EXISTS "+pq.QuoteIdentifier(server.ServerName())+" CASCADE")
+	return err
+}
+
+// optionChanges renders the ADD, SET and DROP clauses of an OPTIONS list
+// that turn the current key=value options into the ones wanted
+func optionChanges(current []string, want map[string]string) string {
+	have := map[string]string{}
+	for _, option := range current {
+		if key, value, ok := strings.Cut(option, "="); ok {
+			have[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []string
+	for _, key := range keys {
+		value, ok := have[key]
+		switch {
+		case !ok:
+			changes = append(changes, "ADD "+pq.QuoteIdentifier(key)+" "+pq.QuoteLiteral(want[key]))
+		case value != want[key]:
+			changes = append(changes, "SET "+pq.QuoteIdentifier(key)+" "+pq.QuoteLiteral(want[key]))
+		}
+		delete(have, key)
+	}
+
+	dropped := make([]string, 0, len(have))
+	for key := range have {
+		dropped = append(dropped, key)
+	}
+	sort.Strings(dropped)
+	for _, key := range dropped {
+		changes = append(changes, "DROP "+pq.QuoteIdentifier(key))
+	}
+	return strings.Join(changes, ", ")
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ForeignServerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.ForeignServer{}).
+		WithOptions(controllerOptions("ForeignServer")).
+		Complete(finishOnShutdown(r))
+}
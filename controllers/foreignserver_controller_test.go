@@ -0,0 +1,36 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import "testing"
+
+func TestOptionChanges(t *testing.T) {
+	tests := []struct {
+		current []string
+		want    map[string]string
+		changes string
+	}{
+		{nil, map[string]string{"host": "db", "port": "5432"}, `ADD "host" 'db', ADD "port" '5432'`},
+		{[]string{"host=db", "port=5432"}, map[string]string{"host": "db", "port": "5432"}, ""},
+		{[]string{"host=db", "dbname=shop"}, map[string]string{"host": "other"}, `SET "host" 'other', DROP "dbname"`},
+	}
+	for _, tt := range tests {
+		if got := optionChanges(tt.current, tt.want); got != tt.changes {
+			t.Errorf("optionChanges(%v, %v) = %s, want %s", tt.current, tt.want, got, tt.changes)
+		}
+	}
+}
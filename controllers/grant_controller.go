@@ -0,0 +1,237 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// grantFinalizer holds a Grant until its privileges have been revoked
+const grantFinalizer = "database.db.example.com/revoke"
+
+// GrantReconciler reconciles a Grant object
+type GrantReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=grants,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=grants/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=grants/finalizers,verbs=update
+
+// Reconcile grants the privileges described by a Grant and revokes them
+// again when the Grant is deleted
+func (r *GrantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var grant databasev1.Grant
+	if err := r.Get(ctx, req.NamespacedName, &grant); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !grant.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&grant, grantFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		err := r.revoke(ctx, &grant)
+		// Privileges on an instance that is gone went with it
+		if errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, grant.Namespace, grant.Spec.InstanceRef) {
+			err = nil
+		}
+		if err != nil {
+			logger.Error(err, "could not revoke privileges", "role", grant.Spec.Role)
+			setReadyCondition(&grant.Status.Conditions, grant.Generation, err)
+			if err := r.Status().Update(ctx, &grant); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&grant, grantFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &grant)
+	}
+
+	if !controllerutil.ContainsFinalizer(&grant, grantFinalizer) {
+		controllerutil.AddFinalizer(&grant, grantFinalizer)
+		if err := r.Update(ctx, &grant); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &grant)
+	if err != nil {
+		logger.Error(err, "could not grant privileges", "role", grant.Spec.Role)
+	}
+	setReadyCondition(&grant.Status.Conditions, grant.Generation, err)
+	if err := r.Status().Update(ctx, &grant); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+// apply revokes whatever was granted before on the target and grants the
+// privileges now listed in one transaction, so privileges dropped from the
+// spec are taken away too. A previously applied target that differs is
+// revoked first.
+func (r *GrantReconciler) apply(ctx context.Context, grant *databasev1.Grant) error {
+	want := grant.Spec.GrantTarget
+	if applied := grant.Status.Applied; applied != nil && !sameTarget(*applied, want) {
+		if err := r.revoke(ctx, grant); err != nil {
+			return err
+		}
+	}
+
+	db, err := connectInstance(ctx, r.Client, grant.Namespace, grant.Spec.InstanceRef, want.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	revoke, statement := grantStatements(want)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, revoke); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	grant.Status.Applied = want.DeepCopy()
+	return nil
+}
+
+// revoke takes back the privileges last applied for the Grant
+func (r *GrantReconciler) revoke(ctx context.Context, grant *databasev1.Grant) error {
+	applied := grant.Status.Applied
+	if applied == nil {
+		return nil
+	}
+	db, err := connectInstance(ctx, r.Client, grant.Namespace, grant.Spec.InstanceRef, applied.Database)
+	if isUndefinedDatabase(err) {
+		// Dropping the database took the privileges with it
+		grant.Status.Applied = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	revoke, _ := grantStatements(*applied)
+	log.FromContext(ctx).Info("revoking privileges", "role", applied.Role, "database", applied.Database)
+	if _, err := db.ExecContext(ctx, revoke); err != nil {
+		return err
+	}
+	grant.Status.Applied = nil
+	return nil
+}
+
+// sameTarget reports whether two targets cover the same objects for the
+// same role, whatever the privileges
+func sameTarget(a, b databasev1.GrantTarget) bool {
+	a.Privileges, b.Privileges = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// grantStatements returns the statements revoking all privileges on the
+// target from its role and granting the privileges listed
+func grantStatements(target databasev1.GrantTarget) (revoke, grant string) {
+	if defaults := target.DefaultPrivileges; defaults != nil {
+		return defaultPrivilegesStatements(target, defaults)
+	}
+
+	var on string
+	switch {
+	case len(target.Tables) > 0:
+		on = "TABLE " + tableList(target.Schema, target.Tables)
+		for _, table := range target.Tables {
+			if table == "*" {
+				// Unqualified tables are looked up in public, and so are all
+				// of them
+				schema := target.Schema
+				if schema == "" {
+					schema = "public"
+				}
+				on = "ALL TABLES IN SCHEMA " + pq.QuoteIdentifier(schema)
+			}
+		}
+	case target.Schema != "":
+		on = "SCHEMA " + pq.QuoteIdentifier(target.Schema)
+	default:
+		on = "DATABASE " + pq.QuoteIdentifier(target.Database)
+	}
+
+	privileges := make([]string, len(target.Privileges))
+	for i, privilege := range target.Privileges {
+		privileges[i] = string(privilege)
+	}
+	role := pq.QuoteIdentifier(target.Role)
+	return "REVOKE ALL ON " + on + " FROM " + role,
+		"GRANT " + strings.Join(privileges, ", ") + " ON " + on + " TO " + role
+}
+
+// defaultPrivilegesStatements are the ALTER DEFAULT PRIVILEGES counterparts
+// of grantStatements
+func defaultPrivilegesStatements(target databasev1.GrantTarget, defaults *databasev1.DefaultPrivileges) (revoke, grant string) {
+	alter := "ALTER DEFAULT PRIVILEGES FOR ROLE " + pq.QuoteIdentifier(defaults.ForRole)
+	if target.Schema != "" {
+		alter += " IN SCHEMA " + pq.QuoteIdentifier(target.Schema)
+	}
+	privileges := make([]string, len(target.Privileges))
+	for i, privilege := range target.Privileges {
+		privileges[i] = string(privilege)
+	}
+	role := pq.QuoteIdentifier(target.Role)
+	return alter + " REVOKE ALL ON " + defaults.ObjectType + " FROM " + role,
+		alter + " GRANT " + strings.Join(privileges, ", ") + " ON " + defaults.ObjectType + " TO " + role
+}
+
+func tableList(schema string, tables []string) string {
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = pq.QuoteIdentifier(table)
+		if schema != "" {
+			names[i] = pq.QuoteIdentifier(schema) + "." + names[i]
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GrantReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Grant{}).
+		WithOptions(controllerOptions("Grant")).
+		Complete(finishOnShutdown(r))
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestGrantStatements(t *testing.T) {
+	tests := []struct {
+		target       databasev1.GrantTarget
+		revoke, want string
+	}{
+		{
+			databasev1.GrantTarget{Role: "app", Database: "shop", Privileges: []databasev1.Privilege{"CONNECT"}},
+			`REVOKE ALL ON DATABASE "shop" FROM "app"`,
+			`GRANT CONNECT ON DATABASE "shop" TO "app"`,
+		},
+		{
+			databasev1.GrantTarget{Role: "app", Database: "shop", Schema: "sales", Privileges: []databasev1.Privilege{"USAGE", "CREATE"}},
+			`REVOKE ALL ON SCHEMA "sales" FROM "app"`,
+			`GRANT USAGE, CREATE ON SCHEMA "sales" TO "app"`,
+		},
+		{
+			databasev1.GrantTarget{Role: "app", Database: "shop", Schema: "sales", Tables: []string{"orders", "items"}, Privileges: []databasev1.Privilege{"SELECT"}},
+			`REVOKE ALL ON TABLE "sales"."orders", "sales"."items" FROM "app"`,
+			`GRANT SELECT ON TABLE "sales"."orders", "sales"."items" TO "app"`,
+		},
+		{
+			databasev1.GrantTarget{Role: "app", Database: "shop", Schema: "sales", Tables: []string{"*"}, Privileges: []databasev1.Privilege{"SELECT"}},
+			`REVOKE ALL ON ALL TABLES IN SCHEMA "sales" FROM "app"`,
+			`GRANT SELECT ON ALL TABLES IN SCHEMA "sales" TO "app"`,
+		},
+		{
+			databasev1.GrantTarget{Role: "app", Database: "shop", Tables: []string{"*"}, Privileges: []databasev1.Privilege{"SELECT"}},
+			`REVOKE ALL ON ALL TABLES IN SCHEMA "public" FROM "app"`,
+			`GRANT SELECT ON ALL TABLES IN SCHEMA "public" TO "app"`,
+		},
+		{
+			databasev1.GrantTarget{Role: "app", Database: "shop", Schema: "sales", Privileges: []databasev1.Privilege{"SELECT", "INSERT"},
+				DefaultPrivileges: &databasev1.DefaultPrivileges{ForRole: "migrator", ObjectType: "TABLES"}},
+			`ALTER DEFAULT PRIVILEGES FOR ROLE "migrator" IN SCHEMA "sales" REVOKE ALL ON TABLES FROM "app"`,
+			`ALTER DEFAULT PRIVILEGES FOR ROLE "migrator" IN SCHEMA "sales" GRANT SELECT, INSERT ON TABLES TO "app"`,
+		},
+	}
+	for _, tt := range tests {
+		revoke, grant := grantStatements(tt.target)
+		if revoke != tt.revoke {
+			t.Errorf("revoke = %s, want %s", revoke, tt.revoke)
+		}
+		if grant != tt.want {
+			t.Errorf("grant = %s, want %s", grant, tt.want)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// How often groups are synced when the spec does not say
+const defaultGroupSyncInterval = 5 * time.Minute
+
+// GroupSyncReconciler reconciles a GroupSync object
+type GroupSyncReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=groupsyncs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=groupsyncs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=groupsyncs/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile makes the members of each mapped role match the members of its
+// directory group, at the sync interval. Roles and memberships are left in
+// place when the GroupSync is deleted.
+func (r *GroupSyncReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var sync databasev1.GroupSync
+	if err := r.Get(ctx, req.NamespacedName, &sync); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	err := r.sync(ctx, &sync)
+	if err != nil {
+		logger.Error(err, "could not sync groups")
+	} else {
+		now := metav1.Now()
+		sync.Status.LastSyncTime = &now
+	}
+	setReadyCondition(&sync.Status.Conditions, sync.Generation, err)
+	if err := r.Status().Update(ctx, &sync); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		interval := defaultGroupSyncInterval
+		if sync.Spec.Interval != nil {
+			interval = sync.Spec.Interval.Duration
+		}
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	return applyResult(err)
+}
+
+func (r *GroupSyncReconciler) sync(ctx context.Context, sync *databasev1.GroupSync) error {
+	groups, err := r.groupMembers(ctx, sync)
+	if err != nil {
+		return err
+	}
+
+	db, err := connectInstance(ctx, r.Client, sync.Namespace, sync.Spec.InstanceRef, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, mapping := range sync.Spec.Groups {
+		if err := syncGroup(ctx, db, mapping.RoleName(), groups[mapping.Group]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupMembers reads the members of every group mapped, by group, from the
+// source of the GroupSync. A group the source does not have is an error.
+func (r *GroupSyncReconciler) groupMembers(ctx context.Context, sync *databasev1.GroupSync) (map[string][]string, error) {
+	source := sync.Spec.Source
+	var names []string
+	for _, mapping := range sync.Spec.Groups {
+		names = append(names, mapping.Group)
+	}
+
+	switch {
+	case source.ConfigMapRef != nil && source.LDAP != nil:
+		return nil, errors.New("source cannot have both a configmap and LDAP")
+	case source.ConfigMapRef != nil:
+		var configMap v1.ConfigMap
+		if err := r.Get(ctx, types.NamespacedName{Namespace: sync.Namespace, Name: source.ConfigMapRef.Name}, &configMap); err != nil {
+			return nil, err
+		}
+		groups := map[string][]string{}
+		for _, name := range names {
+			members, ok := configMap.Data[name]
+			if !ok {
+				return nil, fmt.Errorf("configmap %s has no group %s", source.ConfigMapRef.Name, name)
+			}
+			groups[name] = parseGroupMembers(members)
+		}
+		return groups, nil
+	case source.LDAP != nil:
+		password, err := r.ldapBindPassword(ctx, sync.Namespace, source.LDAP)
+		if err != nil {
+			return nil, err
+		}
+		return searchLDAPGroups(source.LDAP, password, names)
+	default:
+		return nil, errors.New("source needs a configmap or LDAP")
+	}
+}
+
+// ldapBindPassword reads the password of the bind DN of an LDAP source
+func (r *GroupSyncReconciler) ldapBindPassword(ctx context.Context, namespace string, ldap *databasev1.LDAPGroupSource) (string, error) {
+	ref := ldap.BindPasswordSecretRef
+	if ref == nil {
+		return "", nil
+	}
+	var secret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &secret); err != nil {
+		return "", err
+	}
+	password, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+	return string(password), nil
+}
+
+// ldapPageSize is how many entries a page of an LDAP search holds, below
+// the size limit servers commonly set
+const ldapPageSize = 500
+
+// searchLDAPGroups looks the groups up in an LDAP directory, in a single
+// paged search, and returns their members by group
+func searchLDAPGroups(ldap *databasev1.LDAPGroupSource, password string, names []string) (map[string][]string, error) {
+	groupAttribute := ldap.GroupAttribute
+	if groupAttribute == "" {
+		groupAttribute = "cn"
+	}
+	memberAttribute := ldap.MemberAttribute
+	if memberAttribute == "" {
+		memberAttribute = "member"
+	}
+
+	conn, err := dialLDAP(ldap.Server, ldap.Port, ldap.Scheme, ldap.StartTLS)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := bindLDAP(conn, ldap.BindDN, password); err != nil {
+		return nil, err
+	}
+	request := goldap.NewSearchRequest(ldap.BaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false,
+		ldapGroupFilter(groupAttribute, names), []string{groupAttribute, memberAttribute}, nil)
+	result, err := conn.SearchWithPaging(request, ldapPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return ldapGroupMembers(result.Entries, groupAttribute, memberAttribute, names, ldap.BaseDN)
+}
+
+// ldapGroupMembers returns the members of the named groups, by group, from
+// the entries of a search. A group without an entry is an error.
+func ldapGroupMembers(entries []*goldap.Entry, groupAttribute, memberAttribute string, names []string, baseDN string) (map[string][]string, error) {
+	found := map[string][]string{}
+	for _, entry := range entries {
+		// Group names compare case-insensitively, as cn does, and so do
+		// attribute names
+		for _, group := range entry.GetEqualFoldAttributeValues(groupAttribute) {
+			members := found[strings.ToLower(group)]
+			for _, member := range entry.GetEqualFoldAttributeValues(memberAttribute) {
+				members = append(members, ldapMemberName(member))
+			}
+			found[strings.ToLower(group)] = members
+		}
+	}
+	groups := map[string][]string{}
+	for _, name := range names {
+		members, ok := found[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("LDAP directory has no group %s below %s", name, baseDN)
+		}
+		groups[name] = parseGroupMembers(strings.Join(members, "\n"))
+	}
+	return groups, nil
+}
+
+// ldapGroupFilter matches the entries whose attribute names any of the
+// groups
+func ldapGroupFilter(attribute string, names []string) string {
+	var filter strings.Builder
+	filter.WriteString("(|")
+	for _, name := range names {
+		filter.WriteString("(" + attribute + "=" + goldap.EscapeFilter(name) + ")")
+	}
+	filter.WriteString(")")
+	return filter.String()
+}
+
+// ldapMemberName is the role a member of an LDAP group stands for: the
+// value of the first RDN of a DN, or the member itself otherwise
+func ldapMemberName(member string) string {
+	rdn := member
+	if i := strings.Index(rdn, ","); i >= 0 {
+		rdn = rdn[:i]
+	}
+	if i := strings.Index(rdn, "="); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return member
+}
+
+// syncGroup creates the group role and the login roles of its members when
+// missing, then grants and revokes membership so the group holds exactly
+// the members listed
+func syncGroup(ctx context.Context, db *sql.DB, group string, members []string) error {
+	logger := log.FromContext(ctx)
+
+	var existing []string
+	if err := db.QueryRowContext(ctx, "SELECT coalesce(array_agg(rolname), '{}') FROM pg_roles WHERE rolname = ANY($1)",
+		pq.Array(append([]string{group}, members...))).Scan(pq.Array(&existing)); err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, name := range existing {
+		exists[name] = true
+	}
+	if !exists[group] {
+		logger.Info("creating group role", "name", group)
+		if _, err := db.ExecContext(ctx, "CREATE ROLE "+pq.QuoteIdentifier(group)+" NOLOGIN"); err != nil {
+			return err
+		}
+	}
+	for _, member := range members {
+		if exists[member] {
+			continue
+		}
+		// Members authenticate against the directory, so they get no password
+		logger.Info("creating group member role", "name", member, "group", group)
+		if _, err := db.ExecContext(ctx, "CREATE ROLE "+pq.QuoteIdentifier(member)+" LOGIN"); err != nil {
+			return err
+		}
+	}
+
+	var current []string
+	if err := db.QueryRowContext(ctx, `SELECT coalesce(array_agg(u.rolname), '{}') FROM pg_auth_members m
+		JOIN pg_roles g ON g.oid = m.roleid
+		JOIN pg_roles u ON u.oid = m.member
+		WHERE g.rolname = $1`, group).Scan(pq.Array(&current)); err != nil {
+		return err
+	}
+	grant, revoke := membershipChanges(current, members)
+	for _, member := range grant {
+		logger.Info("granting group membership", "name", member, "group", group)
+		if _, err := db.ExecContext(ctx, "GRANT "+pq.QuoteIdentifier(group)+" TO "+pq.QuoteIdentifier(member)); err != nil {
+			return err
+		}
+	}
+	for _, member := range revoke {
+		logger.Info("revoking group membership", "name", member, "group", group)
+		if _, err := db.ExecContext(ctx, "REVOKE "+pq.QuoteIdentifier(group)+" FROM "+pq.QuoteIdentifier(member)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseGroupMembers reads the members of a group, one per line. Blank lines
+// and lines starting with # are skipped.
+func parseGroupMembers(data string) []string {
+	seen := map[string]bool{}
+	var members []string
+	for _, line := range strings.Split(data, "\n") {
+		member := strings.TrimSpace(line)
+		if member == "" || strings.HasPrefix(member, "#") || seen[member] {
+			continue
+		}
+		seen[member] = true
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// membershipChanges returns the members to add to and remove from a group
+// to go from its current members to the ones wanted
+func membershipChanges(current, want []string) (grant, revoke []string) {
+	have := map[string]bool{}
+	for _, member := range current {
+		have[member] = true
+	}
+	for _, member := range want {
+		if !have[member] {
+			grant = append(grant, member)
+		}
+		delete(have, member)
+	}
+	for member := range have {
+		revoke = append(revoke, member)
+	}
+	sort.Strings(grant)
+	sort.Strings(revoke)
+	return grant, revoke
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GroupSyncReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.GroupSync{}).
+		WithOptions(controllerOptions("GroupSync")).
+		Complete(finishOnShutdown(r))
+}
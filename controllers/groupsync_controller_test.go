@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestParseGroupMembers(t *testing.T) {
+	got := parseGroupMembers("# analysts\nbob\n\n  alice \nbob\n")
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGroupMembers = %q, want %q", got, want)
+	}
+}
+
+func TestMembershipChanges(t *testing.T) {
+	grant, revoke := membershipChanges([]string{"carol", "alice"}, []string{"alice", "bob"})
+	if !reflect.DeepEqual(grant, []string{"bob"}) || !reflect.DeepEqual(revoke, []string{"carol"}) {
+		t.Errorf("membershipChanges = %q, %q", grant, revoke)
+	}
+}
+
+func TestLDAPMemberName(t *testing.T) {
+	for member, want := range map[string]string{
+		"uid=alice,ou=people,dc=example,dc=com": "alice",
+		"CN = Bob Smith, dc=example":            "Bob Smith",
+		"carol":                                 "carol",
+	} {
+		if got := ldapMemberName(member); got != want {
+			t.Errorf("ldapMemberName(%q) = %q, want %q", member, got, want)
+		}
+	}
+}
+
+func TestLDAPGroupFilter(t *testing.T) {
+	got := ldapGroupFilter("cn", []string{"analysts", "data (eu)*"})
+	if want := `(|(cn=analysts)(cn=data \28eu\29\2a))`; got != want {
+		t.Errorf("ldapGroupFilter = %s, want %s", got, want)
+	}
+}
+
+func TestLDAPGroupMembers(t *testing.T) {
+	entries := []*goldap.Entry{
+		goldap.NewEntry("cn=analysts,ou=groups", map[string][]string{
+			"CN":     {"analysts"},
+			"member": {"uid=bob,ou=people", "uid=alice,ou=people"},
+		}),
+		goldap.NewEntry("cn=empty,ou=groups", map[string][]string{"cn": {"empty"}}),
+	}
+	groups, err := ldapGroupMembers(entries, "cn", "member", []string{"Analysts", "empty"}, "ou=groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"Analysts": {"alice", "bob"}, "empty": nil}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("ldapGroupMembers = %q, want %q", groups, want)
+	}
+
+	if _, err := ldapGroupMembers(entries, "cn", "member", []string{"analysts", "missing"}, "ou=groups"); err == nil {
+		t.Error("expected a group missing from the directory to fail")
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=roles,verbs=get;list;watch
+
+// reconcileHBA writes the pg_hba.conf of the instance to its <name>-hba
+// Secret, from where the server reads it
+func (r *PostgresqlReconciler) reconcileHBA(ctx context.Context, pg *databasev1.Postgresql) error {
+	var roles databasev1.RoleList
+	if err := r.List(ctx, &roles, client.InNamespace(pg.Namespace), client.MatchingFields{instanceRefIndex: pg.Name}); err != nil {
+		return err
+	}
+	var certRoles []string
+	for _, role := range roles.Items {
+		if role.Spec.ClientCertificate {
+			certRoles = append(certRoles, role.RoleName())
+		}
+	}
+
+	var ldapRules []string
+	if pg.Spec.Authentication != nil && pg.Spec.Authentication.LDAP != nil {
+		password, err := r.ldapBindPassword(ctx, pg)
+		if err != nil {
+			return err
+		}
+		if ldapRules, err = ldapHBARules(pg.Spec.Authentication.LDAP, password, tlsEnabled(*pg), hbaAddresses(*pg)); err != nil {
+			return err
+		}
+	}
+
+	rules, err := hbaRules(certRoles, ldapRules, hbaAddresses(*pg), fipsEnabled(*pg))
+	if err != nil {
+		return err
+	}
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getHBASecretName(*pg), Namespace: pg.Namespace}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		secret.Data = map[string][]byte{"pg_hba.conf": []byte(rules)}
+		setManagedLabels(&secret, *pg)
+		return ctrl.SetControllerReference(pg, &secret, r.Scheme)
+	})
+	return err
+}
+
+// hbaRules renders pg_hba.conf. Local connections are trusted, as the
+// image's own configuration does. Roles authenticating with a client
+// certificate get in over SSL with it and nothing else; the ldapRules of
+// LDAP roles come next, and everyone else uses a password. Clients connect
+// from the addresses given, except for the superuser. In FIPS mode, clients
+// outside the pod need SSL and passwords SCRAM-SHA-256, which refuses the
+// MD5 hashes the md5 method would still take.
+func hbaRules(certRoles, ldapRules, addresses []string, fips bool) (string, error) {
+	rules := []string{
+		"# Managed by the operator, changes are overwritten",
+		"local all all trust",
+		"local replication all trust",
+		"host all all 127.0.0.1/32 trust",
+		"host all all ::1/128 trust",
+	}
+	passwordRule := "host all %s %s md5"
+	if fips {
+		passwordRule = "hostssl all %s %s scram-sha-256"
+	}
+	restricted := !reflect.DeepEqual(addresses, []string{"all"})
+	if restricted {
+		rules = append(rules, fmt.Sprintf(passwordRule, `"`+superuser+`"`, "all"))
+	}
+	sorted := append([]string(nil), certRoles...)
+	sort.Strings(sorted)
+	for _, role := range sorted {
+		name, err := hbaQuote(role)
+		if err != nil {
+			return "", fmt.Errorf("role %q %w", role, err)
+		}
+		for _, address := range addresses {
+			rules = append(rules, "hostssl all "+name+" "+address+" cert")
+		}
+		rules = append(rules, "host all "+name+" all reject")
+	}
+	rules = append(rules, ldapRules...)
+	for _, address := range addresses {
+		rules = append(rules, fmt.Sprintf(passwordRule, "all", address))
+	}
+	if restricted || fips {
+		rules = append(rules, "host all all all reject")
+	}
+	return strings.Join(rules, "\n") + "\n", nil
+}
+
+// hbaAddresses are the client addresses pg_hba.conf lets in: the allowed
+// CIDRs, or all of them
+func hbaAddresses(pg databasev1.Postgresql) []string {
+	if pg.Spec.Access == nil || len(pg.Spec.Access.AllowedCIDRs) == 0 {
+		return []string{"all"}
+	}
+	return pg.Spec.Access.AllowedCIDRs
+}
+
+// hbaQuote quotes a name in pg_hba.conf, where keywords such as all and
+// names with spaces or commas otherwise mean something else. pg_hba.conf
+// has no way to escape a double quote, and a line break would start a rule
+// of its own, so names with either or other control characters are
+// refused.
+func hbaQuote(name string) (string, error) {
+	if strings.Contains(name, `"`) {
+		return "", fmt.Errorf("cannot contain a double quote")
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("cannot contain control characters")
+	}
+	return `"` + name + `"`, nil
+}
+
+// roleToPostgresql maps a Role to its instance, whose pg_hba.conf names the
+// roles with client certificates
+func roleToPostgresql(obj client.Object) []reconcile.Request {
+	role, ok := obj.(*databasev1.Role)
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: role.Namespace, Name: role.Spec.InstanceRef.Name}}}
+}
+
+func getHBASecretName(pg databasev1.Postgresql) string {
+	return pg.Name + "-hba"
+}
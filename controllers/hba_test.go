@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHBARules(t *testing.T) {
+	want := `# Managed by the operator, changes are overwritten
+local all all trust
+local replication all trust
+host all all 127.0.0.1/32 trust
+host all all ::1/128 trust
+hostssl all "app" all cert
+host all "app" all reject
+hostssl all "batch" all cert
+host all "batch" all reject
+host all all all md5
+`
+	if got, _ := hbaRules([]string{"batch", "app"}, nil, []string{"all"}, false); got != want {
+		t.Errorf("hbaRules =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHBARulesWithAllowedCIDRs(t *testing.T) {
+	want := `# Managed by the operator, changes are overwritten
+local all all trust
+local replication all trust
+host all all 127.0.0.1/32 trust
+host all all ::1/128 trust
+host all "postgres" all md5
+hostssl all "app" 10.0.0.0/8 cert
+hostssl all "app" 192.168.1.0/24 cert
+host all "app" all reject
+host all all 10.0.0.0/8 md5
+host all all 192.168.1.0/24 md5
+host all all all reject
+`
+	if got, _ := hbaRules([]string{"app"}, nil, []string{"10.0.0.0/8", "192.168.1.0/24"}, false); got != want {
+		t.Errorf("hbaRules =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHBARulesFIPS(t *testing.T) {
+	want := `# Managed by the operator, changes are overwritten
+local all all trust
+local replication all trust
+host all all 127.0.0.1/32 trust
+host all all ::1/128 trust
+hostssl all "app" all cert
+host all "app" all reject
+hostssl all all all scram-sha-256
+host all all all reject
+`
+	if got, _ := hbaRules([]string{"app"}, nil, []string{"all"}, true); got != want {
+		t.Errorf("hbaRules =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHBARulesRejectInjection(t *testing.T) {
+	for _, role := range []string{`app" all all trust`, "app\nhost all all all trust", "app\x00"} {
+		if _, err := hbaRules([]string{role}, nil, []string{"all"}, false); err == nil {
+			t.Errorf("expected role %q to be refused", role)
+		}
+	}
+}
+
+func TestRoleToPostgresql(t *testing.T) {
+	role := &databasev1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "app"}}
+	role.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	requests := roleToPostgresql(role)
+	if len(requests) != 1 || requests[0].Namespace != "db" || requests[0].Name != "pg" {
+		t.Errorf("expected the instance of the role, got %v", requests)
+	}
+}
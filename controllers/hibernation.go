@@ -0,0 +1,45 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduledHibernation works out whether the schedule currently has the
+// instance stopped and when that will next change. No history is needed:
+// if the next start comes before the next stop, we are inside a stop window.
+func scheduledHibernation(schedule *databasev1.HibernationSchedule, now time.Time) (bool, time.Time, error) {
+	stop, err := cron.ParseStandard(schedule.Stop)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid stop schedule %q: %w", schedule.Stop, err)
+	}
+	start, err := cron.ParseStandard(schedule.Start)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid start schedule %q: %w", schedule.Start, err)
+	}
+
+	nextStop, nextStart := stop.Next(now), start.Next(now)
+	if nextStart.Before(nextStop) {
+		return true, nextStart, nil
+	}
+	return false, nextStop, nil
+}
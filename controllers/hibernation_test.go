@@ -0,0 +1,107 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScheduledHibernation(t *testing.T) {
+	// Stopped from 20:00 to 07:00 on weekdays and all weekend
+	schedule := &databasev1.HibernationSchedule{Stop: "0 20 * * 1-5", Start: "0 7 * * 1-5"}
+
+	tests := []struct {
+		name          string
+		now           string
+		wantHibernate bool
+		wantNext      string
+	}{
+		{"weekday working hours", "2022-09-14T10:00:00Z", false, "2022-09-14T20:00:00Z"},
+		{"weekday night", "2022-09-14T23:00:00Z", true, "2022-09-15T07:00:00Z"},
+		{"friday evening", "2022-09-16T21:00:00Z", true, "2022-09-19T07:00:00Z"},
+		{"sunday", "2022-09-18T12:00:00Z", true, "2022-09-19T07:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, tt.now)
+			hibernate, next, err := scheduledHibernation(schedule, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hibernate != tt.wantHibernate {
+				t.Errorf("hibernate = %v, want %v", hibernate, tt.wantHibernate)
+			}
+			if got := next.UTC().Format(time.RFC3339); got != tt.wantNext {
+				t.Errorf("next transition = %s, want %s", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestScheduledHibernationInvalid(t *testing.T) {
+	schedule := &databasev1.HibernationSchedule{Stop: "every night", Start: "0 7 * * *"}
+	if _, _, err := scheduledHibernation(schedule, time.Now()); err == nil {
+		t.Error("expected an error for an invalid cron expression")
+	}
+}
+
+func TestStopPodKeepsEphemeralData(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.Hibernate = true
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()
+	recorder := record.NewFakeRecorder(2)
+	r := &PostgresqlReconciler{Client: c, Scheme: scheme, Recorder: recorder}
+
+	if r.shouldHibernate(ctx, pg) {
+		t.Error("expected an instance without storage not to hibernate")
+	}
+	if err := r.stopPod(ctx, pg, pod, "restarting instance"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pod), pod); err != nil {
+		t.Errorf("expected the pod holding the data to be kept, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if event := <-recorder.Events; !strings.Contains(event, reason.StopRefused) {
+			t.Errorf("expected a %s event, got %q", reason.StopRefused, event)
+		}
+	}
+
+	pg.Spec.Storage = &databasev1.StorageSpec{}
+	if !r.shouldHibernate(ctx, pg) {
+		t.Error("expected an instance with storage to hibernate")
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Field indexes of the Postgresqls by the names of the Secrets and
+// ConfigMaps their spec refers to, and of the objects living on an instance
+// by the name of the instance
+const (
+	secretRefIndex    = ".spec.secretRefs"
+	configMapRefIndex = ".spec.configMapRefs"
+	instanceRefIndex  = ".spec.instanceRef.name"
+)
+
+// SetupIndexes registers the field indexes the controllers look objects up
+// with. It has to run before the controllers are set up, as more than one
+// of them uses the same index.
+func SetupIndexes(ctx context.Context, mgr ctrl.Manager) error {
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(ctx, &databasev1.Postgresql{}, secretRefIndex, func(obj client.Object) []string {
+		return referencedSecrets(*obj.(*databasev1.Postgresql))
+	}); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &databasev1.Postgresql{}, configMapRefIndex, func(obj client.Object) []string {
+		return referencedConfigMaps(*obj.(*databasev1.Postgresql))
+	}); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &databasev1.Role{}, instanceRefIndex, func(obj client.Object) []string {
+		return []string{obj.(*databasev1.Role).Spec.InstanceRef.Name}
+	}); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &databasev1.Grant{}, instanceRefIndex, func(obj client.Object) []string {
+		return []string{obj.(*databasev1.Grant).Spec.InstanceRef.Name}
+	}); err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, &databasev1.Pooler{}, instanceRefIndex, func(obj client.Object) []string {
+		return []string{obj.(*databasev1.Pooler).Spec.InstanceRef.Name}
+	})
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// How often the LDAP server is checked while the spec stays the same. Not
+// more often, as failed binds may lock out the bind account.
+const ldapCheckInterval = 5 * time.Minute
+
+var hbaGroupName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ldapHBARules renders the pg_hba.conf entries of the LDAP roles connecting
+// from addresses. With TLS on, the password the client sends in clear only
+// travels over SSL.
+func ldapHBARules(ldap *databasev1.LDAPAuthentication, bindPassword string, ssl bool, addresses []string) ([]string, error) {
+	options := []string{"ldapserver=" + ldap.Server}
+	if ldap.Port != 0 {
+		options = append(options, "ldapport="+strconv.Itoa(int(ldap.Port)))
+	}
+	if ldap.Scheme != "" {
+		options = append(options, "ldapscheme="+ldap.Scheme)
+	}
+	if ldap.StartTLS {
+		options = append(options, "ldaptls=1")
+	}
+	values := [][2]string{{"ldapbasedn", ldap.BaseDN}, {"ldapbinddn", ldap.BindDN}}
+	if ldap.BindDN != "" {
+		values = append(values, [2]string{"ldapbindpasswd", bindPassword})
+	}
+	if ldap.SearchFilter != "" {
+		values = append(values, [2]string{"ldapsearchfilter", ldap.SearchFilter})
+	} else if ldap.SearchAttribute != "" {
+		values = append(values, [2]string{"ldapsearchattribute", ldap.SearchAttribute})
+	}
+	for _, value := range values {
+		if value[1] == "" {
+			continue
+		}
+		quoted, err := hbaQuote(value[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", value[0], err)
+		}
+		options = append(options, value[0]+"="+quoted)
+	}
+
+	connection := "host"
+	if ssl {
+		connection = "hostssl"
+	}
+	var rules []string
+	for _, role := range ldap.Roles {
+		if role == superuser {
+			return nil, fmt.Errorf("the superuser %s keeps its password, the operator connects with it", superuser)
+		}
+		name := role
+		if strings.HasPrefix(role, "+") {
+			// Group membership is only recognised unquoted
+			if !hbaGroupName.MatchString(role[1:]) {
+				return nil, fmt.Errorf("group %s must be a plain name", role)
+			}
+		} else {
+			var err error
+			if name, err = hbaQuote(role); err != nil {
+				return nil, fmt.Errorf("role %q %w", role, err)
+			}
+		}
+		for _, address := range addresses {
+			rules = append(rules, connection+" all "+name+" "+address+" ldap "+strings.Join(options, " "))
+		}
+		if ssl {
+			rules = append(rules, "host all "+name+" all reject")
+		}
+	}
+	return rules, nil
+}
+
+// ldapBindPassword reads the password of the bind DN
+func (r *PostgresqlReconciler) ldapBindPassword(ctx context.Context, pg *databasev1.Postgresql) (string, error) {
+	ref := pg.Spec.Authentication.LDAP.BindPasswordSecretRef
+	if ref == nil {
+		return "", nil
+	}
+	var secret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: ref.Name}, &secret); err != nil {
+		return "", err
+	}
+	password, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+	return string(password), nil
+}
+
+// reconcileLDAPStatus checks that the LDAP server is reachable and accepts
+// the bind DN, whenever the spec changes and every ldapCheckInterval
+func (r *PostgresqlReconciler) reconcileLDAPStatus(ctx context.Context, pg *databasev1.Postgresql) error {
+	if pg.Spec.Authentication == nil || pg.Spec.Authentication.LDAP == nil {
+		meta.RemoveStatusCondition(&pg.Status.Conditions, databasev1.ConditionLDAPReachable)
+		pg.Status.LDAPCheckTime = nil
+		return nil
+	}
+	cond := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionLDAPReachable)
+	if cond != nil && cond.ObservedGeneration == pg.Generation &&
+		pg.Status.LDAPCheckTime != nil && time.Since(pg.Status.LDAPCheckTime.Time) < ldapCheckInterval {
+		return nil
+	}
+
+	ldap := pg.Spec.Authentication.LDAP
+	password, err := r.ldapBindPassword(ctx, pg)
+	if err != nil {
+		return err
+	}
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionLDAPReachable,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason.LDAPBound,
+		Message:            "bound to " + ldap.Server,
+		ObservedGeneration: pg.Generation,
+	}
+	switch err := checkLDAP(ldap, password); {
+	case ldapRefused(err):
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reason.LDAPBindFailed, err.Error()
+	case err != nil:
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reason.LDAPUnreachable, err.Error()
+	}
+	meta.SetStatusCondition(&pg.Status.Conditions, condition)
+	pg.Status.LDAPCheckTime = &metav1.Time{Time: time.Now()}
+	return nil
+}
+
+// checkLDAP connects to the LDAP server the way Postgres will and binds as
+// the bind DN, or anonymously without one
+func checkLDAP(ldap *databasev1.LDAPAuthentication, password string) error {
+	conn, err := dialLDAP(ldap.Server, ldap.Port, ldap.Scheme, ldap.StartTLS)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return bindLDAP(conn, ldap.BindDN, password)
+}
+
+// ldapTimeout bounds connecting to an LDAP server and each request to it,
+// as the client takes no context
+const ldapTimeout = 5 * time.Second
+
+// dialLDAP connects to an LDAP server over TLS with the ldaps scheme or
+// StartTLS
+func dialLDAP(server string, port int32, scheme string, startTLS bool) (*goldap.Conn, error) {
+	if port == 0 {
+		port = 389
+		if scheme == "ldaps" {
+			port = 636
+		}
+	}
+	if scheme == "" {
+		scheme = "ldap"
+	}
+	address := scheme + "://" + net.JoinHostPort(server, strconv.Itoa(int(port)))
+	config := &tls.Config{ServerName: server}
+	conn, err := goldap.DialURL(address, goldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}), goldap.DialWithTLSConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if scheme != "ldaps" && startTLS {
+		if err := conn.StartTLS(config); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// bindLDAP authenticates as dn, anonymously when it is empty. Without a
+// password the bind is unauthenticated, which the client otherwise refuses.
+func bindLDAP(conn *goldap.Conn, dn, password string) error {
+	if password == "" {
+		return conn.UnauthenticatedBind(dn)
+	}
+	return conn.Bind(dn, password)
+}
+
+// ldapRefused reports whether err is a result code the LDAP server sent,
+// rather than a failure to talk to it
+func ldapRefused(err error) bool {
+	var result *goldap.Error
+	return errors.As(err, &result) && result.ResultCode < goldap.ErrorNetwork
+}
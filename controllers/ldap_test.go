@@ -0,0 +1,78 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestLDAPHBARules(t *testing.T) {
+	ldap := &databasev1.LDAPAuthentication{
+		Server:       "ldap.example.com",
+		Scheme:       "ldaps",
+		BaseDN:       "ou=people, dc=example, dc=com",
+		BindDN:       "cn=postgres,dc=example,dc=com",
+		SearchFilter: "(uid=$username)",
+		Roles:        []string{"alice", "+analysts"},
+	}
+	options := `ldap ldapserver=ldap.example.com ldapscheme=ldaps ldapbasedn="ou=people, dc=example, dc=com" ` +
+		`ldapbinddn="cn=postgres,dc=example,dc=com" ldapbindpasswd="secret" ldapsearchfilter="(uid=$username)"`
+	want := []string{
+		`hostssl all "alice" all ` + options,
+		`host all "alice" all reject`,
+		`hostssl all +analysts all ` + options,
+		`host all +analysts all reject`,
+	}
+	got, err := ldapHBARules(ldap, "secret", true, []string{"all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ldapHBARules =\n%q\nwant\n%q", got, want)
+	}
+
+	for _, invalid := range []*databasev1.LDAPAuthentication{
+		{Server: "ldap", BaseDN: `dc="x"`, Roles: []string{"alice"}},
+		{Server: "ldap", BaseDN: "dc=x", Roles: []string{"+data team"}},
+		{Server: "ldap", BaseDN: "dc=x", Roles: []string{superuser}},
+	} {
+		if _, err := ldapHBARules(invalid, "", false, []string{"all"}); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestLDAPRefused(t *testing.T) {
+	for err, want := range map[error]bool{
+		goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("denied")): true,
+		goldap.NewError(goldap.ErrorNetwork, errors.New("connection reset")):       false,
+		errors.New("connection refused"):                                           false,
+	} {
+		if got := ldapRefused(fmt.Errorf("bind: %w", err)); got != want {
+			t.Errorf("ldapRefused(%v) = %v, want %v", err, got, want)
+		}
+	}
+	if ldapRefused(nil) {
+		t.Error("expected success not to be a refusal")
+	}
+}
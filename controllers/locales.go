@@ -0,0 +1,51 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// reconcileLocaleSupport records the locales the running instance offers,
+// for the Database webhook to check against. They only change with the
+// image, so they are read again when the version does.
+func (r *PostgresqlReconciler) reconcileLocaleSupport(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if support := pg.Status.LocaleSupport; support != nil && support.Version == pg.Status.Version {
+		return nil
+	}
+
+	db, err := r.openSuperuserDB(ctx, pg, pod, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	support := databasev1.LocaleSupport{Version: pg.Status.Version}
+	err = db.QueryRowContext(ctx, `SELECT
+		coalesce((SELECT array_agg(DISTINCT collcollate ORDER BY collcollate) FROM pg_collation WHERE collprovider = 'c'), '{}'),
+		EXISTS (SELECT 1 FROM pg_collation WHERE collprovider = 'i')`).
+		Scan(pq.Array(&support.Locales), &support.ICU)
+	if err != nil {
+		return err
+	}
+	pg.Status.LocaleSupport = &support
+	return nil
+}
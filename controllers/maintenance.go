@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maintenance decides whether disruptive operations (restarts, upgrades) may
+// run during one reconcile, and keeps track of the ones that had to wait.
+type maintenance struct {
+	allowed  bool
+	next     time.Time
+	deferred []string
+}
+
+func newMaintenance(pg *databasev1.Postgresql, now time.Time) *maintenance {
+	if pg.Spec.MaintenanceWindow == nil || pg.Annotations[databasev1.ForceMaintenanceAnnotation] == "true" {
+		return &maintenance{allowed: true}
+	}
+	open, next, err := windowOpen(pg.Spec.MaintenanceWindow, now)
+	if err != nil {
+		// A window we cannot parse should not block the instance forever
+		return &maintenance{allowed: true}
+	}
+	return &maintenance{allowed: open, next: next}
+}
+
+// permits reports whether the operation may run now, remembering it as
+// deferred otherwise.
+func (m *maintenance) permits(operation string) bool {
+	if !m.allowed {
+		m.deferred = append(m.deferred, operation)
+	}
+	return m.allowed
+}
+
+// report publishes the deferred operations on the MaintenancePending
+// condition.
+func (m *maintenance) report(pg *databasev1.Postgresql) {
+	if len(m.deferred) == 0 {
+		if meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionMaintenancePending) != nil {
+			meta.SetStatusCondition(&pg.Status.Conditions, metav1.Condition{
+				Type:               databasev1.ConditionMaintenancePending,
+				Status:             metav1.ConditionFalse,
+				Reason:             reason.NothingPending,
+				ObservedGeneration: pg.Generation,
+			})
+		}
+		return
+	}
+	meta.SetStatusCondition(&pg.Status.Conditions, metav1.Condition{
+		Type:   databasev1.ConditionMaintenancePending,
+		Status: metav1.ConditionTrue,
+		Reason: reason.OutsideMaintenanceWindow,
+		Message: fmt.Sprintf("%s deferred until the maintenance window opens at %s",
+			strings.Join(m.deferred, ", "), m.next.UTC().Format(time.RFC3339)),
+		ObservedGeneration: pg.Generation,
+	})
+}
+
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday, "Mon": time.Monday, "Tue": time.Tuesday, "Wed": time.Wednesday,
+	"Thu": time.Thursday, "Fri": time.Friday, "Sat": time.Saturday,
+}
+
+// windowOpen reports whether now falls inside the maintenance window and,
+// if it does not, when the window opens next.
+func windowOpen(window *databasev1.MaintenanceWindow, now time.Time) (bool, time.Time, error) {
+	start, err := time.Parse("15:04", window.Start)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid maintenance window start %q: %w", window.Start, err)
+	}
+	days := map[time.Weekday]bool{}
+	for _, day := range window.Days {
+		weekday, ok := weekdays[string(day)]
+		if !ok {
+			return false, time.Time{}, fmt.Errorf("invalid maintenance window day %q", day)
+		}
+		days[weekday] = true
+	}
+
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+	// A window opened yesterday can still be running; look a week ahead for
+	// the next one.
+	for offset := -1; offset <= 7; offset++ {
+		opens := today.AddDate(0, 0, offset)
+		if len(days) > 0 && !days[opens.Weekday()] {
+			continue
+		}
+		if !now.Before(opens) && now.Before(opens.Add(window.Duration.Duration)) {
+			return true, opens, nil
+		}
+		if opens.After(now) {
+			return false, opens, nil
+		}
+	}
+	return false, time.Time{}, fmt.Errorf("maintenance window never opens")
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWindowOpen(t *testing.T) {
+	// Saturday and Sunday nights, 23:00 to 03:00 UTC
+	window := &databasev1.MaintenanceWindow{
+		Days:     []databasev1.Weekday{"Sat", "Sun"},
+		Start:    "23:00",
+		Duration: metav1.Duration{Duration: 4 * time.Hour},
+	}
+
+	tests := []struct {
+		name     string
+		now      string
+		wantOpen bool
+		wantNext string
+	}{
+		{"weekday", "2022-09-14T10:00:00Z", false, "2022-09-17T23:00:00Z"},
+		{"saturday before the window", "2022-09-17T22:59:00Z", false, "2022-09-17T23:00:00Z"},
+		{"saturday night", "2022-09-17T23:30:00Z", true, "2022-09-17T23:00:00Z"},
+		{"early sunday, carried over", "2022-09-18T02:00:00Z", true, "2022-09-17T23:00:00Z"},
+		{"sunday afternoon", "2022-09-18T14:00:00Z", false, "2022-09-18T23:00:00Z"},
+		{"monday after the window", "2022-09-19T03:00:00Z", false, "2022-09-24T23:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, tt.now)
+			open, next, err := windowOpen(window, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if open != tt.wantOpen {
+				t.Errorf("open = %v, want %v", open, tt.wantOpen)
+			}
+			if got := next.Format(time.RFC3339); got != tt.wantNext {
+				t.Errorf("next = %s, want %s", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestWindowOpenInvalid(t *testing.T) {
+	for _, window := range []*databasev1.MaintenanceWindow{
+		{Start: "25:00", Duration: metav1.Duration{Duration: time.Hour}},
+		{Days: []databasev1.Weekday{"Someday"}, Start: "01:00", Duration: metav1.Duration{Duration: time.Hour}},
+	} {
+		if _, _, err := windowOpen(window, time.Now()); err == nil {
+			t.Errorf("expected an error for %+v", window)
+		}
+	}
+}
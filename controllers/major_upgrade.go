@@ -0,0 +1,232 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	"github.com/pkpivot/pg-simple-operator/pkg/features"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Image carrying the binaries of both major versions, tagged <old>-to-<new>
+const upgradeImageRepository = "tianon/postgres-upgrade"
+
+// upgradeScript converts the data directory in place. A --check run acts as
+// the pre-flight test before anything is touched; the upgrade itself links
+// the data files into a fresh cluster and only swaps directories once
+// pg_upgrade has succeeded. Any failure removes the new cluster and puts back
+// the pg_control file pg_upgrade renames when linking, leaving the old
+// data directory usable.
+const upgradeScript = `set -e
+OLD_BIN=/usr/lib/postgresql/$OLD_MAJOR/bin
+NEW_BIN=/usr/lib/postgresql/$NEW_MAJOR/bin
+rollback() {
+  rm -rf /data/pgdata-new
+  if [ -f /data/pgdata/global/pg_control.old ]; then
+    mv /data/pgdata/global/pg_control.old /data/pgdata/global/pg_control
+  fi
+}
+trap rollback ERR
+rm -rf /data/pgdata-new
+mkdir -m 700 /data/pgdata-new
+chown postgres:postgres /data/pgdata-new
+cd /tmp
+gosu postgres $NEW_BIN/initdb -D /data/pgdata-new --username="$PGUSER"
+upgrade() {
+  gosu postgres $NEW_BIN/pg_upgrade --old-bindir=$OLD_BIN --new-bindir=$NEW_BIN \
+    --old-datadir=/data/pgdata --new-datadir=/data/pgdata-new --username="$PGUSER" "$@"
+}
+upgrade --check
+upgrade --link
+cp /data/pgdata/postgresql.conf /data/pgdata/pg_hba.conf /data/pgdata-new/
+mv /data/pgdata /data/pgdata-$OLD_MAJOR
+mv /data/pgdata-new /data/pgdata
+`
+
+// reconcileMajorUpgrade drives a change of major version: stop the pod, run
+// pg_upgrade in a Job against the data volume, then record the new version
+// so that the pod comes back on the new binaries. It reports whether the
+// upgrade is in progress, in which case the pod must stay down.
+func (r *PostgresqlReconciler) reconcileMajorUpgrade(ctx context.Context, pg *databasev1.Postgresql, maintenance *maintenance) (bool, error) {
+	from, to := pg.Status.Version, desiredVersion(*pg)
+	if from == "" || catalog.Major(from) == catalog.Major(to) {
+		return false, nil
+	}
+
+	// A failed upgrade is only retried once the spec changes again
+	cond := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionUpgrading)
+	if cond != nil && cond.Reason == reason.MajorUpgradeFailed && cond.ObservedGeneration == pg.Generation {
+		return false, nil
+	}
+
+	if !features.Enabled(features.MajorVersionUpgrade) {
+		setUpgradingCondition(pg, metav1.ConditionFalse, reason.MajorUpgradeRequired,
+			fmt.Sprintf("moving from %s to %s needs pg_upgrade, which the MajorVersionUpgrade feature gate turns off", from, to))
+		return false, nil
+	}
+	if pg.Spec.Storage == nil {
+		setUpgradingCondition(pg, metav1.ConditionFalse, reason.MajorUpgradeRequired,
+			fmt.Sprintf("moving from %s to %s needs pg_upgrade, which requires persistent storage", from, to))
+		return false, nil
+	}
+
+	var job batchv1.Job
+	if err := r.Get(ctx, getUpgradeJobNamespacedName(*pg), &job); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return true, err
+		}
+
+		// Only starting an upgrade waits for the window, one that is
+		// already running is seen through
+		if !upgradeStarted(pg) && !maintenance.permits("upgrade to "+to) {
+			return false, nil
+		}
+
+		// Wait for the old server to shut down before touching its data
+		var pod v1.Pod
+		if err := r.Get(ctx, GetPodNamespacedName(*pg), &pod); err == nil {
+			setUpgradingCondition(pg, metav1.ConditionTrue, reason.MajorUpgradeInProgress,
+				fmt.Sprintf("stopping %s before upgrading to %s", from, to))
+			return true, nil
+		} else if client.IgnoreNotFound(err) != nil {
+			return true, err
+		}
+
+		job = createUpgradeJob(*pg, from, to)
+		setManagedLabels(&job, *pg)
+		if err := ctrl.SetControllerReference(pg, &job, r.Scheme); err != nil {
+			return true, err
+		}
+		log.FromContext(ctx).Info("starting major version upgrade", "name", pg.Name, "from", from, "to", to)
+		if err := r.Create(ctx, &job); err != nil {
+			return true, err
+		}
+		setUpgradingCondition(pg, metav1.ConditionTrue, reason.MajorUpgradeInProgress,
+			fmt.Sprintf("running pg_upgrade from %s to %s", from, to))
+		return true, nil
+	}
+
+	// A job left over from an earlier attempt at another version
+	if job.Annotations[upgradeTargetAnnotation] != to {
+		return true, r.deleteUpgradeJob(ctx, &job)
+	}
+	if err := r.adopt(ctx, pg, &job); err != nil {
+		return true, err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		pg.Status.Version = to
+		pg.Status.ImageDigest = ""
+		setUpgradingCondition(pg, metav1.ConditionTrue, reason.MajorUpgradeInProgress,
+			fmt.Sprintf("data directory upgraded to %s, starting instance", to))
+		// Recorded before the job goes, or a restart of the operator in
+		// between would run pg_upgrade on the upgraded data directory
+		if err := r.updateStatus(ctx, pg); err != nil {
+			return true, err
+		}
+		return false, r.deleteUpgradeJob(ctx, &job)
+	case job.Status.Failed > 0:
+		// The job is kept so its logs can be inspected
+		setUpgradingCondition(pg, metav1.ConditionFalse, reason.MajorUpgradeFailed,
+			fmt.Sprintf("pg_upgrade to %s failed, staying on %s; see the logs of job %s", to, from, job.Name))
+		return false, nil
+	}
+	return true, nil
+}
+
+// Records which version an upgrade job was created for
+const upgradeTargetAnnotation = "db.example.com/upgrade-to"
+
+func createUpgradeJob(pg databasev1.Postgresql, from, to string) batchv1.Job {
+	const dbDisk = "postgresql-db-disk"
+	var backoffLimit int32
+	oldMajor, newMajor := catalog.Major(from), catalog.Major(to)
+	return batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        getUpgradeJobName(pg),
+			Namespace:   pg.Namespace,
+			Labels:      map[string]string{instanceLabel: pg.Name},
+			Annotations: map[string]string{upgradeTargetAnnotation: to},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Name:    "pg-upgrade",
+						Image:   fmt.Sprintf("%s:%s-to-%s", upgradeImageRepository, oldMajor, newMajor),
+						Command: []string{"bash", "-c", upgradeScript},
+						Env: []v1.EnvVar{
+							{Name: "OLD_MAJOR", Value: oldMajor},
+							{Name: "NEW_MAJOR", Value: newMajor},
+							{Name: "PGUSER", Value: "postgres"},
+						},
+						VolumeMounts: []v1.VolumeMount{{Name: dbDisk, MountPath: "/data"}},
+					}},
+					Volumes: []v1.Volume{dataVolume(pg, dbDisk)},
+				},
+			},
+		},
+	}
+}
+
+// upgradeStarted reports whether the pod has already been stopped for a
+// major version upgrade
+func upgradeStarted(pg *databasev1.Postgresql) bool {
+	cond := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionUpgrading)
+	return cond != nil && cond.Status == metav1.ConditionTrue && cond.Reason == reason.MajorUpgradeInProgress
+}
+
+func (r *PostgresqlReconciler) deleteUpgradeJob(ctx context.Context, job *batchv1.Job) error {
+	policy := metav1.DeletePropagationBackground
+	return client.IgnoreNotFound(r.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &policy}))
+}
+
+func setUpgradingCondition(pg *databasev1.Postgresql, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&pg.Status.Conditions, metav1.Condition{
+		Type:               databasev1.ConditionUpgrading,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: pg.Generation,
+	})
+}
+
+func getUpgradeJobName(pg databasev1.Postgresql) string {
+	return pg.Name + "-upgrade"
+}
+
+func getUpgradeJobNamespacedName(pg databasev1.Postgresql) types.NamespacedName {
+	return types.NamespacedName{
+		Name:      getUpgradeJobName(pg),
+		Namespace: pg.Namespace,
+	}
+}
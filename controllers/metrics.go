@@ -0,0 +1,149 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Metrics of failing reconciles, by instance. An alert on an instance that
+// has failed to reconcile for half an hour reads
+//
+//	time() - pg_operator_reconcile_failing_since_seconds > 1800
+var (
+	reconcileFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pg_operator_reconcile_consecutive_failures",
+		Help: "Number of reconciles of the instance that failed in a row, 0 once one succeeds",
+	}, []string{"namespace", "name"})
+	reconcileFailingSince = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pg_operator_reconcile_failing_since_seconds",
+		Help: "Unix time of the first of the failed reconciles in a row, absent while reconciles succeed",
+	}, []string{"namespace", "name"})
+	reconcileLastError = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pg_operator_reconcile_last_error",
+		Help: "1 for the class of error the last reconcile of the instance failed with, absent while reconciles succeed",
+	}, []string{"namespace", "name", "class"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileFailures, reconcileFailingSince, reconcileLastError)
+}
+
+// Error classes on the pg_operator_reconcile_last_error metric
+const (
+	errorClassConflict   = "Conflict"
+	errorClassForbidden  = "Forbidden"
+	errorClassInvalid    = "Invalid"
+	errorClassTimeout    = "Timeout"
+	errorClassSQL        = "SQL"
+	errorClassConnection = "Connection"
+	errorClassOther      = "Other"
+)
+
+// errorClass sorts an error into the broad classes an alert can tell apart
+func errorClass(err error) string {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case apierrors.IsConflict(err):
+		return errorClassConflict
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return errorClassForbidden
+	case apierrors.IsInvalid(err) || apierrors.IsBadRequest(err):
+		return errorClassInvalid
+	case errors.Is(err, context.DeadlineExceeded) || apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err):
+		return errorClassTimeout
+	case errors.As(err, &pqErr):
+		return errorClassSQL
+	case errors.As(err, &netErr):
+		return errorClassConnection
+	}
+	return errorClassOther
+}
+
+// failureStreak is the run of failed reconciles an instance is on
+type failureStreak struct {
+	count int
+	since time.Time
+	class string
+}
+
+var (
+	failureStreaksMu sync.Mutex
+	failureStreaks   = map[types.NamespacedName]*failureStreak{}
+)
+
+// recordFailures keeps the failure metrics of the instances the reconciler
+// works on
+func recordFailures(c client.Client, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		result, err := r.Reconcile(ctx, req)
+		gone := false
+		if err == nil {
+			gone = apierrors.IsNotFound(c.Get(ctx, req.NamespacedName, &databasev1.Postgresql{}))
+		}
+		recordOutcome(req.NamespacedName, err, gone, time.Now())
+		return result, err
+	})
+}
+
+// recordOutcome updates the failure metrics of an instance after a
+// reconcile. The metrics of an instance that is gone are dropped.
+func recordOutcome(name types.NamespacedName, err error, gone bool, now time.Time) {
+	failureStreaksMu.Lock()
+	defer failureStreaksMu.Unlock()
+
+	labels := prometheus.Labels{"namespace": name.Namespace, "name": name.Name}
+	streak := failureStreaks[name]
+	if streak != nil {
+		reconcileLastError.Delete(prometheus.Labels{"namespace": name.Namespace, "name": name.Name, "class": streak.class})
+	}
+	if err == nil {
+		delete(failureStreaks, name)
+		reconcileFailingSince.Delete(labels)
+		if gone {
+			reconcileFailures.Delete(labels)
+		} else {
+			reconcileFailures.With(labels).Set(0)
+		}
+		return
+	}
+
+	if streak == nil {
+		streak = &failureStreak{since: now}
+		failureStreaks[name] = streak
+	}
+	streak.count++
+	streak.class = errorClass(err)
+	reconcileFailures.With(labels).Set(float64(streak.count))
+	reconcileFailingSince.With(labels).Set(float64(streak.since.Unix()))
+	reconcileLastError.With(prometheus.Labels{"namespace": name.Namespace, "name": name.Name, "class": streak.class}).Set(1)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRecordOutcome(t *testing.T) {
+	name := types.NamespacedName{Namespace: "db", Name: "metrics"}
+	labels := prometheus.Labels{"namespace": name.Namespace, "name": name.Name}
+	start := time.Unix(1000, 0)
+
+	recordOutcome(name, &pq.Error{Code: "42501"}, false, start)
+	recordOutcome(name, apierrors.NewConflict(schema.GroupResource{}, "pg", errors.New("changed")), false, start.Add(time.Minute))
+	if n := testutil.ToFloat64(reconcileFailures.With(labels)); n != 2 {
+		t.Errorf("expected 2 failures in a row, got %v", n)
+	}
+	if since := testutil.ToFloat64(reconcileFailingSince.With(labels)); since != 1000 {
+		t.Errorf("expected the streak to start at the first failure, got %v", since)
+	}
+	if n := testutil.CollectAndCount(reconcileLastError); n != 1 {
+		t.Errorf("expected only the last error class, got %d series", n)
+	}
+	if v := testutil.ToFloat64(reconcileLastError.With(prometheus.Labels{"namespace": "db", "name": "metrics", "class": errorClassConflict})); v != 1 {
+		t.Errorf("expected the last error to be a conflict, got %v", v)
+	}
+
+	recordOutcome(name, nil, false, start.Add(2*time.Minute))
+	if n := testutil.ToFloat64(reconcileFailures.With(labels)); n != 0 {
+		t.Errorf("expected the streak to end, got %v", n)
+	}
+	if n := testutil.CollectAndCount(reconcileFailingSince) + testutil.CollectAndCount(reconcileLastError); n != 0 {
+		t.Errorf("expected no failure series for a healthy instance, got %d", n)
+	}
+
+	recordOutcome(name, nil, true, start.Add(3*time.Minute))
+	if n := testutil.CollectAndCount(reconcileFailures); n != 0 {
+		t.Errorf("expected the series of a deleted instance to go, got %d", n)
+	}
+}
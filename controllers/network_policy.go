@@ -0,0 +1,122 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// clientLabel marks the pods of Jobs the operator runs against an instance,
+// such as the seed, so its NetworkPolicy lets them in
+const clientLabel = "db.example.com/client"
+
+// operatorPodLabels select the operator's own pods, as deployed by
+// config/manager
+var operatorPodLabels = map[string]string{"control-plane": "controller-manager"}
+
+// serviceAccountNamespacePath holds the namespace the operator runs in
+var serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;delete
+
+// reconcileNetworkPolicy keeps the <name>-access NetworkPolicy in line with
+// the access spec, deleting it when no longer asked for
+func (r *PostgresqlReconciler) reconcileNetworkPolicy(ctx context.Context, pg *databasev1.Postgresql) error {
+	policy := networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: getNetworkPolicyName(*pg), Namespace: pg.Namespace}}
+	if !operatorconfig.Get().Enabled(operatorconfig.FeatureDefaultDenyNetwork, r.DefaultDenyNetwork) && (pg.Spec.Access == nil || !pg.Spec.Access.NetworkPolicy) {
+		return client.IgnoreNotFound(r.Delete(ctx, &policy))
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &policy, func() error {
+		policy.Spec = networkPolicySpec(*pg, operatorNamespace())
+		setManagedLabels(&policy, *pg)
+		return ctrl.SetControllerReference(pg, &policy, r.Scheme)
+	})
+	return err
+}
+
+// networkPolicySpec lets the allowed CIDRs and peers, the operator, the
+// instance's Jobs and the other nodes of its Citus cluster reach Postgres,
+// and PgBouncer if it runs as a sidecar, on the instance's pods. Anything
+// else is denied, so without an access spec only the latter ones get in.
+func networkPolicySpec(pg databasev1.Postgresql, operatorNamespace string) networkingv1.NetworkPolicySpec {
+	protocol := v1.ProtocolTCP
+	postgres, pooler := intstr.FromInt(postgresPort), intstr.FromInt(poolerPort)
+	ports := []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &postgres}}
+	if poolerSidecar(pg) {
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &pooler})
+	}
+
+	operator := networkingv1.NetworkPolicyPeer{
+		PodSelector:       &metav1.LabelSelector{MatchLabels: operatorPodLabels},
+		NamespaceSelector: &metav1.LabelSelector{},
+	}
+	if operatorNamespace != "" {
+		operator.NamespaceSelector.MatchLabels = map[string]string{"kubernetes.io/metadata.name": operatorNamespace}
+	}
+	peers := []networkingv1.NetworkPolicyPeer{
+		operator,
+		{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{clientLabel: pg.Name}}},
+	}
+	if coordinator := citusCoordinator(pg); coordinator != "" {
+		// The nodes of a Citus cluster query and move shards between each
+		// other
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{citusLabel: coordinator}},
+		})
+	}
+	if access := pg.Spec.Access; access != nil {
+		for _, cidr := range access.AllowedCIDRs {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		peers = append(peers, access.Peers...)
+	}
+
+	return networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{clusterLabel: pg.Name}},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{{
+			Ports: ports,
+			From:  peers,
+		}},
+	}
+}
+
+// operatorNamespace is the namespace the operator runs in, or empty when it
+// runs outside the cluster
+func operatorNamespace() string {
+	namespace, err := os.ReadFile(serviceAccountNamespacePath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(namespace))
+}
+
+func getNetworkPolicyName(pg databasev1.Postgresql) string {
+	return pg.Name + "-access"
+}
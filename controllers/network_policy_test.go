@@ -0,0 +1,72 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNetworkPolicySpec(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name = "pg"
+	pg.Spec.Access = &databasev1.AccessSpec{AllowedCIDRs: []string{"10.0.0.0/8"}, NetworkPolicy: true}
+
+	spec := networkPolicySpec(pg, "pg-operator-system")
+	if spec.PodSelector.MatchLabels[clusterLabel] != "pg" {
+		t.Errorf("policy should select the instance's pods, got %v", spec.PodSelector)
+	}
+	if len(spec.Ingress) != 1 || spec.Ingress[0].Ports[0].Port.IntValue() != postgresPort {
+		t.Fatalf("expected a single ingress rule on the Postgres port, got %+v", spec.Ingress)
+	}
+	peers := spec.Ingress[0].From
+	if len(peers) != 3 {
+		t.Fatalf("expected the operator, the instance's jobs and one CIDR, got %+v", peers)
+	}
+	if peers[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] != "pg-operator-system" {
+		t.Errorf("operator peer should be limited to its namespace, got %+v", peers[0])
+	}
+	if peers[1].PodSelector.MatchLabels[clientLabel] != "pg" {
+		t.Errorf("jobs of the instance should be let in, got %+v", peers[1])
+	}
+	if peers[2].IPBlock == nil || peers[2].IPBlock.CIDR != "10.0.0.0/8" {
+		t.Errorf("allowed CIDR should be let in, got %+v", peers[2])
+	}
+
+	if spec := networkPolicySpec(pg, ""); len(spec.Ingress[0].From[0].NamespaceSelector.MatchLabels) != 0 {
+		t.Error("operator outside the cluster should be matched in any namespace")
+	}
+}
+
+func TestNetworkPolicySpecDefaultDeny(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name = "pg"
+	spec := networkPolicySpec(pg, "")
+	if len(spec.Ingress[0].From) != 2 {
+		t.Errorf("without an access spec only the operator and jobs should get in, got %+v", spec.Ingress[0].From)
+	}
+
+	app := networkingv1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "shop"}}}
+	pg.Spec.Access = &databasev1.AccessSpec{Peers: []networkingv1.NetworkPolicyPeer{app}}
+	spec = networkPolicySpec(pg, "")
+	if peers := spec.Ingress[0].From; len(peers) != 3 || peers[2].PodSelector.MatchLabels["app"] != "shop" {
+		t.Errorf("declared peer should be let in, got %+v", peers)
+	}
+}
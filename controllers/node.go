@@ -0,0 +1,46 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// nodeLost reports whether the node the pod was scheduled to no longer
+// exists. A node that is merely NotReady may still be running Postgres on
+// the volume, so only a node that has been removed from the cluster counts
+// as confirmed gone.
+func (r *PostgresqlReconciler) nodeLost(ctx context.Context, pod *v1.Pod) bool {
+	if pod.Spec.NodeName == "" {
+		return false
+	}
+	var node v1.Node
+	err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node)
+	if err == nil {
+		return false
+	}
+	if !apierrors.IsNotFound(err) {
+		log.FromContext(ctx).Error(err, "could not look up node", "node", pod.Spec.NodeName)
+		return false
+	}
+	return true
+}
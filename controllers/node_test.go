@@ -0,0 +1,57 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeLost(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionUnknown}}
+	r := &PostgresqlReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build(), Scheme: scheme}
+
+	pod := &v1.Pod{}
+	if r.nodeLost(ctx, pod) {
+		t.Error("a pod not scheduled yet has no node to lose")
+	}
+	pod.Spec.NodeName = "node-a"
+	if r.nodeLost(ctx, pod) {
+		t.Error("a NotReady node may still run Postgres and should not count as lost")
+	}
+	pod.Spec.NodeName = "node-b"
+	if !r.nodeLost(ctx, pod) {
+		t.Error("expected a node removed from the cluster to count as lost")
+	}
+
+	// Without a Node kind to look up, nothing is confirmed gone
+	r.Client = fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build()
+	if r.nodeLost(ctx, pod) {
+		t.Error("expected a failed lookup not to count as a lost node")
+	}
+}
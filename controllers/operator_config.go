@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// OperatorConfigLoader puts the operator configuration of a ConfigMap into
+// effect whenever the ConfigMap changes. It runs on every replica of the
+// manager, not just the leader, as the webhooks read the configuration too.
+// Instances pick up a new configuration the next time they are reconciled.
+type OperatorConfigLoader struct {
+	Cache cache.Cache
+
+	// ConfigMap is the name of the ConfigMap holding the configuration
+	ConfigMap types.NamespacedName
+}
+
+// NeedLeaderElection tells the manager to run the loader on all replicas
+func (l *OperatorConfigLoader) NeedLeaderElection() bool {
+	return false
+}
+
+// Start follows the ConfigMap until the context ends
+func (l *OperatorConfigLoader) Start(ctx context.Context) error {
+	informer, err := l.Cache.GetInformer(ctx, &v1.ConfigMap{})
+	if err != nil {
+		return err
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { l.load(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { l.load(obj, false) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			l.load(obj, true)
+		},
+	})
+	<-ctx.Done()
+	return nil
+}
+
+// load puts the configuration of the ConfigMap into effect, or the built-in
+// one when the ConfigMap is gone. A configuration that does not parse
+// leaves the one in effect in place.
+func (l *OperatorConfigLoader) load(obj interface{}, deleted bool) {
+	configMap, ok := obj.(*v1.ConfigMap)
+	if !ok || configMap.Namespace != l.ConfigMap.Namespace || configMap.Name != l.ConfigMap.Name {
+		return
+	}
+	logger := ctrl.Log.WithName("operatorconfig").WithValues("configmap", l.ConfigMap)
+	if deleted {
+		logger.Info("operator configuration is gone, using built-in settings")
+		operatorconfig.Set(operatorconfig.Config{})
+		return
+	}
+	config, err := operatorconfig.Parse(configMap.Data[operatorconfig.Key])
+	if err != nil {
+		logger.Error(err, "invalid operator configuration, keeping the previous one")
+		return
+	}
+	operatorconfig.Set(config)
+	logger.Info("loaded operator configuration", "resourceVersion", configMap.ResourceVersion)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// managedByLabel marks every object the operator makes for an instance,
+// which also carries the clusterLabel naming the instance
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "pg-simple-operator"
+)
+
+// setManagedLabels marks an object as made by the operator for an instance
+func setManagedLabels(obj metav1.Object, pg databasev1.Postgresql) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[managedByLabel] = managedBy
+	labels[clusterLabel] = pg.Name
+	obj.SetLabels(labels)
+}
+
+//+kubebuilder:rbac:groups="",resources=secrets;configmaps;serviceaccounts,verbs=delete
+
+// OrphanSweeper deletes objects the operator made for an instance that no
+// longer exists. Owner references normally take care of that; the sweep
+// covers objects that lost theirs or whose cleanup was skipped. Volume
+// claims are left, as their data may still be wanted: an instance created
+// again under the same name adopts them.
+type OrphanSweeper struct {
+	client.Client
+
+	// APIReader reads the Postgresqls from the API server, so that one
+	// the cache has not caught up with yet does not lose its objects
+	APIReader client.Reader
+
+	// Interval is the time between sweeps
+	Interval time.Duration
+}
+
+// The kinds the sweep goes through
+var sweptLists = []func() client.ObjectList{
+	func() client.ObjectList { return &v1.PodList{} },
+	func() client.ObjectList { return &v1.ServiceList{} },
+	func() client.ObjectList { return &v1.SecretList{} },
+	func() client.ObjectList { return &v1.ConfigMapList{} },
+	func() client.ObjectList { return &v1.ServiceAccountList{} },
+	func() client.ObjectList { return &batchv1.JobList{} },
+	func() client.ObjectList { return &networkingv1.NetworkPolicyList{} },
+}
+
+// Start sweeps at the interval until the context ends. It only runs on the
+// leader.
+func (s *OrphanSweeper) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, s.sweep, s.Interval)
+	return nil
+}
+
+func (s *OrphanSweeper) sweep(ctx context.Context) {
+	logger := ctrl.Log.WithName("orphans")
+	instances := map[types.NamespacedName]bool{}
+	for _, newList := range sweptLists {
+		list := newList()
+		if err := s.List(ctx, list, client.MatchingLabels{managedByLabel: managedBy}); err != nil {
+			logger.Error(err, "could not list managed objects")
+			continue
+		}
+		objects, err := meta.ExtractList(list)
+		if err != nil {
+			logger.Error(err, "could not list managed objects")
+			continue
+		}
+		for _, item := range objects {
+			obj, ok := item.(client.Object)
+			if !ok || obj.GetLabels()[clusterLabel] == "" || !obj.GetDeletionTimestamp().IsZero() {
+				continue
+			}
+			name := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetLabels()[clusterLabel]}
+			exists, known := instances[name]
+			if !known {
+				err := s.APIReader.Get(ctx, name, &databasev1.Postgresql{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					logger.Error(err, "could not look up instance", "instance", name)
+					continue
+				}
+				exists = err == nil
+				instances[name] = exists
+			}
+			if exists {
+				continue
+			}
+			logger.Info("deleting object of a deleted instance", "instance", name, "object", obj.GetName())
+			policy := metav1.DeletePropagationBackground
+			if err := s.Delete(ctx, obj, &client.DeleteOptions{PropagationPolicy: &policy}); client.IgnoreNotFound(err) != nil {
+				logger.Error(err, "could not delete object", "object", obj.GetName())
+			}
+		}
+	}
+}
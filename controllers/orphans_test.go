@@ -0,0 +1,58 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOrphanSweep(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	live := databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "live"}}
+	gone := databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "gone"}}
+	kept := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "live-hba"}}
+	orphan := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "gone-hba"}}
+	foreign := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "gone-app",
+		Labels: map[string]string{clusterLabel: "gone"}}}
+	setManagedLabels(kept, live)
+	setManagedLabels(orphan, gone)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&live, kept, orphan, foreign).Build()
+	(&OrphanSweeper{Client: c, APIReader: c}).sweep(ctx)
+
+	for _, secret := range []*v1.Secret{kept, foreign} {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(secret), &v1.Secret{}); err != nil {
+			t.Errorf("secret %s should have been kept: %v", secret.Name, err)
+		}
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(orphan), &v1.Secret{}); err == nil {
+		t.Error("secret of the deleted instance should have been deleted")
+	}
+}
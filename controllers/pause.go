@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// observe is the whole reconcile of a paused instance: the status follows
+// the pod, whose changes trigger a reconcile, but nothing in the cluster is
+// changed - not even the finalizer, so deleting a paused instance waits
+// until it is resumed.
+func (r *PostgresqlReconciler) observe(ctx context.Context, pg *databasev1.Postgresql) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("reconciliation paused, observing only", "name", pg.Name)
+
+	var pod v1.Pod
+	if err := r.Get(ctx, GetPodNamespacedName(*pg), &pod); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	pg.Status.Phase = phaseFromPod(&pod)
+	setPausedCondition(pg, true)
+	if err := r.updateStatus(ctx, pg); err != nil {
+		logger.Error(err, "could not update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func isPaused(pg *databasev1.Postgresql) bool {
+	return pg.Annotations[databasev1.ReconcileAnnotation] == databasev1.ReconcilePaused
+}
+
+// setPausedCondition records whether the instance is paused. The condition is
+// only added once an instance has been paused.
+func setPausedCondition(pg *databasev1.Postgresql, paused bool) {
+	if paused {
+		meta.SetStatusCondition(&pg.Status.Conditions, metav1.Condition{
+			Type:    databasev1.ConditionReconcilePaused,
+			Status:  metav1.ConditionTrue,
+			Reason:  reason.PausedByAnnotation,
+			Message: "the operator only observes this instance until the reconcile annotation is removed",
+		})
+	} else if meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionReconcilePaused) != nil {
+		meta.SetStatusCondition(&pg.Status.Conditions, metav1.Condition{
+			Type:   databasev1.ConditionReconcilePaused,
+			Status: metav1.ConditionFalse,
+			Reason: reason.Reconciling,
+		})
+	}
+}
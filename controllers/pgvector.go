@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// The least maintenance_work_mem pgvector is given, the Postgres default
+const minMaintenanceWorkMem = 64 << 20
+
+// pgvectorParameters give index builds a quarter of the instance's memory,
+// its limit or else its request: HNSW indexes build many times faster when
+// the whole graph fits in maintenance_work_mem
+func pgvectorParameters(pg databasev1.Postgresql) map[string]string {
+	if pg.Spec.Resources == nil {
+		return nil
+	}
+	memory := pg.Spec.Resources.Limits.Memory()
+	if memory.IsZero() {
+		memory = pg.Spec.Resources.Requests.Memory()
+	}
+	workMem := memory.Value() / 4
+	if workMem <= minMaintenanceWorkMem {
+		return nil
+	}
+	return map[string]string{
+		"maintenance_work_mem": fmt.Sprintf("%dMB", workMem>>20),
+	}
+}
+
+// reconcilePGVector creates the vector extension in postgres and template1,
+// which later databases copy it from, and in the databases the spec lists.
+// Databases that do not exist yet are tried again on later passes, as is
+// everything while the pod still runs an image without pgvector.
+func (r *PostgresqlReconciler) reconcilePGVector(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if pg.Spec.PGVector == nil {
+		return nil
+	}
+	if container := postgresContainer(pod, *pg); container == nil ||
+		!sameImage(container.Image, imageForVersion(*pg, desiredVersion(*pg))) {
+		// The pod has yet to move to the pgvector image, which
+		// reconcileVersion does within the maintenance window
+		return nil
+	}
+	databases := append([]string{"postgres", "template1"}, pg.Spec.PGVector.Databases...)
+	for _, database := range databases {
+		if err := r.createVectorExtension(ctx, pg, pod, database); err != nil {
+			return fmt.Errorf("database %s: %w", database, err)
+		}
+	}
+	return nil
+}
+
+func (r *PostgresqlReconciler) createVectorExtension(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod, database string) error {
+	logger := log.FromContext(ctx)
+	db, err := r.openSuperuserDB(ctx, pg, pod, database)
+	if isUndefinedDatabase(err) {
+		logger.Info("database for pgvector does not exist yet", "name", pg.Name, "database", database)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT true FROM pg_extension WHERE extname = 'vector'").Scan(&exists)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	if DryRun {
+		logger.Info("dry run: would create the vector extension", "name", pg.Name, "database", database)
+		return nil
+	}
+	logger.Info("creating the vector extension", "name", pg.Name, "database", database)
+	_, err = db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS vector")
+	return err
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPGVectorParameters(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.PGVector = &databasev1.PGVectorSpec{}
+	pg.Spec.Resources = &v1.ResourceRequirements{
+		Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+		Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")},
+	}
+	args := strings.Join(postgresArgs(pg), " ")
+	if !strings.Contains(args, "maintenance_work_mem=1024MB") {
+		t.Errorf("expected a quarter of the memory limit for index builds, got %s", args)
+	}
+
+	pg.Spec.Parameters = map[string]string{"maintenance_work_mem": "256MB"}
+	args = strings.Join(postgresArgs(pg), " ")
+	if !strings.Contains(args, "maintenance_work_mem=256MB") {
+		t.Errorf("expected the parameter of the spec to win, got %s", args)
+	}
+
+	pg.Spec.Resources.Limits = nil
+	pg.Spec.Resources.Requests[v1.ResourceMemory] = resource.MustParse("128Mi")
+	if parameters := pgvectorParameters(pg); parameters != nil {
+		t.Errorf("expected small instances to keep the default, got %v", parameters)
+	}
+}
+
+func TestPGVectorImage(t *testing.T) {
+	defer operatorconfig.Set(operatorconfig.Config{})
+	operatorconfig.Set(operatorconfig.Config{PGVectorImageRepository: "registry.example.com/postgres-pgvector"})
+
+	pg := databasev1.Postgresql{}
+	if image := imageForVersion(pg, "15.4"); image != "postgres:15.4" {
+		t.Errorf("expected the catalog image without pgvector, got %s", image)
+	}
+	pg.Spec.PGVector = &databasev1.PGVectorSpec{}
+	if image := imageForVersion(pg, "15.4"); image != "registry.example.com/postgres-pgvector:15.4" {
+		t.Errorf("expected the pgvector image, got %s", image)
+	}
+	pg.Spec.ImageRepository = "registry.example.com/custom"
+	if image := imageForVersion(pg, "15.4"); image != "registry.example.com/custom:15.4" {
+		t.Errorf("expected the instance's own repository to win, got %s", image)
+	}
+}
+
+func TestReconcilePGVectorWaitsForImage(t *testing.T) {
+	defer operatorconfig.Set(operatorconfig.Config{})
+	operatorconfig.Set(operatorconfig.Config{PGVectorImageRepository: "registry.example.com/postgres-pgvector"})
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.Version = "15.4"
+	pg.Spec.PGVector = &databasev1.PGVectorSpec{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pod.Spec.Containers = []v1.Container{{Name: "pg", Image: "postgres:15.4"}}
+
+	// Without a client, anything but waiting for the image would fail
+	r := &PostgresqlReconciler{}
+	if err := r.reconcilePGVector(context.Background(), pg, pod); err != nil {
+		t.Errorf("expected the extension to wait for the pgvector image, got %v", err)
+	}
+}
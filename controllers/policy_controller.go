@@ -0,0 +1,194 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// PolicyReconciler reconciles a Policy object
+type PolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=policies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=policies/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=policies/finalizers,verbs=update
+
+// Reconcile enables row level security on the table and creates the policy,
+// and drops the policy again when the Policy is deleted
+func (r *PolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var policy databasev1.Policy
+	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !policy.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&policy, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		err := r.drop(ctx, &policy)
+		// Policies on an instance that is gone went with it
+		if errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, policy.Namespace, policy.Spec.InstanceRef) {
+			err = nil
+		}
+		if err != nil {
+			logger.Error(err, "could not drop policy", "name", policy.Target().Name)
+			setReadyCondition(&policy.Status.Conditions, policy.Generation, err)
+			if err := r.Status().Update(ctx, &policy); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&policy, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &policy)
+	}
+
+	if !controllerutil.ContainsFinalizer(&policy, dropFinalizer) {
+		controllerutil.AddFinalizer(&policy, dropFinalizer)
+		if err := r.Update(ctx, &policy); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &policy)
+	if err != nil {
+		logger.Error(err, "could not apply policy", "name", policy.Target().Name)
+	}
+	setReadyCondition(&policy.Status.Conditions, policy.Generation, err)
+	if err := r.Status().Update(ctx, &policy); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+// apply recreates the policy in one transaction, so its definition follows
+// the spec without a window where the table is unprotected. A previously
+// applied policy with another name or table is dropped first.
+func (r *PolicyReconciler) apply(ctx context.Context, policy *databasev1.Policy) error {
+	target := policy.Target()
+	if applied := policy.Status.Applied; applied != nil && *applied != target {
+		if err := r.drop(ctx, policy); err != nil {
+			return err
+		}
+	}
+
+	db, err := connectInstance(ctx, r.Client, policy.Namespace, policy.Spec.InstanceRef, target.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, statement := range policyStatements(policy.Spec, target) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	policy.Status.Applied = &target
+	return nil
+}
+
+// drop removes the policy last applied. Row level security stays enabled on
+// the table, so deleting a policy never exposes rows it was hiding.
+func (r *PolicyReconciler) drop(ctx context.Context, policy *databasev1.Policy) error {
+	applied := policy.Status.Applied
+	if applied == nil {
+		return nil
+	}
+	db, err := connectInstance(ctx, r.Client, policy.Namespace, policy.Spec.InstanceRef, applied.Database)
+	if isUndefinedDatabase(err) {
+		// Dropping the database took the policy with it
+		policy.Status.Applied = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	log.FromContext(ctx).Info("dropping policy", "name", applied.Name, "table", applied.Table)
+	if _, err := db.ExecContext(ctx, dropPolicyStatement(*applied)); err != nil {
+		return err
+	}
+	policy.Status.Applied = nil
+	return nil
+}
+
+// policyStatements enable row level security on the table and replace the
+// policy with the one the spec describes
+func policyStatements(spec databasev1.PolicySpec, target databasev1.PolicyTarget) []string {
+	table := tableList(target.Schema, []string{target.Table})
+	statements := []string{"ALTER TABLE " + table + " ENABLE ROW LEVEL SECURITY"}
+	if spec.Force {
+		statements = append(statements, "ALTER TABLE "+table+" FORCE ROW LEVEL SECURITY")
+	}
+
+	create := "CREATE POLICY " + pq.QuoteIdentifier(target.Name) + " ON " + table
+	if spec.Restrictive {
+		create += " AS RESTRICTIVE"
+	}
+	if spec.Command != "" {
+		create += " FOR " + spec.Command
+	}
+	if len(spec.Roles) > 0 {
+		roles := make([]string, len(spec.Roles))
+		for i, role := range spec.Roles {
+			roles[i] = pq.QuoteIdentifier(role)
+		}
+		create += " TO " + strings.Join(roles, ", ")
+	}
+	if spec.Using != "" {
+		create += " USING (" + spec.Using + ")"
+	}
+	if spec.WithCheck != "" {
+		create += " WITH CHECK (" + spec.WithCheck + ")"
+	}
+	return append(statements, dropPolicyStatement(target), create)
+}
+
+func dropPolicyStatement(target databasev1.PolicyTarget) string {
+	return "DROP POLICY IF EXISTS " + pq.QuoteIdentifier(target.Name) + " ON " + tableList(target.Schema, []string{target.Table})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Policy{}).
+		WithOptions(controllerOptions("Policy")).
+		Complete(finishOnShutdown(r))
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestPolicyStatements(t *testing.T) {
+	target := databasev1.PolicyTarget{Database: "shop", Schema: "public", Table: "orders", Name: "tenant"}
+	spec := databasev1.PolicySpec{
+		Command:   "SELECT",
+		Roles:     []string{"app"},
+		Using:     "tenant_id = current_setting('app.tenant')::int",
+		Force:     true,
+		WithCheck: "true",
+	}
+	want := []string{
+		`ALTER TABLE "public"."orders" ENABLE ROW LEVEL SECURITY`,
+		`ALTER TABLE "public"."orders" FORCE ROW LEVEL SECURITY`,
+		`DROP POLICY IF EXISTS "tenant" ON "public"."orders"`,
+		`CREATE POLICY "tenant" ON "public"."orders" FOR SELECT TO "app" USING (tenant_id = current_setting('app.tenant')::int) WITH CHECK (true)`,
+	}
+	if got := policyStatements(spec, target); !reflect.DeepEqual(got, want) {
+		t.Errorf("policyStatements = %q, want %q", got, want)
+	}
+}
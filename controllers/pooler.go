@@ -0,0 +1,215 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// poolerPort is where PgBouncer takes client connections
+const poolerPort = 6432
+
+// pgbouncerImage runs the pooler, which only needs pgbouncer and a shell
+const pgbouncerImage = "edoburu/pgbouncer:1.18.0"
+
+// Settings PgBouncer gets unless the pooler spec says otherwise
+const (
+	defaultPoolSize             = 20
+	defaultMaxClientConnections = 100
+)
+
+// The superuser password PgBouncer looks up passwords with is mounted from
+// its Secret, so rotations reach running pods
+const (
+	poolerAuthVolume    = "pooler-auth"
+	poolerAuthMountPath = "/pooler-auth"
+)
+
+// poolerAuthQuery looks up the password of a client for PgBouncer. Roles
+// pg_hba.conf of the server admits with a client certificate or LDAP only
+// are left out, whether a rule names them or a +group they are a member
+// of: the server trusts the connections PgBouncer makes, so a password such
+// a role has as well would let it in through the pooler. Groups are
+// matched by OID, so a rule naming a group that does not exist matches
+// nobody rather than failing every lookup.
+const poolerAuthQuery = `SELECT usename, passwd FROM pg_shadow s WHERE usename = \$1 AND NOT EXISTS (` +
+	`SELECT 1 FROM pg_hba_file_rules r CROSS JOIN unnest(r.user_name) u LEFT JOIN pg_roles g ON u = '+' || g.rolname ` +
+	`WHERE r.auth_method IN ('cert', 'ldap') AND (u = s.usename OR pg_has_role(s.usesysid, g.oid, 'member')))`
+
+// poolerScript configures PgBouncer for the server at SERVER_HOST and
+// starts it. Clients are checked against the password of their role, which
+// PgBouncer looks up through the superuser on every login, and PgBouncer
+// logs in to the server with what it looked up, so rotated role passwords
+// take effect right away. When the superuser password or the key pair
+// change, the userlist is rewritten and PgBouncer reloads.
+const poolerScript = `set -eu
+umask 077
+write_userlist() {
+  if [ -f ` + poolerAuthMountPath + `/password ]; then
+    AUTH_PASSWORD=$(cat ` + poolerAuthMountPath + `/password)
+  fi
+  password=$(printf '%s' "${AUTH_PASSWORD:-}" | sed 's/"/""/g')
+  printf '"%s" "%s"\n' "$AUTH_USER" "$password" >/tmp/userlist.txt
+}
+snapshot() {
+  cat ` + tlsSecretMountPath + `/* ` + poolerAuthMountPath + `/* 2>/dev/null | cksum
+}
+write_userlist
+printf '%s' "$POOLER_HBA" >/tmp/pgbouncer_hba.conf
+tls=""
+if [ -d ` + tlsSecretMountPath + ` ]; then
+  tls="client_tls_sslmode = $CLIENT_TLS_SSLMODE
+client_tls_key_file = ` + tlsSecretMountPath + `/tls.key
+client_tls_cert_file = ` + tlsSecretMountPath + `/tls.crt"
+fi
+cat >/tmp/pgbouncer.ini <<EOF
+[databases]
+* = host=$SERVER_HOST port=5432
+
+[pgbouncer]
+listen_addr = *
+listen_port = 6432
+auth_type = hba
+auth_hba_file = /tmp/pgbouncer_hba.conf
+auth_file = /tmp/userlist.txt
+auth_user = $AUTH_USER
+auth_query = ` + poolerAuthQuery + `
+pool_mode = $POOL_MODE
+default_pool_size = $DEFAULT_POOL_SIZE
+max_client_conn = $MAX_CLIENT_CONN
+server_tls_sslmode = $SERVER_TLS_SSLMODE
+ignore_startup_parameters = extra_float_digits
+$tls
+EOF
+(
+  last=$(snapshot)
+  while sleep 30; do
+    current=$(snapshot)
+    if [ "$current" != "$last" ]; then
+      last=$current
+      write_userlist
+      kill -HUP $$
+    fi
+  done
+) &
+exec pgbouncer /tmp/pgbouncer.ini
+`
+
+// poolerSidecar reports whether PgBouncer runs in the pod of the instance
+func poolerSidecar(pg databasev1.Postgresql) bool {
+	return pg.Spec.Pooler != nil && pg.Spec.Pooler.Mode == databasev1.PoolerSidecar
+}
+
+// addPoolerContainer runs PgBouncer next to the server, which trusts the
+// loopback connections PgBouncer makes on behalf of clients. PgBouncer
+// serves the same names as the server, so it is given the server key pair.
+func addPoolerContainer(pg databasev1.Postgresql, spec *v1.PodSpec) {
+	spec.Containers = append(spec.Containers, poolerContainer(pg, "127.0.0.1", "disable", pg.Spec.Pooler.PoolSettings))
+	spec.Volumes = append(spec.Volumes, poolerAuthVolumes(pg)...)
+}
+
+// poolerContainer runs PgBouncer in front of the instance, reached at
+// serverHost with the SSL mode given. The pod has to have the volumes of
+// poolerAuthVolumes and, with TLS, the server key pair.
+func poolerContainer(pg databasev1.Postgresql, serverHost, serverSSLMode string, settings databasev1.PoolSettings) v1.Container {
+	clientSSLMode := "prefer"
+	if fipsEnabled(pg) {
+		clientSSLMode = "require"
+	}
+	container := v1.Container{
+		Name:    "pgbouncer",
+		Image:   pgbouncerImage,
+		Command: []string{"sh", "-c", poolerScript},
+		Ports:   []v1.ContainerPort{{Name: "pgbouncer", ContainerPort: poolerPort}},
+		Env: append([]v1.EnvVar{
+			{Name: "AUTH_USER", Value: superuser},
+			{Name: "POOLER_HBA", Value: poolerHBARules(hbaAddresses(pg), fipsEnabled(pg))},
+			{Name: "CLIENT_TLS_SSLMODE", Value: clientSSLMode},
+			{Name: "SERVER_HOST", Value: serverHost},
+			{Name: "SERVER_TLS_SSLMODE", Value: serverSSLMode},
+		}, poolSettingsEnv(settings)...),
+	}
+	if pg.Spec.PasswordSecretRef != nil {
+		container.VolumeMounts = append(container.VolumeMounts,
+			v1.VolumeMount{Name: poolerAuthVolume, MountPath: poolerAuthMountPath, ReadOnly: true})
+	} else {
+		// A password in the spec changes with the pod template anyway
+		container.Env = append(container.Env, superuserPasswordEnv(pg, "AUTH_PASSWORD"))
+	}
+	if tlsEnabled(pg) {
+		container.VolumeMounts = append(container.VolumeMounts,
+			v1.VolumeMount{Name: tlsSecretVolume, MountPath: tlsSecretMountPath, ReadOnly: true})
+	}
+	return container
+}
+
+// poolerAuthVolumes mount the superuser password from its Secret, if the
+// spec refers to one
+func poolerAuthVolumes(pg databasev1.Postgresql) []v1.Volume {
+	ref := pg.Spec.PasswordSecretRef
+	if ref == nil {
+		return nil
+	}
+	return []v1.Volume{{Name: poolerAuthVolume, VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+		SecretName: ref.Name,
+		Items:      []v1.KeyToPath{{Key: ref.Key, Path: "password"}},
+	}}}}
+}
+
+// poolSettingsEnv passes pool settings, defaults filled in, to
+// poolerScript
+func poolSettingsEnv(pooler databasev1.PoolSettings) []v1.EnvVar {
+	poolMode := pooler.PoolMode
+	if poolMode == "" {
+		poolMode = databasev1.PoolModeSession
+	}
+	poolSize := int32(defaultPoolSize)
+	if pooler.DefaultPoolSize > 0 {
+		poolSize = pooler.DefaultPoolSize
+	}
+	maxClients := int32(defaultMaxClientConnections)
+	if pooler.MaxClientConnections > 0 {
+		maxClients = pooler.MaxClientConnections
+	}
+	return []v1.EnvVar{
+		{Name: "POOL_MODE", Value: string(poolMode)},
+		{Name: "DEFAULT_POOL_SIZE", Value: strconv.Itoa(int(poolSize))},
+		{Name: "MAX_CLIENT_CONN", Value: strconv.Itoa(int(maxClients))},
+	}
+}
+
+// poolerHBARules renders the pg_hba.conf of PgBouncer. The server sees the
+// connections PgBouncer makes as coming from PgBouncer, so PgBouncer
+// applies the address rules of the server itself: clients connect from the
+// addresses given, with a password. In FIPS mode they need SSL and
+// SCRAM-SHA-256.
+func poolerHBARules(addresses []string, fips bool) string {
+	rule := "host all all %s md5"
+	if fips {
+		rule = "hostssl all all %s scram-sha-256"
+	}
+	rules := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		rules = append(rules, fmt.Sprintf(rule, address))
+	}
+	return strings.Join(rules, "\n") + "\n"
+}
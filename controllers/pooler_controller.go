@@ -0,0 +1,219 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// poolerLabel names the Pooler on its pods, which its Service selects
+const poolerLabel = "db.example.com/pooler"
+
+// PoolerReconciler reconciles a Pooler object
+type PoolerReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=poolers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=poolers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=poolers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;delete
+
+// Reconcile keeps the Deployment and the Service of a Pooler in line with
+// its spec and with the instance it pools connections to. Both are owned by
+// the Pooler and go with it.
+func (r *PoolerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var pooler databasev1.Pooler
+	if err := r.Get(ctx, req.NamespacedName, &pooler); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !pooler.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	var pg databasev1.Postgresql
+	err := r.Get(ctx, types.NamespacedName{Namespace: pooler.Namespace, Name: pooler.Spec.InstanceRef.Name}, &pg)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", errInstanceNotReady, err)
+	} else {
+		err = r.reconcileDeployment(ctx, &pooler, pg)
+		if err == nil {
+			err = r.reconcileService(ctx, &pooler)
+		}
+	}
+	if err != nil {
+		logger.Error(err, "could not reconcile pooler")
+		setReadyCondition(&pooler.Status.Conditions, pooler.Generation, err)
+	} else {
+		setPoolerReadyCondition(&pooler)
+	}
+	if err := r.Status().Update(ctx, &pooler); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+// reconcileDeployment runs PgBouncer in front of the primary of the
+// instance, which it reaches through the -rw Service. Its pods carry the
+// client label of the instance, so the NetworkPolicy of the instance lets
+// them in.
+func (r *PoolerReconciler) reconcileDeployment(ctx context.Context, pooler *databasev1.Pooler, pg databasev1.Postgresql) error {
+	deployment := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: pooler.Name, Namespace: pooler.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &deployment, func() error {
+		if deployment.Labels == nil {
+			deployment.Labels = map[string]string{}
+		}
+		deployment.Labels[managedByLabel] = managedBy
+		deployment.Spec = poolerDeploymentSpec(*pooler, pg)
+		return ctrl.SetControllerReference(pooler, &deployment, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	pooler.Status.ReadyReplicas = deployment.Status.ReadyReplicas
+	return nil
+}
+
+// poolerDeploymentSpec runs the PgBouncer pods of a Pooler. TLS to the
+// server is preferred, and required in FIPS mode.
+func poolerDeploymentSpec(pooler databasev1.Pooler, pg databasev1.Postgresql) appsv1.DeploymentSpec {
+	replicas := int32(1)
+	if pooler.Spec.Replicas != nil {
+		replicas = *pooler.Spec.Replicas
+	}
+	serverSSLMode := "prefer"
+	if fipsEnabled(pg) {
+		serverSSLMode = "require"
+	}
+	container := poolerContainer(pg, getServiceName(pg, "rw"), serverSSLMode, pooler.Spec.PoolSettings)
+	if pooler.Spec.Resources != nil {
+		container.Resources = *pooler.Spec.Resources.DeepCopy()
+	}
+	container.ReadinessProbe = &v1.Probe{ProbeHandler: v1.ProbeHandler{
+		TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(poolerPort)},
+	}}
+
+	automountToken := false
+	podSpec := v1.PodSpec{
+		Containers:                   []v1.Container{container},
+		Volumes:                      poolerAuthVolumes(pg),
+		AutomountServiceAccountToken: &automountToken,
+	}
+	if tlsEnabled(pg) {
+		podSpec.Volumes = append(podSpec.Volumes, v1.Volume{Name: tlsSecretVolume, VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{SecretName: getServerTLSSecretName(pg)},
+		}})
+	}
+
+	selector := map[string]string{poolerLabel: pooler.Name}
+	return appsv1.DeploymentSpec{
+		Replicas: &replicas,
+		Selector: &metav1.LabelSelector{MatchLabels: selector},
+		Template: v1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{poolerLabel: pooler.Name, clientLabel: pg.Name}},
+			Spec:       podSpec,
+		},
+	}
+}
+
+// reconcileService exposes the PgBouncer pods under the name of the Pooler
+func (r *PoolerReconciler) reconcileService(ctx context.Context, pooler *databasev1.Pooler) error {
+	svc := v1.Service{ObjectMeta: metav1.ObjectMeta{Name: pooler.Name, Namespace: pooler.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &svc, func() error {
+		if svc.Labels == nil {
+			svc.Labels = map[string]string{}
+		}
+		svc.Labels[managedByLabel] = managedBy
+		svc.Spec.Selector = map[string]string{poolerLabel: pooler.Name}
+		svc.Spec.Ports = []v1.ServicePort{{
+			Name:       "pgbouncer",
+			Protocol:   v1.ProtocolTCP,
+			Port:       poolerPort,
+			TargetPort: intstr.FromInt(poolerPort),
+		}}
+		return ctrl.SetControllerReference(pooler, &svc, r.Scheme)
+	})
+	return err
+}
+
+// setPoolerReadyCondition reports whether all the PgBouncer pods of a
+// Pooler are ready
+func setPoolerReadyCondition(pooler *databasev1.Pooler) {
+	replicas := int32(1)
+	if pooler.Spec.Replicas != nil {
+		replicas = *pooler.Spec.Replicas
+	}
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason.PoolerAvailable,
+		Message:            fmt.Sprintf("%d of %d pods ready", pooler.Status.ReadyReplicas, replicas),
+		ObservedGeneration: pooler.Generation,
+	}
+	if pooler.Status.ReadyReplicas < replicas {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reason.PoolerProgressing
+	}
+	meta.SetStatusCondition(&pooler.Status.Conditions, condition)
+}
+
+// instancePoolers maps a Postgresql to the Poolers in front of it, whose
+// pods follow its TLS, FIPS and access settings
+func (r *PoolerReconciler) instancePoolers(obj client.Object) []reconcile.Request {
+	var poolers databasev1.PoolerList
+	if err := r.List(context.Background(), &poolers,
+		client.InNamespace(obj.GetNamespace()), client.MatchingFields{instanceRefIndex: obj.GetName()}); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(poolers.Items))
+	for _, pooler := range poolers.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pooler)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PoolerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Pooler{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&v1.Service{}).
+		Watches(&source.Kind{Type: &databasev1.Postgresql{}}, handler.EnqueueRequestsFromMapFunc(r.instancePoolers)).
+		WithOptions(controllerOptions("Pooler")).
+		Complete(finishOnShutdown(r))
+}
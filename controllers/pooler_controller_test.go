@@ -0,0 +1,114 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPoolerDeploymentSpec(t *testing.T) {
+	pg := databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "pg"}}
+	replicas := int32(3)
+	pooler := databasev1.Pooler{ObjectMeta: metav1.ObjectMeta{Name: "pooler"}}
+	pooler.Spec.InstanceRef.Name = "pg"
+	pooler.Spec.Replicas = &replicas
+	pooler.Spec.MaxClientConnections = 500
+
+	spec := poolerDeploymentSpec(pooler, pg)
+	if *spec.Replicas != 3 {
+		t.Errorf("expected 3 replicas, got %d", *spec.Replicas)
+	}
+	if labels := spec.Template.Labels; labels[poolerLabel] != "pooler" || labels[clientLabel] != "pg" || labels[clusterLabel] != "" {
+		t.Errorf("pods should be clients of the instance but not part of it, got %v", labels)
+	}
+	env := map[string]string{}
+	for _, variable := range spec.Template.Spec.Containers[0].Env {
+		env[variable.Name] = variable.Value
+	}
+	if env["SERVER_HOST"] != "pg-rw" || env["SERVER_TLS_SSLMODE"] != "prefer" || env["MAX_CLIENT_CONN"] != "500" {
+		t.Errorf("unexpected environment %v", env)
+	}
+	if volumes := spec.Template.Spec.Volumes; len(volumes) != 1 || volumes[0].Secret.SecretName != getServerTLSSecretName(pg) {
+		t.Errorf("pooler should be given the server key pair, got %+v", volumes)
+	}
+
+	pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "pg-superuser"}, Key: "password"}
+	spec = poolerDeploymentSpec(pooler, pg)
+	if volumes := spec.Template.Spec.Volumes; len(volumes) != 2 || volumes[0].Name != poolerAuthVolume {
+		t.Errorf("pooler should follow the superuser secret, got %+v", volumes)
+	}
+}
+
+func TestPoolerReconcile(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pooler := &databasev1.Pooler{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pooler"}}
+	pooler.Spec.InstanceRef.Name = "pg"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pooler).Build()
+	r := &PoolerReconciler{Client: c, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pooler)}
+
+	if result, err := r.Reconcile(ctx, req); err != nil || result.RequeueAfter != instanceNotReadyRetry {
+		t.Fatalf("a pooler without its instance should wait for it, got %v, %v", result, err)
+	}
+	if err := c.Get(ctx, req.NamespacedName, pooler); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(pooler.Status.Conditions, databasev1.ConditionReady); cond == nil || cond.Reason != reason.InstanceNotReady {
+		t.Errorf("expected the pooler to wait for its instance, got %+v", cond)
+	}
+
+	if err := c.Create(ctx, &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	var deployment appsv1.Deployment
+	if err := c.Get(ctx, req.NamespacedName, &deployment); err != nil {
+		t.Fatalf("expected a deployment: %v", err)
+	}
+	var svc v1.Service
+	if err := c.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatalf("expected a service: %v", err)
+	}
+	if svc.Spec.Selector[poolerLabel] != "pooler" || svc.Spec.Ports[0].Port != poolerPort {
+		t.Errorf("service should expose the pooler's pods, got %+v", svc.Spec)
+	}
+	if err := c.Get(ctx, req.NamespacedName, pooler); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(pooler.Status.Conditions, databasev1.ConditionReady); cond == nil || cond.Reason != reason.PoolerProgressing {
+		t.Errorf("expected the pooler to wait for its pods, got %+v", cond)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestAddPoolerContainer(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name = "pg"
+	pg.Spec.Pooler = &databasev1.InstancePoolerSpec{Mode: databasev1.PoolerSidecar}
+	pg.Spec.Pooler.PoolMode = databasev1.PoolModeTransaction
+	pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "pg-superuser"}, Key: "password"}
+	spec := createPodSpec(pg)
+
+	var pooler *v1.Container
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == "pgbouncer" {
+			pooler = &spec.Containers[i]
+		}
+	}
+	if pooler == nil {
+		t.Fatalf("expected a pgbouncer container, got %+v", spec.Containers)
+	}
+	env := map[string]v1.EnvVar{}
+	for _, variable := range pooler.Env {
+		env[variable.Name] = variable
+	}
+	if env["POOL_MODE"].Value != "transaction" || env["DEFAULT_POOL_SIZE"].Value != "20" || env["MAX_CLIENT_CONN"].Value != "100" {
+		t.Errorf("expected the pool mode given and default sizes, got %v", env)
+	}
+	if _, ok := env["AUTH_PASSWORD"]; ok {
+		t.Errorf("auth user password should be read from the mounted secret, got %+v", env["AUTH_PASSWORD"])
+	}
+	mounts := map[string]string{}
+	for _, mount := range pooler.VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	if mounts[poolerAuthVolume] != poolerAuthMountPath || mounts[tlsSecretVolume] != tlsSecretMountPath {
+		t.Errorf("pooler should be given the superuser password and the server key pair, got %+v", pooler.VolumeMounts)
+	}
+	var authVolume *v1.Volume
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == poolerAuthVolume {
+			authVolume = &spec.Volumes[i]
+		}
+	}
+	if authVolume == nil || authVolume.Secret.SecretName != "pg-superuser" || authVolume.Secret.Items[0].Key != "password" {
+		t.Errorf("auth volume should hold the superuser password, got %+v", authVolume)
+	}
+
+	ports := servicePorts(pg)
+	if len(ports) != 2 || ports[1].Port != poolerPort {
+		t.Errorf("services should expose the pooler, got %+v", ports)
+	}
+	if policy := networkPolicySpec(pg, ""); len(policy.Ingress[0].Ports) != 2 {
+		t.Errorf("network policy should let clients reach the pooler, got %+v", policy.Ingress[0].Ports)
+	}
+}
+
+func TestPoolerHBARules(t *testing.T) {
+	if got := poolerHBARules([]string{"10.0.0.0/8"}, false); got != "host all all 10.0.0.0/8 md5\n" {
+		t.Errorf("unexpected rules %q", got)
+	}
+	if got := poolerHBARules([]string{"all"}, true); got != "hostssl all all all scram-sha-256\n" {
+		t.Errorf("FIPS mode should require SSL and SCRAM, got %q", got)
+	}
+}
+
+func TestPoolerAuthQueryExcludesCertificateRoles(t *testing.T) {
+	if !strings.Contains(poolerScript, "auth_query = "+poolerAuthQuery+"\n") {
+		t.Fatal("expected PgBouncer to look passwords up with poolerAuthQuery")
+	}
+	if !strings.Contains(poolerAuthQuery, "auth_method IN ('cert', 'ldap')") {
+		t.Errorf("expected roles with client certificates or LDAP to be left out, got %s", poolerAuthQuery)
+	}
+}
+
+func TestPoolerAuthQueryExcludesGroupMembers(t *testing.T) {
+	// ldapHBARules writes +group entries, which pg_hba_file_rules lists
+	// with the + kept
+	rules, err := ldapHBARules(&databasev1.LDAPAuthentication{Server: "ldap", BaseDN: "dc=x", Roles: []string{"+analysts"}}, "", false, []string{"all"})
+	if err != nil || len(rules) != 1 || !strings.Contains(rules[0], " +analysts ") {
+		t.Fatalf("expected a rule for the analysts group, got %q, %v", rules, err)
+	}
+	if !strings.Contains(poolerAuthQuery, "unnest(r.user_name) u LEFT JOIN pg_roles g ON u = '+' || g.rolname") ||
+		!strings.Contains(poolerAuthQuery, "pg_has_role(s.usesysid, g.oid, 'member')") {
+		t.Errorf("expected the members of groups admitted by LDAP to be left out, got %s", poolerAuthQuery)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// localOnlyAnnotation marks the pod of an instance listening on localhost
+// only, which the operator reaches through port forwarding
+const localOnlyAnnotation = "db.example.com/local-only"
+
+// portForwarder reaches ports inside pods through the API server, set up
+// by SetupPortForwarding
+var portForwarder *podPortForwarder
+
+//+kubebuilder:rbac:groups="",resources=pods/portforward,verbs=create
+
+// SetupPortForwarding lets the operator reach local-only instances through
+// the API server's port forwarding
+func SetupPortForwarding(config *rest.Config) error {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	portForwarder = &podPortForwarder{config: config, client: clientset.CoreV1().RESTClient()}
+	return nil
+}
+
+// dialPod connects to a port of a pod, over the pod network or, for a
+// local-only instance, through port forwarding
+func dialPod(ctx context.Context, pod *v1.Pod, port int) (net.Conn, error) {
+	if pod.Annotations[localOnlyAnnotation] == "true" {
+		return forwardPod(pod, port)
+	}
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("pod %s has no IP yet", pod.Name)
+	}
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)))
+}
+
+// forwardPod connects to a port of a pod through port forwarding, which
+// arrives on the loopback interface of the pod
+func forwardPod(pod *v1.Pod, port int) (net.Conn, error) {
+	if portForwarder == nil {
+		return nil, errors.New("port forwarding is not set up")
+	}
+	return portForwarder.dial(pod, port)
+}
+
+// podDialer is the dialer of lib/pq for a pod, whatever address it is given.
+// With forward set, it always goes through port forwarding.
+type podDialer struct {
+	pod     *v1.Pod
+	forward bool
+}
+
+func (d podDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d podDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d podDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.forward {
+		return forwardPod(d.pod, postgresPort)
+	}
+	return dialPod(ctx, d.pod, postgresPort)
+}
+
+type podPortForwarder struct {
+	config *rest.Config
+	client rest.Interface
+}
+
+// dial opens a port forwarding session of its own for a single connection
+func (f *podPortForwarder) dial(pod *v1.Pod, port int) (net.Conn, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(f.config)
+	if err != nil {
+		return nil, err
+	}
+	url := f.client.Post().Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+	session, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := http.Header{}
+	headers.Set(v1.StreamType, v1.StreamTypeError)
+	headers.Set(v1.PortHeader, strconv.Itoa(port))
+	headers.Set(v1.PortForwardRequestIDHeader, "0")
+	errorStream, err := session.CreateStream(headers)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	// Only the kubelet writes to the error stream
+	errorStream.Close()
+	headers.Set(v1.StreamType, v1.StreamTypeData)
+	dataStream, err := session.CreateStream(headers)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	conn := &forwardedConn{data: dataStream, session: session, pod: pod.Name}
+	go func() {
+		// Anything on the error stream means the kubelet could not connect
+		if message, _ := io.ReadAll(errorStream); len(message) > 0 {
+			conn.fail(fmt.Errorf("port forwarding to pod %s: %s", pod.Name, message))
+		}
+	}()
+	return conn, nil
+}
+
+// forwardedConn is a connection through a port forwarding session. It has
+// no deadlines; the session goes when the connection is closed.
+type forwardedConn struct {
+	data    io.ReadWriteCloser
+	session io.Closer
+	pod     string
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *forwardedConn) fail(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	c.Close()
+}
+
+func (c *forwardedConn) failure(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+func (c *forwardedConn) Read(b []byte) (int, error) {
+	n, err := c.data.Read(b)
+	if err != nil {
+		err = c.failure(err)
+	}
+	return n, err
+}
+
+func (c *forwardedConn) Write(b []byte) (int, error) {
+	n, err := c.data.Write(b)
+	if err != nil {
+		err = c.failure(err)
+	}
+	return n, err
+}
+
+func (c *forwardedConn) Close() error {
+	c.data.Close()
+	return c.session.Close()
+}
+
+func (c *forwardedConn) LocalAddr() net.Addr                { return forwardedAddr("operator") }
+func (c *forwardedConn) RemoteAddr() net.Addr               { return forwardedAddr(c.pod) }
+func (c *forwardedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *forwardedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *forwardedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type forwardedAddr string
+
+func (a forwardedAddr) Network() string { return "portforward" }
+func (a forwardedAddr) String() string  { return string(a) }
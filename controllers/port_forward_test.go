@@ -0,0 +1,60 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestDialPod(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+
+	pod := &v1.Pod{}
+	pod.Name = "pg"
+	pod.Status.PodIP = "127.0.0.1"
+	conn, err := dialPod(context.Background(), pod, n)
+	if err != nil {
+		t.Fatalf("dialling the pod IP: %v", err)
+	}
+	conn.Close()
+
+	// Local-only pods are only reachable through port forwarding
+	pod.Annotations = map[string]string{localOnlyAnnotation: "true"}
+	if _, err := dialPod(context.Background(), pod, n); err == nil {
+		t.Error("expected local-only pod not to be dialled directly")
+	}
+}
+
+func TestLocalOnlyParameters(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.LocalOnly = true
+	if got := serverParameters(pg)["listen_addresses"]; got != "localhost" {
+		t.Errorf("listen_addresses = %q, want localhost", got)
+	}
+}
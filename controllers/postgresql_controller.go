@@ -18,26 +18,63 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/features"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
 	"time"
 )
 
-const postgresImage = "postgres:14.5"
-
 const postgresqlFinalizer = "database.db.example.com/finalizer"
 
+// How long Postgres gets to shut down cleanly before the pod is killed
+const terminationGracePeriod = 60 * time.Second
+
+// Labels placed on the database pod. Services select on these, so changing
+// them moves the pod in and out of service.
+const (
+	instanceLabel = "db.example.com/instance"
+	roleLabel     = "db.example.com/role"
+)
+
+// clusterLabel names the Postgresql on every one of its pods, and on every
+// other object made for it. Unlike the instance label it is never removed,
+// so scheduling constraints keep matching fenced and draining pods.
+const clusterLabel = "db.example.com/cluster"
+
+const (
+	rolePrimary = "primary"
+	roleFenced  = "fenced"
+)
+
 // PostgresqlReconciler reconciles a Postgresql object
 type PostgresqlReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// DefaultDenyNetwork gives every instance a NetworkPolicy, whether its
+	// access spec asks for one or not
+	DefaultDenyNetwork bool
+
+	// Recorder, when set, gets an event for every problem the owner of an
+	// instance has to act on
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=database.db.example.com,resources=postgresqls,verbs=get;list;watch;create;update;patch;delete
@@ -47,6 +84,11 @@ type PostgresqlReconciler struct {
 // Permissions to access Pods
 
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;create;update;delete;watch
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;create;update;delete;watch
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;create;update;delete;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;create;update;delete;watch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=grants,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -69,7 +111,31 @@ func (r *PostgresqlReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if isPaused(&pg) {
+		return r.observe(ctx, &pg)
+	}
+	setPausedCondition(&pg, false)
+
+	if pg.Spec.External != nil {
+		return r.reconcileExternal(ctx, &pg)
+	}
+
+	if migrated, err := r.migratePassword(ctx, &pg); err != nil {
+		logger.Error(err, "could not move password into a secret")
+		return ctrl.Result{}, err
+	} else if migrated {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
 	var pod v1.Pod
+	fenced := isFenced(&pg)
+	hibernate := r.shouldHibernate(ctx, &pg)
+	maintenance := newMaintenance(&pg, time.Now())
+	upgrading, err := r.reconcileMajorUpgrade(ctx, &pg, maintenance)
+	if err != nil {
+		logger.Error(err, "could not run major version upgrade")
+		return ctrl.Result{}, err
+	}
 
 	// If no corresponding pod exists, create one
 	if err := r.Get(ctx, req.NamespacedName, &pod); err != nil {
@@ -77,56 +143,277 @@ func (r *PostgresqlReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		// A notFound error means we should create a pod
-		podSpec := createPodSpec(pg)
+		switch {
+		// A fenced instance must not come back by itself - it may be a
+		// diverged primary that has to be rewound or reinitialized first.
+		case fenced:
+			logger.Info("instance is fenced, not recreating pod", "name", pg.Name)
+		case hibernate, upgrading, objectDeleting(&pg):
+			// The instance stays down until hibernation is lifted or
+			// pg_upgrade has finished with the data directory
+		default:
+			if err := r.reconcileStorage(ctx, &pg); err != nil {
+				logger.Error(err, "could not create data volume claim")
+				return ctrl.Result{}, err
+			}
+			if err := r.reconcileServiceAccount(ctx, &pg); err != nil {
+				logger.Error(err, "could not create service account")
+				return ctrl.Result{}, err
+			}
+			if err := r.ensureSuperuserSecret(ctx, &pg); err != nil {
+				logger.Error(err, "could not create superuser secret")
+				return ctrl.Result{}, err
+			}
+
+			// A notFound error means we should create a pod
+			podSpec := createPodSpec(pg)
 
-		pod.Spec = podSpec
-		pod.Name = pg.Name
-		pod.Namespace = pg.Namespace
-		if err := r.Create(ctx, &pod); err != nil {
-			logger.Error(err, "could not create pod")
-			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+			pod.Spec = podSpec
+			pod.Name = pg.Name
+			pod.Namespace = pg.Namespace
+			pod.Labels = map[string]string{clusterLabel: pg.Name}
+			if coordinator := citusCoordinator(pg); coordinator != "" {
+				pod.Labels[citusLabel] = coordinator
+			}
+			setPodLabels(&pod, pg)
+			setManagedLabels(&pod, pg)
+			pod.Annotations = map[string]string{versionAnnotation: podVersion(pg)}
+			if restartedAt, ok := pg.Annotations[databasev1.RestartedAtAnnotation]; ok {
+				pod.Annotations[databasev1.RestartedAtAnnotation] = restartedAt
+			}
+			if pg.Spec.LocalOnly {
+				pod.Annotations[localOnlyAnnotation] = "true"
+			}
+			if err := ctrl.SetControllerReference(&pg, &pod, r.Scheme); err != nil {
+				return ctrl.Result{}, err
+			}
+			for key, value := range appArmorAnnotations(pg, pod.Spec) {
+				pod.Annotations[key] = value
+			}
+			if err := r.Create(ctx, &pod); err != nil {
+				logger.Error(err, "could not create pod", "reason", reason.PodCreateFailed)
+				r.warn(&pg, reason.PodCreateFailed, "could not create pod: "+err.Error())
+				return ctrl.Result{}, err
+			}
+		}
+	} else if features.Enabled(features.NodeLossFailover) && r.nodeLost(ctx, &pod) {
+		// The kubelet that would finish a graceful delete is gone with its
+		// node, so the pod is removed outright and recreated elsewhere on
+		// the same volume claim.
+		logger.Info("node of pod is gone, force deleting pod", "name", pg.Name, "node", pod.Spec.NodeName)
+		if err := r.Delete(ctx, &pod, client.GracePeriodSeconds(0)); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "could not delete pod")
+			return ctrl.Result{}, err
+		}
+	} else if hibernate {
+		// Only the pod goes away, the data volume is kept so the instance
+		// can resume where it left off
+		if err := r.stopPod(ctx, &pg, &pod, "hibernating instance"); err != nil {
+			logger.Error(err, "could not stop pod")
+			return ctrl.Result{}, err
+		}
+	} else if upgrading {
+		// pg_upgrade needs the old server shut down
+		if err := r.stopPod(ctx, &pg, &pod, "stopping instance for major version upgrade"); err != nil {
+			logger.Error(err, "could not stop pod")
+			return ctrl.Result{}, err
 		}
+	} else if restartRequested(&pod, pg) && maintenance.permits("restart") {
+		// The pod is recreated with the new annotation on a later pass.
+		// Replicas would go first, followed by a switchover, once the
+		// operator runs any.
+		if err := r.stopPod(ctx, &pg, &pod, "restarting instance"); err != nil {
+			logger.Error(err, "could not stop pod")
+			return ctrl.Result{}, err
+		}
+	} else if relabelled := setPodLabels(&pod, pg); relabelled || metav1.GetControllerOf(&pod) == nil || pod.Labels[managedByLabel] == "" {
+		// Relabelling is what takes a fenced pod out of service. Pods
+		// created before the operator owned them are adopted, so that
+		// their changes are watched, and their volume claims with them.
+		if err := r.reconcileStorage(ctx, &pg); err != nil {
+			logger.Error(err, "could not adopt data volume claims")
+			return ctrl.Result{}, err
+		}
+		setManagedLabels(&pod, pg)
+		if err := ctrl.SetControllerReference(&pg, &pod, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, &pod); err != nil {
+			logger.Error(err, "could not update pod labels")
+			return ctrl.Result{}, err
+		}
+	} else if err := r.reconcileVersion(ctx, &pg, &pod, maintenance); err != nil {
+		logger.Error(err, "could not upgrade pod")
+		return ctrl.Result{}, err
 	}
 
-	// Update the status of the postgresql object based on the status of the Pod
-	switch pod.Status.Phase {
-	case v1.PodPending:
-		pg.Status.Phase = databasev1.PgPending
-	case v1.PodRunning:
-		pg.Status.Phase = databasev1.PgUp
+	// Update the status of the postgresql object based on the status of the Pod.
+	// Steps that fail here are retried with backoff once the rest is done.
+	var stepErrs []error
+	switch {
+	case hibernate:
+		pg.Status.Phase = databasev1.PgHibernated
+	case fenced:
+		pg.Status.Phase = databasev1.PgFenced
+	case upgrading:
+		pg.Status.Phase = databasev1.PgUpgrading
 	default:
-		pg.Status.Phase = databasev1.PgFailed
+		pg.Status.Phase = phaseFromPod(&pod)
+		if pg.Status.Phase == databasev1.PgUp {
+			// Everything after connects with the current password
+			if err := r.reconcileSuperuserPassword(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not apply superuser password: %w", err))
+			}
+			if err := r.reconcileTablespaces(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not create tablespaces: %w", err))
+			}
+			if err := r.reconcileLocaleSupport(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not read locale support: %w", err))
+			}
+			if err := r.runUpgradeHooks(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not run upgrade hooks: %w", err))
+			}
+			if err := r.reconcileSeed(ctx, &pg); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not seed instance: %w", err))
+			}
+			if err := r.reconcilePrivilegeAudit(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not audit privileges: %w", err))
+			}
+			if err := r.reconcileTLSStatus(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not check server certificate: %w", err))
+			}
+			if err := r.reconcileLDAPStatus(ctx, &pg); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not check LDAP server: %w", err))
+			}
+			if err := r.reconcileVault(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not configure Vault: %w", err))
+			}
+			if err := r.reconcileReadOnly(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not change read-only mode: %w", err))
+			}
+			if err := r.reconcilePGVector(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not create the vector extension: %w", err))
+			}
+			if err := r.reconcileCitus(ctx, &pg, &pod); err != nil {
+				stepErrs = append(stepErrs, fmt.Errorf("could not register Citus workers: %w", err))
+			}
+		}
+	}
+	if err := r.reconcileEndpoints(ctx, &pg); err != nil {
+		stepErrs = append(stepErrs, fmt.Errorf("could not read endpoints: %w", err))
+	}
+	maintenance.report(&pg)
+	setDegradedCondition(&pg, &pod)
+	if err := r.updateStatus(ctx, &pg); err != nil {
+		stepErrs = append(stepErrs, fmt.Errorf("could not update status: %w", err))
 	}
-	r.Status().Update(ctx, &pg)
 
 	if result, err := r.registerFinalizer(ctx, &pg); err != nil {
 		logger.Error(err, "Could not ergister finalizer")
 		return result, err
 	}
 
+	if err := r.labelPhase(ctx, &pg); err != nil {
+		stepErrs = append(stepErrs, fmt.Errorf("could not label phase: %w", err))
+	}
+	if err := r.reconcilePromote(ctx, &pg); err != nil {
+		stepErrs = append(stepErrs, fmt.Errorf("could not handle promote request: %w", err))
+	}
+
 	if objectDeleting(&pg) {
-		err := r.deleteExternalResources(ctx, &pg)
+		// Until Postgres has shut down, the deletion of the pod brings the
+		// instance back here
+		return ctrl.Result{}, r.deleteExternalResources(ctx, &pg)
+	}
+
+	if err := r.reconcileServices(ctx, &pg); err != nil {
+		logger.Error(err, "could not reconcile services")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileCitusWorkers(ctx, &pg); err != nil {
+		logger.Error(err, "could not reconcile Citus workers")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileTLS(ctx, &pg); err != nil {
+		logger.Error(err, "could not request server certificate")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileHBA(ctx, &pg); err != nil {
+		logger.Error(err, "could not write pg_hba.conf")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileNetworkPolicy(ctx, &pg); err != nil {
+		logger.Error(err, "could not reconcile network policy")
 		return ctrl.Result{}, err
 	}
 
 	logger.Info("Status ", "name", pod.Name, "pod phase ", pod.Status.Phase, "Pg phase", pg.Status.Phase)
 
-	return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+	if err := utilerrors.NewAggregate(stepErrs); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: nextResync(&pg, &pod, maintenance, time.Now())}, nil
+}
+
+// shouldHibernate combines the Hibernate switch with the hibernation schedule
+// and records the next scheduled transition in the status. An instance
+// without storage never hibernates, as its data would go with the pod.
+func (r *PostgresqlReconciler) shouldHibernate(ctx context.Context, pg *databasev1.Postgresql) bool {
+	hibernate := r.hibernationRequested(ctx, pg)
+	if hibernate && pg.Spec.Storage == nil {
+		r.warn(pg, reason.StopRefused, "not hibernating: the data directory is not on a volume claim and would be lost")
+		return false
+	}
+	return hibernate
 }
 
+// hibernationRequested reports whether the spec asks for the instance to
+// be down now
+func (r *PostgresqlReconciler) hibernationRequested(ctx context.Context, pg *databasev1.Postgresql) bool {
+	pg.Status.NextScheduledTransition = nil
+	if pg.Spec.HibernationSchedule == nil {
+		return pg.Spec.Hibernate
+	}
+
+	scheduled, next, err := scheduledHibernation(pg.Spec.HibernationSchedule, time.Now())
+	if err != nil {
+		log.FromContext(ctx).Error(err, "ignoring hibernation schedule")
+		return pg.Spec.Hibernate
+	}
+	pg.Status.NextScheduledTransition = &metav1.Time{Time: next}
+	return pg.Spec.Hibernate || scheduled
+}
+
+// deleteExternalResources stops the database pod and removes the finalizer
+// once it is gone, so the Postgresql does not disappear while Postgres is
+// still writing its shutdown checkpoint.
 func (r *PostgresqlReconciler) deleteExternalResources(ctx context.Context, pg *databasev1.Postgresql) error {
 	var pod v1.Pod
 	logger := log.FromContext(ctx)
 	if controllerutil.ContainsFinalizer(pg, postgresqlFinalizer) {
 		// our finalizer is present, so lets handle any external dependency
 		if err := r.Get(ctx, GetPodNamespacedName(*pg), &pod); err == nil {
-			var policy metav1.DeletionPropagation
-			policy = metav1.DeletePropagationForeground
-			if err := r.Delete(ctx, &pod, &client.DeleteOptions{PropagationPolicy: &policy}); err != nil {
-				logger.Error(err, "Could not delete pod")
-				return err
+			if pod.DeletionTimestamp.IsZero() {
+				var policy metav1.DeletionPropagation
+				policy = metav1.DeletePropagationForeground
+				if err := r.Delete(ctx, &pod, &client.DeleteOptions{PropagationPolicy: &policy}); err != nil {
+					logger.Error(err, "Could not delete pod")
+					return err
+				}
 			}
+			logger.Info("waiting for postgres to shut down", "name", pod.Name)
+			return nil
+		} else if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	if pg.Spec.Vault != nil {
+		if err := r.removeVaultConnection(ctx, pg); err != nil {
+			logger.Error(err, "could not remove Vault connection")
 		}
 	}
 	// remove our finalizer from the list and update it.
@@ -138,22 +425,144 @@ func createPodSpec(db databasev1.Postgresql) v1.PodSpec {
 	const dbDisk = "postgresql-db-disk"
 	container := v1.Container{
 		Name:  getPodName(db),
-		Image: postgresImage,
+		Image: podImage(db),
+		Args:  postgresArgs(db),
 		Ports: []v1.ContainerPort{{ContainerPort: 5432}},
-		Env: []v1.EnvVar{{Name: "POSTGRES_PASSWORD", Value: db.Spec.Password},
+		Env: []v1.EnvVar{superuserPasswordEnv(db, "POSTGRES_PASSWORD"),
 			{Name: "PGDATA", Value: "/data/pgdata"}},
 		VolumeMounts: []v1.VolumeMount{{Name: dbDisk, MountPath: "/data"}},
+		// A fast shutdown rolls back open transactions and writes a
+		// checkpoint, so the next start does not need crash recovery.
+		// Kubernetes would otherwise send SIGTERM, which Postgres treats
+		// as a smart shutdown that waits for every client to leave.
+		Lifecycle: &v1.Lifecycle{
+			PreStop: &v1.LifecycleHandler{
+				Exec: &v1.ExecAction{Command: []string{
+					"/bin/sh", "-c", `gosu postgres pg_ctl stop -D "$PGDATA" -m fast -w`,
+				}},
+			},
+		},
 	}
 
+	if db.Spec.Resources != nil {
+		container.Resources = *db.Spec.Resources.DeepCopy()
+	}
+
+	tablespaces, tablespaceMounts := tablespaceVolumes(db)
+	container.VolumeMounts = append(container.VolumeMounts, tablespaceMounts...)
+
+	gracePeriod := int64(terminationGracePeriod.Seconds())
+	automountToken := false
 	result := v1.PodSpec{
-		Containers: []v1.Container{container},
-		// TODO - replace with persistentvolume claim
-		// default to emptydir for now
-		Volumes: []v1.Volume{{Name: dbDisk}},
+		Containers:                    []v1.Container{container},
+		ServiceAccountName:            getServiceAccountName(db),
+		AutomountServiceAccountToken:  &automountToken,
+		Volumes:                       append([]v1.Volume{dataVolume(db, dbDisk)}, tablespaces...),
+		TerminationGracePeriodSeconds: &gracePeriod,
+		Affinity:                      podAffinity(db),
+		TopologySpreadConstraints:     topologySpread(db),
+	}
+	if init := tablespaceInitContainer(db, container.Image, tablespaceMounts); init != nil {
+		result.InitContainers = []v1.Container{*init}
 	}
+	if db.Spec.Affinity != nil {
+		result.NodeSelector = db.Spec.Affinity.NodeSelector
+	}
+	addServerConfig(db, &result)
+	if db.Spec.Audit != nil {
+		addAuditContainer(db, &result)
+	}
+	if poolerSidecar(db) {
+		addPoolerContainer(db, &result)
+	}
+	applySeccompProfiles(db, &result)
 	return result
 }
 
+// postgresArgs is the server command line, with Spec.Parameters as -c
+// options in a stable order so the pod spec does not change between passes.
+// The operator's own settings win over parameters of the same name, the
+// ones it recommends give way to them.
+func postgresArgs(db databasev1.Postgresql) []string {
+	parameters := map[string]string{}
+	for name, value := range defaultParameters {
+		parameters[name] = value
+	}
+	if db.Spec.PGVector != nil {
+		for name, value := range pgvectorParameters(db) {
+			parameters[name] = value
+		}
+	}
+	for name, value := range db.Spec.Parameters {
+		parameters[name] = value
+	}
+	for name, value := range serverParameters(db) {
+		parameters[name] = value
+	}
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := []string{"postgres"}
+	for _, name := range names {
+		args = append(args, "-c", name+"="+parameters[name])
+	}
+	return args
+}
+
+// phaseFromPod maps the phase of the database pod onto the instance
+func phaseFromPod(pod *v1.Pod) databasev1.PgPhase {
+	switch pod.Status.Phase {
+	case v1.PodPending:
+		return databasev1.PgPending
+	case v1.PodRunning:
+		return databasev1.PgUp
+	default:
+		return databasev1.PgFailed
+	}
+}
+
+// setPodLabels brings the instance and role labels on the pod in line with
+// the Postgresql and reports whether anything changed. A fenced or draining
+// pod loses its instance label so that no Service selector can match it.
+func setPodLabels(pod *v1.Pod, pg databasev1.Postgresql) bool {
+	want := map[string]string{instanceLabel: pg.Name, roleLabel: rolePrimary}
+	if isFenced(&pg) {
+		want = map[string]string{roleLabel: roleFenced}
+	} else if isDraining(pod) {
+		// Out of every Service, but still the primary
+		want = map[string]string{roleLabel: rolePrimary}
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	changed := false
+	for _, key := range []string{instanceLabel, roleLabel} {
+		value, ok := want[key]
+		if current, exists := pod.Labels[key]; exists == ok && current == value {
+			continue
+		}
+		if ok {
+			pod.Labels[key] = value
+		} else {
+			delete(pod.Labels, key)
+		}
+		changed = true
+	}
+	return changed
+}
+
+// restartRequested reports whether the restart annotation on the Postgresql
+// has moved on from the one the pod was created with.
+func restartRequested(pod *v1.Pod, pg databasev1.Postgresql) bool {
+	return pg.Annotations[databasev1.RestartedAtAnnotation] != pod.Annotations[databasev1.RestartedAtAnnotation]
+}
+
+func isFenced(pg *databasev1.Postgresql) bool {
+	return pg.Annotations[databasev1.FencedAnnotation] == "true"
+}
+
 func getPodName(pg databasev1.Postgresql) string {
 	return pg.Name
 }
@@ -181,13 +590,71 @@ func (r *PostgresqlReconciler) registerFinalizer(ctx context.Context, pg *databa
 	return ctrl.Result{}, err
 }
 
+// warn records a warning event on the instance
+func (r *PostgresqlReconciler) warn(pg *databasev1.Postgresql, why, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(pg, v1.EventTypeWarning, why, message)
+	}
+}
+
+// labelPhase copies the status phase into the PhaseLabel, as CRDs cannot
+// offer status fields to field selectors yet
+func (r *PostgresqlReconciler) labelPhase(ctx context.Context, pg *databasev1.Postgresql) error {
+	if objectDeleting(pg) || pg.Status.Phase == "" || pg.Labels[databasev1.PhaseLabel] == string(pg.Status.Phase) {
+		return nil
+	}
+	patch := client.MergeFrom(pg.DeepCopy())
+	if pg.Labels == nil {
+		pg.Labels = map[string]string{}
+	}
+	pg.Labels[databasev1.PhaseLabel] = string(pg.Status.Phase)
+	return r.Patch(ctx, pg, patch)
+}
+
+// updateStatus writes the status of the instance. When the Postgresql was
+// changed in the meantime, the status is written again onto the latest
+// version, which is left in pg: the status is the operator's alone, so
+// nothing it holds can be newer than what the reconcile found.
+func (r *PostgresqlReconciler) updateStatus(ctx context.Context, pg *databasev1.Postgresql) error {
+	status := pg.Status.DeepCopy()
+	refresh := false
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if refresh {
+			if err := r.Get(ctx, client.ObjectKeyFromObject(pg), pg); err != nil {
+				return err
+			}
+			pg.Status = *status.DeepCopy()
+		}
+		refresh = true
+		return r.Status().Update(ctx, pg)
+	})
+}
+
 func objectDeleting(pg *databasev1.Postgresql) bool {
 	return !pg.ObjectMeta.DeletionTimestamp.IsZero()
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PostgresqlReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// One worker more than asked for is kept for instances that need
+	// recovery, so they do not queue up behind healthy ones
+	options := controllerOptions("Postgresql")
+	steadyWorkers := options.MaxConcurrentReconciles
+	options.MaxConcurrentReconciles++
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&databasev1.Postgresql{}).
-		Complete(r)
+		For(&databasev1.Postgresql{}, postgresqlChanges).
+		Owns(&v1.Pod{}, podChanges).
+		Owns(&v1.Service{}).
+		Owns(&v1.PersistentVolumeClaim{}).
+		Owns(&batchv1.Job{}).
+		Owns(&v1.Secret{}).
+		Owns(&v1.ConfigMap{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&databasev1.Postgresql{}).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(secretToPostgresql)).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.referencingPostgresqls(secretRefIndex))).
+		Watches(&source.Kind{Type: &v1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.referencingPostgresqls(configMapRefIndex))).
+		Watches(&source.Kind{Type: &databasev1.Role{}}, handler.EnqueueRequestsFromMapFunc(roleToPostgresql)).
+		WithOptions(options).
+		Complete(finishOnShutdown(prioritize(r.Client, recordFailures(r.Client, r), steadyWorkers)))
 }
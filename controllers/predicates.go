@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// postgresqlChanges passes the changes of a Postgresql that call for a
+// reconcile: its spec, which moves the generation, and the labels and
+// annotations that request restarts, fencing, pausing and the like. Updates
+// of the status alone, most of them written by the reconcile itself, are
+// dropped.
+var postgresqlChanges = builder.WithPredicates(predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.LabelChangedPredicate{},
+	predicate.AnnotationChangedPredicate{},
+))
+
+// podChanges passes pod events that change what the reconcile reads from
+// the pod. The kubelet updates pods on every probe result and restart
+// count, which would otherwise run a full reconcile each time.
+var podChanges = builder.WithPredicates(predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		old, ok := e.ObjectOld.(*v1.Pod)
+		if !ok {
+			return true
+		}
+		pod, ok := e.ObjectNew.(*v1.Pod)
+		if !ok {
+			return true
+		}
+		return podStateChanged(old, pod)
+	},
+})
+
+// podStateChanged reports whether anything the reconcile looks at differs
+// between two versions of a pod
+func podStateChanged(old, pod *v1.Pod) bool {
+	if old.Status.Phase != pod.Status.Phase ||
+		old.Status.PodIP != pod.Status.PodIP ||
+		old.Spec.NodeName != pod.Spec.NodeName ||
+		old.DeletionTimestamp.IsZero() != pod.DeletionTimestamp.IsZero() ||
+		!reflect.DeepEqual(old.Labels, pod.Labels) ||
+		!reflect.DeepEqual(old.Annotations, pod.Annotations) ||
+		len(old.Status.Conditions) != len(pod.Status.Conditions) ||
+		len(old.Status.ContainerStatuses) != len(pod.Status.ContainerStatuses) {
+		return true
+	}
+	for i, c := range pod.Status.Conditions {
+		was := old.Status.Conditions[i]
+		if was.Type != c.Type || was.Status != c.Status || was.Reason != c.Reason {
+			return true
+		}
+	}
+	for i, c := range pod.Status.ContainerStatuses {
+		was := old.Status.ContainerStatuses[i]
+		if was.Name != c.Name || was.Image != c.Image || was.ImageID != c.ImageID || was.Ready != c.Ready {
+			return true
+		}
+	}
+	return false
+}
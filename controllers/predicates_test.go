@@ -0,0 +1,55 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestPodStateChanged(t *testing.T) {
+	old := &v1.Pod{}
+	old.Status.Phase = v1.PodRunning
+	old.Status.PodIP = "10.0.0.1"
+	old.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+	old.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "postgres", ImageID: "postgres@sha256:a", Ready: true}}
+
+	pod := old.DeepCopy()
+	pod.ResourceVersion = "2"
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	if podStateChanged(old, pod) {
+		t.Error("a restart count alone should not trigger a reconcile")
+	}
+
+	pod.Status.ContainerStatuses[0].ImageID = "postgres@sha256:b"
+	if !podStateChanged(old, pod) {
+		t.Error("a new image should trigger a reconcile")
+	}
+
+	pod = old.DeepCopy()
+	pod.Annotations = map[string]string{drainingSinceAnnotation: "now"}
+	if !podStateChanged(old, pod) {
+		t.Error("an annotation change should trigger a reconcile")
+	}
+
+	pod = old.DeepCopy()
+	pod.Status.Conditions[0].Status = v1.ConditionFalse
+	if !podStateChanged(old, pod) {
+		t.Error("a pod turning unready should trigger a reconcile")
+	}
+}
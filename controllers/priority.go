@@ -0,0 +1,74 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// How often an instance that needs recovery is looked at while nothing
+// in the cluster changes
+const recoveryResync = 30 * time.Second
+
+// How long a reconcile of a healthy instance is put off while all the
+// workers healthy instances may use are busy
+const steadyDeferral = time.Second
+
+// needsRecovery tells instances that are failed, degraded or failing to
+// reconcile from those in a steady state
+func needsRecovery(pg *databasev1.Postgresql) bool {
+	return pg.Status.Phase == databasev1.PgFailed ||
+		meta.IsStatusConditionTrue(pg.Status.Conditions, databasev1.ConditionDegraded) ||
+		failing(types.NamespacedName{Namespace: pg.Namespace, Name: pg.Name})
+}
+
+// failing reports whether the last reconcile of an instance failed
+func failing(name types.NamespacedName) bool {
+	failureStreaksMu.Lock()
+	defer failureStreaksMu.Unlock()
+	return failureStreaks[name] != nil
+}
+
+// prioritize keeps workers free for instances that need recovery: healthy
+// instances are reconciled by at most steadyWorkers at once, and put off
+// for a moment when those are busy. Give the controller more workers than
+// steadyWorkers, or instances needing recovery may wait behind healthy
+// ones all the same.
+func prioritize(c client.Reader, r reconcile.Reconciler, steadyWorkers int) reconcile.Reconciler {
+	steady := make(chan struct{}, steadyWorkers)
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		var pg databasev1.Postgresql
+		if err := c.Get(ctx, req.NamespacedName, &pg); err != nil || needsRecovery(&pg) {
+			return r.Reconcile(ctx, req)
+		}
+		select {
+		case steady <- struct{}{}:
+			defer func() { <-steady }()
+			return r.Reconcile(ctx, req)
+		default:
+			return ctrl.Result{RequeueAfter: steadyDeferral}, nil
+		}
+	})
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestPrioritize(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = databasev1.AddToScheme(scheme)
+	healthy := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "healthy"}}
+	healthy.Status.Phase = databasev1.PgUp
+	other := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "other"}}
+	other.Status.Phase = databasev1.PgUp
+	failed := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "failed"}}
+	failed.Status.Phase = databasev1.PgFailed
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(healthy, other, failed).Build()
+
+	started, release := make(chan string), make(chan struct{})
+	r := prioritize(c, reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		started <- req.Name
+		<-release
+		return ctrl.Result{}, nil
+	}), 1)
+	reconcileAsync := func(name string) {
+		go func() {
+			_, _ = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "db", Name: name}})
+		}()
+	}
+
+	reconcileAsync("healthy")
+	if name := <-started; name != "healthy" {
+		t.Fatalf("expected healthy to be reconciled, got %s", name)
+	}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "db", Name: "other"}})
+	if err != nil || result.RequeueAfter != steadyDeferral {
+		t.Errorf("a second healthy instance should be put off, got %v, %v", result, err)
+	}
+	reconcileAsync("failed")
+	if name := <-started; name != "failed" {
+		t.Errorf("a failed instance should not wait for healthy ones, got %s", name)
+	}
+	close(release)
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// How many unmanaged privileges the status lists
+const maxAuditedPrivileges = 50
+
+// privilegesQuery lists the privileges granted on the current database and
+// on the schemas and tables in it. Privileges of owners, superusers, PUBLIC
+// and the predefined pg_ roles are left out: they are not the kind a Grant
+// manages.
+const privilegesQuery = `SELECT 'DATABASE', '', '', r.rolname, a.privilege_type
+	FROM pg_database d CROSS JOIN LATERAL aclexplode(d.datacl) a JOIN pg_roles r ON r.oid = a.grantee
+	WHERE d.datname = current_database() AND a.grantee <> d.datdba AND NOT r.rolsuper AND r.rolname NOT LIKE 'pg\_%'
+UNION ALL
+SELECT 'SCHEMA', n.nspname, '', r.rolname, a.privilege_type
+	FROM pg_namespace n CROSS JOIN LATERAL aclexplode(n.nspacl) a JOIN pg_roles r ON r.oid = a.grantee
+	WHERE a.grantee <> n.nspowner AND NOT r.rolsuper AND r.rolname NOT LIKE 'pg\_%'
+		AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+UNION ALL
+SELECT 'TABLE', n.nspname, c.relname, r.rolname, a.privilege_type
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		CROSS JOIN LATERAL aclexplode(c.relacl) a JOIN pg_roles r ON r.oid = a.grantee
+	WHERE c.relkind IN ('r', 'v', 'm', 'p', 'f') AND a.grantee <> c.relowner AND NOT r.rolsuper
+		AND r.rolname NOT LIKE 'pg\_%' AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'`
+
+// privilege is a single privilege found on the instance
+type privilege struct {
+	Kind      string // DATABASE, SCHEMA or TABLE
+	Database  string
+	Schema    string
+	Table     string
+	Role      string
+	Privilege string
+}
+
+func (p privilege) String() string {
+	object := p.Database
+	switch p.Kind {
+	case "SCHEMA":
+		object += "." + p.Schema
+	case "TABLE":
+		object += "." + p.Schema + "." + p.Table
+	}
+	return fmt.Sprintf("%s on %s %s to %s", p.Privilege, p.Kind, object, p.Role)
+}
+
+// reconcilePrivilegeAudit compares the privileges granted on the instance
+// with the Grants referring to it, at the audit interval, and reports the
+// ones no Grant declares
+func (r *PostgresqlReconciler) reconcilePrivilegeAudit(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	audit := pg.Spec.PrivilegeAudit
+	if audit == nil {
+		pg.Status.PrivilegeAudit = nil
+		meta.RemoveStatusCondition(&pg.Status.Conditions, databasev1.ConditionUnmanagedPrivileges)
+		return nil
+	}
+	if last := pg.Status.PrivilegeAudit; last != nil && time.Since(last.Time.Time) < audit.Interval.Duration {
+		return nil
+	}
+
+	var grants databasev1.GrantList
+	if err := r.List(ctx, &grants, client.InNamespace(pg.Namespace), client.MatchingFields{instanceRefIndex: pg.Name}); err != nil {
+		return err
+	}
+	var declared []databasev1.GrantTarget
+	for _, grant := range grants.Items {
+		declared = append(declared, grant.Spec.GrantTarget)
+	}
+
+	actual, err := r.instancePrivileges(ctx, pg, pod)
+	if err != nil {
+		return err
+	}
+	unmanaged := unmanagedPrivileges(actual, declared)
+
+	result := databasev1.PrivilegeAudit{Time: metav1.Now(), UnmanagedCount: int32(len(unmanaged))}
+	if len(unmanaged) > maxAuditedPrivileges {
+		unmanaged = unmanaged[:maxAuditedPrivileges]
+	}
+	result.Unmanaged = unmanaged
+	pg.Status.PrivilegeAudit = &result
+
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionUnmanagedPrivileges,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason.AllPrivilegesManaged,
+		Message:            "every privilege is declared by a Grant",
+		ObservedGeneration: pg.Generation,
+	}
+	if result.UnmanagedCount > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reason.UnmanagedPrivilegesFound
+		condition.Message = fmt.Sprintf("%d privileges are not declared by any Grant, see status.privilegeAudit", result.UnmanagedCount)
+	}
+	meta.SetStatusCondition(&pg.Status.Conditions, condition)
+	return nil
+}
+
+// instancePrivileges collects the privileges of every database that takes
+// connections
+func (r *PostgresqlReconciler) instancePrivileges(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) ([]privilege, error) {
+	password, err := superuserPassword(ctx, r.Client, pg)
+	if err != nil {
+		return nil, err
+	}
+	sslmode := operatorSSLMode(*pg)
+	db, err := openPodDB(ctx, pod, superuser, password, "postgres", sslmode)
+	if err != nil {
+		return nil, err
+	}
+	var databases []string
+	err = db.QueryRowContext(ctx, "SELECT coalesce(array_agg(datname), '{}') FROM pg_database WHERE datallowconn AND NOT datistemplate").
+		Scan(pq.Array(&databases))
+	db.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var privileges []privilege
+	for _, dbname := range databases {
+		found, err := databasePrivileges(ctx, pod, password, dbname, sslmode)
+		if err != nil {
+			return nil, err
+		}
+		privileges = append(privileges, found...)
+	}
+	return privileges, nil
+}
+
+func databasePrivileges(ctx context.Context, pod *v1.Pod, password, dbname, sslmode string) ([]privilege, error) {
+	db, err := openPodDB(ctx, pod, superuser, password, dbname, sslmode)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, privilegesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var privileges []privilege
+	for rows.Next() {
+		p := privilege{Database: dbname}
+		if err := rows.Scan(&p.Kind, &p.Schema, &p.Table, &p.Role, &p.Privilege); err != nil {
+			return nil, err
+		}
+		privileges = append(privileges, p)
+	}
+	return privileges, rows.Err()
+}
+
+// unmanagedPrivileges returns the privileges not covered by any of the
+// grants, sorted
+func unmanagedPrivileges(actual []privilege, grants []databasev1.GrantTarget) []string {
+	var unmanaged []string
+	for _, p := range actual {
+		covered := false
+		for _, grant := range grants {
+			if grantCovers(grant, p) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			unmanaged = append(unmanaged, p.String())
+		}
+	}
+	sort.Strings(unmanaged)
+	return unmanaged
+}
+
+// grantCovers reports whether a grant declares a privilege. Default
+// privileges on tables cover the privileges they give tables created later.
+func grantCovers(grant databasev1.GrantTarget, p privilege) bool {
+	if grant.Role != p.Role || grant.Database != p.Database {
+		return false
+	}
+	held := false
+	for _, privilege := range grant.Privileges {
+		if string(privilege) == "ALL" || string(privilege) == p.Privilege {
+			held = true
+		}
+	}
+	if !held {
+		return false
+	}
+
+	schema := grant.Schema
+	if schema == "" && len(grant.Tables) > 0 {
+		schema = "public"
+	}
+	if defaults := grant.DefaultPrivileges; defaults != nil {
+		return p.Kind == "TABLE" && defaults.ObjectType == "TABLES" && (grant.Schema == "" || grant.Schema == p.Schema)
+	}
+	switch p.Kind {
+	case "DATABASE":
+		return grant.Schema == "" && len(grant.Tables) == 0
+	case "SCHEMA":
+		return grant.Schema == p.Schema && len(grant.Tables) == 0
+	default:
+		if schema != p.Schema {
+			return false
+		}
+		for _, table := range grant.Tables {
+			if table == "*" || table == p.Table {
+				return true
+			}
+		}
+		return false
+	}
+}
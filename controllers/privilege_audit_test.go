@@ -0,0 +1,53 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestUnmanagedPrivileges(t *testing.T) {
+	grants := []databasev1.GrantTarget{
+		{Role: "app", Database: "shop", Privileges: []databasev1.Privilege{"CONNECT"}},
+		{Role: "app", Database: "shop", Schema: "sales", Privileges: []databasev1.Privilege{"USAGE"}},
+		{Role: "app", Database: "shop", Schema: "sales", Tables: []string{"*"}, Privileges: []databasev1.Privilege{"SELECT"}},
+		{Role: "app", Database: "shop", Tables: []string{"orders"}, Privileges: []databasev1.Privilege{"ALL"}},
+		{Role: "report", Database: "shop", Schema: "sales", Privileges: []databasev1.Privilege{"SELECT"},
+			DefaultPrivileges: &databasev1.DefaultPrivileges{ForRole: "migrator", ObjectType: "TABLES"}},
+	}
+	actual := []privilege{
+		{Kind: "DATABASE", Database: "shop", Role: "app", Privilege: "CONNECT"},
+		{Kind: "DATABASE", Database: "shop", Role: "app", Privilege: "CREATE"},
+		{Kind: "SCHEMA", Database: "shop", Schema: "sales", Role: "app", Privilege: "USAGE"},
+		{Kind: "TABLE", Database: "shop", Schema: "sales", Table: "items", Role: "app", Privilege: "SELECT"},
+		{Kind: "TABLE", Database: "shop", Schema: "sales", Table: "items", Role: "app", Privilege: "DELETE"},
+		{Kind: "TABLE", Database: "shop", Schema: "public", Table: "orders", Role: "app", Privilege: "UPDATE"},
+		{Kind: "TABLE", Database: "shop", Schema: "sales", Table: "items", Role: "report", Privilege: "SELECT"},
+		{Kind: "TABLE", Database: "other", Schema: "public", Table: "orders", Role: "app", Privilege: "SELECT"},
+	}
+	want := []string{
+		"CREATE on DATABASE shop to app",
+		"DELETE on TABLE shop.sales.items to app",
+		"SELECT on TABLE other.public.orders to app",
+	}
+	if got := unmanagedPrivileges(actual, grants); !reflect.DeepEqual(got, want) {
+		t.Errorf("unmanagedPrivileges = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reconcilePromote answers a request made with the promote annotation and
+// clears it. Naming the pod of the instance, the primary, needs nothing
+// more. A standby is promoted once the old primary is fenced; any other pod
+// is refused, should the webhook not have done so already. A promotion
+// that fails keeps the request, so it is retried.
+func (r *PostgresqlReconciler) reconcilePromote(ctx context.Context, pg *databasev1.Postgresql) error {
+	target, ok := pg.Annotations[databasev1.PromoteAnnotation]
+	if !ok {
+		return nil
+	}
+	if target == getPodName(*pg) {
+		log.FromContext(ctx).Info("promote requested for the primary, nothing to do", "pod", target)
+		if r.Recorder != nil {
+			r.Recorder.Event(pg, v1.EventTypeNormal, reason.PromoteNotNeeded, "pod "+target+" is already the primary")
+		}
+	} else if standby, err := r.standby(ctx, pg, target); err != nil {
+		return err
+	} else if standby == nil {
+		r.warn(pg, reason.PromoteRejected, "cannot promote "+target+": "+pg.Name+" has no standby of that name")
+	} else if err := r.promote(ctx, pg, standby); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(pg.DeepCopy())
+	delete(pg.Annotations, databasev1.PromoteAnnotation)
+	return r.Patch(ctx, pg, patch)
+}
+
+// standby returns the named standby of the instance, or nil if there is
+// none. The operator does not run standbys yet, so they are pods labelled
+// as replicas of the instance by whoever started them. A standby already
+// relabelled as the primary is returned as well, so that a promotion cut
+// short is finished rather than refused.
+func (r *PostgresqlReconciler) standby(ctx context.Context, pg *databasev1.Postgresql, name string) (*v1.Pod, error) {
+	var pod v1.Pod
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: name}, &pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if pod.Labels[instanceLabel] != pg.Name {
+		return nil, nil
+	}
+	if role := pod.Labels[roleLabel]; role != roleReplica && role != rolePrimary {
+		return nil, nil
+	}
+	return &pod, nil
+}
+
+// promote makes a standby the primary. The old primary is fenced and
+// taken out of every Service first, so that two writable primaries are
+// never exposed at once. The fence stays until whoever rewinds or
+// reinitializes the old primary removes it.
+func (r *PostgresqlReconciler) promote(ctx context.Context, pg *databasev1.Postgresql, standby *v1.Pod) error {
+	logger := log.FromContext(ctx)
+	if !isFenced(pg) {
+		logger.Info("fencing the primary before promoting a standby", "name", pg.Name, "pod", standby.Name)
+		patch := client.MergeFrom(pg.DeepCopy())
+		metav1.SetMetaDataAnnotation(&pg.ObjectMeta, databasev1.FencedAnnotation, "true")
+		if err := r.Patch(ctx, pg, patch); err != nil {
+			return err
+		}
+		r.warn(pg, reason.Fenced, "fenced for the promotion of "+standby.Name+
+			"; remove the "+databasev1.FencedAnnotation+" annotation once the old primary has been rewound or reinitialized")
+	}
+	// Relabelling would otherwise wait for the next pass
+	var primary v1.Pod
+	err := r.Get(ctx, GetPodNamespacedName(*pg), &primary)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err == nil && setPodLabels(&primary, *pg) {
+		if err := r.Update(ctx, &primary); err != nil {
+			return err
+		}
+	}
+
+	if DryRun {
+		logger.Info("dry run: would promote standby", "name", pg.Name, "pod", standby.Name)
+	} else if err := r.promoteStandby(ctx, pg, standby); err != nil {
+		return err
+	}
+
+	if standby.Labels[roleLabel] != rolePrimary {
+		standby.Labels[roleLabel] = rolePrimary
+		if err := r.Update(ctx, standby); err != nil {
+			return err
+		}
+	}
+	if r.Recorder != nil {
+		r.Recorder.Event(pg, v1.EventTypeNormal, reason.Promoted, "pod "+standby.Name+" is the primary")
+	}
+	return nil
+}
+
+// promoteStandby ends recovery on the standby and waits for it to take
+// writes. A standby that already did is left alone.
+func (r *PostgresqlReconciler) promoteStandby(ctx context.Context, pg *databasev1.Postgresql, standby *v1.Pod) error {
+	db, err := r.openSuperuserDB(ctx, pg, standby, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var recovering bool
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&recovering); err != nil || !recovering {
+		return err
+	}
+	log.FromContext(ctx).Info("promoting standby", "name", pg.Name, "pod", standby.Name)
+	var promoted bool
+	if err := db.QueryRowContext(ctx, "SELECT pg_promote()").Scan(&promoted); err != nil {
+		return err
+	}
+	if !promoted {
+		return errors.New("standby " + standby.Name + " did not finish promoting in time")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcilePromote(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	for target, want := range map[string]string{"pg": reason.PromoteNotNeeded, "pg-1": reason.PromoteRejected} {
+		pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg",
+			Annotations: map[string]string{databasev1.PromoteAnnotation: target}}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg).Build()
+		recorder := record.NewFakeRecorder(1)
+		r := &PostgresqlReconciler{Client: c, Scheme: scheme, Recorder: recorder}
+
+		if err := r.reconcilePromote(ctx, pg); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(pg), pg); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := pg.Annotations[databasev1.PromoteAnnotation]; ok {
+			t.Errorf("promote %s: expected the request to be cleared", target)
+		}
+		select {
+		case event := <-recorder.Events:
+			if !strings.Contains(event, want) {
+				t.Errorf("promote %s: expected a %s event, got %q", target, want, event)
+			}
+		default:
+			t.Errorf("promote %s: expected the request to be answered with an event", target)
+		}
+	}
+
+	// Without a request there is nothing to patch, not even through a client
+	r := &PostgresqlReconciler{}
+	if err := r.reconcilePromote(ctx, &databasev1.Postgresql{}); err != nil {
+		t.Errorf("expected nothing to do, got %v", err)
+	}
+}
+
+func TestPromoteStandbyFencesPrimary(t *testing.T) {
+	// The standby is not connected to
+	DryRun = true
+	defer func() { DryRun = false }()
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg",
+		Annotations: map[string]string{databasev1.PromoteAnnotation: "pg-1"}}}
+	primary := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg",
+		Labels: map[string]string{instanceLabel: "pg", roleLabel: rolePrimary}}}
+	standby := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg-1",
+		Labels: map[string]string{instanceLabel: "pg", roleLabel: roleReplica}}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg, primary, standby).Build()
+	recorder := record.NewFakeRecorder(2)
+	r := &PostgresqlReconciler{Client: c, Scheme: scheme, Recorder: recorder}
+
+	if err := r.reconcilePromote(ctx, pg); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pg), pg); err != nil {
+		t.Fatal(err)
+	}
+	if !isFenced(pg) {
+		t.Error("expected the old primary to be fenced")
+	}
+	if _, ok := pg.Annotations[databasev1.PromoteAnnotation]; ok {
+		t.Error("expected the request to be cleared")
+	}
+	for name, want := range map[string]map[string]string{
+		"pg":   {roleLabel: roleFenced},
+		"pg-1": {instanceLabel: "pg", roleLabel: rolePrimary},
+	} {
+		var pod v1.Pod
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "db", Name: name}, &pod); err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range want {
+			if pod.Labels[key] != value {
+				t.Errorf("pod %s: expected label %s=%s, got %v", name, key, value, pod.Labels)
+			}
+		}
+		if name == "pg" && pod.Labels[instanceLabel] != "" {
+			t.Errorf("expected the old primary out of every Service, got %v", pod.Labels)
+		}
+	}
+	for _, want := range []string{reason.Fenced, reason.Promoted} {
+		if event := <-recorder.Events; !strings.Contains(event, want) {
+			t.Errorf("expected a %s event, got %q", want, event)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const readOnlyParameter = "default_transaction_read_only"
+
+// Client backends in the middle of a transaction that has written, which is
+// when a transaction gets an ID
+const writingBackends = clientBackends + " AND backend_xid IS NOT NULL"
+
+// reconcileReadOnly switches default_transaction_read_only with ALTER
+// SYSTEM, which a reload applies to every session, and which is allowed in a
+// read-only transaction so the operator can always switch back
+func (r *PostgresqlReconciler) reconcileReadOnly(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if _, ok := pg.Spec.Parameters[readOnlyParameter]; ok {
+		return nil
+	}
+	wanted := pg.Spec.ReadOnly != nil
+	if !wanted && meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionReadOnly) == nil {
+		return nil
+	}
+
+	db, err := r.openSuperuserDB(ctx, pg, pod, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var setting string
+	if err := db.QueryRowContext(ctx, "SHOW "+readOnlyParameter).Scan(&setting); err != nil {
+		return err
+	}
+	if (setting == "on") != wanted && DryRun {
+		// ALTER SYSTEM gets through a read-only session
+		log.FromContext(ctx).Info("dry run: would change read-only mode", "name", pg.Name, "readOnly", wanted)
+	} else if (setting == "on") != wanted {
+		log.FromContext(ctx).Info("changing read-only mode", "name", pg.Name, "readOnly", wanted)
+		if _, err := db.ExecContext(ctx, readOnlyStatement(wanted)); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, "SELECT pg_reload_conf()"); err != nil {
+			return err
+		}
+		if wanted && pg.Spec.ReadOnly.TerminateWriters {
+			if _, err := db.ExecContext(ctx, "SELECT pg_terminate_backend(pid) "+writingBackends); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !wanted {
+		meta.RemoveStatusCondition(&pg.Status.Conditions, databasev1.ConditionReadOnly)
+		return nil
+	}
+	meta.SetStatusCondition(&pg.Status.Conditions, metav1.Condition{
+		Type:               databasev1.ConditionReadOnly,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason.ReadOnlyRequested,
+		Message:            "new transactions are read-only by default",
+		ObservedGeneration: pg.Generation,
+	})
+	return nil
+}
+
+// readOnlyStatement turns the read-only default on, or back to the server's
+// configuration
+func readOnlyStatement(readOnly bool) string {
+	if readOnly {
+		return "ALTER SYSTEM SET " + readOnlyParameter + " = on"
+	}
+	return "ALTER SYSTEM RESET " + readOnlyParameter
+}
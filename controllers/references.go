@@ -0,0 +1,78 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// referencedSecrets are the Secrets the spec of an instance refers to, for
+// credentials of the instance or of services it talks to
+func referencedSecrets(pg databasev1.Postgresql) []string {
+	var names []string
+	if ref := pg.Spec.PasswordSecretRef; ref != nil {
+		names = append(names, ref.Name)
+	}
+	if pg.Spec.Authentication != nil && pg.Spec.Authentication.LDAP != nil {
+		if ref := pg.Spec.Authentication.LDAP.BindPasswordSecretRef; ref != nil {
+			names = append(names, ref.Name)
+		}
+	}
+	if pg.Spec.Vault != nil && pg.Spec.Vault.TokenSecretRef != nil {
+		names = append(names, pg.Spec.Vault.TokenSecretRef.Name)
+	}
+	if pg.Spec.Audit != nil && pg.Spec.Audit.S3 != nil {
+		names = append(names, pg.Spec.Audit.S3.CredentialsSecretRef.Name)
+	}
+	return names
+}
+
+// referencedConfigMaps are the ConfigMaps holding SQL the spec of an
+// instance refers to
+func referencedConfigMaps(pg databasev1.Postgresql) []string {
+	var names []string
+	if pg.Spec.Bootstrap != nil && pg.Spec.Bootstrap.Seed != nil && pg.Spec.Bootstrap.Seed.ConfigMapRef != nil {
+		names = append(names, pg.Spec.Bootstrap.Seed.ConfigMapRef.Name)
+	}
+	for _, hook := range pg.Spec.UpgradeHooks {
+		names = append(names, hook.ConfigMapRef.Name)
+	}
+	return names
+}
+
+// referencingPostgresqls maps a Secret or ConfigMap to the instances
+// referring to it through the index given
+func (r *PostgresqlReconciler) referencingPostgresqls(index string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		var instances databasev1.PostgresqlList
+		if err := r.List(context.Background(), &instances,
+			client.InNamespace(obj.GetNamespace()), client.MatchingFields{index: obj.GetName()}); err != nil {
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(instances.Items))
+		for _, pg := range instances.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pg.Namespace, Name: pg.Name}})
+		}
+		return requests
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestReferencedSecrets(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	if names := referencedSecrets(pg); len(names) != 0 {
+		t.Errorf("expected no Secrets, got %v", names)
+	}
+	pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "pg-superuser"}}
+	pg.Spec.Authentication = &databasev1.AuthenticationSpec{LDAP: &databasev1.LDAPAuthentication{
+		BindPasswordSecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "ldap"}},
+	}}
+	pg.Spec.Audit = &databasev1.AuditSpec{S3: &databasev1.AuditS3Sink{CredentialsSecretRef: v1.LocalObjectReference{Name: "s3"}}}
+	if names, want := referencedSecrets(pg), []string{"pg-superuser", "ldap", "s3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("referencedSecrets = %v, want %v", names, want)
+	}
+}
+
+func TestReferencedConfigMaps(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.Bootstrap = &databasev1.BootstrapSpec{Seed: &databasev1.SeedSpec{
+		ConfigMapRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "seed"}},
+	}}
+	pg.Spec.UpgradeHooks = []databasev1.UpgradeHook{
+		{Name: "analyze", ConfigMapRef: v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "hooks"}}},
+	}
+	if names, want := referencedConfigMaps(pg), []string{"seed", "hooks"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("referencedConfigMaps = %v, want %v", names, want)
+	}
+}
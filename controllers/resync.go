@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// How often an instance is looked at while it waits for something no watch
+// reports: connections draining, the server picking up a renewed
+// certificate or the DNS records of its endpoints
+const transitionRetry = 5 * time.Second
+
+// nextResync is how long until an instance has to be reconciled again
+// without anything changing in the cluster, zero for never: changes of the
+// Postgresql and of the objects it owns or refers to, its pod and Jobs
+// included, trigger a reconcile of their own. That leaves the waits no
+// watch ends and the work due at a certain time, as well as instances that
+// need recovery, which are looked at again every recoveryResync.
+func nextResync(pg *databasev1.Postgresql, pod *v1.Pod, maintenance *maintenance, now time.Time) time.Duration {
+	switch {
+	case isDraining(pod):
+		return transitionRetry
+	case pg.Status.TLS != nil && pg.Status.TLS.SecretNotAfter != nil && pg.Status.Phase == databasev1.PgUp &&
+		!pg.Status.TLS.SecretNotAfter.Equal(pg.Status.TLS.ServedNotAfter):
+		return transitionRetry
+	case endpointsUnresolved(pg):
+		return transitionRetry
+	}
+
+	var due []time.Time
+	if next := pg.Status.NextScheduledTransition; next != nil {
+		due = append(due, next.Time)
+	}
+	if len(maintenance.deferred) > 0 && !maintenance.next.IsZero() {
+		due = append(due, maintenance.next)
+	}
+	if needsRecovery(pg) {
+		due = append(due, now.Add(recoveryResync))
+	}
+	if pg.Status.Phase == databasev1.PgUp {
+		if audit, last := pg.Spec.PrivilegeAudit, pg.Status.PrivilegeAudit; audit != nil && last != nil {
+			due = append(due, last.Time.Add(audit.Interval.Duration))
+		}
+		if checked := pg.Status.LDAPCheckTime; checked != nil {
+			due = append(due, checked.Add(ldapCheckInterval))
+		}
+		if pg.Spec.Citus != nil {
+			due = append(due, now.Add(citusHealthInterval))
+		}
+	}
+	if tls := pg.Status.TLS; tls != nil && tls.SecretNotAfter != nil && tlsEnabled(*pg) &&
+		(pg.Spec.TLS == nil || pg.Spec.TLS.CertManager == nil) {
+		due = append(due, tls.SecretNotAfter.Add(-serverCertificateRenewBefore))
+	}
+
+	var next time.Duration
+	for _, at := range due {
+		wait := at.Sub(now)
+		if wait <= 0 {
+			wait = time.Second
+		}
+		if next == 0 || wait < next {
+			next = wait
+		}
+	}
+	return next
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNextResync(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	pod := &v1.Pod{}
+	pg := &databasev1.Postgresql{}
+	pg.Spec.TLS = &databasev1.TLSSpec{Disabled: true}
+	pg.Status.Phase = databasev1.PgUp
+	if got := nextResync(pg, pod, &maintenance{allowed: true}, now); got != 0 {
+		t.Errorf("steady instance should not be requeued, got %s", got)
+	}
+
+	pod.Annotations = map[string]string{drainingSinceAnnotation: now.Format(time.RFC3339)}
+	if got := nextResync(pg, pod, &maintenance{allowed: true}, now); got != transitionRetry {
+		t.Errorf("draining instance should be looked at again soon, got %s", got)
+	}
+	pod.Annotations = nil
+
+	pg.Status.Phase = databasev1.PgUp
+	pg.Status.NextScheduledTransition = &metav1.Time{Time: now.Add(2 * time.Hour)}
+	pg.Spec.PrivilegeAudit = &databasev1.PrivilegeAuditSpec{Interval: metav1.Duration{Duration: time.Hour}}
+	pg.Status.PrivilegeAudit = &databasev1.PrivilegeAudit{Time: metav1.Time{Time: now.Add(-30 * time.Minute)}}
+	if got := nextResync(pg, pod, &maintenance{allowed: true}, now); got != 30*time.Minute {
+		t.Errorf("expected the next privilege audit in 30m, got %s", got)
+	}
+
+	pg.Status.Phase = databasev1.PgFailed
+	if got := nextResync(pg, pod, &maintenance{allowed: true}, now); got != recoveryResync {
+		t.Errorf("failed instance should be looked at again in %s, got %s", recoveryResync, got)
+	}
+	pg.Status.Phase = databasev1.PgUp
+
+	deferred := &maintenance{next: now.Add(10 * time.Minute), deferred: []string{"restart"}}
+	if got := nextResync(pg, pod, deferred, now); got != 10*time.Minute {
+		t.Errorf("expected the maintenance window to open in 10m, got %s", got)
+	}
+
+	pg.Status.NextScheduledTransition = nil
+	pg.Spec.PrivilegeAudit = nil
+	pg.Spec.Citus = &databasev1.CitusSpec{Workers: 2}
+	if got := nextResync(pg, pod, &maintenance{allowed: true}, now); got != citusHealthInterval {
+		t.Errorf("expected the shards of a coordinator to be checked every %s, got %s", citusHealthInterval, got)
+	}
+}
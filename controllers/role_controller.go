@@ -0,0 +1,625 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Roles are checked again at this interval so changes made with ALTER ROLE
+// outside of the operator are reverted
+const roleResyncInterval = time.Minute
+
+// RoleReconciler reconciles a Role object
+type RoleReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// roleAttributes are the attributes of a role as found in pg_roles
+type roleAttributes struct {
+	Superuser       bool
+	CreateDB        bool
+	CreateRole      bool
+	Login           bool
+	Replication     bool
+	ConnectionLimit int32
+	// ValidUntil is an RFC 3339 time in UTC, or infinity
+	ValidUntil string
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=roles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=roles/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=roles/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
+
+// Reconcile creates the role on its instance and reverts any drift in its
+// attributes, memberships and password
+func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var role databasev1.Role
+	if err := r.Get(ctx, req.NamespacedName, &role); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !role.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&role, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.drop(ctx, &role); err != nil {
+			logger.Error(err, "could not drop role", "name", role.RoleName())
+			setReadyCondition(&role.Status.Conditions, role.Generation, err)
+			if err := r.Status().Update(ctx, &role); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&role, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &role)
+	}
+
+	// The finalizer follows the reclaim policy, so switching back to
+	// Retain releases the role again
+	if drop := role.Spec.ReclaimPolicy == databasev1.ReclaimDelete; drop != controllerutil.ContainsFinalizer(&role, dropFinalizer) {
+		if drop {
+			controllerutil.AddFinalizer(&role, dropFinalizer)
+		} else {
+			controllerutil.RemoveFinalizer(&role, dropFinalizer)
+		}
+		if err := r.Update(ctx, &role); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &role)
+	if err != nil {
+		logger.Error(err, "could not apply role", "name", role.RoleName())
+	}
+	setReadyCondition(&role.Status.Conditions, role.Generation, err)
+	if err := r.Status().Update(ctx, &role); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		return ctrl.Result{RequeueAfter: roleResyncInterval}, nil
+	}
+	return applyResult(err)
+}
+
+func (r *RoleReconciler) apply(ctx context.Context, role *databasev1.Role) error {
+	logger := log.FromContext(ctx)
+	if err := checkRoleName(role); err != nil {
+		return err
+	}
+
+	db, err := connectInstance(ctx, r.Client, role.Namespace, role.Spec.InstanceRef, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := role.RoleName()
+	want := desiredRoleAttributes(role.Spec)
+	var got roleAttributes
+	var drift []string
+	err = db.QueryRowContext(ctx, `SELECT rolsuper, rolcreatedb, rolcreaterole, rolcanlogin, rolreplication, rolconnlimit,
+		CASE WHEN rolvaliduntil IS NULL OR isinfinite(rolvaliduntil) THEN 'infinity'
+			ELSE to_char(rolvaliduntil AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') END
+		FROM pg_roles WHERE rolname = $1`, name).
+		Scan(&got.Superuser, &got.CreateDB, &got.CreateRole, &got.Login, &got.Replication, &got.ConnectionLimit, &got.ValidUntil)
+	switch {
+	case err == sql.ErrNoRows:
+		logger.Info("creating role", "name", name)
+		if _, err := db.ExecContext(ctx, "CREATE ROLE "+pq.QuoteIdentifier(name)+" WITH "+want.options()); err != nil {
+			return err
+		}
+		if role.Status.ObservedGeneration != 0 {
+			drift = append(drift, "role was dropped")
+		}
+		// A new role never has the password applied yet
+		role.Status.PasswordSecretVersion = ""
+		role.Status.PasswordProviderGeneration = 0
+	case err != nil:
+		return err
+	case got != want:
+		logger.Info("correcting role attributes", "name", name)
+		if _, err := db.ExecContext(ctx, "ALTER ROLE "+pq.QuoteIdentifier(name)+" WITH "+want.options()); err != nil {
+			return err
+		}
+		drift = append(drift, got.differences(want)...)
+	}
+
+	if err := r.rotatePassword(ctx, role); err != nil {
+		return err
+	}
+	if err := r.issueClientCertificate(ctx, role); err != nil {
+		return err
+	}
+	if err := r.applyPassword(ctx, db, role); err != nil {
+		return err
+	}
+	if err := r.applyProviderPassword(ctx, role); err != nil {
+		return err
+	}
+	changed, err := applyMemberships(ctx, db, name, role.Spec.InRoles)
+	if err != nil {
+		return err
+	}
+	drift = append(drift, changed...)
+	changed, err = applyRoleSettings(ctx, db, name, role.Spec.Parameters)
+	if err != nil {
+		return err
+	}
+	drift = append(drift, changed...)
+
+	// Differences from a spec that was applied before were made behind the
+	// operator's back; differences from a new spec are just its changes
+	if role.Status.ObservedGeneration != role.Generation {
+		drift = nil
+	}
+	setDriftCondition(role, drift)
+	role.Status.ObservedGeneration = role.Generation
+	return nil
+}
+
+// setDriftCondition reports the out-of-band changes the last check reverted
+func setDriftCondition(role *databasev1.Role, drift []string) {
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionDriftDetected,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason.NoDrift,
+		Message:            "role matches the spec",
+		ObservedGeneration: role.Generation,
+	}
+	if len(drift) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reason.DriftReverted
+		condition.Message = "reverted changes made outside of the operator: " + strings.Join(drift, ", ")
+	}
+	meta.SetStatusCondition(&role.Status.Conditions, condition)
+}
+
+// applyPassword sets the role's password whenever the Secret holding it has
+// changed since it was last applied, or the password is still hashed with
+// MD5 so that setting it again migrates it to SCRAM-SHA-256
+func (r *RoleReconciler) applyPassword(ctx context.Context, db *sql.DB, role *databasev1.Role) error {
+	ref := role.Spec.PasswordSecretRef
+	if ref == nil {
+		return nil
+	}
+	var secret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: ref.Name}, &secret); err != nil {
+		return err
+	}
+	if secret.ResourceVersion == role.Status.PasswordSecretVersion {
+		if md5, err := hasMD5Password(ctx, db, role.RoleName()); err != nil || !md5 {
+			return err
+		}
+	}
+	password, ok := secret.Data[ref.Key]
+	if !ok {
+		return fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+	// A password already in place is only being rehashed
+	if secret.ResourceVersion != role.Status.PasswordSecretVersion {
+		if err := databasev1.OperatorPasswordPolicy.Check(string(password)); err != nil {
+			return fmt.Errorf("secret %s: %w", ref.Name, err)
+		}
+	}
+	log.FromContext(ctx).Info("setting role password", "name", role.RoleName())
+	if err := setRolePassword(ctx, db, role.RoleName(), string(password)); err != nil {
+		return err
+	}
+	role.Status.PasswordSecretVersion = secret.ResourceVersion
+	return nil
+}
+
+// rotatePassword writes a freshly generated password to the password Secret
+// once the rotation interval has passed. A password the Secret holds when
+// rotation is enabled starts the interval instead. applyPassword then sets
+// the new one on the role, as the Secret has changed.
+func (r *RoleReconciler) rotatePassword(ctx context.Context, role *databasev1.Role) error {
+	rotation, ref := role.Spec.PasswordRotation, role.Spec.PasswordSecretRef
+	if rotation == nil {
+		return nil
+	}
+	if ref == nil {
+		return fmt.Errorf("password rotation needs passwordSecretRef")
+	}
+	last := role.Status.LastRotationTime
+	if last != nil && time.Since(last.Time) < rotation.Interval.Duration {
+		return nil
+	}
+
+	var secret v1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: ref.Name}, &secret)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	exists := err == nil && len(secret.Data[ref.Key]) > 0
+
+	// The rotation is recorded before the password changes: should the
+	// status be lost, the password would otherwise be rotated again
+	status := client.MergeFrom(role.DeepCopy())
+	now := metav1.Now()
+	role.Status.LastRotationTime = &now
+	if err := r.Status().Patch(ctx, role, status); err != nil {
+		return err
+	}
+	if last == nil && exists {
+		// Rotation was just enabled: the password of the Secret is kept
+		// for the first interval
+		return nil
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+	secret = v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: role.Namespace}}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		if secret.CreationTimestamp.IsZero() {
+			// A Secret created here goes away with the Role
+			if err := ctrl.SetControllerReference(role, &secret, r.Scheme); err != nil {
+				return err
+			}
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[ref.Key] = []byte(password)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("rotated role password", "name", role.RoleName(), "secret", ref.Name, "operation", op)
+	return nil
+}
+
+// issueClientCertificate keeps a current client certificate for the role in
+// its client certificate Secret, along with the CA to verify the server by
+func (r *RoleReconciler) issueClientCertificate(ctx context.Context, role *databasev1.Role) error {
+	if !role.Spec.ClientCertificate {
+		return nil
+	}
+	var pg databasev1.Postgresql
+	if err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: role.Spec.InstanceRef.Name}, &pg); err != nil {
+		return err
+	}
+	if !tlsEnabled(pg) || (pg.Spec.TLS != nil && pg.Spec.TLS.CertManager != nil) {
+		return fmt.Errorf("client certificates need instance %s to use the operator-managed CA", pg.Name)
+	}
+	var caSecret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: getCASecretName(pg)}, &caSecret); err != nil {
+		return err
+	}
+	ca := keyPair{Certificate: caSecret.Data[v1.TLSCertKey], Key: caSecret.Data[v1.TLSPrivateKeyKey]}
+
+	name, now := role.RoleName(), time.Now()
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: role.Name + "-client-cert", Namespace: role.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		if !clientCertificateCurrent(secret.Data[v1.TLSCertKey], ca.Certificate, name, now) {
+			log.FromContext(ctx).Info("issuing client certificate", "name", name)
+			client, err := newClientCertificate(ca, name, now)
+			if err != nil {
+				return err
+			}
+			secret.Type = v1.SecretTypeTLS
+			secret.Data = map[string][]byte{
+				v1.TLSCertKey:       client.Certificate,
+				v1.TLSPrivateKeyKey: client.Key,
+				"ca.crt":            ca.Certificate,
+				"user":              []byte(name),
+			}
+		}
+		return ctrl.SetControllerReference(role, &secret, r.Scheme)
+	})
+	return err
+}
+
+// generatePassword returns a random password safe to use in connection
+// strings and URIs, long enough for the operator's password policy
+func generatePassword() (string, error) {
+	policy := databasev1.OperatorPasswordPolicy
+	size := 24
+	if n := policy.MinLength*3/4 + 1; n > size {
+		size = n
+	}
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(buf)
+	return password, policy.Check(password)
+}
+
+// applyMemberships grants the role membership in the roles listed and
+// revokes any other membership it holds. It returns the changes made.
+func applyMemberships(ctx context.Context, db *sql.DB, name string, inRoles []string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT g.rolname FROM pg_auth_members m
+		JOIN pg_roles g ON g.oid = m.roleid
+		JOIN pg_roles u ON u.oid = m.member
+		WHERE u.rolname = $1`, name)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]bool{}
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current[group] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var changes []string
+
+	for _, group := range inRoles {
+		if current[group] {
+			delete(current, group)
+			continue
+		}
+		log.FromContext(ctx).Info("granting role membership", "name", name, "role", group)
+		if _, err := db.ExecContext(ctx, "GRANT "+pq.QuoteIdentifier(group)+" TO "+pq.QuoteIdentifier(name)); err != nil {
+			return nil, err
+		}
+		changes = append(changes, "membership in "+group+" revoked")
+	}
+	for group := range current {
+		log.FromContext(ctx).Info("revoking role membership", "name", name, "role", group)
+		if _, err := db.ExecContext(ctx, "REVOKE "+pq.QuoteIdentifier(group)+" FROM "+pq.QuoteIdentifier(name)); err != nil {
+			return nil, err
+		}
+		changes = append(changes, "membership in "+group+" granted")
+	}
+	sort.Strings(changes)
+	return changes, nil
+}
+
+// applyRoleSettings brings the settings of the role, outside of any
+// particular database, in line with the parameters wanted. It returns the
+// settings changed.
+func applyRoleSettings(ctx context.Context, db *sql.DB, name string, want map[string]string) ([]string, error) {
+	var current []string
+	err := db.QueryRowContext(ctx, `SELECT coalesce(s.setconfig, '{}') FROM pg_roles r
+		LEFT JOIN pg_db_role_setting s ON s.setrole = r.oid AND s.setdatabase = 0
+		WHERE r.rolname = $1`, name).Scan(pq.Array(&current))
+	if err != nil {
+		return nil, err
+	}
+
+	statements, changed := roleSettingStatements(name, current, want)
+	for _, statement := range statements {
+		log.FromContext(ctx).Info("changing role setting", "name", name, "statement", statement)
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return nil, err
+		}
+	}
+	return changed, nil
+}
+
+// roleSettingStatements builds the ALTER ROLE SET and RESET statements that
+// turn the current key=value settings into the ones wanted, along with the
+// names of the settings they change
+func roleSettingStatements(name string, current []string, want map[string]string) (statements, changed []string) {
+	have := map[string]string{}
+	for _, setting := range current {
+		if key, value, ok := strings.Cut(setting, "="); ok {
+			have[key] = value
+		}
+	}
+	keys := make([]string, 0, len(want)+len(have))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	for key := range have {
+		if _, ok := want[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	alter := "ALTER ROLE " + pq.QuoteIdentifier(name)
+	for _, key := range keys {
+		value, wanted := want[key]
+		old, ok := have[key]
+		switch {
+		case !wanted:
+			statements = append(statements, alter+" RESET "+pq.QuoteIdentifier(key))
+		case !ok || old != value:
+			statements = append(statements, alter+" SET "+pq.QuoteIdentifier(key)+" TO "+pq.QuoteLiteral(value))
+		default:
+			continue
+		}
+		changed = append(changed, "setting "+key)
+	}
+	return statements, changed
+}
+
+func desiredRoleAttributes(spec databasev1.RoleSpec) roleAttributes {
+	attributes := roleAttributes{
+		Superuser:       spec.Superuser,
+		CreateDB:        spec.CreateDB,
+		CreateRole:      spec.CreateRole,
+		Login:           spec.Login,
+		Replication:     spec.Replication,
+		ConnectionLimit: -1,
+	}
+	if spec.ConnectionLimit != nil {
+		attributes.ConnectionLimit = *spec.ConnectionLimit
+	}
+	attributes.ValidUntil = "infinity"
+	if spec.ValidUntil != nil {
+		attributes.ValidUntil = spec.ValidUntil.UTC().Format(time.RFC3339)
+	}
+	return attributes
+}
+
+// options renders the attributes as options of CREATE or ALTER ROLE
+func (a roleAttributes) options() string {
+	flag := func(set bool, name string) string {
+		if set {
+			return name
+		}
+		return "NO" + name
+	}
+	return strings.Join([]string{
+		flag(a.Superuser, "SUPERUSER"),
+		flag(a.CreateDB, "CREATEDB"),
+		flag(a.CreateRole, "CREATEROLE"),
+		flag(a.Login, "LOGIN"),
+		flag(a.Replication, "REPLICATION"),
+		fmt.Sprintf("CONNECTION LIMIT %d", a.ConnectionLimit),
+		"VALID UNTIL " + pq.QuoteLiteral(a.ValidUntil),
+	}, " ")
+}
+
+// differences names the attributes that differ from want, as found in a
+// role changed outside of the operator
+func (a roleAttributes) differences(want roleAttributes) []string {
+	var names []string
+	check := func(differs bool, name string) {
+		if differs {
+			names = append(names, name)
+		}
+	}
+	check(a.Superuser != want.Superuser, "SUPERUSER")
+	check(a.CreateDB != want.CreateDB, "CREATEDB")
+	check(a.CreateRole != want.CreateRole, "CREATEROLE")
+	check(a.Login != want.Login, "LOGIN")
+	check(a.Replication != want.Replication, "REPLICATION")
+	check(a.ConnectionLimit != want.ConnectionLimit, "CONNECTION LIMIT")
+	check(a.ValidUntil != want.ValidUntil, "VALID UNTIL")
+	return names
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Role{}).
+		Owns(&v1.Secret{}).
+		Owns(&batchv1.Job{}).
+		WithOptions(controllerOptions("Role")).
+		Complete(finishOnShutdown(r))
+}
+
+func (r *RoleReconciler) drop(ctx context.Context, role *databasev1.Role) error {
+	if checkRoleName(role) != nil {
+		// Never applied, so there is nothing of the Role's to drop
+		return nil
+	}
+	db, err := connectInstance(ctx, r.Client, role.Namespace, role.Spec.InstanceRef, "postgres")
+	if errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, role.Namespace, role.Spec.InstanceRef) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if to := role.Spec.ReassignOwnedTo; to != "" {
+		if err := r.reassignOwned(ctx, db, role, to); err != nil {
+			return err
+		}
+	}
+
+	// Fails, and is retried, while the role still owns objects or holds
+	// privileges
+	log.FromContext(ctx).Info("dropping role", "name", role.RoleName())
+	_, err = db.ExecContext(ctx, "DROP ROLE IF EXISTS "+pq.QuoteIdentifier(role.RoleName()))
+	return err
+}
+
+// checkRoleName refuses the superuser the operator connects as: altering
+// its attributes would lock the operator out, and dropping it is fatal
+func checkRoleName(role *databasev1.Role) error {
+	if role.RoleName() == superuser {
+		return fmt.Errorf("role %s is the superuser the operator connects as and cannot be managed by a Role", superuser)
+	}
+	return nil
+}
+
+// reassignOwned hands the objects of the role over to another role and drops
+// its privileges, in each database it could own objects in. Both statements
+// only act on the database connected to, besides shared objects.
+func (r *RoleReconciler) reassignOwned(ctx context.Context, db *sql.DB, role *databasev1.Role, to string) error {
+	name := role.RoleName()
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", name).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	var databases []string
+	if err := db.QueryRowContext(ctx, "SELECT coalesce(array_agg(datname), '{}') FROM pg_database WHERE datallowconn").
+		Scan(pq.Array(&databases)); err != nil {
+		return err
+	}
+	for _, dbname := range databases {
+		log.FromContext(ctx).Info("reassigning owned objects", "name", name, "to", to, "database", dbname)
+		conn, err := connectInstance(ctx, r.Client, role.Namespace, role.Spec.InstanceRef, dbname)
+		if isUndefinedDatabase(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, reassignOwnedStatement(name, to))
+		conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reassignOwnedStatement moves the objects of a role to another and drops
+// what privileges it still holds
+func reassignOwnedStatement(name, to string) string {
+	return "REASSIGN OWNED BY " + pq.QuoteIdentifier(name) + " TO " + pq.QuoteIdentifier(to) +
+		"; DROP OWNED BY " + pq.QuoteIdentifier(name)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRoleOptions(t *testing.T) {
+	limit := int32(10)
+	validUntil := metav1.NewTime(time.Date(2023, 1, 1, 1, 0, 0, 0, time.FixedZone("CET", 3600)))
+	tests := []struct {
+		spec databasev1.RoleSpec
+		want string
+	}{
+		{databasev1.RoleSpec{},
+			"NOSUPERUSER NOCREATEDB NOCREATEROLE NOLOGIN NOREPLICATION CONNECTION LIMIT -1 VALID UNTIL 'infinity'"},
+		{databasev1.RoleSpec{Login: true, CreateDB: true, ConnectionLimit: &limit, ValidUntil: &validUntil},
+			"NOSUPERUSER CREATEDB NOCREATEROLE LOGIN NOREPLICATION CONNECTION LIMIT 10 VALID UNTIL '2023-01-01T00:00:00Z'"},
+	}
+	for _, tt := range tests {
+		if got := desiredRoleAttributes(tt.spec).options(); got != tt.want {
+			t.Errorf("options(%+v) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestRoleDifferences(t *testing.T) {
+	want := desiredRoleAttributes(databasev1.RoleSpec{Login: true})
+	got := want
+	got.Superuser = true
+	got.ValidUntil = "2022-01-01T00:00:00Z"
+	if diff := got.differences(want); !reflect.DeepEqual(diff, []string{"SUPERUSER", "VALID UNTIL"}) {
+		t.Errorf("differences = %v", diff)
+	}
+	if diff := want.differences(want); diff != nil {
+		t.Errorf("differences of equal attributes = %v", diff)
+	}
+}
+
+func TestRoleSettingStatements(t *testing.T) {
+	current := []string{"statement_timeout=30s", "work_mem=64MB", "search_path=app, public"}
+	want := map[string]string{
+		"statement_timeout":                   "60s",
+		"idle_in_transaction_session_timeout": "5min",
+		"search_path":                         "app, public",
+	}
+	statements, changed := roleSettingStatements("app", current, want)
+	wantStatements := []string{
+		`ALTER ROLE "app" SET "idle_in_transaction_session_timeout" TO '5min'`,
+		`ALTER ROLE "app" SET "statement_timeout" TO '60s'`,
+		`ALTER ROLE "app" RESET "work_mem"`,
+	}
+	if !reflect.DeepEqual(statements, wantStatements) {
+		t.Errorf("statements = %q, want %q", statements, wantStatements)
+	}
+	if !reflect.DeepEqual(changed, []string{"setting idle_in_transaction_session_timeout", "setting statement_timeout", "setting work_mem"}) {
+		t.Errorf("changed = %v", changed)
+	}
+}
+
+func TestReassignOwnedStatement(t *testing.T) {
+	want := `REASSIGN OWNED BY "app" TO "app_owner"; DROP OWNED BY "app"`
+	if got := reassignOwnedStatement("app", "app_owner"); got != want {
+		t.Errorf("reassignOwnedStatement = %s, want %s", got, want)
+	}
+}
+
+func TestGeneratePasswordMeetsPolicy(t *testing.T) {
+	policy := databasev1.OperatorPasswordPolicy
+	defer func() { databasev1.OperatorPasswordPolicy = policy }()
+	databasev1.OperatorPasswordPolicy = databasev1.PasswordPolicy{MinLength: 64, MinEntropyBits: 200}
+
+	password, err := generatePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) < 64 {
+		t.Errorf("generated password %q is shorter than the policy's minimum", password)
+	}
+}
+
+func TestRoleRefusesSuperuser(t *testing.T) {
+	role := &databasev1.Role{ObjectMeta: metav1.ObjectMeta{Name: "admin"}}
+	role.Spec.Name = "postgres"
+	r := &RoleReconciler{}
+	if err := r.apply(context.Background(), role); err == nil {
+		t.Error("expected a Role of the superuser to be refused")
+	}
+	if err := r.drop(context.Background(), role); err != nil {
+		t.Errorf("expected the superuser never to be dropped, got %v", err)
+	}
+	role.Spec.Name = ""
+	role.Name = "postgres"
+	if err := checkRoleName(role); err == nil {
+		t.Error("expected a Role named after the superuser to be refused")
+	}
+}
+
+func TestRotatePassword(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	role := &databasev1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "app"}}
+	role.Spec.PasswordSecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "app-password"}, Key: "password"}
+	role.Spec.PasswordRotation = &databasev1.PasswordRotation{Interval: metav1.Duration{Duration: time.Hour}}
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "app-password"},
+		Data: map[string][]byte{"password": []byte("chosen by the user")}}
+	r := &RoleReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(role, secret).Build(), Scheme: scheme}
+	password := func() string {
+		if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "app-password"}, secret); err != nil {
+			t.Fatal(err)
+		}
+		return string(secret.Data["password"])
+	}
+
+	// Enabling rotation starts the clock on the existing password
+	if err := r.rotatePassword(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	if password() != "chosen by the user" {
+		t.Error("expected the existing password to be kept for the first interval")
+	}
+	var stored databasev1.Role
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "app"}, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.LastRotationTime == nil {
+		t.Fatal("expected the start of the interval to be recorded")
+	}
+
+	// Once it has passed, the rotation is recorded and the password changed
+	role.Status.LastRotationTime = &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+	if err := r.rotatePassword(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	if password() == "chosen by the user" {
+		t.Error("expected the password to be rotated")
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "app"}, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(stored.Status.LastRotationTime.Time) > time.Minute {
+		t.Errorf("expected the rotation to be recorded, got %s", stored.Status.LastRotationTime)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// dropFinalizer holds a resource until the object it manages in Postgres
+// has been dropped
+const dropFinalizer = "database.db.example.com/drop"
+
+// SchemaReconciler reconciles a Schema object
+type SchemaReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=schemas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=schemas/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=schemas/finalizers,verbs=update
+
+// Reconcile creates the schema in its database, keeps its owner in line
+// with the spec and drops it on delete when asked to
+func (r *SchemaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var schema databasev1.Schema
+	if err := r.Get(ctx, req.NamespacedName, &schema); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !schema.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&schema, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.drop(ctx, &schema); err != nil {
+			logger.Error(err, "could not drop schema", "name", schema.SchemaName())
+			setReadyCondition(&schema.Status.Conditions, schema.Generation, err)
+			if err := r.Status().Update(ctx, &schema); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&schema, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &schema)
+	}
+
+	// The finalizer follows DropOnDelete, so turning it off releases the
+	// schema again
+	if schema.Spec.DropOnDelete != controllerutil.ContainsFinalizer(&schema, dropFinalizer) {
+		if schema.Spec.DropOnDelete {
+			controllerutil.AddFinalizer(&schema, dropFinalizer)
+		} else {
+			controllerutil.RemoveFinalizer(&schema, dropFinalizer)
+		}
+		if err := r.Update(ctx, &schema); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &schema)
+	if err != nil {
+		logger.Error(err, "could not apply schema", "name", schema.SchemaName())
+	}
+	setReadyCondition(&schema.Status.Conditions, schema.Generation, err)
+	if err := r.Status().Update(ctx, &schema); err != nil {
+		return ctrl.Result{}, err
+	}
+	return applyResult(err)
+}
+
+func (r *SchemaReconciler) apply(ctx context.Context, schema *databasev1.Schema) error {
+	db, err := connectInstance(ctx, r.Client, schema.Namespace, schema.Spec.InstanceRef, schema.Spec.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := schema.SchemaName()
+	var owner string
+	err = db.QueryRowContext(ctx,
+		"SELECT pg_get_userbyid(nspowner) FROM pg_namespace WHERE nspname = $1", name).Scan(&owner)
+	if err == sql.ErrNoRows {
+		log.FromContext(ctx).Info("creating schema", "name", name, "database", schema.Spec.Database)
+		_, err = db.ExecContext(ctx, createSchemaStatement(name, schema.Spec.Owner))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if schema.Spec.Owner != "" && schema.Spec.Owner != owner {
+		log.FromContext(ctx).Info("changing schema owner", "name", name, "from", owner, "to", schema.Spec.Owner)
+		_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER SCHEMA %s OWNER TO %s",
+			pq.QuoteIdentifier(name), pq.QuoteIdentifier(schema.Spec.Owner)))
+	}
+	return err
+}
+
+// createSchemaStatement creates the schema, owned by owner or else by the
+// superuser the operator connects as
+func createSchemaStatement(name, owner string) string {
+	statement := "CREATE SCHEMA " + pq.QuoteIdentifier(name)
+	if owner != "" {
+		statement += " AUTHORIZATION " + pq.QuoteIdentifier(owner)
+	}
+	return statement
+}
+
+func (r *SchemaReconciler) drop(ctx context.Context, schema *databasev1.Schema) error {
+	db, err := connectInstance(ctx, r.Client, schema.Namespace, schema.Spec.InstanceRef, schema.Spec.Database)
+	if isUndefinedDatabase(err) ||
+		errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, schema.Namespace, schema.Spec.InstanceRef) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	log.FromContext(ctx).Info("dropping schema", "name", schema.SchemaName(), "database", schema.Spec.Database)
+	_, err = db.ExecContext(ctx, "DROP SCHEMA IF EXISTS "+pq.QuoteIdentifier(schema.SchemaName())+" CASCADE")
+	return err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SchemaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Schema{}).
+		WithOptions(controllerOptions("Schema")).
+		Complete(finishOnShutdown(r))
+}
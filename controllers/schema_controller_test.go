@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestCreateSchemaStatement(t *testing.T) {
+	if got := createSchemaStatement("sales", ""); got != `CREATE SCHEMA "sales"` {
+		t.Errorf("unexpected statement %s", got)
+	}
+	if got := createSchemaStatement(`odd"name`, "app"); got != `CREATE SCHEMA "odd""name" AUTHORIZATION "app"` {
+		t.Errorf("expected the names to be quoted, got %s", got)
+	}
+}
+
+func TestSchemaInstanceNotReady(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	schema := &databasev1.Schema{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "sales"}}
+	schema.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	schema.Spec.Database = "app"
+	schema.Spec.DropOnDelete = true
+	r := &SchemaReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg, schema).Build(), Scheme: scheme}
+
+	// The instance has no pod yet, so the schema waits for it
+	if err := r.apply(ctx, schema); !errors.Is(err, errInstanceNotReady) {
+		t.Errorf("expected the instance not to be ready, got %v", err)
+	}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "db", Name: "sales"}})
+	if err != nil || result.RequeueAfter != instanceNotReadyRetry {
+		t.Errorf("expected a retry once the instance is ready, got %+v, %v", result, err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "sales"}, schema); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(schema, dropFinalizer) {
+		t.Error("expected DropOnDelete to hold the schema with a finalizer")
+	}
+
+	// Dropping waits for an instance that still exists, but not for one
+	// that is gone
+	if err := r.drop(ctx, schema); !errors.Is(err, errInstanceNotReady) {
+		t.Errorf("expected the drop to wait for the instance, got %v", err)
+	}
+	if err := r.Delete(ctx, pg); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.drop(ctx, schema); err != nil {
+		t.Errorf("expected nothing to drop without an instance, got %v", err)
+	}
+}
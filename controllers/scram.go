@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/lib/pq"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Iterations of the SCRAM-SHA-256 verifiers the operator computes, the same
+// as Postgres uses by default
+const scramIterations = 4096
+
+// scramVerifier hashes a password into the SCRAM-SHA-256 verifier Postgres
+// stores in pg_authid. Setting a role's password to a verifier rather than
+// the password itself keeps the password out of the server's logs and
+// makes the hash independent of the server's password_encryption. Unlike
+// Postgres, the password is not SASLprep normalized, which only makes a
+// difference for non-ASCII passwords.
+func scramVerifier(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return scramVerifierWithSalt(password, salt, scramIterations), nil
+}
+
+func scramVerifierWithSalt(password string, salt []byte, iterations int) string {
+	salted := scramHi([]byte(password), salt, iterations)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+	encode := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("SCRAM-SHA-256$%d:%s$%s:%s", iterations, encode(salt), encode(storedKey[:]), encode(serverKey))
+}
+
+// scramHi is the Hi function of RFC 5802, PBKDF2 with HMAC-SHA-256 for a
+// single block
+func scramHi(password, salt []byte, iterations int) []byte {
+	block := make([]byte, 4)
+	binary.BigEndian.PutUint32(block, 1)
+	u := hmacSHA256(password, append(append([]byte{}, salt...), block...))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSHA256(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// setRolePassword sets the password of a role as a SCRAM-SHA-256 verifier
+func setRolePassword(ctx context.Context, db *sql.DB, role, password string) error {
+	verifier, err := scramVerifier(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "ALTER ROLE "+pq.QuoteIdentifier(role)+" WITH PASSWORD "+pq.QuoteLiteral(verifier))
+	return err
+}
+
+// hasMD5Password reports whether the password of a role is still stored as
+// an MD5 hash, which setting it again turns into a SCRAM verifier
+func hasMD5Password(ctx context.Context, db *sql.DB, role string) (bool, error) {
+	var md5 bool
+	err := db.QueryRowContext(ctx, "SELECT coalesce(rolpassword LIKE 'md5%', false) FROM pg_authid WHERE rolname = $1", role).Scan(&md5)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if md5 {
+		log.FromContext(ctx).Info("role password is an MD5 hash, rehashing as SCRAM-SHA-256", "name", role)
+	}
+	return md5, err
+}
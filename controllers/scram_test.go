@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestScramVerifier(t *testing.T) {
+	// The exchange of RFC 7677: the server signature must follow from the
+	// server key of the verifier
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	verifier := scramVerifierWithSalt("pencil", salt, 4096)
+	if !strings.HasPrefix(verifier, "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$") {
+		t.Fatalf("unexpected verifier %q", verifier)
+	}
+	serverKey, err := base64.StdEncoding.DecodeString(verifier[strings.LastIndex(verifier, ":")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMessage := "n=user,r=rOprNGfwEbeRWgbNEkqO," +
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096," +
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	signature := base64.StdEncoding.EncodeToString(hmacSHA256(serverKey, []byte(authMessage)))
+	if want := "6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="; signature != want {
+		t.Errorf("server signature = %s, want %s", signature, want)
+	}
+}
+
+func TestScramVerifierSalted(t *testing.T) {
+	first, err := scramVerifier("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := scramVerifier("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := regexp.MustCompile(`^SCRAM-SHA-256\$4096:[A-Za-z0-9+/=]{24}\$[A-Za-z0-9+/=]{44}:[A-Za-z0-9+/=]{44}$`)
+	if !format.MatchString(first) {
+		t.Errorf("verifier %q is not in the pg_authid format", first)
+	}
+	if first == second {
+		t.Error("verifiers of the same password should use different salts")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// secretsStoreDriver is the CSI driver of the Secrets Store project
+const secretsStoreDriver = "secrets-store.csi.k8s.io"
+
+// generationAnnotation records on a password Job the generation of the
+// Role it sets the password for
+const generationAnnotation = "db.example.com/role-generation"
+
+// providerPasswordScript sets the password mounted at PASSWORD_FILE. psql
+// interpolates it, quoted, into the statement, so it never shows up in the
+// Job's spec or its logs.
+const providerPasswordScript = `set -euo pipefail
+psql -v ON_ERROR_STOP=1 -v role="$ROLE_NAME" -v password="$(cat "$PASSWORD_FILE")" -d postgres <<'SQL'
+ALTER ROLE :"role" WITH PASSWORD :'password';
+SQL
+`
+
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;create;delete;watch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=postgresqls,verbs=get;list;watch
+
+// applyProviderPassword sets a password taken from the Secrets Store CSI
+// driver through a Job, once per generation of the spec. The operator never
+// sees the password.
+func (r *RoleReconciler) applyProviderPassword(ctx context.Context, role *databasev1.Role) error {
+	provider := role.Spec.PasswordSecretProvider
+	if provider == nil {
+		return nil
+	}
+	if role.Spec.PasswordSecretRef != nil {
+		return fmt.Errorf("passwordSecretRef and passwordSecretProvider cannot both be set")
+	}
+	if role.Status.PasswordProviderGeneration == role.Generation {
+		return nil
+	}
+
+	generation := strconv.FormatInt(role.Generation, 10)
+	var job batchv1.Job
+	err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: getPasswordJobName(role)}, &job)
+	switch {
+	case client.IgnoreNotFound(err) != nil:
+		return err
+	case err == nil && job.Annotations[generationAnnotation] != generation:
+		// Left over from an earlier spec
+		policy := metav1.DeletePropagationBackground
+		return client.IgnoreNotFound(r.Delete(ctx, &job, &client.DeleteOptions{PropagationPolicy: &policy}))
+	case err == nil && job.Status.Succeeded > 0:
+		role.Status.PasswordProviderGeneration = role.Generation
+		policy := metav1.DeletePropagationBackground
+		return client.IgnoreNotFound(r.Delete(ctx, &job, &client.DeleteOptions{PropagationPolicy: &policy}))
+	case err == nil && job.Status.Failed > 0:
+		// The job is kept so its logs can be inspected
+		return fmt.Errorf("setting the password failed; see the logs of job %s", job.Name)
+	case err == nil:
+		// Still running, the Job is watched
+		return nil
+	}
+
+	var pg databasev1.Postgresql
+	if err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: role.Spec.InstanceRef.Name}, &pg); err != nil {
+		return err
+	}
+	job = createPasswordJob(role, pg)
+	setManagedLabels(&job, pg)
+	if err := ctrl.SetControllerReference(role, &job, r.Scheme); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("setting role password from secret provider", "name", role.RoleName(), "job", job.Name)
+	return r.Create(ctx, &job)
+}
+
+func createPasswordJob(role *databasev1.Role, pg databasev1.Postgresql) batchv1.Job {
+	const volume, mountPath = "password", "/mnt/secrets-store"
+	provider := role.Spec.PasswordSecretProvider
+	readOnly := true
+	var backoffLimit int32 = 2
+	return batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        getPasswordJobName(role),
+			Namespace:   role.Namespace,
+			Labels:      map[string]string{instanceLabel: pg.Name},
+			Annotations: map[string]string{generationAnnotation: strconv.FormatInt(role.Generation, 10)},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{clientLabel: pg.Name}},
+				Spec: v1.PodSpec{
+					RestartPolicy:      v1.RestartPolicyNever,
+					ServiceAccountName: provider.ServiceAccountName,
+					Containers: []v1.Container{{
+						Name:    "password",
+						Image:   podImage(pg),
+						Command: []string{"bash", "-c", providerPasswordScript},
+						Env: []v1.EnvVar{
+							{Name: "ROLE_NAME", Value: role.RoleName()},
+							{Name: "PASSWORD_FILE", Value: mountPath + "/" + provider.ObjectName},
+							{Name: "PGHOST", Value: getServiceName(pg, "rw")},
+							{Name: "PGUSER", Value: superuser},
+							superuserPasswordEnv(pg, "PGPASSWORD"),
+						},
+						VolumeMounts: []v1.VolumeMount{{Name: volume, MountPath: mountPath, ReadOnly: true}},
+					}},
+					Volumes: []v1.Volume{{
+						Name: volume,
+						VolumeSource: v1.VolumeSource{CSI: &v1.CSIVolumeSource{
+							Driver:           secretsStoreDriver,
+							ReadOnly:         &readOnly,
+							VolumeAttributes: map[string]string{"secretProviderClass": provider.SecretProviderClass},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func getPasswordJobName(role *databasev1.Role) string {
+	return role.Name + "-password"
+}
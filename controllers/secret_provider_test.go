@@ -0,0 +1,53 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestCreatePasswordJob(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name, pg.Namespace = "pg", "default"
+	role := &databasev1.Role{}
+	role.Name, role.Namespace, role.Generation = "app", "default", 3
+	role.Spec.PasswordSecretProvider = &databasev1.SecretProviderPassword{
+		SecretProviderClass: "app-vault", ObjectName: "password", ServiceAccountName: "app",
+	}
+
+	job := createPasswordJob(role, pg)
+	if job.Name != "app-password" || job.Annotations[generationAnnotation] != "3" {
+		t.Errorf("unexpected job %s with annotations %v", job.Name, job.Annotations)
+	}
+	spec := job.Spec.Template.Spec
+	if spec.ServiceAccountName != "app" {
+		t.Errorf("service account = %q, want app", spec.ServiceAccountName)
+	}
+	csi := spec.Volumes[0].CSI
+	if csi == nil || csi.Driver != secretsStoreDriver || csi.VolumeAttributes["secretProviderClass"] != "app-vault" {
+		t.Errorf("password volume should come from the secret provider, got %+v", spec.Volumes[0])
+	}
+	env := map[string]string{}
+	for _, e := range spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["PASSWORD_FILE"] != "/mnt/secrets-store/password" || env["ROLE_NAME"] != "app" || env["PGHOST"] != "pg-rw" {
+		t.Errorf("unexpected environment %v", env)
+	}
+}
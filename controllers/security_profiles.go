@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// appArmorAnnotationPrefix is followed by the name of the container the
+// AppArmor profile applies to
+const appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+// containerProfile is the security profile of a container of the pod, the
+// first of its containers being the server
+func containerProfile(pg databasev1.Postgresql, server bool) *databasev1.ContainerSecurityProfile {
+	if pg.Spec.SecurityProfiles == nil {
+		return nil
+	}
+	if server {
+		return pg.Spec.SecurityProfiles.Postgres
+	}
+	return pg.Spec.SecurityProfiles.Sidecars
+}
+
+// applySeccompProfiles sets the seccomp profile of every container of the
+// pod that has one
+func applySeccompProfiles(pg databasev1.Postgresql, spec *v1.PodSpec) {
+	apply := func(container *v1.Container, server bool) {
+		profile := containerProfile(pg, server)
+		if profile == nil || profile.Seccomp == nil {
+			return
+		}
+		if container.SecurityContext == nil {
+			container.SecurityContext = &v1.SecurityContext{}
+		}
+		container.SecurityContext.SeccompProfile = profile.Seccomp.DeepCopy()
+	}
+	for i := range spec.InitContainers {
+		apply(&spec.InitContainers[i], false)
+	}
+	for i := range spec.Containers {
+		apply(&spec.Containers[i], i == 0)
+	}
+}
+
+// appArmorAnnotations are the pod annotations giving its containers their
+// AppArmor profile, which the pod spec has no field for yet
+func appArmorAnnotations(pg databasev1.Postgresql, spec v1.PodSpec) map[string]string {
+	annotations := map[string]string{}
+	add := func(container v1.Container, server bool) {
+		if profile := containerProfile(pg, server); profile != nil && profile.AppArmor != "" {
+			annotations[appArmorAnnotationPrefix+container.Name] = profile.AppArmor
+		}
+	}
+	for _, container := range spec.InitContainers {
+		add(container, false)
+	}
+	for i, container := range spec.Containers {
+		add(container, i == 0)
+	}
+	return annotations
+}
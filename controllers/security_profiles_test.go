@@ -0,0 +1,53 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestSecurityProfiles(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name = "pg"
+	pg.Spec.Audit = &databasev1.AuditSpec{}
+	pg.Spec.SecurityProfiles = &databasev1.SecurityProfiles{
+		Postgres: &databasev1.ContainerSecurityProfile{
+			Seccomp:  &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+			AppArmor: "localhost/postgres",
+		},
+		Sidecars: &databasev1.ContainerSecurityProfile{AppArmor: "runtime/default"},
+	}
+
+	spec := createPodSpec(pg)
+	if context := spec.Containers[0].SecurityContext; context == nil || context.SeccompProfile.Type != v1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("server should get its seccomp profile, got %+v", context)
+	}
+	if spec.Containers[1].SecurityContext != nil {
+		t.Errorf("sidecar has no seccomp profile, got %+v", spec.Containers[1].SecurityContext)
+	}
+
+	annotations := appArmorAnnotations(pg, spec)
+	if annotations[appArmorAnnotationPrefix+"pg"] != "localhost/postgres" {
+		t.Errorf("server should get its AppArmor profile, got %v", annotations)
+	}
+	if annotations[appArmorAnnotationPrefix+"audit"] != "runtime/default" {
+		t.Errorf("sidecar should get its AppArmor profile, got %v", annotations)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// seedScript creates the database when missing and loads the dump into it,
+// stopping at the first error
+const seedScript = `set -eo pipefail
+if ! echo "SELECT 1 FROM pg_database WHERE datname = :'db'" | psql -v db="$SEED_DATABASE" -tA -d postgres | grep -q 1; then
+  createdb "$SEED_DATABASE"
+fi
+case "$SEED_FILE" in
+  *.gz) gunzip -c "$SEED_FILE" ;;
+  *) cat "$SEED_FILE" ;;
+esac | psql -v ON_ERROR_STOP=1 -d "$SEED_DATABASE" -f -
+`
+
+// reconcileSeed loads the bootstrap seed once the instance is up, through a
+// Job running psql against the primary's Service
+func (r *PostgresqlReconciler) reconcileSeed(ctx context.Context, pg *databasev1.Postgresql) error {
+	if pg.Spec.Bootstrap == nil || pg.Spec.Bootstrap.Seed == nil {
+		return nil
+	}
+	if cond := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionSeeded); cond != nil &&
+		(cond.Status == metav1.ConditionTrue || cond.Reason == reason.SeedFailed) {
+		return nil
+	}
+
+	var job batchv1.Job
+	if err := r.Get(ctx, getSeedJobNamespacedName(*pg), &job); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		job, err := createSeedJob(*pg)
+		if err != nil {
+			setSeededCondition(pg, metav1.ConditionFalse, reason.SeedFailed, err.Error())
+			return nil
+		}
+		setManagedLabels(&job, *pg)
+		if err := ctrl.SetControllerReference(pg, &job, r.Scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("seeding instance", "name", pg.Name, "job", job.Name)
+		if err := r.Create(ctx, &job); err != nil {
+			return err
+		}
+		setSeededCondition(pg, metav1.ConditionFalse, reason.SeedRunning, "loading the seed in job "+job.Name)
+		return nil
+	}
+
+	if err := r.adopt(ctx, pg, &job); err != nil {
+		return err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		// Recorded before the job goes, or a restart of the operator in
+		// between would load the seed a second time
+		setSeededCondition(pg, metav1.ConditionTrue, reason.SeedLoaded, "seed loaded")
+		if err := r.updateStatus(ctx, pg); err != nil {
+			return err
+		}
+		policy := metav1.DeletePropagationBackground
+		return client.IgnoreNotFound(r.Delete(ctx, &job, &client.DeleteOptions{PropagationPolicy: &policy}))
+	case job.Status.Failed > 0:
+		// The job is kept so its logs can be inspected
+		setSeededCondition(pg, metav1.ConditionFalse, reason.SeedFailed,
+			"loading the seed failed; see the logs of job "+job.Name)
+	}
+	return nil
+}
+
+func createSeedJob(pg databasev1.Postgresql) (batchv1.Job, error) {
+	const seedVolume = "seed"
+	seed := pg.Spec.Bootstrap.Seed
+	database := seed.Database
+	if database == "" {
+		database = "postgres"
+	}
+
+	var backoffLimit int32
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getSeedJobName(pg),
+			Namespace: pg.Namespace,
+			Labels:    map[string]string{instanceLabel: pg.Name},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{clientLabel: pg.Name}},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Name:    "seed",
+						Image:   podImage(pg),
+						Command: []string{"bash", "-c", seedScript},
+						Env: []v1.EnvVar{
+							{Name: "SEED_DATABASE", Value: database},
+							{Name: "PGHOST", Value: getServiceName(pg, "rw")},
+							{Name: "PGUSER", Value: superuser},
+							superuserPasswordEnv(pg, "PGPASSWORD"),
+						},
+						VolumeMounts: []v1.VolumeMount{{Name: seedVolume, MountPath: "/seed"}},
+					}},
+				},
+			},
+		},
+	}
+
+	podSpec := &job.Spec.Template.Spec
+	var file string
+	switch {
+	case seed.ConfigMapRef != nil && seed.URL != "":
+		return job, fmt.Errorf("seed takes either configMapRef or url, not both")
+	case seed.ConfigMapRef != nil:
+		file = "/seed/" + seed.ConfigMapRef.Key
+		podSpec.Volumes = []v1.Volume{{
+			Name: seedVolume,
+			VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: seed.ConfigMapRef.LocalObjectReference,
+				Items:                []v1.KeyToPath{{Key: seed.ConfigMapRef.Key, Path: seed.ConfigMapRef.Key}},
+			}},
+		}}
+	case seed.URL != "":
+		u, err := url.Parse(seed.URL)
+		if err != nil {
+			return job, fmt.Errorf("invalid seed url: %w", err)
+		}
+		file = "/seed/dump.sql"
+		if path.Ext(u.Path) == ".gz" {
+			file += ".gz"
+		}
+		podSpec.Volumes = []v1.Volume{{
+			Name:         seedVolume,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		}}
+		podSpec.InitContainers = []v1.Container{{
+			Name:         "fetch",
+			Image:        operatorconfig.Get().CurlImage,
+			Command:      []string{"curl", "-fsSL", "-o", file, seed.URL},
+			VolumeMounts: []v1.VolumeMount{{Name: seedVolume, MountPath: "/seed"}},
+		}}
+	default:
+		return job, fmt.Errorf("seed needs configMapRef or url")
+	}
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, v1.EnvVar{Name: "SEED_FILE", Value: file})
+	return job, nil
+}
+
+func setSeededCondition(pg *databasev1.Postgresql, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&pg.Status.Conditions, metav1.Condition{
+		Type:               databasev1.ConditionSeeded,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: pg.Generation,
+	})
+}
+
+func getSeedJobName(pg databasev1.Postgresql) string {
+	return pg.Name + "-seed"
+}
+
+func getSeedJobNamespacedName(pg databasev1.Postgresql) types.NamespacedName {
+	return types.NamespacedName{
+		Name:      getSeedJobName(pg),
+		Namespace: pg.Namespace,
+	}
+}
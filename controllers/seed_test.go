@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestCreateSeedJob(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Name = "pg"
+	pg.Spec.Bootstrap = &databasev1.BootstrapSpec{Seed: &databasev1.SeedSpec{URL: "https://bucket.s3.amazonaws.com/demo.sql.gz?X-Amz-Signature=abc"}}
+	job, err := createSeedJob(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.InitContainers) != 1 || spec.Volumes[0].EmptyDir == nil {
+		t.Fatalf("url seed should be fetched into an emptyDir, got %+v", spec)
+	}
+	if image := spec.InitContainers[0].Image; image != catalog.CurlImage {
+		t.Errorf("expected the seed to be fetched with the pinned curl image, got %s", image)
+	}
+	if env := seedEnv(spec.Containers[0], "SEED_FILE"); env != "/seed/dump.sql.gz" {
+		t.Errorf("SEED_FILE = %q", env)
+	}
+	if env := seedEnv(spec.Containers[0], "SEED_DATABASE"); env != "postgres" {
+		t.Errorf("SEED_DATABASE = %q", env)
+	}
+
+	pg.Spec.Bootstrap.Seed = &databasev1.SeedSpec{Database: "demo", ConfigMapRef: &v1.ConfigMapKeySelector{
+		LocalObjectReference: v1.LocalObjectReference{Name: "demo-data"}, Key: "demo.sql"}}
+	job, err = createSeedJob(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec = job.Spec.Template.Spec
+	if len(spec.InitContainers) != 0 || spec.Volumes[0].ConfigMap == nil {
+		t.Fatalf("configmap seed should be mounted, got %+v", spec)
+	}
+	if env := seedEnv(spec.Containers[0], "SEED_FILE"); env != "/seed/demo.sql" {
+		t.Errorf("SEED_FILE = %q", env)
+	}
+
+	pg.Spec.Bootstrap.Seed = &databasev1.SeedSpec{}
+	if _, err := createSeedJob(pg); err == nil {
+		t.Error("seed without a source should be rejected")
+	}
+}
+
+func seedEnv(container v1.Container, name string) string {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
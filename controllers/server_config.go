@@ -0,0 +1,140 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Where the server key pair is mounted from its Secret, and where the copy
+// Postgres reads lives. Postgres refuses a key file it does not own or that
+// others can read, which a Secret volume cannot provide.
+const (
+	tlsSecretMountPath = "/tls-secret"
+	tlsMountPath       = "/tls"
+)
+
+// tlsSecretVolume is the pod volume of the server key pair Secret
+const tlsSecretVolume = "tls-secret"
+
+// Where the operator's pg_hba.conf is mounted from its Secret
+const hbaMountPath = "/hba"
+
+// serverWrapperScript installs the key pair for the postgres user before the
+// server starts, then keeps checking the Secret volumes, which the kubelet
+// updates in place, and reloads the server whenever the key pair was renewed
+// or pg_hba.conf changed. When the server logs to files, for auditing, the
+// plain log files are followed onto the output. The server command line is
+// passed on as arguments.
+const serverWrapperScript = `set -e
+sync_tls() {
+  [ -d ` + tlsSecretMountPath + ` ] || return 0
+  install -o postgres -g postgres -m 0600 ` + tlsSecretMountPath + `/tls.key ` + tlsMountPath + `/tls.key
+  install -o postgres -g postgres -m 0644 ` + tlsSecretMountPath + `/tls.crt ` + tlsMountPath + `/tls.crt
+  if [ -s ` + tlsSecretMountPath + `/ca.crt ]; then
+    install -o postgres -g postgres -m 0644 ` + tlsSecretMountPath + `/ca.crt ` + tlsMountPath + `/ca.crt
+  fi
+}
+snapshot() {
+  cat ` + tlsSecretMountPath + `/* ` + hbaMountPath + `/* 2>/dev/null | cksum
+}
+sync_tls
+(
+  last=$(snapshot)
+  while sleep 30; do
+    current=$(snapshot)
+    if [ "$current" != "$last" ]; then
+      last=$current
+      sync_tls && gosu postgres pg_ctl reload -D "$PGDATA" || true
+    fi
+  done
+) &
+if [ -n "${LOG_DIRECTORY:-}" ]; then
+  tail -q -n +1 -F "$LOG_DIRECTORY"/postgresql-{00..23}.log 2>/dev/null &
+fi
+exec docker-entrypoint.sh "$@"
+`
+
+// defaultParameters are the settings the operator picks unless
+// Spec.Parameters says otherwise. New passwords are stored as SCRAM-SHA-256
+// verifiers; roles still holding an MD5 hash keep working, as the md5
+// method of pg_hba.conf accepts both, until their password is set again.
+var defaultParameters = map[string]string{
+	"password_encryption": "scram-sha-256",
+}
+
+// serverParameters are the settings the operator sets on the server command
+// line on top of Spec.Parameters
+func serverParameters(pg databasev1.Postgresql) map[string]string {
+	parameters := map[string]string{"hba_file": hbaMountPath + "/pg_hba.conf"}
+	if pg.Spec.LocalOnly {
+		parameters["listen_addresses"] = "localhost"
+	}
+	if tlsEnabled(pg) {
+		for name, value := range tlsParameters(pg) {
+			parameters[name] = value
+		}
+	}
+	if fipsEnabled(pg) {
+		for name, value := range fipsParameters() {
+			parameters[name] = value
+		}
+	}
+	if pg.Spec.Audit != nil {
+		for name, value := range auditParameters(pg) {
+			parameters[name] = value
+		}
+	}
+	if citusCoordinator(pg) != "" {
+		preloaded, ok := parameters["shared_preload_libraries"]
+		if !ok {
+			preloaded = pg.Spec.Parameters["shared_preload_libraries"]
+		}
+		for name, value := range citusParameters(pg, preloaded) {
+			parameters[name] = value
+		}
+	}
+	return parameters
+}
+
+// addServerConfig mounts pg_hba.conf and the server key pair into the pod
+// and runs the server through serverWrapperScript
+func addServerConfig(pg databasev1.Postgresql, spec *v1.PodSpec) {
+	const hbaVolume = "hba"
+	container := &spec.Containers[0]
+	spec.Volumes = append(spec.Volumes, v1.Volume{Name: hbaVolume, VolumeSource: v1.VolumeSource{
+		Secret: &v1.SecretVolumeSource{SecretName: getHBASecretName(pg)},
+	}})
+	container.VolumeMounts = append(container.VolumeMounts,
+		v1.VolumeMount{Name: hbaVolume, MountPath: hbaMountPath, ReadOnly: true})
+
+	if tlsEnabled(pg) {
+		const volume = "tls"
+		spec.Volumes = append(spec.Volumes,
+			v1.Volume{Name: tlsSecretVolume, VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+				SecretName: getServerTLSSecretName(pg),
+			}}},
+			v1.Volume{Name: volume, VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+		)
+		container.VolumeMounts = append(container.VolumeMounts,
+			v1.VolumeMount{Name: tlsSecretVolume, MountPath: tlsSecretMountPath, ReadOnly: true},
+			v1.VolumeMount{Name: volume, MountPath: tlsMountPath},
+		)
+	}
+	container.Command = []string{"bash", "-c", serverWrapperScript, "postgres"}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update
+
+// reconcileServiceAccount writes the ServiceAccount the pod of the instance
+// runs under, before the pod is created. It is bound to no role, the server
+// does not talk to the API server, and the pod does not mount its token;
+// policies and workload identity can tell instances apart by it.
+func (r *PostgresqlReconciler) reconcileServiceAccount(ctx context.Context, pg *databasev1.Postgresql) error {
+	account := v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: getServiceAccountName(*pg), Namespace: pg.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &account, func() error {
+		if account.Labels == nil {
+			account.Labels = map[string]string{}
+		}
+		account.Labels[instanceLabel] = pg.Name
+		setManagedLabels(&account, *pg)
+		if pg.Spec.ServiceAccount != nil {
+			if account.Annotations == nil {
+				account.Annotations = map[string]string{}
+			}
+			for key, value := range pg.Spec.ServiceAccount.Annotations {
+				account.Annotations[key] = value
+			}
+		}
+		return ctrl.SetControllerReference(pg, &account, r.Scheme)
+	})
+	return err
+}
+
+func getServiceAccountName(pg databasev1.Postgresql) string {
+	return pg.Name
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const postgresPort = 5432
+
+const roleReplica = "replica"
+
+// serviceLabel marks the additional Services of an instance with their
+// suffix, so the ones dropped from the spec can be found and deleted
+const serviceLabel = "db.example.com/service"
+
+// Each instance gets three Services, following the naming other Postgres
+// operators use: -rw reaches the primary only, -ro the replicas only and -r
+// any pod of the instance. An empty role selects every pod.
+var instanceServices = []struct {
+	suffix string
+	role   string
+}{
+	{suffix: "rw", role: rolePrimary},
+	{suffix: "ro", role: roleReplica},
+	{suffix: "r", role: ""},
+}
+
+// reconcileServices creates the Services of an instance and keeps their
+// selectors pointing at the right pods and their type in line with the
+// service spec. A local-only instance has none.
+func (r *PostgresqlReconciler) reconcileServices(ctx context.Context, pg *databasev1.Postgresql) error {
+	for _, s := range instanceServices {
+		svc := v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Name:      getServiceName(*pg, s.suffix),
+			Namespace: pg.Namespace,
+		}}
+		if pg.Spec.LocalOnly {
+			if err := r.Delete(ctx, &svc); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			continue
+		}
+		role, suffix := s.role, s.suffix
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, &svc, func() error {
+			if svc.Labels == nil {
+				svc.Labels = map[string]string{}
+			}
+			svc.Labels[instanceLabel] = pg.Name
+			setManagedLabels(&svc, *pg)
+			svc.Spec.Selector = serviceSelector(*pg, role)
+			setServiceExposure(&svc, pg.Spec.Service.Settings(suffix), servicePorts(*pg))
+			return ctrl.SetControllerReference(pg, &svc, r.Scheme)
+		}); err != nil {
+			return err
+		}
+	}
+	return r.reconcileAdditionalServices(ctx, pg)
+}
+
+// reconcileAdditionalServices creates the Services declared in the service
+// spec and deletes those no longer declared
+func (r *PostgresqlReconciler) reconcileAdditionalServices(ctx context.Context, pg *databasev1.Postgresql) error {
+	var additional []databasev1.AdditionalService
+	if pg.Spec.Service != nil && !pg.Spec.LocalOnly {
+		additional = pg.Spec.Service.Additional
+	}
+	wanted := map[string]bool{}
+	for _, a := range additional {
+		a := a
+		svc := v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Name:      getServiceName(*pg, a.Suffix),
+			Namespace: pg.Namespace,
+		}}
+		wanted[svc.Name] = true
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, &svc, func() error {
+			if svc.Labels == nil {
+				svc.Labels = map[string]string{}
+			}
+			svc.Labels[instanceLabel] = pg.Name
+			svc.Labels[serviceLabel] = a.Suffix
+			setManagedLabels(&svc, *pg)
+			svc.Spec.Selector = serviceSelector(*pg, a.Role)
+			setServiceExposure(&svc, a.ServiceSettings, additionalServicePorts(a))
+			return ctrl.SetControllerReference(pg, &svc, r.Scheme)
+		}); err != nil {
+			return err
+		}
+	}
+
+	var services v1.ServiceList
+	if err := r.List(ctx, &services, client.InNamespace(pg.Namespace),
+		client.MatchingLabels{instanceLabel: pg.Name}, client.HasLabels{serviceLabel}); err != nil {
+		return err
+	}
+	for i := range services.Items {
+		if wanted[services.Items[i].Name] {
+			continue
+		}
+		if err := r.Delete(ctx, &services.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// additionalServicePorts is the single port of an additional Service,
+// Postgres unless it targets another one
+func additionalServicePorts(a databasev1.AdditionalService) []v1.ServicePort {
+	target := a.TargetPort
+	if target == 0 {
+		target = postgresPort
+	}
+	port := a.Port
+	if port == 0 {
+		port = target
+	}
+	return []v1.ServicePort{{
+		Name:       "tcp",
+		Protocol:   v1.ProtocolTCP,
+		Port:       port,
+		TargetPort: intstr.FromInt(int(target)),
+	}}
+}
+
+// setServiceExposure sets the type, ports, IP families and annotations of a
+// Service. Node ports already allocated are kept, so they do not change
+// under clients and the Service is not updated on every pass.
+func setServiceExposure(svc *v1.Service, settings databasev1.ServiceSettings, ports []v1.ServicePort) {
+	serviceType := settings.Type
+	if serviceType == "" {
+		serviceType = v1.ServiceTypeClusterIP
+	}
+	if serviceType != v1.ServiceTypeClusterIP {
+		nodePorts := map[string]int32{}
+		for _, port := range svc.Spec.Ports {
+			nodePorts[port.Name] = port.NodePort
+		}
+		for i := range ports {
+			ports[i].NodePort = nodePorts[ports[i].Name]
+		}
+	}
+	if len(settings.Annotations) > 0 && svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	for key, value := range settings.Annotations {
+		svc.Annotations[key] = value
+	}
+	svc.Spec.Type = serviceType
+	// Left to the API server unless asked for, which defaults them
+	if settings.IPFamilyPolicy != nil {
+		svc.Spec.IPFamilyPolicy = settings.IPFamilyPolicy
+	}
+	if settings.IPFamilies != nil {
+		svc.Spec.IPFamilies = settings.IPFamilies
+	}
+	svc.Spec.Ports = ports
+	svc.Spec.LoadBalancerSourceRanges = nil
+	if serviceType == v1.ServiceTypeLoadBalancer {
+		svc.Spec.LoadBalancerSourceRanges = settings.LoadBalancerSourceRanges
+	}
+}
+
+// servicePorts are the ports of the Services of an instance: Postgres, and
+// PgBouncer when it runs as a sidecar
+func servicePorts(pg databasev1.Postgresql) []v1.ServicePort {
+	ports := []v1.ServicePort{{
+		Name:       "postgres",
+		Protocol:   v1.ProtocolTCP,
+		Port:       postgresPort,
+		TargetPort: intstr.FromInt(postgresPort),
+	}}
+	if poolerSidecar(pg) {
+		ports = append(ports, v1.ServicePort{
+			Name:       "pgbouncer",
+			Protocol:   v1.ProtocolTCP,
+			Port:       poolerPort,
+			TargetPort: intstr.FromInt(poolerPort),
+		})
+	}
+	return ports
+}
+
+func serviceSelector(pg databasev1.Postgresql, role string) map[string]string {
+	selector := map[string]string{instanceLabel: pg.Name}
+	if role != "" {
+		selector[roleLabel] = role
+	}
+	return selector
+}
+
+func getServiceName(pg databasev1.Postgresql, suffix string) string {
+	return pg.Name + "-" + suffix
+}
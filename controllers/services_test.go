@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetServiceExposure(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	dualStack := v1.IPFamilyPolicyPreferDualStack
+	pg.Spec.Service = &databasev1.ServiceSpec{
+		ServiceSettings: databasev1.ServiceSettings{
+			Type:        v1.ServiceTypeNodePort,
+			Annotations: map[string]string{"example.com/team": "db"},
+		},
+		Overrides: []databasev1.ServiceOverride{{
+			Name: "rw",
+			ServiceSettings: databasev1.ServiceSettings{
+				Type:                     v1.ServiceTypeLoadBalancer,
+				LoadBalancerSourceRanges: []string{"203.0.113.0/24"},
+				Annotations:              map[string]string{"external-dns.alpha.kubernetes.io/hostname": "pg.example.com"},
+				IPFamilyPolicy:           &dualStack,
+				IPFamilies:               []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol},
+			},
+		}},
+	}
+
+	var rw v1.Service
+	setServiceExposure(&rw, pg.Spec.Service.Settings("rw"), servicePorts(pg))
+	if rw.Spec.Type != v1.ServiceTypeLoadBalancer || len(rw.Spec.LoadBalancerSourceRanges) != 1 {
+		t.Errorf("the override should make the rw Service a load balancer, got %+v", rw.Spec)
+	}
+	if rw.Annotations["example.com/team"] != "db" || rw.Annotations["external-dns.alpha.kubernetes.io/hostname"] != "pg.example.com" {
+		t.Errorf("the rw Service should have both sets of annotations, got %v", rw.Annotations)
+	}
+	if *rw.Spec.IPFamilyPolicy != dualStack || rw.Spec.IPFamilies[0] != v1.IPv6Protocol {
+		t.Errorf("the rw Service should be dual-stack, IPv6 first, got %+v", rw.Spec)
+	}
+
+	var ro v1.Service
+	setServiceExposure(&ro, pg.Spec.Service.Settings("ro"), servicePorts(pg))
+	if ro.Spec.Type != v1.ServiceTypeNodePort || ro.Spec.LoadBalancerSourceRanges != nil || len(ro.Annotations) != 1 {
+		t.Errorf("the ro Service should follow the defaults, got %+v", ro.Spec)
+	}
+	if ro.Spec.IPFamilyPolicy != nil || ro.Spec.IPFamilies != nil {
+		t.Errorf("IP families should be left to the API server, got %+v", ro.Spec)
+	}
+	ro.Spec.Ports[0].NodePort = 30432
+	setServiceExposure(&ro, pg.Spec.Service.Settings("ro"), servicePorts(pg))
+	if ro.Spec.Ports[0].NodePort != 30432 {
+		t.Errorf("allocated node port should be kept, got %+v", ro.Spec.Ports)
+	}
+
+	pg.Spec.Service = nil
+	setServiceExposure(&ro, pg.Spec.Service.Settings("ro"), servicePorts(pg))
+	if ro.Spec.Type != v1.ServiceTypeClusterIP || ro.Spec.Ports[0].NodePort != 0 {
+		t.Errorf("without a service spec the Service should be ClusterIP, got %+v", ro.Spec)
+	}
+}
+
+func TestReconcileAdditionalServices(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.Service = &databasev1.ServiceSpec{Additional: []databasev1.AdditionalService{
+		{Suffix: "metrics", TargetPort: 9187},
+		{Suffix: "replication", Role: rolePrimary, Port: 15432, ServiceSettings: databasev1.ServiceSettings{Type: v1.ServiceTypeLoadBalancer}},
+	}}
+	r := &PostgresqlReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg).Build(), Scheme: scheme}
+	if err := r.reconcileAdditionalServices(ctx, pg); err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics, replication v1.Service
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "pg-metrics"}, &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if port := metrics.Spec.Ports[0]; port.Port != 9187 || port.TargetPort.IntValue() != 9187 || len(metrics.Spec.Selector) != 1 {
+		t.Errorf("expected the metrics port on every pod, got %+v", metrics.Spec)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "pg-replication"}, &replication); err != nil {
+		t.Fatal(err)
+	}
+	if port := replication.Spec.Ports[0]; port.Port != 15432 || port.TargetPort.IntValue() != postgresPort ||
+		replication.Spec.Selector[roleLabel] != rolePrimary || replication.Spec.Type != v1.ServiceTypeLoadBalancer {
+		t.Errorf("expected Postgres of the primary behind a load balancer, got %+v", replication.Spec)
+	}
+
+	pg.Spec.Service.Additional = pg.Spec.Service.Additional[1:]
+	if err := r.reconcileAdditionalServices(ctx, pg); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "pg-metrics"}, &metrics); err == nil {
+		t.Error("expected the Service dropped from the spec to be deleted")
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestFinishOnShutdown(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "logger"))
+	cancel()
+
+	r := finishOnShutdown(reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		if err := ctx.Err(); err != nil {
+			t.Errorf("reconcile should not see the stop of the manager, got %v", err)
+		}
+		if ctx.Value(key{}) != "logger" {
+			t.Error("reconcile should keep the values of its context")
+		}
+		return ctrl.Result{}, nil
+	}))
+	if _, err := r.Reconcile(ctx, ctrl.Request{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// superuser is the role the operator connects as. The postgres image
+// creates it when POSTGRES_USER is left unset.
+const superuser = "postgres"
+
+// openPodDB connects to the named database on the instance running in pod,
+// with the sslmode given. The pod is dialled directly so the connection does
+// not depend on the pod being selected by a Service.
+func openPodDB(ctx context.Context, pod *v1.Pod, user, password, dbname, sslmode string) (*sql.DB, error) {
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("pod %s has no IP yet", pod.Name)
+	}
+	return openDialedDB(ctx, podDialer{pod: pod}, user, password, dbname, sslmode)
+}
+
+// openDialedDB connects to the named database on the instance dialer
+// reaches
+func openDialedDB(ctx context.Context, dialer podDialer, user, password, dbname, sslmode string) (*sql.DB, error) {
+	address := net.JoinHostPort(dialer.pod.Status.PodIP, strconv.Itoa(postgresPort))
+	return openDB(ctx, dialer, address, user, password, dbname, sslmode)
+}
+
+// openDB connects to the named database on the server at address, through
+// dialer
+func openDB(ctx context.Context, dialer pq.Dialer, address, user, password, dbname, sslmode string) (*sql.DB, error) {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(user, password),
+		Host:     address,
+		Path:     "/" + dbname,
+		RawQuery: "sslmode=" + sslmode + "&connect_timeout=5",
+	}
+	if DryRun {
+		// Passed on to the server as a setting of the session. SQL the
+		// operator runs for users could turn it off, so it is not run.
+		dsn.RawQuery += "&default_transaction_read_only=on"
+	}
+	connector, err := pq.NewConnector(dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	connector.Dialer(dialer)
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// superuserPassword returns the password of the superuser of an instance,
+// from its password Secret or, until that has been migrated, its spec
+func superuserPassword(ctx context.Context, c client.Reader, pg *databasev1.Postgresql) (string, error) {
+	password, _, err := superuserCredentials(ctx, c, pg)
+	return password, err
+}
+
+// superuserCredentials returns the password of the superuser along with its
+// version: the UID and resource version of its Secret, or the generation of
+// the spec while the password is still given there
+func superuserCredentials(ctx context.Context, c client.Reader, pg *databasev1.Postgresql) (password, version string, err error) {
+	ref := pg.Spec.PasswordSecretRef
+	if ref == nil {
+		return pg.Spec.Password, fmt.Sprintf("spec/%d", pg.Generation), nil
+	}
+	var secret v1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: ref.Name}, &secret); err != nil {
+		return "", "", err
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", "", fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+	return string(data), string(secret.UID) + "/" + secret.ResourceVersion, nil
+}
+
+// superuserPasswordEnv passes the password of the superuser to a container
+// in the named environment variable, straight from its Secret when it has
+// one
+func superuserPasswordEnv(pg databasev1.Postgresql, name string) v1.EnvVar {
+	if ref := pg.Spec.PasswordSecretRef; ref != nil {
+		return v1.EnvVar{Name: name, ValueFrom: &v1.EnvVarSource{SecretKeyRef: ref.DeepCopy()}}
+	}
+	return v1.EnvVar{Name: name, Value: pg.Spec.Password}
+}
+
+// openSuperuserDB connects to the named database on the instance running in
+// pod as the superuser
+func (r *PostgresqlReconciler) openSuperuserDB(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod, dbname string) (*sql.DB, error) {
+	password, err := superuserPassword(ctx, r.Client, pg)
+	if err != nil {
+		return nil, err
+	}
+	return openPodDB(ctx, pod, superuser, password, dbname, operatorSSLMode(*pg))
+}
+
+// errInstanceNotReady is returned when the instance a resource refers to
+// cannot take SQL yet. It is expected while an instance is starting up and
+// is retried at a fixed interval rather than with backoff.
+var errInstanceNotReady = errors.New("instance is not ready")
+
+// How often resources waiting for their instance are retried
+const instanceNotReadyRetry = 10 * time.Second
+
+// connectInstance connects to the named database on the Postgresql
+// referenced from namespace as the superuser
+func connectInstance(ctx context.Context, c client.Client, namespace string, ref v1.LocalObjectReference, dbname string) (*sql.DB, error) {
+	return connectInstanceAs(ctx, c, namespace, ref, dbname, "", "")
+}
+
+// connectInstanceAs connects to the named database on the Postgresql
+// referenced from namespace as user, or as the superuser when user is empty
+func connectInstanceAs(ctx context.Context, c client.Client, namespace string, ref v1.LocalObjectReference, dbname, user, password string) (*sql.DB, error) {
+	var pg databasev1.Postgresql
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &pg); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInstanceNotReady, err)
+	}
+	if pg.Spec.External != nil {
+		return connectExternal(ctx, c, &pg, dbname, user, password)
+	}
+	if isFenced(&pg) || objectDeleting(&pg) {
+		return nil, fmt.Errorf("%w: %s is fenced or being deleted", errInstanceNotReady, pg.Name)
+	}
+	var pod v1.Pod
+	if err := c.Get(ctx, GetPodNamespacedName(pg), &pod); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInstanceNotReady, err)
+	}
+	if !isRunning(&pod) {
+		return nil, fmt.Errorf("%w: pod %s is not running", errInstanceNotReady, pod.Name)
+	}
+	if user == "" {
+		var err error
+		user = superuser
+		if password, err = superuserPassword(ctx, c, &pg); err != nil {
+			return nil, err
+		}
+	}
+	db, err := openPodDB(ctx, &pod, user, password, dbname, operatorSSLMode(pg))
+	if err != nil {
+		return nil, connectError(err)
+	}
+	return db, nil
+}
+
+// connectError tells whether waiting may help with a failed connection.
+// Postgres refusing the connection for a reason other than starting up or
+// shutting down will not go away by waiting.
+func connectError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Class() != "57" {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errInstanceNotReady, err)
+}
+
+// instanceGone reports whether the referenced Postgresql no longer exists,
+// in which case there is nothing left to clean up on it
+func instanceGone(ctx context.Context, c client.Client, namespace string, ref v1.LocalObjectReference) bool {
+	var pg databasev1.Postgresql
+	err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &pg)
+	return apierrors.IsNotFound(err)
+}
+
+// isUndefinedDatabase reports whether a connection was refused because the
+// database asked for does not exist
+func isUndefinedDatabase(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "3D000"
+}
+
+// configMapValue reads the key of a ConfigMap in namespace, typically a SQL
+// script
+func configMapValue(ctx context.Context, c client.Client, namespace string, ref v1.ConfigMapKeySelector) (string, error) {
+	var configMap v1.ConfigMap
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &configMap); err != nil {
+		return "", err
+	}
+	value, ok := configMap.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("configmap %s has no key %s", ref.Name, ref.Key)
+	}
+	return value, nil
+}
+
+// setReadyCondition records the outcome of applying a resource through SQL
+func setReadyCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason.Applied,
+		Message:            "applied to the instance",
+		ObservedGeneration: generation,
+	}
+	switch {
+	case errors.Is(err, errInstanceNotReady):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reason.InstanceNotReady
+		condition.Message = err.Error()
+	case err != nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reason.ApplyFailed
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+// applyResult turns the outcome of applying a resource through SQL into a
+// reconcile result. Failed statements are retried with backoff.
+func applyResult(err error) (ctrl.Result, error) {
+	if errors.Is(err, errInstanceNotReady) {
+		return ctrl.Result{RequeueAfter: instanceNotReadyRetry}, nil
+	}
+	return ctrl.Result{}, err
+}
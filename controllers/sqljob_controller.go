@@ -0,0 +1,227 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Output kept in the status of a SQLJob, in bytes
+const maxSQLJobOutput = 4096
+
+// SQLJobReconciler reconciles a SQLJob object
+type SQLJobReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder, when set, gets an event for every run a dry run skips
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=sqljobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=sqljobs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=sqljobs/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// Reconcile runs the SQL of a job once per spec change, or on its schedule
+func (r *SQLJobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var job databasev1.SQLJob
+	if err := r.Get(ctx, req.NamespacedName, &job); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// The status is patched rather than updated: a conflict must not lose
+	// the record of a run, or the one-shot SQL would run again
+	status := client.MergeFrom(job.DeepCopy())
+
+	now := time.Now()
+	due, next, err := sqlJobDue(&job, now)
+	if err != nil {
+		logger.Error(err, "invalid schedule", "name", job.Name)
+		setReadyCondition(&job.Status.Conditions, job.Generation, err)
+		return ctrl.Result{}, r.Status().Patch(ctx, &job, status)
+	}
+
+	if due && DryRun {
+		// The status would not be written either, so the job stays due
+		reportDryRun(ctx, r.Recorder, &job, fmt.Sprintf("run the SQL of SQLJob %s in database %s", job.Name, job.Spec.Database))
+	} else if due {
+		run, err := r.run(ctx, &job)
+		if err != nil {
+			// Nothing ran, so the job stays due
+			logger.Error(err, "could not run SQL", "name", job.Name)
+			setReadyCondition(&job.Status.Conditions, job.Generation, err)
+			if err := r.Status().Patch(ctx, &job, status); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		job.Status.LastRun = run
+		job.Status.ObservedGeneration = job.Generation
+		if !run.Succeeded {
+			err = errors.New(run.Error)
+		}
+		setReadyCondition(&job.Status.Conditions, job.Generation, err)
+		if job.Spec.Schedule != "" {
+			_, next, _ = sqlJobDue(&job, time.Now())
+		}
+	}
+
+	job.Status.NextRun = nil
+	if !next.IsZero() {
+		job.Status.NextRun = &metav1.Time{Time: next}
+	}
+	if err := r.Status().Patch(ctx, &job, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if next.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Until(next)}, nil
+}
+
+// sqlJobDue reports whether the job should run now and when a scheduled job
+// runs next. Unscheduled jobs run once per generation.
+func sqlJobDue(job *databasev1.SQLJob, now time.Time) (bool, time.Time, error) {
+	if job.Spec.Schedule == "" {
+		return job.Status.ObservedGeneration != job.Generation, time.Time{}, nil
+	}
+	schedule, err := cron.ParseStandard(job.Spec.Schedule)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid schedule %q: %w", job.Spec.Schedule, err)
+	}
+	last := job.CreationTimestamp.Time
+	if job.Status.LastRun != nil {
+		last = job.Status.LastRun.StartTime.Time
+	}
+	next := schedule.Next(last)
+	if !next.After(now) {
+		return true, schedule.Next(now), nil
+	}
+	return false, next, nil
+}
+
+// run executes the job's SQL. Errors from Postgres are recorded in the run;
+// only failures to get the SQL or a connection are returned.
+func (r *SQLJobReconciler) run(ctx context.Context, job *databasev1.SQLJob) (*databasev1.SQLJobRun, error) {
+	script, err := r.script(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var user, password string
+	if ref := job.Spec.CredentialsSecretRef; ref != nil {
+		var secret v1.Secret
+		if err := r.Get(ctx, types.NamespacedName{Namespace: job.Namespace, Name: ref.Name}, &secret); err != nil {
+			return nil, err
+		}
+		user, password = string(secret.Data["user"]), string(secret.Data["password"])
+		if user == "" {
+			return nil, fmt.Errorf("secret %s has no key user", ref.Name)
+		}
+	}
+
+	db, err := connectInstanceAs(ctx, r.Client, job.Namespace, job.Spec.InstanceRef, job.Spec.Database, user, password)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	log.FromContext(ctx).Info("running SQL", "name", job.Name, "database", job.Spec.Database)
+	run := &databasev1.SQLJobRun{StartTime: metav1.Now()}
+	output, err := querySQL(ctx, db, script)
+	run.CompletionTime = metav1.Now()
+	run.Output = output
+	run.Succeeded = err == nil
+	if err != nil {
+		run.Error = err.Error()
+	}
+	return run, nil
+}
+
+func (r *SQLJobReconciler) script(ctx context.Context, job *databasev1.SQLJob) (string, error) {
+	ref := job.Spec.ConfigMapRef
+	if ref == nil {
+		return job.Spec.SQL, nil
+	}
+	return configMapValue(ctx, r.Client, job.Namespace, *ref)
+}
+
+// querySQL runs a script and collects the rows of every statement in it
+func querySQL(ctx context.Context, db *sql.DB, script string) (string, error) {
+	rows, err := db.QueryContext(ctx, script)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var output strings.Builder
+	for {
+		columns, err := rows.Columns()
+		if err != nil {
+			return output.String(), err
+		}
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(pointers...); err != nil {
+				return output.String(), err
+			}
+			fields := make([]string, len(values))
+			for i, value := range values {
+				fields[i] = value.String
+			}
+			if output.Len() < maxSQLJobOutput {
+				output.WriteString(strings.Join(fields, "\t") + "\n")
+			}
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	result := output.String()
+	if len(result) > maxSQLJobOutput {
+		result = result[:maxSQLJobOutput]
+	}
+	return result, rows.Err()
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SQLJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.SQLJob{}).
+		WithOptions(controllerOptions("SQLJob")).
+		Complete(finishOnShutdown(r))
+}
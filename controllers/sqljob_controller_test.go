@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSQLJobDue(t *testing.T) {
+	created := time.Date(2022, 9, 5, 10, 30, 0, 0, time.UTC)
+	job := func(schedule string, generation, observed int64, lastRun *time.Time) *databasev1.SQLJob {
+		j := &databasev1.SQLJob{
+			ObjectMeta: metav1.ObjectMeta{Generation: generation, CreationTimestamp: metav1.NewTime(created)},
+			Spec:       databasev1.SQLJobSpec{Schedule: schedule},
+			Status:     databasev1.SQLJobStatus{ObservedGeneration: observed},
+		}
+		if lastRun != nil {
+			j.Status.LastRun = &databasev1.SQLJobRun{StartTime: metav1.NewTime(*lastRun)}
+		}
+		return j
+	}
+	ranAt := time.Date(2022, 9, 5, 11, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		job      *databasev1.SQLJob
+		now      time.Time
+		wantDue  bool
+		wantNext time.Time
+	}{
+		{"new one-shot job", job("", 1, 0, nil), created, true, time.Time{}},
+		{"one-shot job already run", job("", 1, 1, nil), created, false, time.Time{}},
+		{"one-shot job changed", job("", 2, 1, nil), created, true, time.Time{}},
+		{"scheduled job before first run", job("0 * * * *", 1, 0, nil), created.Add(time.Minute), false, ranAt},
+		{"scheduled job due", job("0 * * * *", 1, 0, nil), ranAt, true, ranAt.Add(time.Hour)},
+		{"scheduled job after run", job("0 * * * *", 1, 1, &ranAt), ranAt.Add(time.Minute), false, ranAt.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due, next, err := sqlJobDue(tt.job, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if due != tt.wantDue || !next.Equal(tt.wantNext) {
+				t.Errorf("got due %v next %v, want due %v next %v", due, next, tt.wantDue, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestSQLJobDryRun(t *testing.T) {
+	DryRun = true
+	defer func() { DryRun = false }()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	// The instance does not exist, so running the SQL would fail
+	job := &databasev1.SQLJob{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "grant", Generation: 1}}
+	job.Spec.InstanceRef = v1.LocalObjectReference{Name: "pg"}
+	job.Spec.Database = "app"
+	job.Spec.SQL = "SET default_transaction_read_only = off; DROP TABLE accounts"
+	recorder := record.NewFakeRecorder(1)
+	r := &SQLJobReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(job).Build(), Scheme: scheme, Recorder: recorder}
+
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "db", Name: "grant"}}); err != nil {
+		t.Fatalf("expected the SQL to be skipped, got %v", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "would run the SQL of SQLJob grant") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected an event for the skipped run")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileStorage makes sure the data volume claim exists when the instance
+// asks for persistent storage, along with a claim for every tablespace. The claim is owned by the Postgresql, so it
+// outlives the pod (hibernation, restarts) but not the instance itself.
+func (r *PostgresqlReconciler) reconcileStorage(ctx context.Context, pg *databasev1.Postgresql) error {
+	if pg.Spec.Storage != nil {
+		if err := r.ensureClaim(ctx, pg, getDataClaimName(*pg), *pg.Spec.Storage); err != nil {
+			return err
+		}
+	}
+	for _, tablespace := range pg.Spec.Tablespaces {
+		if err := r.ensureClaim(ctx, pg, getTablespaceClaimName(*pg, tablespace.Name), tablespace.Storage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureClaim creates a volume claim owned by the Postgresql unless it
+// exists already, in which case it is adopted
+func (r *PostgresqlReconciler) ensureClaim(ctx context.Context, pg *databasev1.Postgresql, name string, storage databasev1.StorageSpec) error {
+	var pvc v1.PersistentVolumeClaim
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: name}, &pvc); err == nil {
+		return r.adopt(ctx, pg, &pvc)
+	} else if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+
+	pvc.Name = name
+	pvc.Namespace = pg.Namespace
+	pvc.Labels = map[string]string{instanceLabel: pg.Name}
+	setManagedLabels(&pvc, *pg)
+	// The operator's default class is only picked once, changing it later
+	// leaves existing claims alone
+	if storage.StorageClassName == nil {
+		if class := operatorconfig.Get().DefaultStorageClass; class != "" {
+			storage.StorageClassName = &class
+		}
+	}
+	pvc.Spec = v1.PersistentVolumeClaimSpec{
+		AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+		StorageClassName: storage.StorageClassName,
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceStorage: storage.Size},
+		},
+	}
+	if err := ctrl.SetControllerReference(pg, &pvc, r.Scheme); err != nil {
+		return err
+	}
+	return r.Create(ctx, &pvc)
+}
+
+// dataVolume is the pod volume holding the data directory: the instance's
+// claim when storage is configured, an emptyDir otherwise.
+func dataVolume(pg databasev1.Postgresql, name string) v1.Volume {
+	volume := v1.Volume{Name: name}
+	if pg.Spec.Storage != nil {
+		volume.PersistentVolumeClaim = &v1.PersistentVolumeClaimVolumeSource{
+			ClaimName: getDataClaimName(pg),
+		}
+	} else {
+		volume.EmptyDir = &v1.EmptyDirVolumeSource{}
+	}
+	return volume
+}
+
+func getDataClaimName(pg databasev1.Postgresql) string {
+	return pg.Name + "-data"
+}
+
+func GetDataClaimNamespacedName(pg databasev1.Postgresql) types.NamespacedName {
+	return types.NamespacedName{
+		Name:      getDataClaimName(pg),
+		Namespace: pg.Namespace,
+	}
+}
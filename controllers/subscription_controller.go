@@ -0,0 +1,209 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// How often the apply lag of a subscription is refreshed
+const subscriptionLagInterval = 30 * time.Second
+
+// SubscriptionReconciler reconciles a Subscription object
+type SubscriptionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=database.db.example.com,resources=subscriptions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=database.db.example.com,resources=subscriptions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=database.db.example.com,resources=subscriptions/finalizers,verbs=update
+
+// Reconcile creates the subscription, keeps its connection and publications
+// in line with the spec and drops it, along with its slot on the publisher,
+// on delete
+func (r *SubscriptionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var subscription databasev1.Subscription
+	if err := r.Get(ctx, req.NamespacedName, &subscription); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !subscription.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&subscription, dropFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.drop(ctx, &subscription); err != nil {
+			logger.Error(err, "could not drop subscription", "name", subscription.SubscriptionName())
+			setReadyCondition(&subscription.Status.Conditions, subscription.Generation, err)
+			if err := r.Status().Update(ctx, &subscription); err != nil {
+				return ctrl.Result{}, err
+			}
+			return applyResult(err)
+		}
+		controllerutil.RemoveFinalizer(&subscription, dropFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &subscription)
+	}
+
+	if !controllerutil.ContainsFinalizer(&subscription, dropFinalizer) {
+		controllerutil.AddFinalizer(&subscription, dropFinalizer)
+		if err := r.Update(ctx, &subscription); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := r.apply(ctx, &subscription)
+	if err != nil {
+		logger.Error(err, "could not apply subscription", "name", subscription.SubscriptionName())
+	}
+	setReadyCondition(&subscription.Status.Conditions, subscription.Generation, err)
+	if err := r.Status().Update(ctx, &subscription); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		return ctrl.Result{RequeueAfter: subscriptionLagInterval}, nil
+	}
+	return applyResult(err)
+}
+
+func (r *SubscriptionReconciler) apply(ctx context.Context, subscription *databasev1.Subscription) error {
+	logger := log.FromContext(ctx)
+	spec := subscription.Spec
+
+	var secret v1.Secret
+	ref := spec.ConnectionSecretRef
+	if err := r.Get(ctx, types.NamespacedName{Namespace: subscription.Namespace, Name: ref.Name}, &secret); err != nil {
+		return err
+	}
+	conninfo, ok := secret.Data[ref.Key]
+	if !ok {
+		return fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+
+	db, err := connectInstance(ctx, r.Client, subscription.Namespace, spec.InstanceRef, spec.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := subscription.SubscriptionName()
+	var currentConninfo string
+	var publications []string
+	err = db.QueryRowContext(ctx, `SELECT subconninfo, subpublication FROM pg_subscription
+		WHERE subname = $1 AND subdbid = (SELECT oid FROM pg_database WHERE datname = current_database())`, name).
+		Scan(&currentConninfo, pq.Array(&publications))
+	switch {
+	case err == sql.ErrNoRows:
+		logger.Info("creating subscription", "name", name, "database", spec.Database)
+		if _, err := db.ExecContext(ctx, createSubscriptionStatement(spec, name, string(conninfo))); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if currentConninfo != string(conninfo) {
+			logger.Info("changing subscription connection", "name", name)
+			if _, err := db.ExecContext(ctx, "ALTER SUBSCRIPTION "+pq.QuoteIdentifier(name)+
+				" CONNECTION "+pq.QuoteLiteral(string(conninfo))); err != nil {
+				return err
+			}
+		}
+		if !reflect.DeepEqual(publications, spec.Publications) {
+			logger.Info("changing subscription publications", "name", name, "publications", spec.Publications)
+			if _, err := db.ExecContext(ctx, "ALTER SUBSCRIPTION "+pq.QuoteIdentifier(name)+
+				" SET PUBLICATION "+identifierList(spec.Publications)); err != nil {
+				return err
+			}
+		}
+	}
+
+	var lag sql.NullFloat64
+	if err := db.QueryRowContext(ctx, `SELECT EXTRACT(EPOCH FROM now() - max(latest_end_time))
+		FROM pg_stat_subscription WHERE subname = $1`, name).Scan(&lag); err != nil {
+		return err
+	}
+	subscription.Status.ApplyLag = nil
+	if lag.Valid {
+		subscription.Status.ApplyLag = &metav1.Duration{Duration: time.Duration(lag.Float64 * float64(time.Second))}
+	}
+	return nil
+}
+
+// drop removes the subscription. DROP SUBSCRIPTION also drops the slot on
+// the publisher, so it fails while the publisher cannot be reached.
+func (r *SubscriptionReconciler) drop(ctx context.Context, subscription *databasev1.Subscription) error {
+	db, err := connectInstance(ctx, r.Client, subscription.Namespace, subscription.Spec.InstanceRef, subscription.Spec.Database)
+	if isUndefinedDatabase(err) ||
+		errors.Is(err, errInstanceNotReady) && instanceGone(ctx, r.Client, subscription.Namespace, subscription.Spec.InstanceRef) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := subscription.SubscriptionName()
+	log.FromContext(ctx).Info("dropping subscription", "name", name, "database", subscription.Spec.Database)
+	_, err = db.ExecContext(ctx, "DROP SUBSCRIPTION IF EXISTS "+pq.QuoteIdentifier(name))
+	return err
+}
+
+func createSubscriptionStatement(spec databasev1.SubscriptionSpec, name, conninfo string) string {
+	createSlot := spec.CreateSlot == nil || *spec.CreateSlot
+	options := []string{fmt.Sprintf("create_slot = %t", createSlot)}
+	if spec.SlotName != "" {
+		options = append(options, "slot_name = "+pq.QuoteLiteral(spec.SlotName))
+	}
+	return "CREATE SUBSCRIPTION " + pq.QuoteIdentifier(name) +
+		" CONNECTION " + pq.QuoteLiteral(conninfo) +
+		" PUBLICATION " + identifierList(spec.Publications) +
+		" WITH (" + strings.Join(options, ", ") + ")"
+}
+
+func identifierList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = pq.QuoteIdentifier(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SubscriptionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Subscription{}).
+		WithOptions(controllerOptions("Subscription")).
+		Complete(finishOnShutdown(r))
+}
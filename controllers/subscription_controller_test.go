@@ -0,0 +1,45 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestCreateSubscriptionStatement(t *testing.T) {
+	noSlot := false
+	tests := []struct {
+		spec databasev1.SubscriptionSpec
+		want string
+	}{
+		{
+			databasev1.SubscriptionSpec{Publications: []string{"orders"}},
+			`CREATE SUBSCRIPTION "sub" CONNECTION 'host=src' PUBLICATION "orders" WITH (create_slot = true)`,
+		},
+		{
+			databasev1.SubscriptionSpec{Publications: []string{"orders", "items"}, CreateSlot: &noSlot, SlotName: "shop"},
+			`CREATE SUBSCRIPTION "sub" CONNECTION 'host=src' PUBLICATION "orders", "items" WITH (create_slot = false, slot_name = 'shop')`,
+		},
+	}
+	for _, tt := range tests {
+		if got := createSubscriptionStatement(tt.spec, "sub", "host=src"); got != tt.want {
+			t.Errorf("createSubscriptionStatement(%+v) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -83,6 +83,9 @@ var _ = BeforeSuite(func() {
 	k8sManager, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme: scheme.Scheme})
 
+	err = SetupIndexes(ctx, k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
 	// Set the reconciler up with its own client independent of the one used
 	// by the test code.
 	err = (&PostgresqlReconciler{
@@ -0,0 +1,191 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// lastAppliedAnnotation is where kubectl apply keeps the manifest it last
+// applied, password included
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// migratePassword moves a password set in the spec into the <name>-superuser
+// Secret, points PasswordSecretRef at it and scrubs the password from the
+// resource. It reports whether the Postgresql was updated.
+func (r *PostgresqlReconciler) migratePassword(ctx context.Context, pg *databasev1.Postgresql) (bool, error) {
+	password := pg.Spec.Password
+	if password == "" {
+		return false, nil
+	}
+
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getSuperuserSecretName(*pg), Namespace: pg.Namespace}}
+	if ref := pg.Spec.PasswordSecretRef; ref != nil {
+		// Applying the original manifest again brings the password back;
+		// it can go again as long as it is the one in the Secret
+		current, err := superuserPassword(ctx, r.Client, pg)
+		if err != nil {
+			return false, err
+		}
+		if current != password {
+			return false, fmt.Errorf("spec.password differs from the password in secret %s; change it there instead", ref.Name)
+		}
+	} else {
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+			secret.Type = v1.SecretTypeBasicAuth
+			secret.Data = map[string][]byte{
+				v1.BasicAuthUsernameKey: []byte(superuser),
+				v1.BasicAuthPasswordKey: []byte(password),
+			}
+			setManagedLabels(&secret, *pg)
+			return ctrl.SetControllerReference(pg, &secret, r.Scheme)
+		}); err != nil {
+			return false, err
+		}
+		pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: secret.Name},
+			Key:                  v1.BasicAuthPasswordKey,
+		}
+	}
+
+	log.FromContext(ctx).Info("moving superuser password out of the spec", "name", pg.Name, "secret", pg.Spec.PasswordSecretRef.Name)
+	pg.Spec.Password = ""
+	if applied, ok := pg.Annotations[lastAppliedAnnotation]; ok {
+		pg.Annotations[lastAppliedAnnotation] = scrubLastApplied(applied)
+	}
+	return true, r.Update(ctx, pg)
+}
+
+// scrubLastApplied removes the password from the manifest kubectl last
+// applied. A manifest that cannot be parsed is dropped altogether.
+func scrubLastApplied(applied string) string {
+	var manifest map[string]interface{}
+	if err := json.Unmarshal([]byte(applied), &manifest); err != nil {
+		return ""
+	}
+	if spec, ok := manifest["spec"].(map[string]interface{}); ok {
+		delete(spec, "password")
+	}
+	scrubbed, err := json.Marshal(manifest)
+	if err != nil {
+		return ""
+	}
+	return string(scrubbed) + "\n"
+}
+
+func getSuperuserSecretName(pg databasev1.Postgresql) string {
+	return pg.Name + "-superuser"
+}
+
+// ensureSuperuserSecret creates the <name>-superuser Secret with a generated
+// password when the instance refers to it and it does not exist yet, as
+// happens with the default the webhook fills in. Any other Secret it refers
+// to is the user's to create, a missing one is reported in an event.
+func (r *PostgresqlReconciler) ensureSuperuserSecret(ctx context.Context, pg *databasev1.Postgresql) error {
+	ref := pg.Spec.PasswordSecretRef
+	if ref == nil {
+		return nil
+	}
+	var secret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: ref.Name}, &secret); err == nil {
+		return nil
+	} else if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if ref.Name != getSuperuserSecretName(*pg) {
+		// The pod cannot start without it; creating the Secret brings the
+		// instance back here
+		log.FromContext(ctx).Info("superuser secret does not exist", "name", pg.Name, "secret", ref.Name, "reason", reason.SecretMissing)
+		r.warn(pg, reason.SecretMissing, fmt.Sprintf("secret %s with the superuser password does not exist", ref.Name))
+		return nil
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+	secret = v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: pg.Namespace},
+		Type:       v1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			v1.BasicAuthUsernameKey: []byte(superuser),
+			ref.Key:                 []byte(password),
+		},
+	}
+	setManagedLabels(&secret, *pg)
+	if err := ctrl.SetControllerReference(pg, &secret, r.Scheme); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("generated superuser password", "name", pg.Name, "secret", secret.Name)
+	return r.Create(ctx, &secret)
+}
+
+// credentialsVersionAnnotation records on the pod the version of the
+// superuser password the instance was last given: the UID and resource
+// version of its Secret, which tell a change without revealing anything of
+// the password
+const credentialsVersionAnnotation = "db.example.com/credentials-version"
+
+// credentialsChecksumAnnotation held a checksum of the password, which
+// anyone able to read pods could test guesses against. It is removed from
+// pods still carrying it.
+const credentialsChecksumAnnotation = "db.example.com/credentials-checksum"
+
+// reconcileSuperuserPassword applies a changed superuser password, which the
+// image only sets when it initializes the data directory. The operator can
+// no longer log in with the old password, so it connects through port
+// forwarding, which pg_hba.conf trusts as a loopback connection. The server
+// key pair and pg_hba.conf need nothing of the kind, the server reloads them
+// as their Secrets change.
+func (r *PostgresqlReconciler) reconcileSuperuserPassword(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	password, version, err := superuserCredentials(ctx, r.Client, pg)
+	if err != nil {
+		return err
+	}
+	if _, ok := pod.Annotations[credentialsChecksumAnnotation]; !ok && pod.Annotations[credentialsVersionAnnotation] == version {
+		return nil
+	}
+
+	db, err := openDialedDB(ctx, podDialer{pod: pod, forward: true}, superuser, "", "postgres", operatorSSLMode(*pg))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := setRolePassword(ctx, db, superuser, password); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("applied superuser password", "name", pg.Name)
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	delete(pod.Annotations, credentialsChecksumAnnotation)
+	pod.Annotations[credentialsVersionAnnotation] = version
+	return r.Update(ctx, pod)
+}
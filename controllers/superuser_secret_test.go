@@ -0,0 +1,127 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScrubLastApplied(t *testing.T) {
+	applied := `{"apiVersion":"database.db.example.com/v1","kind":"Postgresql","metadata":{"name":"pg"},"spec":{"password":"s3cret-password","version":"14.9"}}`
+	var manifest struct {
+		Spec map[string]interface{} `json:"spec"`
+	}
+	if err := json.Unmarshal([]byte(scrubLastApplied(applied)), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manifest.Spec["password"]; ok {
+		t.Error("password should be scrubbed from the last applied manifest")
+	}
+	if manifest.Spec["version"] != "14.9" {
+		t.Errorf("the rest of the spec should be kept, got %v", manifest.Spec)
+	}
+	if got := scrubLastApplied("not json"); got != "" {
+		t.Errorf("unparseable manifest should be dropped, got %q", got)
+	}
+}
+
+func TestSuperuserPasswordEnv(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.Password = "s3cret-password"
+	if env := superuserPasswordEnv(pg, "PGPASSWORD"); env.Value != "s3cret-password" || env.ValueFrom != nil {
+		t.Errorf("unexpected env for a spec password: %+v", env)
+	}
+
+	pg.Spec.Password = ""
+	pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{
+		LocalObjectReference: v1.LocalObjectReference{Name: "pg-superuser"}, Key: "password"}
+	env := superuserPasswordEnv(pg, "PGPASSWORD")
+	if env.Value != "" || env.ValueFrom == nil || env.ValueFrom.SecretKeyRef.Name != "pg-superuser" {
+		t.Errorf("password should come from the secret, got %+v", env)
+	}
+}
+
+func TestSuperuserCredentials(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "pg-superuser"}, Key: "password"}
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg-superuser", UID: "1f0c"},
+		Data: map[string][]byte{"password": []byte("s3cret-password")}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+
+	password, version, err := superuserCredentials(ctx, c, pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "s3cret-password" || strings.Contains(version, password) || !strings.HasPrefix(version, "1f0c/") {
+		t.Errorf("expected the version of the Secret, got %q for %q", version, password)
+	}
+	secret.Data["password"] = []byte("other-password")
+	if err := c.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, _ := superuserCredentials(ctx, c, pg); changed == version {
+		t.Error("expected the version to change with the Secret")
+	}
+
+	// A pod at the current version needs nothing, and no connection
+	_, version, _ = superuserCredentials(ctx, c, pg)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{credentialsVersionAnnotation: version}}}
+	r := &PostgresqlReconciler{Client: c, Scheme: scheme}
+	if err := r.reconcileSuperuserPassword(ctx, pg, pod); err != nil {
+		t.Errorf("expected the password to be up to date, got %v", err)
+	}
+}
+
+func TestMissingSuperuserSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.PasswordSecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "mine"}, Key: "password"}
+	recorder := record.NewFakeRecorder(1)
+	r := &PostgresqlReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), Scheme: scheme, Recorder: recorder}
+
+	if err := r.ensureSuperuserSecret(context.Background(), pg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, reason.SecretMissing) {
+			t.Errorf("expected a %s event, got %q", reason.SecretMissing, event)
+		}
+	default:
+		t.Error("a missing secret should be reported")
+	}
+}
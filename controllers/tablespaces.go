@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"path"
+	"strings"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Tablespace volumes are mounted under this directory
+const tablespacesPath = "/tablespaces"
+
+// tablespaceVolumes are the pod volumes and mounts of the tablespaces
+func tablespaceVolumes(pg databasev1.Postgresql) ([]v1.Volume, []v1.VolumeMount) {
+	var volumes []v1.Volume
+	var mounts []v1.VolumeMount
+	for _, tablespace := range pg.Spec.Tablespaces {
+		name := getTablespaceVolumeName(tablespace.Name)
+		volumes = append(volumes, v1.Volume{
+			Name: name,
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: getTablespaceClaimName(pg, tablespace.Name),
+			}},
+		})
+		mounts = append(mounts, v1.VolumeMount{Name: name, MountPath: path.Join(tablespacesPath, tablespace.Name)})
+	}
+	return volumes, mounts
+}
+
+// tablespaceInitContainer prepares a directory owned by postgres on every
+// tablespace volume, as volumes are mounted owned by root and Postgres
+// refuses a tablespace location it does not own
+func tablespaceInitContainer(pg databasev1.Postgresql, image string, mounts []v1.VolumeMount) *v1.Container {
+	if len(pg.Spec.Tablespaces) == 0 {
+		return nil
+	}
+	command := []string{"install", "-d", "-o", "postgres", "-g", "postgres", "-m", "0700"}
+	for _, tablespace := range pg.Spec.Tablespaces {
+		command = append(command, tablespaceLocation(tablespace.Name))
+	}
+	return &v1.Container{
+		Name:         "tablespaces",
+		Image:        image,
+		Command:      command,
+		VolumeMounts: mounts,
+	}
+}
+
+// reconcileTablespaces creates the tablespaces that are missing on the
+// running instance. Tablespaces added after the pod was created are only
+// created once the pod has been recreated with their volume.
+func (r *PostgresqlReconciler) reconcileTablespaces(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if len(pg.Spec.Tablespaces) == 0 {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+
+	mounted := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		mounted[volume.Name] = true
+	}
+
+	db, err := r.openSuperuserDB(ctx, pg, pod, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, tablespace := range pg.Spec.Tablespaces {
+		if !mounted[getTablespaceVolumeName(tablespace.Name)] {
+			logger.Info("tablespace volume not mounted yet, restart the instance to add it", "tablespace", tablespace.Name)
+			continue
+		}
+		var exists bool
+		err := db.QueryRowContext(ctx, "SELECT true FROM pg_tablespace WHERE spcname = $1", tablespace.Name).Scan(&exists)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		logger.Info("creating tablespace", "name", pg.Name, "tablespace", tablespace.Name)
+		statement := "CREATE TABLESPACE " + pq.QuoteIdentifier(tablespace.Name)
+		if tablespace.Owner != "" {
+			statement += " OWNER " + pq.QuoteIdentifier(tablespace.Owner)
+		}
+		statement += " LOCATION " + pq.QuoteLiteral(tablespaceLocation(tablespace.Name))
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tablespaceLocation is a directory below the mount point, since the mount
+// point itself holds lost+found on many file systems
+func tablespaceLocation(name string) string {
+	return path.Join(tablespacesPath, name, "data")
+}
+
+// Object and volume names cannot hold the underscores tablespace names may
+func getTablespaceVolumeName(name string) string {
+	return "tablespace-" + strings.ReplaceAll(name, "_", "-")
+}
+
+func getTablespaceClaimName(pg databasev1.Postgresql, name string) string {
+	return pg.Name + "-tablespace-" + strings.ReplaceAll(name, "_", "-")
+}
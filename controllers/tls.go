@@ -0,0 +1,213 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// cert-manager's Certificate, handled as unstructured so the operator does
+// not depend on cert-manager's API packages
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update
+
+// reconcileTLS provides the server certificate of the instance, from
+// cert-manager when an issuer is configured and from the operator's own CA
+// otherwise
+func (r *PostgresqlReconciler) reconcileTLS(ctx context.Context, pg *databasev1.Postgresql) error {
+	if !tlsEnabled(*pg) {
+		return nil
+	}
+	var err error
+	if pg.Spec.TLS == nil || pg.Spec.TLS.CertManager == nil {
+		err = r.reconcileOperatorTLS(ctx, pg)
+	} else {
+		err = r.reconcileCertificate(ctx, pg)
+	}
+	if err != nil {
+		return err
+	}
+	return r.publishCABundle(ctx, pg)
+}
+
+// publishCABundle copies the CA of the server certificate into the
+// <name>-ca-bundle ConfigMap, which clients can mount to verify the server
+// without access to any Secret. Issuers that do not hand out their CA, such
+// as ACME ones, leave nothing to publish.
+func (r *PostgresqlReconciler) publishCABundle(ctx context.Context, pg *databasev1.Postgresql) error {
+	var secret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: getServerTLSSecretName(*pg)}, &secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	ca := secret.Data["ca.crt"]
+	if len(ca) == 0 {
+		return nil
+	}
+	configMap := v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: getCABundleName(*pg), Namespace: pg.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &configMap, func() error {
+		configMap.Data = map[string]string{"ca.crt": string(ca)}
+		setManagedLabels(&configMap, *pg)
+		return ctrl.SetControllerReference(pg, &configMap, r.Scheme)
+	})
+	return err
+}
+
+// reconcileCertificate requests the server certificate from cert-manager
+func (r *PostgresqlReconciler) reconcileCertificate(ctx context.Context, pg *databasev1.Postgresql) error {
+	issuer := pg.Spec.TLS.CertManager.IssuerRef
+
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetName(getServerCertificateName(*pg))
+	certificate.SetNamespace(pg.Namespace)
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, certificate, func() error {
+		issuerRef := map[string]interface{}{"name": issuer.Name}
+		if issuer.Kind != "" {
+			issuerRef["kind"] = issuer.Kind
+		}
+		if issuer.Group != "" {
+			issuerRef["group"] = issuer.Group
+		}
+		dnsNames := make([]interface{}, 0, 4*len(instanceServices))
+		for _, name := range serverDNSNames(*pg) {
+			dnsNames = append(dnsNames, name)
+		}
+		certificate.Object["spec"] = map[string]interface{}{
+			"secretName": getServerTLSSecretName(*pg),
+			"commonName": getServiceName(*pg, "rw"),
+			"dnsNames":   dnsNames,
+			"usages":     []interface{}{"server auth"},
+			"issuerRef":  issuerRef,
+			"privateKey": map[string]interface{}{"rotationPolicy": "Always"},
+		}
+		setManagedLabels(certificate, *pg)
+		return ctrl.SetControllerReference(pg, certificate, r.Scheme)
+	})
+	return err
+}
+
+// reconcileOperatorTLS keeps a CA for the instance in the <name>-ca Secret
+// and a server certificate signed by it in the server TLS Secret, renewing
+// the latter ahead of its expiry. Clients verify the server with the ca.crt
+// key of either Secret.
+func (r *PostgresqlReconciler) reconcileOperatorTLS(ctx context.Context, pg *databasev1.Postgresql) error {
+	now := time.Now()
+	caSecret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getCASecretName(*pg), Namespace: pg.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, &caSecret, func() error {
+		if len(caSecret.Data[v1.TLSCertKey]) == 0 || len(caSecret.Data[v1.TLSPrivateKeyKey]) == 0 {
+			log.FromContext(ctx).Info("generating instance CA", "name", pg.Name)
+			ca, err := newCA(pg.Name+" CA", now)
+			if err != nil {
+				return err
+			}
+			caSecret.Type = v1.SecretTypeTLS
+			caSecret.Data = map[string][]byte{
+				v1.TLSCertKey:       ca.Certificate,
+				v1.TLSPrivateKeyKey: ca.Key,
+				"ca.crt":            ca.Certificate,
+			}
+		}
+		setManagedLabels(&caSecret, *pg)
+		return ctrl.SetControllerReference(pg, &caSecret, r.Scheme)
+	}); err != nil {
+		return err
+	}
+	ca := keyPair{Certificate: caSecret.Data[v1.TLSCertKey], Key: caSecret.Data[v1.TLSPrivateKeyKey]}
+
+	dnsNames := serverDNSNames(*pg)
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getServerTLSSecretName(*pg), Namespace: pg.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		if !serverCertificateCurrent(secret.Data[v1.TLSCertKey], ca.Certificate, dnsNames, now) {
+			log.FromContext(ctx).Info("issuing server certificate", "name", pg.Name)
+			server, err := newServerCertificate(ca, dnsNames, now)
+			if err != nil {
+				return err
+			}
+			secret.Type = v1.SecretTypeTLS
+			secret.Data = map[string][]byte{
+				v1.TLSCertKey:       server.Certificate,
+				v1.TLSPrivateKeyKey: server.Key,
+				"ca.crt":            ca.Certificate,
+			}
+		}
+		setManagedLabels(&secret, *pg)
+		return ctrl.SetControllerReference(pg, &secret, r.Scheme)
+	})
+	return err
+}
+
+// serverDNSNames are the names clients reach the instance by, through each
+// of its Services
+func serverDNSNames(pg databasev1.Postgresql) []string {
+	var names []string
+	for _, s := range instanceServices {
+		name := getServiceName(pg, s.suffix)
+		names = append(names, name, name+"."+pg.Namespace, name+"."+pg.Namespace+".svc",
+			name+"."+pg.Namespace+".svc.cluster.local")
+	}
+	return names
+}
+
+// tlsEnabled reports whether the instance serves SSL
+func tlsEnabled(pg databasev1.Postgresql) bool {
+	return pg.Spec.TLS == nil || !pg.Spec.TLS.Disabled
+}
+
+// tlsParameters are the server settings pointing Postgres at the key pair.
+// The operator's CA also verifies client certificates.
+func tlsParameters(pg databasev1.Postgresql) map[string]string {
+	parameters := map[string]string{
+		"ssl":           "on",
+		"ssl_cert_file": tlsMountPath + "/tls.crt",
+		"ssl_key_file":  tlsMountPath + "/tls.key",
+	}
+	if pg.Spec.TLS == nil || pg.Spec.TLS.CertManager == nil {
+		parameters["ssl_ca_file"] = tlsMountPath + "/ca.crt"
+	}
+	return parameters
+}
+
+func getServerCertificateName(pg databasev1.Postgresql) string {
+	return pg.Name + "-server"
+}
+
+func getServerTLSSecretName(pg databasev1.Postgresql) string {
+	return pg.Name + "-server-tls"
+}
+
+func getCASecretName(pg databasev1.Postgresql) string {
+	return pg.Name + "-ca"
+}
+
+func getCABundleName(pg databasev1.Postgresql) string {
+	return pg.Name + "-ca-bundle"
+}
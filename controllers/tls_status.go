@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// sslRequestCode asks a Postgres server to switch the connection to TLS
+const sslRequestCode = 80877103
+
+// reconcileTLSStatus records the expiry of the certificate in the server TLS
+// Secret and of the one the server actually presents. The server is only
+// asked again until the two agree, as every probe shows up in its log as an
+// incomplete connection.
+func (r *PostgresqlReconciler) reconcileTLSStatus(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if !tlsEnabled(*pg) {
+		pg.Status.TLS = nil
+		return nil
+	}
+	var secret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: getServerTLSSecretName(*pg)}, &secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	status := pg.Status.TLS
+	if status != nil && status.SecretVersion == secret.ResourceVersion &&
+		status.SecretNotAfter != nil && status.ServedNotAfter != nil && status.SecretNotAfter.Equal(status.ServedNotAfter) {
+		return nil
+	}
+
+	status = &databasev1.TLSStatus{SecretVersion: secret.ResourceVersion}
+	if len(secret.Data["ca.crt"]) > 0 {
+		status.CABundle = getCABundleName(*pg)
+	}
+	if cert, err := parseCertificate(secret.Data[v1.TLSCertKey]); err == nil {
+		status.SecretNotAfter = &metav1.Time{Time: cert.NotAfter}
+	}
+	var caSecret v1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: getCASecretName(*pg)}, &caSecret); err == nil {
+		if cert, err := parseCertificate(caSecret.Data[v1.TLSCertKey]); err == nil {
+			status.CANotAfter = &metav1.Time{Time: cert.NotAfter}
+		}
+	}
+	pg.Status.TLS = status
+
+	notAfter, err := servedCertificateExpiry(ctx, pod)
+	if err != nil {
+		return err
+	}
+	status.ServedNotAfter = &metav1.Time{Time: notAfter}
+	return nil
+}
+
+// servedCertificateExpiry connects to the server in the pod, negotiates TLS
+// the way libpq does and returns the expiry of the certificate presented
+func servedCertificateExpiry(ctx context.Context, pod *v1.Pod) (time.Time, error) {
+	conn, err := dialPod(ctx, pod, postgresPort)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], sslRequestCode)
+	if _, err := conn.Write(request); err != nil {
+		return time.Time{}, err
+	}
+	answer := make([]byte, 1)
+	if _, err := conn.Read(answer); err != nil {
+		return time.Time{}, err
+	}
+	if answer[0] != 'S' {
+		return time.Time{}, errors.New("server does not accept SSL")
+	}
+
+	// Only the expiry is of interest here, the chain is not verified
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return time.Time{}, err
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, errors.New("server presented no certificate")
+	}
+	return certs[0].NotAfter, nil
+}
+
+// secretToPostgresql maps a server TLS Secret to its instance. cert-manager
+// does not make its Secrets owned by the Certificate, so they cannot be
+// watched through owner references.
+func secretToPostgresql(obj client.Object) []reconcile.Request {
+	name := strings.TrimSuffix(obj.GetName(), "-server-tls")
+	if name == obj.GetName() {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServedCertificateExpiry(t *testing.T) {
+	now := time.Now()
+	ca, err := newCA("pg CA", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newServerCertificate(ca, []string{"pg-rw"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(server.Certificate, server.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(postgresPort))
+	if err != nil {
+		t.Skipf("cannot listen on the Postgres port: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.ReadFull(conn, make([]byte, 8))
+		conn.Write([]byte{'S'})
+		tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+
+	pod := &v1.Pod{Status: v1.PodStatus{PodIP: "127.0.0.1"}}
+	notAfter, err := servedCertificateExpiry(context.Background(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := parseCertificate(server.Certificate)
+	if !notAfter.Equal(want.NotAfter) {
+		t.Errorf("servedCertificateExpiry = %v, want %v", notAfter, want.NotAfter)
+	}
+}
+
+func TestSecretToPostgresql(t *testing.T) {
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "pg-server-tls", Namespace: "db"}}
+	requests := secretToPostgresql(secret)
+	if len(requests) != 1 || requests[0].Name != "pg" || requests[0].Namespace != "db" {
+		t.Errorf("secretToPostgresql = %v", requests)
+	}
+	secret.Name = "pg-app"
+	if requests := secretToPostgresql(secret); requests != nil {
+		t.Errorf("unrelated secret mapped to %v", requests)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+)
+
+func TestPostgresArgsWithTLS(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.Parameters = map[string]string{"ssl": "off", "work_mem": "64MB"}
+	pg.Spec.TLS = &databasev1.TLSSpec{CertManager: &databasev1.CertManagerTLS{
+		IssuerRef: databasev1.IssuerReference{Name: "ca"}}}
+	want := []string{"postgres",
+		"-c", "hba_file=/hba/pg_hba.conf",
+		"-c", "password_encryption=scram-sha-256",
+		"-c", "ssl=on",
+		"-c", "ssl_cert_file=/tls/tls.crt",
+		"-c", "ssl_key_file=/tls/tls.key",
+		"-c", "work_mem=64MB",
+	}
+	if got := postgresArgs(pg); !reflect.DeepEqual(got, want) {
+		t.Errorf("postgresArgs = %q, want %q", got, want)
+	}
+
+	spec := createPodSpec(pg)
+	if command := spec.Containers[0].Command; len(command) == 0 || command[0] != "bash" {
+		t.Errorf("server should run through the TLS wrapper, got command %q", command)
+	}
+}
+
+func TestPostgresArgsWithoutTLS(t *testing.T) {
+	pg := databasev1.Postgresql{}
+	pg.Spec.TLS = &databasev1.TLSSpec{Disabled: true}
+	pg.Spec.Parameters = map[string]string{"password_encryption": "md5"}
+	want := []string{"postgres", "-c", "hba_file=/hba/pg_hba.conf", "-c", "password_encryption=md5"}
+	if got := postgresArgs(pg); !reflect.DeepEqual(got, want) {
+		t.Errorf("postgresArgs = %q, want %q", got, want)
+	}
+}
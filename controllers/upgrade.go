@@ -0,0 +1,232 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// versionAnnotation records the Postgres version the container of a pod
+// runs, which the tag of a catalog image need not spell out
+const versionAnnotation = "db.example.com/version"
+
+// reconcileVersion rolls a change of Spec.Version, or of the image it maps
+// to, out to the running pod. Within a major version the pod image can be
+// changed in place: the kubelet restarts the container on the new binaries
+// and the data directory stays where it is.
+func (r *PostgresqlReconciler) reconcileVersion(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod, maintenance *maintenance) error {
+	container := postgresContainer(pod, *pg)
+	if container == nil {
+		return nil
+	}
+
+	want := desiredVersion(*pg)
+	current := containerVersion(pod, container)
+	running := containerRunningImage(pod, container)
+	image := imageForVersion(*pg, want)
+	upToDate := current == want && sameImage(container.Image, image)
+	if running {
+		pg.Status.Version = current
+		pg.Status.ImageDigest = imageDigest(containerStatus(pod, container.Name).ImageID)
+	}
+	if !upToDate {
+		// The digest is of an image no longer wanted, which a pod
+		// recreated in the meantime must not be pinned to
+		pg.Status.ImageDigest = ""
+	}
+	if upToDate {
+		if running && meta.IsStatusConditionTrue(pg.Status.Conditions, databasev1.ConditionUpgrading) {
+			setUpgradingCondition(pg, metav1.ConditionFalse, reason.UpgradeComplete,
+				fmt.Sprintf("running version %s", want))
+		}
+		return r.undrain(ctx, pg, pod)
+	}
+
+	// Major version changes go through reconcileMajorUpgrade
+	if catalog.Major(current) != catalog.Major(want) {
+		return nil
+	}
+
+	if !maintenance.permits("upgrade to " + want) {
+		return r.undrain(ctx, pg, pod)
+	}
+
+	if drained, err := r.drain(ctx, pg, pod); err != nil || !drained {
+		return err
+	}
+
+	log.FromContext(ctx).Info("upgrading instance", "name", pg.Name, "from", container.Image, "to", image)
+	container.Image = image
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[versionAnnotation] = want
+	// The restarted container is back in service straight away
+	delete(pod.Annotations, drainingSinceAnnotation)
+	setPodLabels(pod, *pg)
+	pg.Status.ImageDigest = ""
+	message := fmt.Sprintf("upgrading from %s to %s", current, want)
+	if current == want {
+		message = fmt.Sprintf("moving version %s to image %s", want, image)
+	}
+	setUpgradingCondition(pg, metav1.ConditionTrue, reason.MinorUpgradeInProgress, message)
+	return r.Update(ctx, pod)
+}
+
+// containerVersion is the version the container of a pod runs, as recorded
+// when it was created or upgraded. Pods from before the record was kept
+// fall back to the tag of their image.
+func containerVersion(pod *v1.Pod, container *v1.Container) string {
+	if version, ok := pod.Annotations[versionAnnotation]; ok {
+		return version
+	}
+	return versionFromImage(container.Image)
+}
+
+// sameImage reports whether a container image is the one wanted. The
+// digest podImage pins a tag to does not make it another image, unless the
+// image wanted names a digest of its own.
+func sameImage(image, want string) bool {
+	if image == want {
+		return true
+	}
+	if imageDigest(want) != "" {
+		return false
+	}
+	return strings.SplitN(image, "@", 2)[0] == want
+}
+
+// podVersion is the version pods are started on. A pod only moves to another
+// major version once pg_upgrade has converted the data directory, which is
+// recorded by Status.Version.
+func podVersion(pg databasev1.Postgresql) string {
+	want := desiredVersion(pg)
+	if pg.Status.Version != "" && catalog.Major(pg.Status.Version) != catalog.Major(want) {
+		return pg.Status.Version
+	}
+	return want
+}
+
+// desiredVersion is the version the instance should be running. Instances
+// on the AutoPatch policy follow the newest patch release in the catalog.
+func desiredVersion(pg databasev1.Postgresql) string {
+	version := pg.Spec.Version
+	if version == "" {
+		version = operatorconfig.Get().DefaultVersion
+	}
+	if pg.Spec.UpdatePolicy == databasev1.UpdatePolicyAutoPatch {
+		version = operatorconfig.Get().Catalog.LatestPatch(version)
+	}
+	return version
+}
+
+// imageForVersion is the image of a version, from the catalog unless the
+// Postgresql asks for its own repository or, for FIPS or pgvector, the
+// operator has a repository for those
+func imageForVersion(pg databasev1.Postgresql, version string) string {
+	if pg.Spec.ImageRepository != "" {
+		return pg.Spec.ImageRepository + ":" + version
+	}
+	config := operatorconfig.Get()
+	if fipsEnabled(pg) && config.FIPSImageRepository != "" {
+		return config.FIPSImageRepository + ":" + version
+	}
+	if pg.Spec.PGVector != nil && config.PGVectorImageRepository != "" {
+		return config.PGVectorImageRepository + ":" + version
+	}
+	if entry, ok := config.Catalog.Lookup(version); ok {
+		return entry.Image
+	}
+	return config.ImageRepository + ":" + version
+}
+
+// versionFromImage returns the tag of an image reference, which for the
+// official images is the Postgres version.
+func versionFromImage(image string) string {
+	image = strings.SplitN(image, "@", 2)[0]
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		return image[i+1:]
+	}
+	return ""
+}
+
+func postgresContainer(pod *v1.Pod, pg databasev1.Postgresql) *v1.Container {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == getPodName(pg) {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+// containerRunningImage reports whether the container is up and ready on the
+// image currently in its spec, i.e. a restart onto a new image has finished.
+func containerRunningImage(pod *v1.Pod, container *v1.Container) bool {
+	status := containerStatus(pod, container.Name)
+	if status == nil || !status.Ready {
+		return false
+	}
+	if digest := imageDigest(container.Image); digest != "" {
+		return imageDigest(status.ImageID) == digest
+	}
+	return strings.HasSuffix(status.Image, container.Image)
+}
+
+func containerStatus(pod *v1.Pod, name string) *v1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// imageDigest returns the digest part of an image reference or image ID
+// (e.g. docker-pullable://postgres@sha256:...), if there is one.
+func imageDigest(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// podImage is the image new pods are started with. Once the running version
+// has been resolved to a digest, the pod is pinned to it so that a restart
+// comes back on exactly the same bits even if the tag has since moved. An
+// image that names a digest itself is taken as it is.
+func podImage(pg databasev1.Postgresql) string {
+	version := podVersion(pg)
+	image := imageForVersion(pg, version)
+	if imageDigest(image) != "" {
+		return image
+	}
+	if pg.Status.ImageDigest != "" && pg.Status.Version == version {
+		image = strings.SplitN(image, "@", 2)[0] + "@" + pg.Status.ImageDigest
+	}
+	return image
+}
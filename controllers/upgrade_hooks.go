@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// runUpgradeHooks runs the upgrade hooks once for each version the running
+// instance moves to, and records their outcome in the status. A failed hook
+// is not retried; its error is left for the user to act on. The first
+// version an instance is seen on is not an upgrade, so it only becomes the
+// baseline.
+func (r *PostgresqlReconciler) runUpgradeHooks(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	version := pg.Status.Version
+	if version == "" || version == pg.Status.UpgradeHooksVersion {
+		return nil
+	}
+	if pg.Status.UpgradeHooksVersion == "" || len(pg.Spec.UpgradeHooks) == 0 {
+		pg.Status.UpgradeHooksVersion = version
+		pg.Status.UpgradeHookResults = nil
+		return nil
+	}
+
+	results := make([]databasev1.UpgradeHookResult, 0, len(pg.Spec.UpgradeHooks))
+	for _, hook := range pg.Spec.UpgradeHooks {
+		run, err := r.runUpgradeHook(ctx, pg, pod, hook)
+		if err != nil {
+			return err
+		}
+		results = append(results, databasev1.UpgradeHookResult{Name: hook.Name, SQLJobRun: *run})
+	}
+	pg.Status.UpgradeHooksVersion = version
+	pg.Status.UpgradeHookResults = results
+	return nil
+}
+
+// runUpgradeHook runs a single hook. As with SQLJobs, errors from Postgres
+// are recorded in the run; only failures to get the SQL or a connection are
+// returned, and have all hooks tried again.
+func (r *PostgresqlReconciler) runUpgradeHook(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod, hook databasev1.UpgradeHook) (*databasev1.SQLJobRun, error) {
+	script, err := configMapValue(ctx, r.Client, pg.Namespace, hook.ConfigMapRef)
+	if err != nil {
+		return nil, err
+	}
+	dbname := hook.Database
+	if dbname == "" {
+		dbname = "postgres"
+	}
+	db, err := r.openSuperuserDB(ctx, pg, pod, dbname)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	log.FromContext(ctx).Info("running upgrade hook", "name", pg.Name, "hook", hook.Name, "version", pg.Status.Version)
+	run := &databasev1.SQLJobRun{StartTime: metav1.Now()}
+	output, err := querySQL(ctx, db, script)
+	run.CompletionTime = metav1.Now()
+	run.Output = output
+	run.Succeeded = err == nil
+	if err != nil {
+		run.Error = err.Error()
+	}
+	return run, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	"github.com/pkpivot/pg-simple-operator/pkg/operatorconfig"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestVersionFromImage(t *testing.T) {
+	tests := map[string]string{
+		"postgres:14.5":                   "14.5",
+		"docker.io/library/postgres:14.9": "14.9",
+		"registry:5000/postgres":          "",
+		"registry:5000/postgres:16.1":     "16.1",
+		"postgres:14.5@sha256:0123abcd":   "14.5",
+	}
+	for image, want := range tests {
+		if got := versionFromImage(image); got != want {
+			t.Errorf("versionFromImage(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	tests := map[string]string{
+		"docker-pullable://postgres@sha256:0123abcd": "sha256:0123abcd",
+		"postgres:14.5@sha256:0123abcd":              "sha256:0123abcd",
+		"postgres:14.5":                              "",
+	}
+	for image, want := range tests {
+		if got := imageDigest(image); got != want {
+			t.Errorf("imageDigest(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestSameImage(t *testing.T) {
+	tests := []struct {
+		image, want string
+		same        bool
+	}{
+		{"postgres:14.9", "postgres:14.9", true},
+		{"postgres:14.9@sha256:0123abcd", "postgres:14.9", true},
+		{"postgres:14.9", "registry.example.com/postgres-pgvector:14.9", false},
+		{"postgres:14.9@sha256:0123abcd", "postgres:14.9@sha256:4567cdef", false},
+	}
+	for _, test := range tests {
+		if got := sameImage(test.image, test.want); got != test.same {
+			t.Errorf("sameImage(%q, %q) = %v, want %v", test.image, test.want, got, test.same)
+		}
+	}
+}
+
+func TestReconcileVersionImage(t *testing.T) {
+	ctx := context.Background()
+	defer operatorconfig.Set(operatorconfig.Config{})
+	operatorconfig.Set(operatorconfig.Config{Catalog: catalog.Catalog{{Version: "14.9", Image: "postgres:14.9-bookworm"}}})
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = databasev1.AddToScheme(scheme)
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg"}}
+	pg.Spec.Version = "14.9"
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg", Annotations: map[string]string{versionAnnotation: "14.9"}}}
+	pod.Spec.Containers = []v1.Container{{Name: "pg", Image: "postgres:14.9-bookworm"}}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "pg", Ready: true, Image: "docker.io/library/postgres:14.9-bookworm",
+		ImageID: "docker-pullable://postgres@sha256:0123abcd"}}
+	r := &PostgresqlReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pg, pod).Build(), Scheme: scheme}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "db", Name: "pg"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	resourceVersion := pod.ResourceVersion
+
+	// A catalog image whose tag is not the version is left alone
+	if err := r.reconcileVersion(ctx, pg, pod, &maintenance{allowed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if pod.ResourceVersion != resourceVersion {
+		t.Error("expected a pod on the catalog image to be left alone")
+	}
+	if pg.Status.Version != "14.9" || pg.Status.ImageDigest != "sha256:0123abcd" {
+		t.Errorf("expected version 14.9 on the running digest, got %s %s", pg.Status.Version, pg.Status.ImageDigest)
+	}
+
+	// Another repository rolls the pod onto it
+	pg.Spec.ImageRepository = "registry.example.com/postgres"
+	if err := r.reconcileVersion(ctx, pg, pod, &maintenance{allowed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if image := pod.Spec.Containers[0].Image; image != "registry.example.com/postgres:14.9" {
+		t.Errorf("expected the pod to move to the new repository, got %s", image)
+	}
+	if pg.Status.ImageDigest != "" {
+		t.Errorf("expected the digest of the old image to be dropped, got %s", pg.Status.ImageDigest)
+	}
+}
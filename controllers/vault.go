@@ -0,0 +1,411 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/reason"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// vaultUser is the role Vault connects to the instance as
+const vaultUser = "vault"
+
+// defaultVaultAudience is the audience of the tokens Vault's Kubernetes auth
+// method is given, unless the spec names another
+const defaultVaultAudience = "vault"
+
+// vaultTokenExpiration is how long the tokens Vault is given are valid, the
+// least the API server allows: Vault only needs them to log in
+const vaultTokenExpiration = 10 * 60
+
+// serviceAccountTokens requests tokens of the ServiceAccounts of instances,
+// set up by SetupServiceAccountTokens and replaced in tests
+var serviceAccountTokens func(ctx context.Context, namespace, name, audience string) (string, error)
+
+//+kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
+
+// SetupServiceAccountTokens lets the operator log in to Vault as the
+// ServiceAccounts of instances, with short-lived tokens bound to Vault's
+// audience. The operator's own token never leaves it: the Vault address is
+// up to whoever creates an instance.
+func SetupServiceAccountTokens(config *rest.Config) error {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	serviceAccountTokens = func(ctx context.Context, namespace, name, audience string) (string, error) {
+		expiration := int64(vaultTokenExpiration)
+		request, err := clientset.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, name, &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{Audiences: []string{audience}, ExpirationSeconds: &expiration},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+		return request.Status.Token, nil
+	}
+	return nil
+}
+
+// reconcileVault configures the database secrets engine of Vault against
+// the instance whenever the spec changes, retrying until it succeeds
+func (r *PostgresqlReconciler) reconcileVault(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	if pg.Spec.Vault == nil {
+		meta.RemoveStatusCondition(&pg.Status.Conditions, databasev1.ConditionVaultConfigured)
+		return nil
+	}
+	if cond := meta.FindStatusCondition(pg.Status.Conditions, databasev1.ConditionVaultConfigured); cond != nil &&
+		cond.Status == metav1.ConditionTrue && cond.ObservedGeneration == pg.Generation {
+		return nil
+	}
+
+	condition := metav1.Condition{
+		Type:               databasev1.ConditionVaultConfigured,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason.VaultConfigured,
+		Message:            "credentials are available from Vault connection " + vaultConnectionName(*pg),
+		ObservedGeneration: pg.Generation,
+	}
+	err := r.configureVault(ctx, pg, pod)
+	if err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reason.VaultFailed, err.Error()
+	}
+	meta.SetStatusCondition(&pg.Status.Conditions, condition)
+	return err
+}
+
+func (r *PostgresqlReconciler) configureVault(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod) error {
+	spec := pg.Spec.Vault
+	password, err := r.vaultPassword(ctx, pg)
+	if err != nil {
+		return err
+	}
+	if err := r.createVaultUser(ctx, pg, pod, password); err != nil {
+		return err
+	}
+
+	vault, err := r.vaultClient(ctx, pg)
+	if err != nil {
+		return err
+	}
+	sslmode := "disable"
+	if tlsEnabled(*pg) {
+		sslmode = "require"
+	}
+	connectionURL := fmt.Sprintf("postgresql://{{username}}:{{password}}@%s.%s.svc:%d/postgres?sslmode=%s",
+		getServiceName(*pg, "rw"), pg.Namespace, postgresPort, sslmode)
+	var roles []string
+	for _, role := range spec.Roles {
+		roles = append(roles, role.Name)
+	}
+	connection := vaultConnectionName(*pg)
+	log.FromContext(ctx).Info("configuring Vault database connection", "name", connection)
+	if err := vault.do(ctx, http.MethodPost, "config/"+connection, map[string]interface{}{
+		"plugin_name":    "postgresql-database-plugin",
+		"connection_url": connectionURL,
+		"username":       vaultUser,
+		"password":       password,
+		"allowed_roles":  roles,
+	}, nil); err != nil {
+		return err
+	}
+	return vault.syncRoles(ctx, connection, spec.Roles)
+}
+
+// vaultPassword reads the password of the vault role from the <name>-vault
+// Secret, generating it the first time round
+func (r *PostgresqlReconciler) vaultPassword(ctx context.Context, pg *databasev1.Postgresql) (string, error) {
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getVaultSecretName(*pg), Namespace: pg.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		if len(secret.Data["password"]) == 0 {
+			password, err := generatePassword()
+			if err != nil {
+				return err
+			}
+			secret.Data = map[string][]byte{"username": []byte(vaultUser), "password": []byte(password)}
+		}
+		setManagedLabels(&secret, *pg)
+		return ctrl.SetControllerReference(pg, &secret, r.Scheme)
+	})
+	return string(secret.Data["password"]), err
+}
+
+// createVaultUser makes sure the vault role can log in and create the
+// roles of credentials. It needs the admin option on the roles those are
+// members of.
+func (r *PostgresqlReconciler) createVaultUser(ctx context.Context, pg *databasev1.Postgresql, pod *v1.Pod, password string) error {
+	db, err := r.openSuperuserDB(ctx, pg, pod, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	verifier, err := scramVerifier(password)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT true FROM pg_roles WHERE rolname = $1", vaultUser).Scan(&exists)
+	verb := "ALTER ROLE "
+	if errors.Is(err, sql.ErrNoRows) {
+		verb = "CREATE ROLE "
+	} else if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, verb+pq.QuoteIdentifier(vaultUser)+" WITH LOGIN CREATEROLE PASSWORD "+pq.QuoteLiteral(verifier)); err != nil {
+		return err
+	}
+	for _, role := range pg.Spec.Vault.Roles {
+		for _, grant := range role.GrantRoles {
+			if _, err := db.ExecContext(ctx, "GRANT "+pq.QuoteIdentifier(grant)+" TO "+pq.QuoteIdentifier(vaultUser)+" WITH ADMIN OPTION"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeVaultConnection deletes the connection of a deleted instance and
+// its roles from Vault. It is best effort: Vault may be gone already.
+func (r *PostgresqlReconciler) removeVaultConnection(ctx context.Context, pg *databasev1.Postgresql) error {
+	vault, err := r.vaultClient(ctx, pg)
+	if err != nil {
+		return err
+	}
+	connection := vaultConnectionName(*pg)
+	if err := vault.syncRoles(ctx, connection, nil); err != nil {
+		return err
+	}
+	return vault.do(ctx, http.MethodDelete, "config/"+connection, nil, nil)
+}
+
+// vaultCreationStatement creates the login role of a credential, a member
+// of the grant roles
+func vaultCreationStatement(role databasev1.VaultRole) string {
+	statement := `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}'`
+	if len(role.GrantRoles) > 0 {
+		var grants []string
+		for _, grant := range role.GrantRoles {
+			grants = append(grants, pq.QuoteIdentifier(grant))
+		}
+		statement += " IN ROLE " + strings.Join(grants, ", ")
+	}
+	return statement
+}
+
+// vaultAPI talks to the database secrets engine of a Vault
+type vaultAPI struct {
+	address, mount, token string
+	client                *http.Client
+}
+
+// vaultClient logs in to the Vault of the instance
+func (r *PostgresqlReconciler) vaultClient(ctx context.Context, pg *databasev1.Postgresql) (*vaultAPI, error) {
+	spec := pg.Spec.Vault
+	vault := &vaultAPI{
+		address: strings.TrimSuffix(spec.Address, "/"),
+		mount:   spec.Mount,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	if vault.mount == "" {
+		vault.mount = "database"
+	}
+	switch {
+	case spec.TokenSecretRef != nil:
+		ref := spec.TokenSecretRef
+		var secret v1.Secret
+		if err := r.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: ref.Name}, &secret); err != nil {
+			return nil, err
+		}
+		token, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+		}
+		vault.token = strings.TrimSpace(string(token))
+	case spec.KubernetesAuth != nil:
+		if err := vault.kubernetesLogin(ctx, pg, spec.KubernetesAuth); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("vault needs a tokenSecretRef or kubernetesAuth")
+	}
+	return vault, nil
+}
+
+// kubernetesLogin logs in with a token of the instance's own ServiceAccount,
+// which Vault checks with the API server
+func (v *vaultAPI) kubernetesLogin(ctx context.Context, pg *databasev1.Postgresql, auth *databasev1.VaultKubernetesAuth) error {
+	if serviceAccountTokens == nil {
+		return errors.New("service account tokens are not set up")
+	}
+	audience := auth.Audience
+	if audience == "" {
+		audience = defaultVaultAudience
+	}
+	jwt, err := serviceAccountTokens(ctx, pg.Namespace, getServiceAccountName(*pg), audience)
+	if err != nil {
+		return fmt.Errorf("service account token: %w", err)
+	}
+	mount := auth.Mount
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.request(ctx, http.MethodPost, "/v1/auth/"+mount+"/login",
+		map[string]string{"role": auth.Role, "jwt": jwt}, &response); err != nil {
+		return fmt.Errorf("vault login: %w", err)
+	}
+	v.token = response.Auth.ClientToken
+	return nil
+}
+
+// syncRoles writes the roles of a connection and deletes the ones of the
+// connection no longer wanted
+func (v *vaultAPI) syncRoles(ctx context.Context, connection string, roles []databasev1.VaultRole) error {
+	wanted := map[string]bool{}
+	for _, role := range roles {
+		wanted[role.Name] = true
+		body := map[string]interface{}{
+			"db_name":             connection,
+			"creation_statements": []string{vaultCreationStatement(role)},
+		}
+		if role.DefaultTTL != nil {
+			body["default_ttl"] = strconv.Itoa(int(role.DefaultTTL.Seconds())) + "s"
+		}
+		if role.MaxTTL != nil {
+			body["max_ttl"] = strconv.Itoa(int(role.MaxTTL.Seconds())) + "s"
+		}
+		if err := v.do(ctx, http.MethodPost, "roles/"+role.Name, body, nil); err != nil {
+			return err
+		}
+	}
+
+	var list struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, "roles?list=true", nil, &list); err != nil {
+		return err
+	}
+	for _, name := range list.Data.Keys {
+		if wanted[name] {
+			continue
+		}
+		var role struct {
+			Data struct {
+				DBName string `json:"db_name"`
+			} `json:"data"`
+		}
+		if err := v.do(ctx, http.MethodGet, "roles/"+name, nil, &role); err != nil {
+			return err
+		}
+		if role.Data.DBName != connection {
+			continue
+		}
+		log.FromContext(ctx).Info("deleting Vault role", "name", name)
+		if err := v.do(ctx, http.MethodDelete, "roles/"+name, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do calls an endpoint of the secrets engine
+func (v *vaultAPI) do(ctx context.Context, method, path string, body, out interface{}) error {
+	if DryRun && method != http.MethodGet {
+		log.FromContext(ctx).Info("dry run: would call Vault", "method", method, "path", path)
+		return nil
+	}
+	return v.request(ctx, method, "/v1/"+v.mount+"/"+path, body, out)
+}
+
+func (v *vaultAPI) request(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+path, reader)
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// A list without any keys is a 404
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(data, &vaultErr)
+		return fmt.Errorf("vault %s %s: %s %s", method, path, resp.Status, strings.Join(vaultErr.Errors, "; "))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func vaultConnectionName(pg databasev1.Postgresql) string {
+	return pg.Namespace + "-" + pg.Name
+}
+
+func getVaultSecretName(pg databasev1.Postgresql) string {
+	return pg.Name + "-vault"
+}
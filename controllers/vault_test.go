@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVaultCreationStatement(t *testing.T) {
+	got := vaultCreationStatement(databasev1.VaultRole{Name: "app", GrantRoles: []string{"readers", "app writers"}})
+	want := `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}' IN ROLE "readers", "app writers"`
+	if got != want {
+		t.Errorf("vaultCreationStatement = %s, want %s", got, want)
+	}
+}
+
+func TestVaultSyncRoles(t *testing.T) {
+	// Roles stored by the fake engine, by name, holding their db_name
+	roles := map[string]string{"stale": "default-pg", "other": "default-other"}
+	var written map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		name := strings.TrimPrefix(req.URL.Path, "/v1/database/roles/")
+		switch {
+		case req.Method == http.MethodGet && req.URL.Path == "/v1/database/roles":
+			var keys []string
+			for key := range roles {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+		case req.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"db_name": roles[name]}})
+		case req.Method == http.MethodPost:
+			json.NewDecoder(req.Body).Decode(&written)
+			roles[name] = written["db_name"].(string)
+			w.WriteHeader(http.StatusNoContent)
+		case req.Method == http.MethodDelete:
+			delete(roles, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	vault := &vaultAPI{address: server.URL, mount: "database", token: "token", client: server.Client()}
+	err := vault.syncRoles(context.Background(), "default-pg", []databasev1.VaultRole{
+		{Name: "app", DefaultTTL: &metav1.Duration{Duration: time.Hour}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app": "default-pg", "other": "default-other"}
+	if !reflect.DeepEqual(roles, want) {
+		t.Errorf("roles = %v, want %v", roles, want)
+	}
+	if written["default_ttl"] != "3600s" {
+		t.Errorf("default_ttl = %v, want 3600s", written["default_ttl"])
+	}
+
+	vault.token = "wrong"
+	if err := vault.syncRoles(context.Background(), "default-pg", nil); err == nil {
+		t.Error("expected an error with a rejected token")
+	}
+}
+
+func TestVaultKubernetesLogin(t *testing.T) {
+	var login map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/auth/kubernetes/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(req.Body).Decode(&login)
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": "token"}})
+	}))
+	defer server.Close()
+
+	var requested []string
+	defer func(tokens func(context.Context, string, string, string) (string, error)) {
+		serviceAccountTokens = tokens
+	}(serviceAccountTokens)
+	serviceAccountTokens = func(ctx context.Context, namespace, name, audience string) (string, error) {
+		requested = []string{namespace, name, audience}
+		return "instance-jwt", nil
+	}
+
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pg"}}
+	vault := &vaultAPI{address: server.URL, client: server.Client()}
+	if err := vault.kubernetesLogin(context.Background(), pg, &databasev1.VaultKubernetesAuth{Role: "pg"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"default", "pg", "vault"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("token requested for %v, want %v", requested, want)
+	}
+	if login["jwt"] != "instance-jwt" || login["role"] != "pg" {
+		t.Errorf("unexpected login %v", login)
+	}
+	if vault.token != "token" {
+		t.Errorf("token = %q, want token", vault.token)
+	}
+}
@@ -17,22 +17,29 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
 	"github.com/pkpivot/pg-simple-operator/controllers"
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	"github.com/pkpivot/pg-simple-operator/pkg/features"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -51,12 +58,69 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var leaderElectionNamespace, leaderElectionID string
 	var probeAddr string
+	var defaultDenyNetwork bool
+	var watchNamespaces string
+	var operatorConfig string
+	var gracefulShutdownTimeout time.Duration
+	var orphanSweepInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
+		"The namespace of the leader election lease. Defaults to the namespace the manager runs in.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "053669b3.db.example.com",
+		"The name of the leader election lease. Managers sharing it reconcile one at a time.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", os.Getenv("WATCH_NAMESPACE"),
+		"A comma-separated list of the namespaces whose objects the operator manages. "+
+			"Defaults to the WATCH_NAMESPACE environment variable, or to all namespaces if that is empty too.")
+	flag.DurationVar(&gracefulShutdownTimeout, "graceful-shutdown-timeout", 30*time.Second,
+		"How long reconciles in flight may take to finish when the manager stops. "+
+			"Keep it below the termination grace period of the pod.")
+	flag.DurationVar(&orphanSweepInterval, "orphan-sweep-interval", time.Hour,
+		"How often objects made for instances that no longer exist are looked for and deleted. 0 turns the sweep off.")
+	flag.BoolVar(&controllers.DryRun, "dry-run", false,
+		"Work out what would change and report it as events, without changing anything. "+
+			"Run without webhooks, which keep defaulting new objects.")
+	flag.StringVar(&operatorConfig, "operator-config", "",
+		"The ConfigMap, as namespace/name, holding the operator configuration in its config.yaml key. "+
+			"Changes take effect without a restart.")
+	flag.IntVar(&controllers.MaxConcurrentReconciles, "max-concurrent-reconciles", controllers.MaxConcurrentReconciles,
+		"The number of objects each controller reconciles at once.")
+	flag.DurationVar(&controllers.RetryBaseDelay, "retry-base-delay", controllers.RetryBaseDelay,
+		"How long after a failed reconcile an object is retried. The delay doubles with every further failure.")
+	flag.DurationVar(&controllers.RetryMaxDelay, "retry-max-delay", controllers.RetryMaxDelay,
+		"The longest delay before a failed reconcile is retried.")
+	flag.Float64Var(&controllers.RetryQPS, "retry-qps", controllers.RetryQPS,
+		"The number of objects a second each controller retries at most.")
+	flag.IntVar(&controllers.RetryBurst, "retry-burst", controllers.RetryBurst,
+		"The number of objects each controller may retry at once above retry-qps.")
+	flag.Func("concurrent-reconciles",
+		"The number of objects the controller of a kind reconciles at once, as kind=number, e.g. Postgresql=4. Can be repeated.",
+		controllers.ParseConcurrentReconciles)
+	flag.BoolVar(&defaultDenyNetwork, "default-deny-network", false,
+		"Give every instance a NetworkPolicy that only lets in the peers its access spec declares.")
+	flag.Func("require-storage-encryption",
+		"A StorageClass parameter, key=value or just key, marking encrypted classes. Instances must use such classes. Can be repeated.",
+		func(matcher string) error {
+			databasev1.OperatorStorageEncryptionPolicy.Matchers = append(databasev1.OperatorStorageEncryptionPolicy.Matchers, matcher)
+			return nil
+		})
+	flag.StringVar(&catalog.FIPSImageRepository, "fips-image-repository", "",
+		"The repository of FIPS-enabled Postgres images, tagged with the version, FIPS instances run.")
+	flag.StringVar(&catalog.PGVectorImageRepository, "pgvector-image-repository", "",
+		"The repository of Postgres images with pgvector, tagged with the version, instances enabling pgvector run.")
+	flag.IntVar(&databasev1.OperatorPasswordPolicy.MinLength, "password-min-length",
+		databasev1.OperatorPasswordPolicy.MinLength, "The minimum length of passwords.")
+	flag.Float64Var(&databasev1.OperatorPasswordPolicy.MinEntropyBits, "password-min-entropy",
+		databasev1.OperatorPasswordPolicy.MinEntropyBits, "The minimum estimated entropy of passwords, in bits.")
+	flag.Func("feature-gates",
+		"A comma-separated list of feature=true|false pairs turning features on or off. Options are:\n"+
+			strings.Join(features.Gate.KnownFeatures(), "\n"),
+		features.Gate.Set)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,37 +129,193 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "053669b3.db.example.com",
-		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
-		// when the Manager ends. This requires the binary to immediately end when the
-		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
-		// speeds up voluntary leader transitions as the new leader don't have to wait
-		// LeaseDuration time first.
-		//
-		// In the default scaffold provided, the program ends immediately after
-		// the manager stops, so would be fine to enable this option. However,
-		// if you are doing or is intended to do any operation such as perform cleanups
-		// after the manager stops then its usage might be unsafe.
-		// LeaderElectionReleaseOnCancel: true,
-	})
+	enableWebhooks := features.Enabled(features.Webhooks) && os.Getenv("ENABLE_WEBHOOKS") != "false"
+
+	options := ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      metricsAddr,
+		Port:                    9443,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaderElectionID:        leaderElectionID,
+		// The program ends as soon as the manager stops, so the lease can be
+		// handed over right away rather than after it expires, which keeps
+		// rolling updates of a replicated manager short.
+		LeaderElectionReleaseOnCancel: true,
+		GracefulShutdownTimeout:       &gracefulShutdownTimeout,
+	}
+	// Cluster-scoped objects such as nodes are still read from all of the
+	// cluster, whatever the namespaces
+	var namespaces []string
+	for _, namespace := range strings.Split(watchNamespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	switch len(namespaces) {
+	case 0:
+	case 1:
+		options.Namespace = namespaces[0]
+	default:
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	setupLog.Info("watching namespaces", "namespaces", namespaces)
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	if operatorConfig != "" {
+		namespace, name, ok := strings.Cut(operatorConfig, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "operator configuration must be given as namespace/name", "operator-config", operatorConfig)
+			os.Exit(1)
+		}
+		if err = mgr.Add(&controllers.OperatorConfigLoader{
+			Cache:     mgr.GetCache(),
+			ConfigMap: types.NamespacedName{Namespace: namespace, Name: name},
+		}); err != nil {
+			setupLog.Error(err, "unable to load operator configuration")
+			os.Exit(1)
+		}
+	}
+
+	client := mgr.GetClient()
+	if controllers.DryRun {
+		setupLog.Info("dry run, nothing will be changed")
+		client = controllers.NewDryRunClient(client, mgr.GetEventRecorderFor("pg-simple-operator"))
+	}
+
+	if err = controllers.SetupIndexes(context.Background(), mgr); err != nil {
+		setupLog.Error(err, "unable to set up field indexes")
+		os.Exit(1)
+	}
+	if orphanSweepInterval > 0 {
+		if err = mgr.Add(&controllers.OrphanSweeper{
+			Client:    client,
+			APIReader: mgr.GetAPIReader(),
+			Interval:  orphanSweepInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up orphan sweep")
+			os.Exit(1)
+		}
+	}
+	if err = controllers.SetupPortForwarding(mgr.GetConfig()); err != nil {
+		setupLog.Error(err, "unable to set up port forwarding")
+		os.Exit(1)
+	}
+	if err = controllers.SetupServiceAccountTokens(mgr.GetConfig()); err != nil {
+		setupLog.Error(err, "unable to set up service account tokens")
+		os.Exit(1)
+	}
 	if err = (&controllers.PostgresqlReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:             client,
+		Scheme:             mgr.GetScheme(),
+		DefaultDenyNetwork: defaultDenyNetwork,
+		Recorder:           mgr.GetEventRecorderFor("postgresql-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Postgresql")
 		os.Exit(1)
 	}
+	if err = (&controllers.DatabaseReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Database")
+		os.Exit(1)
+	}
+	if err = (&controllers.RoleReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Role")
+		os.Exit(1)
+	}
+	if err = (&controllers.GrantReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Grant")
+		os.Exit(1)
+	}
+	if err = (&controllers.SchemaReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Schema")
+		os.Exit(1)
+	}
+	if err = (&controllers.ExtensionReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Extension")
+		os.Exit(1)
+	}
+	if err = (&controllers.SubscriptionReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Subscription")
+		os.Exit(1)
+	}
+	if err = (&controllers.ForeignServerReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ForeignServer")
+		os.Exit(1)
+	}
+	if err = (&controllers.SQLJobReconciler{
+		Client:   client,
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("sqljob-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SQLJob")
+		os.Exit(1)
+	}
+	if err = (&controllers.CronSQLReconciler{
+		Client:   client,
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("cronsql-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CronSQL")
+		os.Exit(1)
+	}
+	if err = (&controllers.GroupSyncReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "GroupSync")
+		os.Exit(1)
+	}
+	if err = (&controllers.PolicyReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Policy")
+		os.Exit(1)
+	}
+	if err = (&controllers.PoolerReconciler{
+		Client: client,
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Pooler")
+		os.Exit(1)
+	}
+	if enableWebhooks {
+		if err = (&databasev1.Postgresql{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Postgresql")
+			os.Exit(1)
+		}
+		if err = (&databasev1.Database{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Database")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -106,6 +326,18 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("cache", controllers.CacheSyncedChecker(mgr.GetCache())); err != nil {
+		setupLog.Error(err, "unable to set up cache sync check")
+		os.Exit(1)
+	}
+	// A replica only takes webhook requests once it serves with its
+	// certificate
+	if enableWebhooks {
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook check")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -0,0 +1,116 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package catalog lists the Postgres versions the operator supports and the
+// images that provide them.
+package catalog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Entry is a supported Postgres version and the image to run it with
+type Entry struct {
+	Version string `json:"version"`
+	Image   string `json:"image"`
+}
+
+// Catalog is the set of versions an operator offers
+type Catalog []Entry
+
+// DefaultVersion is run by instances that do not ask for a version
+const DefaultVersion = "14.5"
+
+// Default is the catalog compiled into the operator
+var Default = Catalog{
+	{Version: "13.8", Image: "postgres:13.8"},
+	{Version: "13.12", Image: "postgres:13.12"},
+	{Version: "14.5", Image: "postgres:14.5"},
+	{Version: "14.9", Image: "postgres:14.9"},
+	{Version: "15.4", Image: "postgres:15.4"},
+	{Version: "16.0", Image: "postgres:16.0"},
+}
+
+// ImageRepository provides the images of versions the catalog does not
+// list, tagged with the version
+const ImageRepository = "postgres"
+
+// FIPSImageRepository is the repository of FIPS-enabled Postgres images,
+// tagged with the version, which FIPS instances run. There is none by
+// default, as the official images are not FIPS-enabled.
+var FIPSImageRepository string
+
+// PGVectorImageRepository is the repository of Postgres images with the
+// pgvector extension, tagged with the version, which instances enabling
+// pgvector run. There is none by default, as the official images do not
+// ship the extension.
+var PGVectorImageRepository string
+
+// CurlImage downloads seeds given by URL and runs the audit container. It
+// is pinned so that the image only changes with the operator.
+const CurlImage = "curlimages/curl:8.4.0"
+
+// Lookup finds the entry for an exact version
+func (c Catalog) Lookup(version string) (Entry, bool) {
+	for _, e := range c {
+		if e.Version == version {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// LatestPatch returns the newest version in the catalog with the same major
+// version as the one given, or the version itself if nothing newer exists.
+func (c Catalog) LatestPatch(version string) string {
+	latest := version
+	for _, e := range c {
+		if Major(e.Version) == Major(version) && Compare(e.Version, latest) > 0 {
+			latest = e.Version
+		}
+	}
+	return latest
+}
+
+// Major returns the major part of a version, e.g. 14 for 14.5
+func Major(version string) string {
+	return strings.SplitN(version, ".", 2)[0]
+}
+
+// Compare orders two versions numerically component by component, returning
+// -1, 0 or 1. Missing components count as zero.
+func Compare(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := component(as, i), component(bs, i)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func component(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[i])
+	return n
+}
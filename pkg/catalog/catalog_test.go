@@ -0,0 +1,65 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package catalog
+
+import "testing"
+
+func TestMajor(t *testing.T) {
+	tests := map[string]string{"14.5": "14", "16": "16", "": ""}
+	for version, want := range tests {
+		if got := Major(version); got != want {
+			t.Errorf("Major(%q) = %q, want %q", version, got, want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"14.5", "14.9", -1},
+		{"14.10", "14.9", 1},
+		{"14", "14.0", 0},
+		{"16.0", "15.4", 1},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLatestPatch(t *testing.T) {
+	c := Catalog{
+		{Version: "14.5", Image: "postgres:14.5"},
+		{Version: "14.9", Image: "postgres:14.9"},
+		{Version: "15.4", Image: "postgres:15.4"},
+	}
+	tests := map[string]string{
+		"14.5":  "14.9",
+		"14.9":  "14.9",
+		"14.10": "14.10",
+		"15.1":  "15.4",
+		"16.0":  "16.0",
+	}
+	for version, want := range tests {
+		if got := c.LatestPatch(version); got != want {
+			t.Errorf("LatestPatch(%q) = %q, want %q", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"regexp"
+	"sort"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// cnpgCluster is the part of a Cluster of CloudNativePG,
+// postgresql.cnpg.io/v1, that is translated or warned about
+type cnpgCluster struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Instances int32  `json:"instances"`
+		ImageName string `json:"imageName"`
+		Storage   struct {
+			Size         string  `json:"size"`
+			StorageClass *string `json:"storageClass"`
+		} `json:"storage"`
+		PostgreSQL struct {
+			Parameters map[string]string `json:"parameters"`
+		} `json:"postgresql"`
+		Resources       *corev1.ResourceRequirements `json:"resources"`
+		SuperuserSecret *corev1.LocalObjectReference `json:"superuserSecret"`
+		Bootstrap       *struct {
+			InitDB *struct {
+				Database string                       `json:"database"`
+				Owner    string                       `json:"owner"`
+				Secret   *corev1.LocalObjectReference `json:"secret"`
+			} `json:"initdb"`
+			Recovery     map[string]interface{} `json:"recovery"`
+			PgBaseBackup map[string]interface{} `json:"pg_basebackup"`
+		} `json:"bootstrap"`
+		Managed *struct {
+			Roles []cnpgRole `json:"roles"`
+		} `json:"managed"`
+
+		// Settings that are only warned about
+		Backup           map[string]interface{} `json:"backup"`
+		Replica          map[string]interface{} `json:"replica"`
+		ExternalClusters []interface{}          `json:"externalClusters"`
+		Monitoring       map[string]interface{} `json:"monitoring"`
+	} `json:"spec"`
+}
+
+type cnpgRole struct {
+	Name            string                       `json:"name"`
+	Ensure          string                       `json:"ensure"`
+	Login           bool                         `json:"login"`
+	Superuser       bool                         `json:"superuser"`
+	CreateDB        bool                         `json:"createdb"`
+	CreateRole      bool                         `json:"createrole"`
+	Replication     bool                         `json:"replication"`
+	ConnectionLimit *int32                       `json:"connectionLimit"`
+	ValidUntil      *metav1.Time                 `json:"validUntil"`
+	InRoles         []string                     `json:"inRoles"`
+	PasswordSecret  *corev1.LocalObjectReference `json:"passwordSecret"`
+}
+
+// cnpgImageVersion finds the version in the tag of a CloudNativePG image,
+// e.g. 15.3 in ghcr.io/cloudnative-pg/postgresql:15.3-5
+var cnpgImageVersion = regexp.MustCompile(`:(\d+(\.\d+)?)[^:/]*$`)
+
+// fromCloudNativePG translates a Cluster of CloudNativePG. Both operators
+// keep the superuser password in the <name>-superuser Secret, and roles keep
+// the Secrets of their passwords, so clients keep their passwords.
+func fromCloudNativePG(source cnpgCluster) Result {
+	var result Result
+	spec := source.Spec
+	pg := newPostgresql(source.ObjectMeta)
+	if match := cnpgImageVersion.FindStringSubmatch(spec.ImageName); match != nil {
+		pg.Spec.Version = catalogVersion(&result, match[1])
+	} else {
+		result.warn("no version found in imageName %q, the default version of the operator is used", spec.ImageName)
+	}
+	pg.Spec.Parameters = spec.PostgreSQL.Parameters
+	pg.Spec.Resources = spec.Resources
+	if spec.SuperuserSecret != nil {
+		pg.Spec.PasswordSecretRef = passwordKey(spec.SuperuserSecret.Name)
+	}
+	if spec.Storage.Size != "" {
+		pg.Spec.Storage = &databasev1.StorageSpec{StorageClassName: spec.Storage.StorageClass}
+		if size, err := resource.ParseQuantity(spec.Storage.Size); err != nil {
+			result.warn("storage size %q: %v", spec.Storage.Size, err)
+		} else {
+			pg.Spec.Storage.Size = size
+		}
+	}
+	if spec.Instances > 1 {
+		result.warn("instances is %d, only the primary is translated", spec.Instances)
+	}
+	result.Objects = append(result.Objects, pg)
+
+	managed := map[string]bool{}
+	if spec.Managed != nil {
+		for _, declared := range spec.Managed.Roles {
+			if declared.Ensure == "absent" {
+				result.warn("role %s is to be absent, it is left out", declared.Name)
+				continue
+			}
+			role := newRole(pg, declared.Name)
+			role.Spec.Login = declared.Login
+			role.Spec.Superuser = declared.Superuser
+			role.Spec.CreateDB = declared.CreateDB
+			role.Spec.CreateRole = declared.CreateRole
+			role.Spec.Replication = declared.Replication
+			role.Spec.ConnectionLimit = declared.ConnectionLimit
+			role.Spec.ValidUntil = declared.ValidUntil
+			role.Spec.InRoles = declared.InRoles
+			if declared.PasswordSecret != nil {
+				role.Spec.PasswordSecretRef = passwordKey(declared.PasswordSecret.Name)
+			}
+			managed[declared.Name] = true
+			result.Objects = append(result.Objects, role)
+		}
+	}
+
+	// Without a bootstrap section CloudNativePG runs initdb with its
+	// defaults: the app database owned by the app role
+	if spec.Bootstrap == nil || (spec.Bootstrap.Recovery == nil && spec.Bootstrap.PgBaseBackup == nil) {
+		database, owner, secret := "app", "", ""
+		if spec.Bootstrap != nil && spec.Bootstrap.InitDB != nil {
+			initdb := spec.Bootstrap.InitDB
+			if initdb.Database != "" {
+				database = initdb.Database
+			}
+			owner = initdb.Owner
+			if initdb.Secret != nil {
+				secret = initdb.Secret.Name
+			}
+		}
+		if owner == "" {
+			owner = database
+		}
+		if secret == "" {
+			secret = source.Name + "-app"
+		}
+		if !managed[owner] {
+			role := newRole(pg, owner)
+			role.Spec.Login = true
+			role.Spec.PasswordSecretRef = passwordKey(secret)
+			result.Objects = append(result.Objects, role)
+		}
+		result.Objects = append(result.Objects, newDatabase(pg, database, owner))
+	} else {
+		result.warn("bootstrap from a backup or another cluster is not supported, move the data separately")
+	}
+
+	for setting, set := range map[string]bool{
+		"backup":           len(spec.Backup) > 0,
+		"replica":          len(spec.Replica) > 0,
+		"externalClusters": len(spec.ExternalClusters) > 0,
+		"monitoring":       len(spec.Monitoring) > 0,
+	} {
+		if set {
+			result.warn("%s is not supported", setting)
+		}
+	}
+	sort.Strings(result.Warnings)
+	return result
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package convert translates the resources of other Postgres operators, the
+// postgresql of Zalando's operator and the Cluster of CloudNativePG, into
+// the resources of this one, so instances can be moved over without
+// translating their specs by hand. Only the declarations are translated:
+// the data has to be moved separately, e.g. with pg_dump or a Subscription.
+package convert
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Result holds the resources a resource of another operator translates to,
+// the Postgresql first, and what could not be translated
+type Result struct {
+	Objects []client.Object
+
+	// Warnings name the settings that were left out, as this operator has
+	// nothing like them
+	Warnings []string
+}
+
+func (r *Result) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Convert translates a postgresql of Zalando's operator or a Cluster of
+// CloudNativePG
+func Convert(obj *unstructured.Unstructured) (Result, error) {
+	gvk := obj.GroupVersionKind()
+	switch {
+	case gvk.Group == "acid.zalan.do" && gvk.Kind == "postgresql":
+		var source zalandoPostgresql
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &source); err != nil {
+			return Result{}, err
+		}
+		return fromZalando(source), nil
+	case gvk.Group == "postgresql.cnpg.io" && gvk.Kind == "Cluster":
+		var source cnpgCluster
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &source); err != nil {
+			return Result{}, err
+		}
+		return fromCloudNativePG(source), nil
+	}
+	return Result{}, fmt.Errorf("cannot convert %s %s: only postgresql.acid.zalan.do and cluster.postgresql.cnpg.io are supported",
+		gvk.Kind, obj.GetName())
+}
+
+// catalogVersion is the newest version of the catalog matching the one
+// given, which may be a major version only
+func catalogVersion(result *Result, version string) string {
+	latest := catalog.Default.LatestPatch(version)
+	if _, ok := catalog.Default.Lookup(latest); !ok {
+		result.warn("version %s is not in the catalog of the operator", version)
+	}
+	return latest
+}
+
+func newPostgresql(meta metav1.ObjectMeta) *databasev1.Postgresql {
+	pg := &databasev1.Postgresql{ObjectMeta: metav1.ObjectMeta{
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+	}}
+	pg.SetGroupVersionKind(databasev1.GroupVersion.WithKind("Postgresql"))
+	return pg
+}
+
+func newRole(pg *databasev1.Postgresql, name string) *databasev1.Role {
+	role := &databasev1.Role{ObjectMeta: objectMeta(pg, name)}
+	role.SetGroupVersionKind(databasev1.GroupVersion.WithKind("Role"))
+	role.Spec.InstanceRef.Name = pg.Name
+	role.Spec.Name = name
+	return role
+}
+
+func newDatabase(pg *databasev1.Postgresql, name, owner string) *databasev1.Database {
+	database := &databasev1.Database{ObjectMeta: objectMeta(pg, name)}
+	database.SetGroupVersionKind(databasev1.GroupVersion.WithKind("Database"))
+	database.Spec.InstanceRef.Name = pg.Name
+	database.Spec.Name = name
+	database.Spec.Owner = owner
+	return database
+}
+
+func newPooler(pg *databasev1.Postgresql, name string) *databasev1.Pooler {
+	pooler := &databasev1.Pooler{ObjectMeta: objectMeta(pg, name)}
+	pooler.SetGroupVersionKind(databasev1.GroupVersion.WithKind("Pooler"))
+	pooler.Spec.InstanceRef.Name = pg.Name
+	return pooler
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// objectMeta names the resource of a role or database of an instance after
+// both, as role and database names need not be valid resource names
+func objectMeta(pg *databasev1.Postgresql, name string) metav1.ObjectMeta {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	return metav1.ObjectMeta{Name: strings.Trim(pg.Name+"-"+name, "-"), Namespace: pg.Namespace}
+}
+
+// passwordKey selects the password of a basic-auth Secret, which both
+// operators keep credentials in
+func passwordKey(secret string) *corev1.SecretKeySelector {
+	return &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+		Key:                  corev1.BasicAuthPasswordKey,
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const zalandoManifest = `
+apiVersion: acid.zalan.do/v1
+kind: postgresql
+metadata:
+  name: acid-minimal-cluster
+  namespace: db
+spec:
+  numberOfInstances: 2
+  volume:
+    size: 1Gi
+  users:
+    zalando: [superuser, createdb]
+    foo_user: []
+  databases:
+    foo: zalando
+  postgresql:
+    version: "14"
+  resources:
+    requests: {cpu: 10m, memory: 100Mi}
+  enableMasterLoadBalancer: true
+  allowedSourceRanges: [10.0.0.0/8]
+  enableConnectionPooler: true
+  connectionPooler:
+    mode: session
+  enableLogicalBackup: true
+`
+
+const cnpgManifest = `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: postgresql.cnpg.io/v1
+  kind: Cluster
+  metadata:
+    name: cluster-example
+    namespace: db
+  spec:
+    instances: 1
+    imageName: ghcr.io/cloudnative-pg/postgresql:15.4-1
+    storage:
+      size: 2Gi
+      storageClass: ssd
+    bootstrap:
+      initdb:
+        database: shop
+        owner: shopper
+    managed:
+      roles:
+      - name: reporting
+        login: true
+        passwordSecret: {name: reporting-password}
+      - name: legacy
+        ensure: absent
+    backup:
+      retentionPolicy: 30d
+`
+
+func convertManifest(t *testing.T, manifest string) Result {
+	t.Helper()
+	sources, err := ReadManifests(strings.NewReader(manifest))
+	if err != nil || len(sources) != 1 {
+		t.Fatalf("expected one resource, got %d, %v", len(sources), err)
+	}
+	result, err := Convert(sources[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestFromZalando(t *testing.T) {
+	result := convertManifest(t, zalandoManifest)
+	if len(result.Objects) != 5 {
+		t.Fatalf("expected an instance, two roles, a database and a pooler, got %d objects", len(result.Objects))
+	}
+
+	pg := result.Objects[0].(*databasev1.Postgresql)
+	if pg.Name != "acid-minimal-cluster" || pg.Namespace != "db" || pg.Spec.Version != "14.9" {
+		t.Errorf("unexpected instance %+v", pg.ObjectMeta)
+	}
+	if pg.Spec.Storage == nil || pg.Spec.Storage.Size.String() != "1Gi" {
+		t.Errorf("expected 1Gi of storage, got %+v", pg.Spec.Storage)
+	}
+	if pg.Spec.Resources.Requests.Memory().String() != "100Mi" {
+		t.Errorf("expected the memory request to be kept, got %+v", pg.Spec.Resources)
+	}
+	if settings := pg.Spec.Service.Settings("rw"); settings.Type != corev1.ServiceTypeLoadBalancer || settings.LoadBalancerSourceRanges[0] != "10.0.0.0/8" {
+		t.Errorf("expected the primary behind a load balancer, got %+v", settings)
+	}
+
+	role := result.Objects[1].(*databasev1.Role)
+	if role.Name != "acid-minimal-cluster-foo-user" || role.Spec.Name != "foo_user" || !role.Spec.Login ||
+		role.Spec.PasswordSecretRef.Name != "foo-user.acid-minimal-cluster.credentials.postgresql.acid.zalan.do" {
+		t.Errorf("unexpected role %+v", role)
+	}
+	if role := result.Objects[2].(*databasev1.Role); !role.Spec.Superuser || !role.Spec.CreateDB {
+		t.Errorf("expected the flags of the user to be kept, got %+v", role.Spec)
+	}
+	if database := result.Objects[3].(*databasev1.Database); database.Spec.Name != "foo" || database.Spec.Owner != "zalando" {
+		t.Errorf("unexpected database %+v", database.Spec)
+	}
+	if pooler := result.Objects[4].(*databasev1.Pooler); *pooler.Spec.Replicas != 2 || pooler.Spec.PoolMode != databasev1.PoolModeSession {
+		t.Errorf("unexpected pooler %+v", pooler.Spec)
+	}
+	if len(result.Warnings) != 2 {
+		t.Errorf("expected warnings about the replica and logical backups, got %q", result.Warnings)
+	}
+}
+
+func TestFromCloudNativePG(t *testing.T) {
+	result := convertManifest(t, cnpgManifest)
+	if len(result.Objects) != 4 {
+		t.Fatalf("expected an instance, two roles and a database, got %d objects", len(result.Objects))
+	}
+
+	pg := result.Objects[0].(*databasev1.Postgresql)
+	if pg.Spec.Version != "15.4" || *pg.Spec.Storage.StorageClassName != "ssd" || pg.Spec.PasswordSecretRef != nil {
+		t.Errorf("unexpected instance %+v", pg.Spec)
+	}
+	if role := result.Objects[1].(*databasev1.Role); role.Spec.Name != "reporting" || role.Spec.PasswordSecretRef.Name != "reporting-password" {
+		t.Errorf("unexpected role %+v", role.Spec)
+	}
+	if role := result.Objects[2].(*databasev1.Role); role.Spec.Name != "shopper" || role.Spec.PasswordSecretRef.Name != "cluster-example-app" {
+		t.Errorf("the owner should keep the password of the app Secret, got %+v", role.Spec)
+	}
+	if database := result.Objects[3].(*databasev1.Database); database.Spec.Name != "shop" || database.Spec.Owner != "shopper" {
+		t.Errorf("unexpected database %+v", database.Spec)
+	}
+	if len(result.Warnings) != 2 {
+		t.Errorf("expected warnings about the absent role and backups, got %q", result.Warnings)
+	}
+}
+
+func TestConvertUnsupported(t *testing.T) {
+	sources, err := ReadManifests(strings.NewReader("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Convert(sources[0]); err == nil {
+		t.Error("expected a ConfigMap to be rejected")
+	}
+}
+
+func TestWriteManifests(t *testing.T) {
+	result := convertManifest(t, cnpgManifest)
+	var out bytes.Buffer
+	if err := WriteManifests(&out, result.Objects); err != nil {
+		t.Fatal(err)
+	}
+	written, err := ReadManifests(&out)
+	if err != nil || len(written) != len(result.Objects) {
+		t.Fatalf("expected the objects to read back, got %d, %v", len(written), err)
+	}
+	if written[0].GetKind() != "Postgresql" || written[0].GetAPIVersion() != databasev1.GroupVersion.String() {
+		t.Errorf("expected the type to be written, got %s %s", written[0].GetAPIVersion(), written[0].GetKind())
+	}
+	if _, ok := written[0].Object["status"]; ok {
+		t.Error("status should not be written")
+	}
+}
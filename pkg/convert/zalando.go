@@ -0,0 +1,213 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	databasev1 "github.com/pkpivot/pg-simple-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// zalandoPostgresql is the part of a postgresql of Zalando's operator,
+// acid.zalan.do/v1, that is translated or warned about
+type zalandoPostgresql struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		NumberOfInstances int32 `json:"numberOfInstances"`
+		Volume            struct {
+			Size         string `json:"size"`
+			StorageClass string `json:"storageClass"`
+		} `json:"volume"`
+		PostgreSQL struct {
+			Version    string            `json:"version"`
+			Parameters map[string]string `json:"parameters"`
+		} `json:"postgresql"`
+		Resources                 *zalandoResources   `json:"resources"`
+		Users                     map[string][]string `json:"users"`
+		Databases                 map[string]string   `json:"databases"`
+		AllowedSourceRanges       []string            `json:"allowedSourceRanges"`
+		EnableMasterLoadBalancer  *bool               `json:"enableMasterLoadBalancer"`
+		EnableReplicaLoadBalancer *bool               `json:"enableReplicaLoadBalancer"`
+		EnableConnectionPooler    *bool               `json:"enableConnectionPooler"`
+		ConnectionPooler          *struct {
+			NumberOfInstances *int32            `json:"numberOfInstances"`
+			Mode              string            `json:"mode"`
+			Resources         *zalandoResources `json:"resources"`
+		} `json:"connectionPooler"`
+
+		// Settings that are only warned about
+		PreparedDatabases   map[string]interface{} `json:"preparedDatabases"`
+		Patroni             map[string]interface{} `json:"patroni"`
+		Standby             map[string]interface{} `json:"standby"`
+		Clone               map[string]interface{} `json:"clone"`
+		EnableLogicalBackup bool                   `json:"enableLogicalBackup"`
+		Sidecars            []interface{}          `json:"sidecars"`
+	} `json:"spec"`
+}
+
+type zalandoResources struct {
+	Requests map[corev1.ResourceName]string `json:"requests"`
+	Limits   map[corev1.ResourceName]string `json:"limits"`
+}
+
+// zalandoSecret is the Secret Zalando's operator keeps the password of a
+// user of a cluster in
+func zalandoSecret(user, cluster string) string {
+	return fmt.Sprintf("%s.%s.credentials.postgresql.acid.zalan.do", strings.ReplaceAll(user, "_", "-"), cluster)
+}
+
+// fromZalando translates a postgresql of Zalando's operator. Roles keep the
+// Secrets Zalando's operator created for them, so clients keep their
+// passwords; the superuser keeps the password of postgres.
+func fromZalando(source zalandoPostgresql) Result {
+	var result Result
+	spec := source.Spec
+	pg := newPostgresql(source.ObjectMeta)
+	pg.Spec.Version = catalogVersion(&result, spec.PostgreSQL.Version)
+	pg.Spec.Parameters = spec.PostgreSQL.Parameters
+	pg.Spec.PasswordSecretRef = passwordKey(zalandoSecret("postgres", source.Name))
+	pg.Spec.Resources = zalandoResourceRequirements(&result, spec.Resources)
+	if spec.Volume.Size != "" {
+		pg.Spec.Storage = &databasev1.StorageSpec{}
+		if size, err := resource.ParseQuantity(spec.Volume.Size); err != nil {
+			result.warn("volume size %q: %v", spec.Volume.Size, err)
+		} else {
+			pg.Spec.Storage.Size = size
+		}
+		if spec.Volume.StorageClass != "" {
+			pg.Spec.Storage.StorageClassName = &spec.Volume.StorageClass
+		}
+	}
+	if spec.NumberOfInstances > 1 {
+		result.warn("numberOfInstances is %d, only the primary is translated", spec.NumberOfInstances)
+	}
+
+	// Zalando's operator lets the source ranges in through load balancers
+	// only
+	var overrides []databasev1.ServiceOverride
+	for name, enabled := range map[string]*bool{"rw": spec.EnableMasterLoadBalancer, "ro": spec.EnableReplicaLoadBalancer} {
+		if enabled != nil && *enabled {
+			overrides = append(overrides, databasev1.ServiceOverride{Name: name, ServiceSettings: databasev1.ServiceSettings{
+				Type:                     corev1.ServiceTypeLoadBalancer,
+				LoadBalancerSourceRanges: spec.AllowedSourceRanges,
+			}})
+		}
+	}
+	if len(overrides) > 0 {
+		sort.Slice(overrides, func(i, j int) bool { return overrides[i].Name > overrides[j].Name })
+		pg.Spec.Service = &databasev1.ServiceSpec{Overrides: overrides}
+	}
+	result.Objects = append(result.Objects, pg)
+
+	users := make([]string, 0, len(spec.Users))
+	for name := range spec.Users {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	for _, name := range users {
+		role := newRole(pg, name)
+		role.Spec.Login = true
+		role.Spec.PasswordSecretRef = passwordKey(zalandoSecret(name, source.Name))
+		for _, flag := range spec.Users[name] {
+			switch strings.ToLower(flag) {
+			case "superuser":
+				role.Spec.Superuser = true
+			case "createdb":
+				role.Spec.CreateDB = true
+			case "createrole":
+				role.Spec.CreateRole = true
+			case "replication":
+				role.Spec.Replication = true
+			case "login":
+			case "nologin":
+				role.Spec.Login = false
+				role.Spec.PasswordSecretRef = nil
+			default:
+				result.warn("user %s: flag %s is not supported", name, flag)
+			}
+		}
+		result.Objects = append(result.Objects, role)
+	}
+	databases := make([]string, 0, len(spec.Databases))
+	for name := range spec.Databases {
+		databases = append(databases, name)
+	}
+	sort.Strings(databases)
+	for _, name := range databases {
+		result.Objects = append(result.Objects, newDatabase(pg, name, spec.Databases[name]))
+	}
+
+	if spec.EnableConnectionPooler != nil && *spec.EnableConnectionPooler {
+		pooler := newPooler(pg, "pooler")
+		// The defaults of Zalando's operator
+		replicas := int32(2)
+		pooler.Spec.PoolMode = databasev1.PoolModeTransaction
+		if settings := spec.ConnectionPooler; settings != nil {
+			if settings.NumberOfInstances != nil {
+				replicas = *settings.NumberOfInstances
+			}
+			if settings.Mode != "" {
+				pooler.Spec.PoolMode = databasev1.PoolMode(settings.Mode)
+			}
+			pooler.Spec.Resources = zalandoResourceRequirements(&result, settings.Resources)
+		}
+		pooler.Spec.Replicas = &replicas
+		result.Objects = append(result.Objects, pooler)
+	}
+
+	for setting, set := range map[string]bool{
+		"preparedDatabases":   len(spec.PreparedDatabases) > 0,
+		"patroni":             len(spec.Patroni) > 0,
+		"standby":             len(spec.Standby) > 0,
+		"clone":               len(spec.Clone) > 0,
+		"enableLogicalBackup": spec.EnableLogicalBackup,
+		"sidecars":            len(spec.Sidecars) > 0,
+	} {
+		if set {
+			result.warn("%s is not supported", setting)
+		}
+	}
+	sort.Strings(result.Warnings)
+	return result
+}
+
+func zalandoResourceRequirements(result *Result, source *zalandoResources) *corev1.ResourceRequirements {
+	if source == nil {
+		return nil
+	}
+	parse := func(list map[corev1.ResourceName]string) corev1.ResourceList {
+		if len(list) == 0 {
+			return nil
+		}
+		parsed := corev1.ResourceList{}
+		for name, value := range list {
+			quantity, err := resource.ParseQuantity(value)
+			if err != nil {
+				result.warn("resource %s %q: %v", name, value, err)
+				continue
+			}
+			parsed[name] = quantity
+		}
+		return parsed
+	}
+	return &corev1.ResourceRequirements{Requests: parse(source.Requests), Limits: parse(source.Limits)}
+}
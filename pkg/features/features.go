@@ -0,0 +1,58 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package features holds the feature gates of the operator, which let new
+// subsystems ship turned off and be turned on per cluster with
+// --feature-gates, as in Kubernetes. Alpha features are off by default,
+// beta features on. Unlike the features of the operator configuration,
+// gates are fixed for the life of the process.
+package features
+
+import "k8s.io/component-base/featuregate"
+
+const (
+	// NodeLossFailover force deletes the pod of an instance whose node is
+	// gone, so it is recreated elsewhere on the same volume claim
+	NodeLossFailover featuregate.Feature = "NodeLossFailover"
+
+	// MajorVersionUpgrade runs pg_upgrade when the version of an instance
+	// moves to a new major version
+	MajorVersionUpgrade featuregate.Feature = "MajorVersionUpgrade"
+
+	// Webhooks serves the defaulting and validating webhooks. Setting the
+	// ENABLE_WEBHOOKS environment variable to false turns them off as well.
+	Webhooks featuregate.Feature = "Webhooks"
+)
+
+var defaults = map[featuregate.Feature]featuregate.FeatureSpec{
+	NodeLossFailover:    {Default: true, PreRelease: featuregate.Beta},
+	MajorVersionUpgrade: {Default: true, PreRelease: featuregate.Beta},
+	Webhooks:            {Default: true, PreRelease: featuregate.Beta},
+}
+
+// Gate is set from the command line
+var Gate = featuregate.NewFeatureGate()
+
+func init() {
+	if err := Gate.Add(defaults); err != nil {
+		panic(err)
+	}
+}
+
+// Enabled reports whether a feature is turned on
+func Enabled(feature featuregate.Feature) bool {
+	return Gate.Enabled(feature)
+}
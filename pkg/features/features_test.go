@@ -0,0 +1,35 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import "testing"
+
+func TestGate(t *testing.T) {
+	gate := Gate.DeepCopy()
+	if !gate.Enabled(NodeLossFailover) {
+		t.Error("beta features should be on by default")
+	}
+	if err := gate.Set("NodeLossFailover=false"); err != nil {
+		t.Fatal(err)
+	}
+	if gate.Enabled(NodeLossFailover) {
+		t.Error("NodeLossFailover should be turned off")
+	}
+	if err := gate.Set("Unknown=true"); err == nil {
+		t.Error("unknown features should be rejected")
+	}
+}
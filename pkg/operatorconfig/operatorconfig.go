@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package operatorconfig holds the operator settings that can change while
+// it runs. They are read from a ConfigMap and fall back to the settings
+// compiled into the operator and given on its command line.
+package operatorconfig
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+)
+
+// Key is the ConfigMap key holding the configuration, as YAML
+const Key = "config.yaml"
+
+// FeatureDefaultDenyNetwork gives every instance a NetworkPolicy, whether
+// its access spec asks for one or not
+const FeatureDefaultDenyNetwork = "DefaultDenyNetwork"
+
+// Config is the operator configuration. Empty fields take the operator's
+// built-in settings.
+type Config struct {
+	// Catalog lists the versions instances can run and their images
+	Catalog catalog.Catalog `json:"catalog,omitempty"`
+
+	// DefaultVersion is run by instances that do not ask for a version. It
+	// has to be in the catalog.
+	DefaultVersion string `json:"defaultVersion,omitempty"`
+
+	// ImageRepository provides the images of versions the catalog does not
+	// list, tagged with the version
+	ImageRepository string `json:"imageRepository,omitempty"`
+
+	// FIPSImageRepository provides the images of FIPS instances, tagged with
+	// the version
+	FIPSImageRepository string `json:"fipsImageRepository,omitempty"`
+
+	// PGVectorImageRepository provides the images of instances enabling
+	// pgvector, tagged with the version
+	PGVectorImageRepository string `json:"pgvectorImageRepository,omitempty"`
+
+	// CurlImage downloads seeds given by URL and runs the audit container,
+	// e.g. from a registry mirror. It needs curl and a shell. Instances
+	// auditing their logs pick it up when their pod is next recreated.
+	CurlImage string `json:"curlImage,omitempty"`
+
+	// DefaultStorageClass is the StorageClass of volume claims whose storage
+	// spec does not name one, instead of the cluster default
+	DefaultStorageClass string `json:"defaultStorageClass,omitempty"`
+
+	// Features switches operator features on or off by name, e.g.
+	// DefaultDenyNetwork
+	Features map[string]bool `json:"features,omitempty"`
+}
+
+var current atomic.Value
+
+// Get returns the configuration in effect
+func Get() Config {
+	c, _ := current.Load().(Config)
+	if len(c.Catalog) == 0 {
+		c.Catalog = catalog.Default
+	}
+	if c.DefaultVersion == "" {
+		c.DefaultVersion = catalog.DefaultVersion
+	}
+	if c.ImageRepository == "" {
+		c.ImageRepository = catalog.ImageRepository
+	}
+	if c.FIPSImageRepository == "" {
+		c.FIPSImageRepository = catalog.FIPSImageRepository
+	}
+	if c.PGVectorImageRepository == "" {
+		c.PGVectorImageRepository = catalog.PGVectorImageRepository
+	}
+	if c.CurlImage == "" {
+		c.CurlImage = catalog.CurlImage
+	}
+	return c
+}
+
+// Set puts a configuration into effect
+func Set(c Config) {
+	current.Store(c)
+}
+
+// Enabled reports whether a feature is switched on, the given default when
+// the configuration does not mention it
+func (c Config) Enabled(feature string, byDefault bool) bool {
+	if enabled, ok := c.Features[feature]; ok {
+		return enabled
+	}
+	return byDefault
+}
+
+// Parse reads a configuration from YAML and checks it is consistent
+func Parse(data string) (Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict([]byte(data), &c); err != nil {
+		return Config{}, err
+	}
+	for i, entry := range c.Catalog {
+		if entry.Version == "" || entry.Image == "" {
+			return Config{}, fmt.Errorf("catalog[%d]: version and image are required", i)
+		}
+	}
+	if c.DefaultVersion != "" {
+		versions := c.Catalog
+		if len(versions) == 0 {
+			versions = catalog.Default
+		}
+		if _, ok := versions.Lookup(c.DefaultVersion); !ok {
+			return Config{}, fmt.Errorf("defaultVersion %s is not in the catalog", c.DefaultVersion)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operatorconfig
+
+import (
+	"testing"
+
+	"github.com/pkpivot/pg-simple-operator/pkg/catalog"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse(`
+catalog:
+- version: "15.4"
+  image: registry.example.com/postgres:15.4
+defaultVersion: "15.4"
+defaultStorageClass: encrypted
+features:
+  DefaultDenyNetwork: true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := c.Catalog.Lookup("15.4"); !ok || entry.Image != "registry.example.com/postgres:15.4" {
+		t.Errorf("unexpected catalog %v", c.Catalog)
+	}
+	if !c.Enabled(FeatureDefaultDenyNetwork, false) || c.Enabled("Other", false) {
+		t.Errorf("unexpected features %v", c.Features)
+	}
+
+	for _, data := range []string{
+		"defaultVersion: \"9.6\"",
+		"catalog:\n- version: \"15.4\"",
+		"unknownSetting: true",
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("expected %q to be rejected", data)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	defer Set(Config{})
+
+	Set(Config{})
+	if c := Get(); c.DefaultVersion != catalog.DefaultVersion || len(c.Catalog) != len(catalog.Default) ||
+		c.ImageRepository != catalog.ImageRepository || c.CurlImage != catalog.CurlImage {
+		t.Errorf("empty configuration should fall back to the built-in settings, got %+v", c)
+	}
+
+	Set(Config{DefaultVersion: "16.0", DefaultStorageClass: "fast"})
+	if c := Get(); c.DefaultVersion != "16.0" || c.DefaultStorageClass != "fast" {
+		t.Errorf("configuration not in effect, got %+v", c)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reason holds the reasons the operator gives on conditions, events
+// and log lines. Automation keys off them, so a reason, once released, keeps
+// its value: add new ones rather than renaming.
+package reason
+
+// Reasons used on the Upgrading condition
+const (
+	MinorUpgradeInProgress = "MinorUpgradeInProgress"
+	MajorUpgradeInProgress = "MajorUpgradeInProgress"
+	MajorUpgradeFailed     = "MajorUpgradeFailed"
+	UpgradeComplete        = "UpgradeComplete"
+	MajorUpgradeRequired   = "MajorUpgradeRequired"
+)
+
+// Reasons used on the Seeded condition
+const (
+	SeedRunning = "SeedRunning"
+	SeedLoaded  = "SeedLoaded"
+	SeedFailed  = "SeedFailed"
+)
+
+// Reasons used on the Ready condition of resources managed through SQL
+const (
+	Applied          = "Applied"
+	InstanceNotReady = "InstanceNotReady"
+	ApplyFailed      = "ApplyFailed"
+)
+
+// Reasons used on the Ready condition of a Pooler, on top of those of
+// resources managed through SQL
+const (
+	PoolerAvailable   = "Available"
+	PoolerProgressing = "Progressing"
+)
+
+// Reasons used on the DriftDetected condition of a Role
+const (
+	NoDrift       = "NoDrift"
+	DriftReverted = "DriftReverted"
+)
+
+// Reasons used on the MaintenancePending condition
+const (
+	OutsideMaintenanceWindow = "OutsideMaintenanceWindow"
+	NothingPending           = "NothingPending"
+)
+
+// Reasons used on the LDAPReachable condition
+const (
+	LDAPBound       = "Bound"
+	LDAPUnreachable = "Unreachable"
+	LDAPBindFailed  = "BindFailed"
+)
+
+// Reasons used on the VaultConfigured condition
+const (
+	VaultConfigured = "Configured"
+	VaultFailed     = "ConfigurationFailed"
+)
+
+// Reasons used on the UnmanagedPrivileges condition
+const (
+	UnmanagedPrivilegesFound = "UnmanagedPrivilegesFound"
+	AllPrivilegesManaged     = "AllPrivilegesManaged"
+)
+
+// Reasons used on the ReconcilePaused condition
+const (
+	PausedByAnnotation = "PausedByAnnotation"
+	Reconciling        = "Reconciling"
+)
+
+// Reasons used on the Degraded condition
+const (
+	ZoneSpreadUnsatisfiable = "ZoneSpreadUnsatisfiable"
+	PlacementSatisfied      = "PlacementSatisfied"
+)
+
+// Reasons used on the ShardsHealthy condition
+const (
+	ShardsHealthy       = "ShardsHealthy"
+	WorkersPending      = "WorkersPending"
+	WorkersInactive     = "WorkersInactive"
+	ShardsInactive      = "ShardsInactive"
+	WorkerRemovalFailed = "WorkerRemovalFailed"
+)
+
+// ReadOnlyRequested is the reason of the ReadOnly condition
+const ReadOnlyRequested = "ReadOnlyRequested"
+
+// Reasons of events about an instance
+const (
+	// PodCreateFailed is given when the pod of an instance cannot be created
+	PodCreateFailed = "PodCreateFailed"
+	// SecretMissing is given when a Secret the spec refers to does not exist
+	SecretMissing = "SecretMissing"
+	// DryRun is given for each write the operator skipped in dry-run mode
+	DryRun = "DryRun"
+	// ExternalUnreachable is given when the operator cannot connect to the
+	// external server an instance points at
+	ExternalUnreachable = "ExternalUnreachable"
+	// PromoteNotNeeded is given when the pod named by the promote
+	// annotation already is the primary
+	PromoteNotNeeded = "PromoteNotNeeded"
+	// PromoteRejected is given when the promote annotation names a pod
+	// that is not a standby of the instance
+	PromoteRejected = "PromoteRejected"
+	// Promoted is given when a standby has been promoted to primary
+	Promoted = "Promoted"
+	// Fenced is given when the operator fences the primary of an instance,
+	// which stays out of service until it is rewound or reinitialized
+	Fenced = "Fenced"
+	// StopRefused is given when hibernating or restarting an instance would
+	// lose its data, as it has no volume claim
+	StopRefused = "StopRefused"
+)